@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// AuthRequired tells if the HTTP server only answers requests with the
+// ApiToken or the ApiUser and ApiPassword of the config.
+func AuthRequired() bool {
+	return conf.ApiToken != "" || conf.ApiUser != ""
+}
+
+func secretEqual(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// Authorized tells if r carries ApiToken as a bearer token or ApiUser and
+// ApiPassword with basic auth.
+func Authorized(r *http.Request) bool {
+	if !AuthRequired() {
+		return true
+	}
+	if conf.ApiToken != "" {
+		if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); secretEqual(token, conf.ApiToken) {
+			return true
+		}
+	}
+	if conf.ApiUser != "" {
+		if user, password, ok := r.BasicAuth(); ok && secretEqual(user, conf.ApiUser) && secretEqual(password, conf.ApiPassword) {
+			return true
+		}
+	}
+	return false
+}
+
+// CrossSite tells if a browser sent r from a page of another site. Such a
+// page can POST text/plain to the server on 127.0.0.1 without asking first.
+func CrossSite(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site != "same-origin" && site != "none"
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
+
+// ApiAuth answers 401 to the unauthorized requests for next, and 403 to the
+// cross-site requests changing anything under /api/. /add is left alone, it
+// is called from the browser with a token of its own.
+func ApiAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") && r.Method != http.MethodGet && r.Method != http.MethodHead && CrossSite(r) {
+			http.Error(w, "Cross-Site Request Refused", http.StatusForbidden)
+			return
+		}
+		if r.URL.Path == "/add" || r.URL.Path == "/healthz" || Authorized(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if conf.ApiUser != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="hitomi-go"`)
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// SetApiAuth adds the credentials of the config to a request to the server.
+func SetApiAuth(req *http.Request) {
+	if conf.ApiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+conf.ApiToken)
+	} else if conf.ApiUser != "" {
+		req.SetBasicAuth(conf.ApiUser, conf.ApiPassword)
+	}
+}
+
+// ApiUrl is the url of path on the server of Listen.
+func ApiUrl(path string) string {
+	scheme := "http://"
+	if conf.ListenCert != "" {
+		scheme = "https://"
+	}
+	return scheme + ListenHost() + path
+}
+
+// ApiClient is the client for the server of Listen, which also trusts its
+// certificate when it is self-signed.
+func ApiClient() (*http.Client, error) {
+	if conf.ListenCert == "" {
+		return http.DefaultClient, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	cert, err := ioutil.ReadFile(conf.ListenCert)
+	if err != nil {
+		return nil, err
+	}
+	pool.AppendCertsFromPEM(cert)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
+}
+
+// IsLocalListen tells if listen only accepts connections from this machine.
+func IsLocalListen(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApiAuth(t *testing.T) {
+	defer func(token, user, password string) {
+		conf.ApiToken, conf.ApiUser, conf.ApiPassword = token, user, password
+	}(conf.ApiToken, conf.ApiUser, conf.ApiPassword)
+	conf.ApiToken, conf.ApiUser, conf.ApiPassword = "secret", "user", "pass"
+	handler := ApiAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, test := range []struct {
+		name string
+		path string
+		set  func(r *http.Request)
+		code int
+	}{
+		{"none", "/api/status", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"token", "/api/status", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, http.StatusOK},
+		{"wrong token", "/api/status", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized},
+		{"basic", "/metrics", func(r *http.Request) { r.SetBasicAuth("user", "pass") }, http.StatusOK},
+		{"wrong password", "/metrics", func(r *http.Request) { r.SetBasicAuth("user", "nope") }, http.StatusUnauthorized},
+		{"add page", "/add", func(r *http.Request) {}, http.StatusOK},
+	} {
+		req := httptest.NewRequest("GET", test.path, nil)
+		test.set(req)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		if res.Code != test.code {
+			t.Errorf("%s: %d, want %d", test.name, res.Code, test.code)
+		}
+	}
+}
+
+func TestApiAuthCrossSite(t *testing.T) {
+	defer func(token, user string) { conf.ApiToken, conf.ApiUser = token, user }(conf.ApiToken, conf.ApiUser)
+	conf.ApiToken, conf.ApiUser = "", ""
+	handler := ApiAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, test := range []struct {
+		method string
+		path   string
+		header map[string]string
+		code   int
+	}{
+		{"POST", "/api/add", map[string]string{}, http.StatusOK},
+		{"POST", "/api/add", map[string]string{"Origin": "http://127.0.0.1:8420"}, http.StatusOK},
+		{"POST", "/api/add", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"POST", "/api/pause", map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"POST", "/api/skip", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"POST", "/api/resume", map[string]string{"Sec-Fetch-Site": "same-site", "Origin": "http://localhost:3000"}, http.StatusForbidden},
+		{"POST", "/api/resume", map[string]string{"Sec-Fetch-Site": "same-origin", "Origin": "http://127.0.0.1:8420"}, http.StatusOK},
+		{"DELETE", "/api/pending/3", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"GET", "/api/status", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusOK},
+		{"GET", "/add", map[string]string{"Origin": "https://hitomi.la"}, http.StatusOK},
+	} {
+		req := httptest.NewRequest(test.method, "http://127.0.0.1:8420"+test.path, nil)
+		for name, value := range test.header {
+			req.Header.Set(name, value)
+		}
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		if res.Code != test.code {
+			t.Errorf("%s %s %v: %d, want %d", test.method, test.path, test.header, res.Code, test.code)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+// defaultMaxBufferedBytes is used when MaxBufferedBytes is 0.
+const defaultMaxBufferedBytes = 256 << 20
+
+// ByteLimit bounds the bytes of image bodies held in memory between the
+// download workers and the writer. A worker waits with its body until the
+// writer has caught up.
+type ByteLimit struct {
+	lock     sync.Mutex
+	max      int64
+	used     int64
+	released chan struct{}
+}
+
+var buffered = &ByteLimit{max: defaultMaxBufferedBytes, released: make(chan struct{})}
+
+func (l *ByteLimit) SetMax(max int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.max = max
+}
+
+// Acquire reserves n bytes. A body larger than the limit is let through
+// once nothing else is buffered.
+func (l *ByteLimit) Acquire(ctx context.Context, n int64) error {
+	for {
+		l.lock.Lock()
+		if l.used == 0 || l.used+n <= l.max {
+			l.used += n
+			l.lock.Unlock()
+			return nil
+		}
+		released := l.released
+		l.lock.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// TryAcquire reserves n bytes if they fit right now.
+func (l *ByteLimit) TryAcquire(n int64) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.used+n > l.max {
+		return false
+	}
+	l.used += n
+	return true
+}
+
+func (l *ByteLimit) Release(n int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.used -= n
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+func (l *ByteLimit) Used() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.used
+}
+
+// maxPooledBody is the largest buffer kept for reuse, bigger ones are left
+// to the garbage collector.
+const maxPooledBody = 32 << 20
+
+var bodyPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// ReadBody reads r into a buffer from bodyPool, give it back with
+// PutBody once its bytes are no longer used.
+func ReadBody(r io.Reader) (*bytes.Buffer, error) {
+	buf := bodyPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	if _, err := buf.ReadFrom(r); err != nil {
+		PutBody(buf)
+		return nil, err
+	}
+	return buf, nil
+}
+
+func PutBody(buf *bytes.Buffer) {
+	if buf != nil && buf.Cap() <= maxPooledBody {
+		bodyPool.Put(buf)
+	}
+}
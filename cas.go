@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type CASConf struct {
+	// Dir turns content-addressable storage on, relative paths are below
+	// SavePath.
+	Dir string
+	// Link is "hardlink" (default) or "symlink".
+	Link string
+}
+
+// CasStorage stores every image once under its SHA-256 in Dir and links it
+// into the gallery folders, so pages shared by several galleries take up
+// space only once. Other files like manifest.json are saved as usual.
+type CasStorage struct {
+	*LocalStorage
+	Dir     string
+	Symlink bool
+}
+
+func NewCasStorage(local *LocalStorage, conf CASConf) *CasStorage {
+	dir := conf.Dir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(local.Root, dir)
+	}
+	return &CasStorage{LocalStorage: local, Dir: dir, Symlink: conf.Link == "symlink"}
+}
+
+// casExts are the images kept in Dir.
+var casExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".avif": true}
+
+// hardLink is os.Link, replaced by the tests.
+var hardLink = os.Link
+
+func (s *CasStorage) stored(name string) bool {
+	return casExts[strings.ToLower(path.Ext(name))]
+}
+
+// blobPath is where the content with hash is kept, e.g. "ab/abcdef….webp".
+func (s *CasStorage) blobPath(hash string, name string) string {
+	return filepath.Join(s.Dir, hash[:2], hash+path.Ext(name))
+}
+
+func (s *CasStorage) Write(name string, content []byte) error {
+	if !s.stored(name) {
+		return s.LocalStorage.Write(name, content)
+	}
+	blob := s.blobPath(Sha256Sum(content), name)
+	if _, err := os.Stat(blob); err != nil {
+		if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+			return err
+		}
+		tmp := blob + ".tmp"
+		if err := ioutil.WriteFile(tmp, content, s.Mode); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := os.Rename(tmp, blob); err != nil {
+			return err
+		}
+	}
+	return s.link(blob, name)
+}
+
+func (s *CasStorage) WriteStream(name string, r io.Reader) (int64, error) {
+	if !s.stored(name) {
+		return s.LocalStorage.WriteStream(name, r)
+	}
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return 0, err
+	}
+	f, err := ioutil.TempFile(s.Dir, ".blob-")
+	if err != nil {
+		return 0, err
+	}
+	hash := sha256.New()
+	buf := copyBufferPool.Get().([]byte)
+	n, err := io.CopyBuffer(f, io.TeeReader(r, hash), buf)
+	copyBufferPool.Put(buf)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n == 0 {
+		err = errors.New("Empty Body")
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return n, err
+	}
+	blob := s.blobPath(hex.EncodeToString(hash.Sum(nil)), name)
+	if _, err = os.Stat(blob); err == nil {
+		os.Remove(f.Name())
+	} else {
+		if err = os.MkdirAll(filepath.Dir(blob), 0755); err == nil {
+			if err = os.Chmod(f.Name(), s.Mode); err == nil {
+				err = os.Rename(f.Name(), blob)
+			}
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			return n, err
+		}
+	}
+	return n, s.link(blob, name)
+}
+
+// link puts blob at name, replacing what was there. A hardlink which can't
+// be made, like when Dir is on another filesystem, becomes a symlink, or a
+// copy where symlinks can't be made either.
+func (s *CasStorage) link(blob string, name string) error {
+	fileName := filepath.Join(s.Root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	tmp := fileName + ".tmp"
+	os.Remove(tmp)
+	var err error
+	if !s.Symlink {
+		err = hardLink(blob, tmp)
+	}
+	if s.Symlink || err != nil {
+		target := blob
+		if rel, relErr := filepath.Rel(filepath.Dir(fileName), blob); relErr == nil {
+			target = rel
+		}
+		symlinkErr := os.Symlink(target, tmp)
+		switch {
+		case symlinkErr == nil:
+			err = nil
+		case s.Symlink:
+			err = symlinkErr
+		default:
+			err = s.copyBlob(blob, tmp)
+		}
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, fileName)
+}
+
+func (s *CasStorage) copyBlob(blob string, fileName string) error {
+	src, err := os.Open(blob)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, s.Mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
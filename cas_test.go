@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCasStored(t *testing.T) {
+	s := NewCasStorage(&LocalStorage{Root: t.TempDir(), Mode: 0644}, CASConf{Dir: "cas"})
+	for name, want := range map[string]bool{
+		"g/01.jpg":        true,
+		"g/02.JPEG":       true,
+		"g/03.webp":       true,
+		"g/04.avif":       true,
+		"g/video.mp4":     false,
+		"g/manifest.json": false,
+		"g/index.xhtml":   false,
+	} {
+		if got := s.stored(name); got != want {
+			t.Errorf("stored(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestCasOtherFilesystem(t *testing.T) {
+	defer func(link func(string, string) error) { hardLink = link }(hardLink)
+	hardLink = func(oldname, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+	root := t.TempDir()
+	s := NewCasStorage(&LocalStorage{Root: root, Mode: 0644}, CASConf{Dir: "cas"})
+	if err := s.Write("gallery/01.jpg", []byte("page")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(root, "gallery", "01.jpg"))
+	if err != nil || string(data) != "page" {
+		t.Errorf("page = %q, %v", data, err)
+	}
+	blobs, _ := filepath.Glob(filepath.Join(root, "cas", "*", "*.jpg"))
+	if len(blobs) != 1 {
+		t.Errorf("blobs = %v, want one", blobs)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// orphanAge is how long a partial file or empty directory must have been
+// left alone before it counts as left over, so the files of another running
+// instance are not taken away from it.
+const orphanAge = 10 * time.Minute
+
+var noClean = flag.Bool("no-clean", false, "keep the .tmp files and empty directories left in SavePath by crashed runs")
+
+// OrphanReport is what CleanOrphans found.
+type OrphanReport struct {
+	// Removed are the .tmp files and empty directories deleted.
+	Removed []string
+	// Parts are the .part files kept, they are resumed when their gallery is
+	// downloaded again.
+	Parts []string
+}
+
+// CleanOrphans removes the .tmp files and empty directories left under root
+// by crashed runs. Every storage writes through "<name>.tmp" and truncates it
+// when it starts over, so none of them is worth keeping.
+func CleanOrphans(root string, now time.Time) (OrphanReport, error) {
+	var report OrphanReport
+	var dirs []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if now.Sub(info.ModTime()) < orphanAge {
+			return nil
+		}
+		switch {
+		case info.IsDir():
+			if path != root {
+				dirs = append(dirs, path)
+			}
+		case strings.HasSuffix(path, ".tmp"):
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			report.Removed = append(report.Removed, path)
+		case strings.HasSuffix(path, ".part"):
+			report.Parts = append(report.Parts, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	// deepest first, so a directory holding only empty directories goes too
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		if entries, err := ioutil.ReadDir(dir); err == nil && len(entries) == 0 {
+			if os.Remove(dir) == nil {
+				report.Removed = append(report.Removed, dir)
+			}
+		}
+	}
+	return report, nil
+}
+
+// CleanSavePath runs CleanOrphans on the local directories of the storage,
+// unless --no-clean is given.
+func CleanSavePath() {
+	if *noClean {
+		return
+	}
+	var roots []string
+	switch conf.Storage {
+	case "", "local", "zip", "epub", "tar", "tar.zst":
+		roots = append(roots, conf.SavePath)
+		if filepath.IsAbs(conf.CAS.Dir) {
+			roots = append(roots, conf.CAS.Dir)
+		}
+	}
+	for _, root := range roots {
+		report, err := CleanOrphans(root, time.Now())
+		if err != nil {
+			log.Println("Clean " + root + " Fail: " + err.Error())
+		}
+		if len(report.Removed) > 0 {
+			log.Println("Removed " + strconv.Itoa(len(report.Removed)) + " Files And Directories Left By Crashed Runs In " + root)
+		}
+		if len(report.Parts) > 0 {
+			log.Println("Kept " + strconv.Itoa(len(report.Parts)) + " .part Files In " + root + ", They Are Resumed When Their Gallery Is Downloaded Again")
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCleanOrphans(t *testing.T) {
+	root := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	write := func(name string, modTime time.Time) string {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, modTime, modTime)
+		return path
+	}
+	crashed := write("a/001.webp.tmp", old)
+	running := write("b/001.webp.tmp", time.Now())
+	kept := write("a/001.webp", old)
+	part := write("c/video.mp4.part", old)
+	empty := filepath.Join(root, "d", "e")
+	os.MkdirAll(empty, 0755)
+	for _, dir := range []string{"a", "b", "c", "d", "d/e"} {
+		os.Chtimes(filepath.Join(root, dir), old, old)
+	}
+
+	report, err := CleanOrphans(root, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{crashed, empty, filepath.Dir(empty)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s left", path)
+		}
+	}
+	for _, path := range []string{running, kept, part} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s removed", path)
+		}
+	}
+	if len(report.Removed) != 3 || len(report.Parts) != 1 {
+		t.Errorf("report = %+v", report)
+	}
+}
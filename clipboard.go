@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const clipboardInterval = time.Second
+
+var watchClipboard = flag.Bool("watch-clipboard", false, "keep running and queue the hitomi urls copied to the clipboard")
+
+var clipboardUrlRegexp = regexp.MustCompile(`https?://(?:[a-z0-9-]+\.)?hitomi\.la/[^\s"'<>]+`)
+
+// clipboardCommands print the text of the clipboard, the first one installed
+// is used.
+func clipboardCommands() [][]string {
+	switch runtime.GOOS {
+	case "windows":
+		return [][]string{{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard"}}
+	case "darwin":
+		return [][]string{{"pbpaste"}}
+	default:
+		return [][]string{
+			{"wl-paste", "--no-newline"},
+			{"xclip", "-selection", "clipboard", "-o"},
+			{"xsel", "--clipboard", "--output"},
+		}
+	}
+}
+
+func FindClipboardCommand() ([]string, error) {
+	for _, command := range clipboardCommands() {
+		if _, err := exec.LookPath(command[0]); err == nil {
+			return command, nil
+		}
+	}
+	return nil, errors.New("No Clipboard Tool Found, Install wl-clipboard, xclip or xsel")
+}
+
+func ReadClipboard(ctx context.Context, command []string) (string, error) {
+	out, err := exec.CommandContext(ctx, command[0], command[1:]...).Output()
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// HitomiUrls returns the hitomi gallery and search urls in text, gallery urls
+// of any kind (reader, manga, ...) as their plain gallery url.
+func HitomiUrls(text string) []string {
+	var urls []string
+	for _, u := range clipboardUrlRegexp.FindAllString(text, -1) {
+		u = strings.TrimRight(u, ".,;:!?)]}")
+		if IsSearchUrl(u) {
+			urls = append(urls, u)
+			continue
+		}
+		id := GalleryId(u)
+		if _, err := strconv.Atoi(id); err == nil && strings.Contains(u, ".html") {
+			urls = append(urls, GalleryUrl(id))
+		}
+	}
+	return Unique(urls)
+}
+
+// WatchClipboard starts polling the clipboard, the urls copied from then on
+// are added to the daemon, each url only once.
+func WatchClipboard() error {
+	command, err := FindClipboardCommand()
+	if err != nil {
+		return err
+	}
+	log.Println("Watching Clipboard For Hitomi Urls")
+	go pollClipboard(command)
+	return nil
+}
+
+func pollClipboard(command []string) {
+	ticker := time.NewTicker(clipboardInterval)
+	defer ticker.Stop()
+	seen := make(map[string]struct{})
+	var last string
+	started := false
+	for {
+		select {
+		case <-appCtx.Done():
+			return
+		case <-ticker.C:
+		}
+		text, err := ReadClipboard(appCtx, command)
+		if err != nil {
+			// some tools fail on an empty clipboard
+			text = ""
+		}
+		if !started {
+			// what was copied before is not downloaded
+			last, started = text, true
+			continue
+		}
+		if text == last {
+			continue
+		}
+		last = text
+		var urls []string
+		for _, u := range HitomiUrls(text) {
+			if _, ok := seen[u]; !ok {
+				seen[u] = struct{}{}
+				urls = append(urls, u)
+			}
+		}
+		if len(urls) > 0 {
+			log.Println("Clipboard Added " + strconv.Itoa(len(urls)) + " Urls: " + strings.Join(urls, " "))
+			daemon.Add(UrlJobs(urls))
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHitomiUrls(t *testing.T) {
+	text := "look (https://hitomi.la/reader/1234.html#2), " +
+		"https://hitomi.la/manga/some-title-1234.html\n" +
+		"https://hitomi.la/tag/female:glasses-all.html " +
+		"https://hitomi.la/search.html?female:glasses " +
+		"https://example.com/galleries/99.html https://hitomi.la/galleries/5678.html."
+	want := []string{
+		"https://hitomi.la/galleries/1234.html",
+		"https://hitomi.la/search.html?female:glasses",
+		"https://hitomi.la/galleries/5678.html",
+	}
+	if got := HitomiUrls(text); !reflect.DeepEqual(got, want) {
+		t.Errorf("HitomiUrls = %v, want %v", got, want)
+	}
+}
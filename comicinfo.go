@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/xml"
+	"image"
+	"strconv"
+	"strings"
+)
+
+const comicInfoName = "ComicInfo.xml"
+
+// spreadRatio is how much wider than high a page must be to be a double
+// page spread.
+const spreadRatio = 1.2
+
+type ComicInfo struct {
+	XMLName     xml.Name        `xml:"ComicInfo"`
+	Title       string          `xml:"Title,omitempty"`
+	Year        int             `xml:"Year,omitempty"`
+	Month       int             `xml:"Month,omitempty"`
+	Day         int             `xml:"Day,omitempty"`
+	Writer      string          `xml:"Writer,omitempty"`
+	Genre       string          `xml:"Genre,omitempty"`
+	Tags        string          `xml:"Tags,omitempty"`
+	Web         string          `xml:"Web,omitempty"`
+	PageCount   int             `xml:"PageCount"`
+	LanguageISO string          `xml:"LanguageISO,omitempty"`
+	Characters  string          `xml:"Characters,omitempty"`
+	Manga       string          `xml:"Manga"`
+	Pages       []ComicInfoPage `xml:"Pages>Page"`
+}
+
+type ComicInfoPage struct {
+	Image       int    `xml:"Image,attr"`
+	Type        string `xml:"Type,attr,omitempty"`
+	DoublePage  bool   `xml:"DoublePage,attr,omitempty"`
+	ImageWidth  int    `xml:"ImageWidth,attr,omitempty"`
+	ImageHeight int    `xml:"ImageHeight,attr,omitempty"`
+}
+
+// IsSpread tells if a page of size is two pages side by side.
+func IsSpread(size image.Point) bool {
+	return size.Y > 0 && float64(size.X) > float64(size.Y)*spreadRatio
+}
+
+// ComicInfoManga is the Manga value of ComicInfo.xml: right-to-left for
+// direction "rtl", otherwise whether the gallery is a manga at all.
+func ComicInfoManga(galleryType string, direction string) string {
+	if direction == "" || direction == "rtl" {
+		return "YesAndRightToLeft"
+	}
+	if galleryType == "manga" || galleryType == "doujinshi" {
+		return "Yes"
+	}
+	return "No"
+}
+
+// writeComicInfo writes the ComicInfo.xml of a CBZ, with the reading
+// direction and the double page spreads for the reader to lay them out.
+func (a *zipArchive) writeComicInfo(book ArchiveBook, direction string) error {
+	pages := a.readingOrder(book)
+	info := ComicInfo{
+		Title:       book.Title,
+		Writer:      strings.Join(book.Authors, ", "),
+		Genre:       book.Type,
+		Tags:        strings.Join(book.Tags, ", "),
+		Web:         book.Url,
+		PageCount:   len(pages),
+		LanguageISO: book.Lang,
+		Characters:  strings.Join(book.Characters, ", "),
+		Manga:       ComicInfoManga(book.Type, direction),
+	}
+	if len(book.Date) >= 10 {
+		info.Year, _ = strconv.Atoi(book.Date[:4])
+		info.Month, _ = strconv.Atoi(book.Date[5:7])
+		info.Day, _ = strconv.Atoi(book.Date[8:10])
+	}
+	for i, name := range pages {
+		size := a.sizes[name]
+		page := ComicInfoPage{Image: i, DoublePage: IsSpread(size), ImageWidth: size.X, ImageHeight: size.Y}
+		if i == 0 {
+			page.Type = "FrontCover"
+		}
+		info.Pages = append(info.Pages, page)
+	}
+	data, err := xml.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return a.writeGenerated(comicInfoName, xml.Header+string(data)+"\n")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image"
+	"image/png"
+	"os"
+	"strings"
+	"testing"
+)
+
+func testPng(t *testing.T, width int, height int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writeTestArchive(t *testing.T, s *ZipStorage) {
+	for name, content := range map[string][]byte{
+		"gallery/01.png": testPng(t, 70, 100),
+		"gallery/02.png": testPng(t, 140, 100),
+		"gallery/03.png": testPng(t, 70, 100),
+	} {
+		if err := s.Write(name, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Describe("gallery", Gallery{Id: "1234", Title: "Test", Type: "manga", Date: "2020-03-04 00:00:00-06"}, []string{"01.png", "02.png", "03.png"})
+	if err := s.Finalize("gallery"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestComicInfo(t *testing.T) {
+	s := &ZipStorage{Root: t.TempDir(), Mode: 0644, Ext: ".cbz", archives: make(map[string]*zipArchive),
+		indexes: make(map[string]map[string]os.FileInfo), books: make(map[string]ArchiveBook)}
+	writeTestArchive(t, s)
+	data, err := s.Read("gallery/" + comicInfoName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info ComicInfo
+	if err = xml.Unmarshal(data, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Manga != "YesAndRightToLeft" || info.PageCount != 3 || info.Year != 2020 || info.Month != 3 || info.Day != 4 {
+		t.Errorf("got %+v", info)
+	}
+	for i, page := range info.Pages {
+		if page.DoublePage != (i == 1) {
+			t.Errorf("page %d DoublePage = %v", i, page.DoublePage)
+		}
+	}
+
+	// finalizing again must not leave two ComicInfo.xml in the archive
+	s.Write("gallery/04.png", testPng(t, 70, 100))
+	s.Describe("gallery", Gallery{Id: "1234", Title: "Test"}, nil)
+	if err = s.Finalize("gallery"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = s.Read("gallery/" + comicInfoName); err != nil || !strings.Contains(string(data), "<PageCount>4</PageCount>") {
+		t.Errorf("ComicInfo.xml after adding a page: %s %v", data, err)
+	}
+}
+
+func TestEpubSpreads(t *testing.T) {
+	s := &ZipStorage{Root: t.TempDir(), Mode: 0644, Ext: ".epub", Epub: true, archives: make(map[string]*zipArchive),
+		indexes: make(map[string]map[string]os.FileInfo), books: make(map[string]ArchiveBook)}
+	writeTestArchive(t, s)
+	opf, err := s.Read("gallery/content.opf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(opf), `<itemref idref="page-1" properties="rendition:page-spread-center"/>`) ||
+		strings.Count(string(opf), "page-spread-center") != 1 {
+		t.Errorf("content.opf doesn't mark just the second page as a spread:\n%s", opf)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// commands are the first arguments main understands.
+var commands = []string{"init", "info", "stats", "dupes", "export", "search-local", "serve", "add", "userscript", "ctl", "sync", "verify", "repair", "retry-failed", "doctor", "estimate", "install-service", "service", "completion"}
+
+// commandArgs are the words completed after a command.
+var commandArgs = map[string][]string{
+	"ctl":        {"pause", "resume", "skip", "status", "add"},
+	"completion": {"bash", "zsh", "fish", "powershell"},
+}
+
+// flagValues are the words completed after a flag, nil for a file name.
+var flagValues = map[string][]string{
+	"config":   nil,
+	"jobs":     nil,
+	"priority": {"high", "normal", "low"},
+	"output":   {"text", "json"},
+}
+
+type completionFlag struct {
+	Name   string
+	Usage  string
+	Value  bool
+	Values []string
+}
+
+func completionFlags() []completionFlag {
+	var flags []completionFlag
+	flag.VisitAll(func(f *flag.Flag) {
+		values, ok := flagValues[f.Name]
+		if !ok {
+			if bf, isBool := f.Value.(interface{ IsBoolFlag() bool }); !isBool || !bf.IsBoolFlag() {
+				ok = true
+			}
+		}
+		flags = append(flags, completionFlag{Name: f.Name, Usage: f.Usage, Value: ok, Values: values})
+	})
+	return flags
+}
+
+func programName() string {
+	return strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+}
+
+// Completion returns the completion script of shell.
+func Completion(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return bashCompletion(programName()), nil
+	case "zsh":
+		return zshCompletion(programName()), nil
+	case "fish":
+		return fishCompletion(programName()), nil
+	case "powershell":
+		return powershellCompletion(programName()), nil
+	}
+	return "", errors.New("Usage: completion bash|zsh|fish|powershell")
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func bashCompletion(name string) string {
+	fn := "_" + strings.Replace(name, "-", "_", -1)
+	var b strings.Builder
+	b.WriteString(fn + "() {\n")
+	b.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	b.WriteString("    case \"$prev\" in\n")
+	var allFlags []string
+	for _, f := range completionFlags() {
+		allFlags = append(allFlags, "--"+f.Name)
+		if !f.Value {
+			continue
+		}
+		if f.Values == nil {
+			b.WriteString("        -" + f.Name + "|--" + f.Name + ") COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n")
+		} else {
+			b.WriteString("        -" + f.Name + "|--" + f.Name + ") COMPREPLY=($(compgen -W \"" + strings.Join(f.Values, " ") + "\" -- \"$cur\")); return ;;\n")
+		}
+	}
+	for _, command := range sortedKeys(commandArgs) {
+		b.WriteString("        " + command + ") COMPREPLY=($(compgen -W \"" + strings.Join(commandArgs[command], " ") + "\" -- \"$cur\")); return ;;\n")
+	}
+	b.WriteString("    esac\n")
+	b.WriteString("    if [[ \"$cur\" == -* ]]; then\n")
+	b.WriteString("        COMPREPLY=($(compgen -W \"" + strings.Join(allFlags, " ") + "\" -- \"$cur\")); return\n")
+	b.WriteString("    fi\n")
+	b.WriteString("    local word\n")
+	b.WriteString("    for word in \"${COMP_WORDS[@]:1:COMP_CWORD-1}\"; do\n")
+	b.WriteString("        case \"$word\" in\n")
+	b.WriteString("            " + strings.Join(commands, "|") + ") return ;;\n")
+	b.WriteString("        esac\n")
+	b.WriteString("    done\n")
+	b.WriteString("    COMPREPLY=($(compgen -W \"" + strings.Join(commands, " ") + "\" -- \"$cur\"))\n")
+	b.WriteString("}\n")
+	b.WriteString("complete -F " + fn + " " + name + " " + name + ".exe\n")
+	return b.String()
+}
+
+func zshQuote(s string) string {
+	r := strings.NewReplacer("'", "'\\''", "[", "\\[", "]", "\\]", ":", "\\:")
+	return r.Replace(s)
+}
+
+func zshCompletion(name string) string {
+	var b strings.Builder
+	b.WriteString("#compdef " + name + "\n\n")
+	b.WriteString("_" + name + "() {\n")
+	b.WriteString("    local state\n")
+	b.WriteString("    _arguments \\\n")
+	for _, f := range completionFlags() {
+		spec := "'--" + f.Name + "[" + zshQuote(f.Usage) + "]"
+		if f.Value {
+			if f.Values == nil {
+				spec += ":file:_files"
+			} else {
+				spec += ":" + f.Name + ":(" + strings.Join(f.Values, " ") + ")"
+			}
+		}
+		b.WriteString("        " + spec + "' \\\n")
+	}
+	b.WriteString("        '1:command:(" + strings.Join(commands, " ") + ")' \\\n")
+	b.WriteString("        '*::arg:->args'\n")
+	b.WriteString("    case $state in\n")
+	b.WriteString("    args)\n")
+	b.WriteString("        case $words[1] in\n")
+	for _, command := range sortedKeys(commandArgs) {
+		b.WriteString("        " + command + ") _values " + command + " " + strings.Join(commandArgs[command], " ") + " ;;\n")
+	}
+	b.WriteString("        esac\n")
+	b.WriteString("    esac\n")
+	b.WriteString("}\n\n")
+	b.WriteString("_" + name + " \"$@\"\n")
+	return b.String()
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.Replace(strings.Replace(s, "\\", "\\\\", -1), "'", "\\'", -1) + "'"
+}
+
+func fishCompletion(name string) string {
+	var b strings.Builder
+	b.WriteString("complete -c " + name + " -f\n")
+	for _, f := range completionFlags() {
+		line := "complete -c " + name + " -l " + f.Name
+		if f.Value {
+			if f.Values == nil {
+				line += " -r -F"
+			} else {
+				line += " -x -a " + fishQuote(strings.Join(f.Values, " "))
+			}
+		}
+		b.WriteString(line + " -d " + fishQuote(f.Usage) + "\n")
+	}
+	b.WriteString("complete -c " + name + " -n '__fish_use_subcommand' -a " + fishQuote(strings.Join(commands, " ")) + "\n")
+	for _, command := range sortedKeys(commandArgs) {
+		b.WriteString("complete -c " + name + " -n '__fish_seen_subcommand_from " + command + "' -a " + fishQuote(strings.Join(commandArgs[command], " ")) + "\n")
+	}
+	return b.String()
+}
+
+func powershellList(words []string) string {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		quoted = append(quoted, "'"+strings.Replace(word, "'", "''", -1)+"'")
+	}
+	return "@(" + strings.Join(quoted, ", ") + ")"
+}
+
+func powershellCompletion(name string) string {
+	var b strings.Builder
+	b.WriteString("Register-ArgumentCompleter -Native -CommandName '" + name + "', '" + name + ".exe' -ScriptBlock {\n")
+	b.WriteString("    param($wordToComplete, $commandAst, $cursorPosition)\n")
+	b.WriteString("    $words = @($commandAst.CommandElements | ForEach-Object { $_.ToString() })\n")
+	b.WriteString("    if ($wordToComplete -ne '') { $words = $words[0..($words.Count - 2)] }\n")
+	b.WriteString("    $prev = $words[-1]\n")
+	b.WriteString("    $candidates = " + powershellList(commands) + "\n")
+	b.WriteString("    if (@($words | Where-Object { $candidates -contains $_ }).Count -gt 0) { $candidates = @() }\n")
+	var allFlags []string
+	for _, f := range completionFlags() {
+		allFlags = append(allFlags, "--"+f.Name)
+		if f.Value && f.Values != nil {
+			b.WriteString("    if ($prev -eq '--" + f.Name + "' -or $prev -eq '-" + f.Name + "') { $candidates = " + powershellList(f.Values) + " }\n")
+		} else if f.Value {
+			b.WriteString("    if ($prev -eq '--" + f.Name + "' -or $prev -eq '-" + f.Name + "') { return }\n")
+		}
+	}
+	for _, command := range sortedKeys(commandArgs) {
+		b.WriteString("    if ($prev -eq '" + command + "') { $candidates = " + powershellList(commandArgs[command]) + " }\n")
+	}
+	b.WriteString("    if ($wordToComplete.StartsWith('-')) { $candidates = " + powershellList(allFlags) + " }\n")
+	b.WriteString("    $candidates | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {\n")
+	b.WriteString("        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)\n")
+	b.WriteString("    }\n")
+	b.WriteString("}\n")
+	return b.String()
+}
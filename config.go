@@ -0,0 +1,474 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const envPrefix = "HITOMI_"
+
+var configFlag = flag.String("config", "", "path of the config file (.json, .yaml or .toml)")
+
+var profileFlag = flag.String("profile", "", "name of the Profiles entry of the config to apply on top of it")
+
+var configNames = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+// FindConfig returns the --config path, or the first config file found in the
+// working directory, then in the XDG config directory.
+func FindConfig() string {
+	if *configFlag != "" {
+		return *configFlag
+	}
+	dirs := []string{"."}
+	if dir := ConfigDir(); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	for _, dir := range dirs {
+		for _, name := range configNames {
+			p := filepath.Join(dir, name)
+			if _, err := os.Stat(p); err == nil {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+func ConfigDir() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "hitomi-go")
+}
+
+// ReadConfig reads a json, yaml or toml file into conf. Keys are the field
+// names of Conf, like in config.json.
+func ReadConfig(fileName string, conf *Conf) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	var values map[string]interface{}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".json":
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return errors.New("Unknown Config Format: " + fileName)
+	}
+	if err != nil {
+		return err
+	}
+	if values != nil {
+		if data, err = json.Marshal(values); err != nil {
+			return err
+		}
+	}
+	if err = json.Unmarshal(data, conf); err != nil {
+		return err
+	}
+	return ApplyProfile(conf, *profileFlag)
+}
+
+// ApplyProfile sets the keys of the profile name of Profiles on conf, the
+// others keep their value. Maps like Headers are merged.
+func ApplyProfile(conf *Conf, name string) error {
+	if name == "" {
+		return nil
+	}
+	profile, ok := conf.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(conf.Profiles))
+		for n := range conf.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return errors.New("Unknown Profile: " + name + " (Profiles: " + strings.Join(names, ", ") + ")")
+	}
+	if err := json.Unmarshal(profile, conf); err != nil {
+		return errors.New("Profile " + name + ": " + err.Error())
+	}
+	log.Println("Using Profile: " + name)
+	return nil
+}
+
+// ApplyEnv overrides conf with HITOMI_* environment variables, like
+// HITOMI_SAVEPATH or HITOMI_S3_BUCKET. Lists are comma separated and maps
+// are written as "key=value,key=value", lists and maps of settings, like
+// HITOMI_WEBHOOKS, as JSON.
+func ApplyEnv(conf *Conf) error {
+	return applyEnv(reflect.ValueOf(conf).Elem(), envPrefix)
+}
+
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		name := prefix + strings.ToUpper(t.Field(i).Name)
+		if name == envPrefix+"PROFILES" {
+			continue
+		}
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, name+"_"); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			return errors.New("Invalid " + name + ": " + err.Error())
+		}
+		log.Println("Config From Env: " + name)
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			// lists of settings, like Webhooks, are written as JSON
+			return json.Unmarshal([]byte(value), field.Addr().Interface())
+		}
+		list := reflect.MakeSlice(field.Type(), 0, 0)
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				list = reflect.Append(list, reflect.ValueOf(s).Convert(field.Type().Elem()))
+			}
+		}
+		field.Set(list)
+	case reflect.Map:
+		if field.Type().Key().Kind() != reflect.String || !scalarKind(field.Type().Elem().Kind()) {
+			return json.Unmarshal([]byte(value), field.Addr().Interface())
+		}
+		m := reflect.MakeMap(field.Type())
+		for _, pair := range strings.Split(value, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return errors.New("expected key=value, got " + pair)
+			}
+			elem := reflect.New(field.Type().Elem()).Elem()
+			if err := setField(elem, strings.TrimSpace(kv[1])); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(kv[0])).Convert(field.Type().Key()), elem)
+		}
+		field.Set(m)
+	default:
+		return errors.New("unsupported type")
+	}
+	return nil
+}
+
+func scalarKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Slice, reflect.Map, reflect.Struct, reflect.Array, reflect.Ptr, reflect.Interface:
+		return false
+	}
+	return true
+}
+
+// ValidateConfig checks conf before anything is started and returns one
+// message per wrong field, saying why it is wrong.
+func ValidateConfig(conf Conf) []string {
+	var problems []string
+	add := func(field string, why string) {
+		problems = append(problems, field+": "+why)
+	}
+
+	switch conf.Storage {
+	case "", "local", "zip", "epub", "tar", "tar.zst":
+		if conf.SavePath == "" {
+			add("SavePath", "is empty, set it to the folder to save galleries in")
+		} else if err := CheckWritable(conf.SavePath); err != nil {
+			add("SavePath", "is not writable ("+err.Error()+")")
+		}
+	case "s3":
+		if conf.S3.Bucket == "" {
+			add("S3.Bucket", "is empty")
+		}
+		switch conf.S3.Archive {
+		case "", "zip", "epub", "tar", "tar.zst":
+		default:
+			add("S3.Archive", "must be zip, epub, tar or tar.zst, got "+strconv.Quote(conf.S3.Archive))
+		}
+	case "webdav":
+		if _, err := url.Parse(conf.WebDAV.Url); err != nil || conf.WebDAV.Url == "" {
+			add("WebDAV.Url", "is not a valid url")
+		}
+	case "sftp":
+		if conf.SFTP.Host == "" {
+			add("SFTP.Host", "is empty")
+		}
+	default:
+		add("Storage", "must be one of local, zip, epub, tar, tar.zst, s3, webdav or sftp, got "+strconv.Quote(conf.Storage))
+	}
+	if conf.CAS.Dir != "" && conf.Storage != "" && conf.Storage != "local" {
+		add("CAS.Dir", "is set but content-addressable storage needs Storage \"local\"")
+	}
+	switch conf.CAS.Link {
+	case "", "hardlink", "symlink":
+	default:
+		add("CAS.Link", "must be \"hardlink\" or \"symlink\", got "+strconv.Quote(conf.CAS.Link))
+	}
+	if conf.EHentai.ExHentai && len(conf.EHentai.Cookies) == 0 {
+		add("EHentai.Cookies", "are empty but exhentai.org needs the cookies of a logged in account")
+	}
+	if conf.Komga.Url != "" {
+		if _, err := url.Parse(conf.Komga.Url); err != nil {
+			add("Komga.Url", "is not a valid url")
+		}
+		if conf.Komga.LibraryId == "" {
+			add("Komga.LibraryId", "is empty, copy it from the library url in Komga")
+		}
+		switch conf.Storage {
+		case "zip", "epub":
+		default:
+			add("Komga.Url", "is set but Komga only reads archives, set Storage to \"zip\" or \"epub\"")
+		}
+	}
+	for _, webhook := range conf.Webhooks {
+		if u, err := url.Parse(webhook.Url); err != nil || u.Host == "" {
+			add("Webhooks", "can't parse url "+strconv.Quote(webhook.Url))
+		}
+		for _, event := range webhook.Events {
+			switch event {
+			case EventGalleryStarted, EventGalleryCompleted, EventGalleryFailed, EventBatchFinished:
+			default:
+				add("Webhooks", "unknown event "+strconv.Quote(event))
+			}
+		}
+	}
+	if conf.Listen != "" && conf.Listen != "off" {
+		if _, _, err := net.SplitHostPort(conf.Listen); err != nil {
+			add("Listen", "must be host:port like \"127.0.0.1:8080\" or \"off\", got "+strconv.Quote(conf.Listen))
+		}
+	}
+	if (conf.ListenCert == "") != (conf.ListenKey == "") {
+		add("ListenCert", "and ListenKey must be set together")
+	}
+	if conf.ApiUser != "" && conf.ApiPassword == "" {
+		add("ApiPassword", "is empty but ApiUser is set")
+	}
+	for field, expr := range map[string]string{"Schedule.Sync": conf.Schedule.Sync, "Schedule.Run": conf.Schedule.Run} {
+		if expr == "" {
+			continue
+		}
+		if _, err := ParseCron(expr); err != nil {
+			add(field, "can't parse cron expression "+strconv.Quote(expr)+": "+err.Error())
+		}
+	}
+	for i, rule := range conf.Schedule.Speed {
+		field := "Schedule.Speed[" + strconv.Itoa(i) + "]"
+		if _, err := ParseCron(rule.When); err != nil {
+			add(field+".When", "can't parse cron expression "+strconv.Quote(rule.When)+": "+err.Error())
+		}
+		if rule.MaxSpeed < 0 {
+			add(field+".MaxSpeed", "must not be negative")
+		}
+	}
+	if conf.FileMode != "" {
+		if _, err := strconv.ParseUint(conf.FileMode, 8, 32); err != nil {
+			add("FileMode", "must be an octal permission like \"0644\", got "+strconv.Quote(conf.FileMode))
+		}
+	}
+
+	if conf.Retry < 0 || conf.Retry > 100 {
+		add("Retry", "must be between 0 and 100, got "+strconv.Itoa(conf.Retry))
+	}
+	if conf.RequestRetry < 0 || conf.RequestRetry > 100 {
+		add("RequestRetry", "must be between 0 (Retry) and 100, got "+strconv.Itoa(conf.RequestRetry))
+	}
+	if conf.ThreadNum < 0 || conf.ThreadNum > 256 {
+		add("ThreadNum", "must be between 0 (one per cpu) and 256, got "+strconv.Itoa(conf.ThreadNum))
+	}
+	if conf.ConvertThreadNum < 0 {
+		add("ConvertThreadNum", "must not be negative")
+	}
+	if conf.HashThreadNum < 0 {
+		add("HashThreadNum", "must not be negative")
+	}
+	for field, value := range map[string]int{
+		"GalleryTimeout":     conf.GalleryTimeout,
+		"ConnectTimeout":     conf.ConnectTimeout,
+		"ReadTimeout":        conf.ReadTimeout,
+		"RequestTimeout":     conf.RequestTimeout,
+		"ImageTimeout":       conf.ImageTimeout,
+		"MaxConnsPerHost":    conf.MaxConnsPerHost,
+		"MaxWidth":           conf.MaxWidth,
+		"MaxDimension":       conf.MaxDimension,
+		"RateLimitHits":      conf.RateLimitHits,
+		"RateLimitCooldown":  conf.RateLimitCooldown,
+		"MaxSpeed":           conf.MaxSpeed,
+		"VolumePages":        conf.VolumePages,
+		"MetadataThreads":    conf.MetadataThreads,
+		"ImageAttempts":      conf.ImageAttempts,
+		"GalleryMaxFailures": conf.GalleryMaxFailures,
+	} {
+		if value < 0 {
+			add(field, "must not be negative, got "+strconv.Itoa(value))
+		}
+	}
+
+	for host, rate := range conf.RequestsPerSecond {
+		if rate < 0 {
+			add("RequestsPerSecond."+host, "must not be negative")
+		}
+	}
+
+	if conf.GalleryFailPercent < 0 || conf.GalleryFailPercent > 100 {
+		add("GalleryFailPercent", "must be between 0 (off) and 100, got "+strconv.Itoa(conf.GalleryFailPercent))
+	}
+	switch conf.GalleryFailAction {
+	case "", "keep", "remove", "quarantine":
+	default:
+		add("GalleryFailAction", "must be \"keep\", \"remove\" or \"quarantine\", got "+strconv.Quote(conf.GalleryFailAction))
+	}
+
+	if conf.MaxBufferedBytes < 0 {
+		add("MaxBufferedBytes", "must not be negative")
+	}
+
+	if problem := DnsServerProblem(conf.DnsServer); problem != "" {
+		add("DnsServer", problem)
+	}
+	switch conf.NetworkPreference {
+	case "", "auto", "ipv4", "ipv6":
+	default:
+		add("NetworkPreference", "must be \"auto\", \"ipv4\" or \"ipv6\", got "+strconv.Quote(conf.NetworkPreference))
+	}
+	if conf.Tls.CaFile != "" {
+		if _, err := os.Stat(conf.Tls.CaFile); err != nil {
+			add("Tls.CaFile", "can't be read ("+err.Error()+")")
+		}
+	}
+	if _, err := url.Parse(MetadataUrl("")); err != nil {
+		add("MetadataHost", "must be a host or a url, got "+strconv.Quote(conf.MetadataHost))
+	}
+	if strings.Contains(conf.ImageDomain, "/") || strings.HasPrefix(conf.ImageDomain, ".") {
+		add("ImageDomain", "must be a domain like \"hitomi.la\", got "+strconv.Quote(conf.ImageDomain))
+	}
+	if conf.Socks != "" {
+		if _, _, err := net.SplitHostPort(conf.Socks); err != nil {
+			add("Socks", "must be \"host:port\" or empty, got "+strconv.Quote(conf.Socks))
+		}
+	}
+	for _, addr := range conf.Proxies {
+		if _, err := ParseProxyUrl(addr); err != nil {
+			add("Proxies", "can't parse "+strconv.Quote(addr)+" ("+err.Error()+")")
+		}
+	}
+	switch conf.UserAgentRotation {
+	case "", "request", "gallery":
+	default:
+		add("UserAgentRotation", "must be \"request\" or \"gallery\", got "+strconv.Quote(conf.UserAgentRotation))
+	}
+
+	switch conf.Duplicates {
+	case "", "warn", "skip":
+	default:
+		add("Duplicates", "must be \"warn\" or \"skip\", got "+strconv.Quote(conf.Duplicates))
+	}
+	switch conf.TitlePreference {
+	case "", "japanese", "english", "romaji":
+	default:
+		add("TitlePreference", "must be \"japanese\", \"english\" or \"romaji\", got "+strconv.Quote(conf.TitlePreference))
+	}
+	switch conf.ReadingDirection {
+	case "", "rtl", "ltr":
+	default:
+		add("ReadingDirection", "must be \"rtl\" or \"ltr\", got "+strconv.Quote(conf.ReadingDirection))
+	}
+	if conf.ConvertTo != "" && ConvertExt(conf.ConvertTo) == "" {
+		add("ConvertTo", "must be \"jpeg\" or \"png\", got "+strconv.Quote(conf.ConvertTo))
+	}
+	if conf.ConvertQuality < 0 || conf.ConvertQuality > 100 {
+		add("ConvertQuality", "must be between 1 and 100, got "+strconv.Itoa(conf.ConvertQuality))
+	}
+	if _, err := CompilePathTemplate(conf.PathTemplate, conf.Layout); err != nil {
+		if conf.PathTemplate == "" {
+			add("Layout", err.Error())
+		} else {
+			add("PathTemplate", err.Error())
+		}
+	}
+	if conf.Filter != "" {
+		if _, err := CompileFilter(conf.Filter); err != nil {
+			add("Filter", err.Error())
+		}
+	}
+	if conf.TagTranslation != "" {
+		if _, err := LoadTagTranslation(conf.TagTranslation); err != nil {
+			add("TagTranslation", err.Error())
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// CheckWritable creates dir when needed and makes sure a file can be
+// written into it.
+func CheckWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".write-check-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
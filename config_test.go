@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testProfilesYaml = `SavePath: ./archive/
+ConvertTo: ""
+Headers:
+  X-A: a
+Profiles:
+  phone:
+    SavePath: ./phone/
+    Storage: zip
+    MaxWidth: 1080
+    Headers:
+      X-B: b
+`
+
+func TestReadConfigProfile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(fileName, []byte(testProfilesYaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(profile string) { *profileFlag = profile }(*profileFlag)
+
+	*profileFlag = ""
+	var base Conf
+	if err := ReadConfig(fileName, &base); err != nil {
+		t.Fatal(err)
+	}
+	if base.SavePath != "./archive/" || base.MaxWidth != 0 || len(base.Profiles) != 1 {
+		t.Errorf("without a profile got %+v", base)
+	}
+
+	*profileFlag = "phone"
+	var phone Conf
+	if err := ReadConfig(fileName, &phone); err != nil {
+		t.Fatal(err)
+	}
+	if phone.SavePath != "./phone/" || phone.Storage != "zip" || phone.MaxWidth != 1080 {
+		t.Errorf("with the phone profile got %+v", phone)
+	}
+	if phone.Headers["X-A"] != "a" || phone.Headers["X-B"] != "b" {
+		t.Errorf("Headers = %v, want both merged", phone.Headers)
+	}
+
+	*profileFlag = "tablet"
+	if err := ReadConfig(fileName, &Conf{}); err == nil {
+		t.Error("want an error for an unknown profile")
+	}
+}
+
+// sampleValue is an env value setField accepts for a field of kind.
+func sampleValue(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "x"
+	case reflect.Bool:
+		return "true"
+	case reflect.Float32, reflect.Float64:
+		return "1.5"
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.String {
+			return "a, b"
+		}
+		return "[]"
+	case reflect.Map:
+		if scalarKind(t.Elem().Kind()) {
+			return "k=" + sampleValue(t.Elem())
+		}
+		return "{}"
+	}
+	return "3"
+}
+
+func TestSetFieldEveryConfKind(t *testing.T) {
+	var walk func(v reflect.Value, name string)
+	walk = func(v reflect.Value, name string) {
+		for i := 0; i < v.NumField(); i++ {
+			field, fieldName := v.Field(i), name+v.Type().Field(i).Name
+			if fieldName == "Profiles" {
+				continue
+			}
+			if field.Kind() == reflect.Struct {
+				walk(field, fieldName+".")
+				continue
+			}
+			value := sampleValue(field.Type())
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("%s (%s) = %q panics: %v", fieldName, field.Type(), value, r)
+					}
+				}()
+				if err := setField(field, value); err != nil {
+					t.Errorf("%s (%s) = %q: %v", fieldName, field.Type(), value, err)
+				}
+			}()
+		}
+	}
+	var c Conf
+	walk(reflect.ValueOf(&c).Elem(), "")
+}
+
+func TestApplyEnv(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		value string
+		check func(c Conf) bool
+	}{
+		{"HITOMI_SAVEPATH", "/data", func(c Conf) bool { return c.SavePath == "/data" }},
+		{"HITOMI_THREADNUM", "8", func(c Conf) bool { return c.ThreadNum == 8 }},
+		{"HITOMI_MAXBUFFEREDBYTES", "1073741824", func(c Conf) bool { return c.MaxBufferedBytes == 1<<30 }},
+		{"HITOMI_PROXIES", "host1:1080, host2:1080", func(c Conf) bool {
+			return reflect.DeepEqual(c.Proxies, []string{"host1:1080", "host2:1080"})
+		}},
+		{"HITOMI_HEADERS", "X-A=a,X-B=b", func(c Conf) bool { return c.Headers["X-A"] == "a" && c.Headers["X-B"] == "b" }},
+		{"HITOMI_WEBHOOKS", `[{"Url":"https://example.com/hook","Events":["gallery.finished"]}]`, func(c Conf) bool {
+			return len(c.Webhooks) == 1 && c.Webhooks[0].Url == "https://example.com/hook" && len(c.Webhooks[0].Events) == 1
+		}},
+		{"HITOMI_SCHEDULE_SPEED", `[{"When":"* 9-17 * * 1-5","MaxSpeed":512}]`, func(c Conf) bool {
+			return len(c.Schedule.Speed) == 1 && c.Schedule.Speed[0].MaxSpeed == 512
+		}},
+	} {
+		os.Setenv(c.name, c.value)
+		var conf Conf
+		err := ApplyEnv(&conf)
+		os.Unsetenv(c.name)
+		if err != nil {
+			t.Errorf("%s=%s: %v", c.name, c.value, err)
+		} else if !c.check(conf) {
+			t.Errorf("%s=%s: got %+v", c.name, c.value, conf)
+		}
+	}
+
+	os.Setenv("HITOMI_WEBHOOKS", "not json")
+	defer os.Unsetenv("HITOMI_WEBHOOKS")
+	if err := ApplyEnv(&Conf{}); err == nil {
+		t.Error("HITOMI_WEBHOOKS that is not JSON is accepted")
+	}
+}
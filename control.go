@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+)
+
+// Gate holds back the downloads while paused.
+type Gate struct {
+	lock   sync.Mutex
+	paused bool
+	resume chan struct{}
+}
+
+var pause = &Gate{}
+
+func (g *Gate) Pause() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if !g.paused {
+		g.paused = true
+		g.resume = make(chan struct{})
+	}
+}
+
+func (g *Gate) Resume() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.paused {
+		g.paused = false
+		close(g.resume)
+	}
+}
+
+func (g *Gate) Paused() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.paused
+}
+
+func (g *Gate) Wait(ctx context.Context) error {
+	g.lock.Lock()
+	if !g.paused {
+		g.lock.Unlock()
+		return nil
+	}
+	resume := g.resume
+	g.lock.Unlock()
+	select {
+	case <-resume:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ControlRequest is one JSON line sent to the control socket.
+type ControlRequest struct {
+	Command  string   `json:"command"`
+	Args     []string `json:"args,omitempty"`
+	Priority string   `json:"priority,omitempty"`
+}
+
+type ControlResponse struct {
+	Error   string        `json:"error,omitempty"`
+	Message string        `json:"message,omitempty"`
+	Status  *DaemonStatus `json:"status,omitempty"`
+}
+
+// controlListener accepts the connections of ctl, on a Unix socket or on a
+// named pipe on Windows.
+type controlListener interface {
+	Accept() (io.ReadWriteCloser, error)
+}
+
+// ControlSocketPath is ControlSocket, by default hitomi-go.sock in a
+// directory of the user below the temp dir, or a named pipe on Windows.
+func ControlSocketPath() string {
+	if conf.ControlSocket != "" {
+		return conf.ControlSocket
+	}
+	return defaultControlPath()
+}
+
+// ServeControl accepts the commands of ctl on the control socket.
+func (d *Daemon) ServeControl() {
+	path := ControlSocketPath()
+	listener, err := listenControl(path)
+	if err != nil {
+		log.Println("Control Socket Fail: " + path + " Because " + err.Error())
+		return
+	}
+	log.Println("Control Socket On " + path)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Println("Control Socket Fail: " + err.Error())
+			return
+		}
+		go d.handleControl(conn)
+	}
+}
+
+func (d *Daemon) handleControl(conn io.ReadWriteCloser) {
+	defer conn.Close()
+	var req ControlRequest
+	var res ControlResponse
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, &req)
+	}
+	if err == nil {
+		res, err = d.Control(req)
+	}
+	if err != nil {
+		res = ControlResponse{Error: err.Error()}
+	}
+	if err = json.NewEncoder(conn).Encode(res); err != nil {
+		log.Println("Control Socket Fail: " + err.Error())
+	}
+}
+
+func (d *Daemon) Control(req ControlRequest) (ControlResponse, error) {
+	switch req.Command {
+	case "pause":
+		pause.Pause()
+		log.Println("Paused")
+		return ControlResponse{Message: "Paused"}, nil
+	case "resume":
+		pause.Resume()
+		log.Println("Resumed")
+		return ControlResponse{Message: "Resumed"}, nil
+	case "skip":
+		if !SkipActive() {
+			return ControlResponse{}, errors.New("No Gallery Downloading")
+		}
+		return ControlResponse{Message: "Skipped"}, nil
+	case "status":
+		status := d.Status()
+		return ControlResponse{Status: &status}, nil
+	case "add":
+		if len(req.Args) == 0 {
+			return ControlResponse{}, errors.New("No Urls Given")
+		}
+		jobs := UrlJobs(req.Args)
+		for i := range jobs {
+			jobs[i].Priority = req.Priority
+			if err := jobs[i].Validate(); err != nil {
+				return ControlResponse{}, err
+			}
+		}
+		added := d.Add(jobs)
+		log.Println("Queued " + strconv.Itoa(len(added)) + " Galleries From Control Socket")
+		return ControlResponse{Message: "Queued " + strconv.Itoa(len(added)) + " Galleries"}, nil
+	}
+	return ControlResponse{}, errors.New("Unknown Command: " + req.Command)
+}
+
+// Ctl sends args, like "pause" or "add url...", to the running daemon and
+// prints its answer.
+func Ctl(args []string) error {
+	if len(args) == 0 {
+		return errors.New("Usage: ctl pause|resume|skip|status|add <url>...")
+	}
+	req := ControlRequest{Command: args[0], Args: args[1:], Priority: *priorityFlag}
+	conn, err := dialControl(ControlSocketPath())
+	if err != nil {
+		return errors.New("No Running Daemon On " + ControlSocketPath() + ": " + err.Error())
+	}
+	defer conn.Close()
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return err
+	}
+	var res ControlResponse
+	if err = json.NewDecoder(conn).Decode(&res); err != nil {
+		return err
+	}
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+	if res.Status != nil {
+		data, err := json.MarshalIndent(res.Status, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	}
+	if res.Message != "" {
+		fmt.Println(res.Message)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+func defaultControlPath() string {
+	return filepath.Join(os.TempDir(), "hitomi-go-"+strconv.Itoa(os.Getuid()), "hitomi-go.sock")
+}
+
+type unixControlListener struct {
+	net.Listener
+}
+
+func (l unixControlListener) Accept() (io.ReadWriteCloser, error) {
+	return l.Listener.Accept()
+}
+
+// listenControl listens on the Unix socket path, which only the user can
+// connect to.
+func listenControl(path string) (controlListener, error) {
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return nil, errors.New("Another Instance Is Using It")
+	}
+	if filepath.Dir(path) == filepath.Dir(defaultControlPath()) {
+		if err := privateDir(filepath.Dir(path)); err != nil {
+			return nil, err
+		}
+	}
+	os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return unixControlListener{listener}, nil
+}
+
+// privateDir creates dir for the user alone, or makes sure the one there
+// already is not shared, since anyone in the temp dir could create it first.
+func privateDir(dir string) error {
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() || info.Mode().Perm()&0077 != 0 {
+		return errors.New(dir + " Is Not A Private Directory")
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return errors.New(dir + " Belongs To Another User")
+	}
+	return nil
+}
+
+func dialControl(path string) (io.ReadWriteCloser, error) {
+	return net.DialTimeout("unix", path, 5*time.Second)
+}
@@ -0,0 +1,64 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenControlPrivate(t *testing.T) {
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", t.TempDir())
+	path := defaultControlPath()
+
+	listener, err := listenControl(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.(unixControlListener).Close()
+	for name, want := range map[string]os.FileMode{path: 0600, filepath.Dir(path): 0700} {
+		if info, err := os.Stat(name); err != nil || info.Mode().Perm() != want {
+			t.Errorf("%s has mode %v (%v), want %v", name, info.Mode().Perm(), err, want)
+		}
+	}
+
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			NewDaemon().handleControl(conn)
+		}
+	}()
+	defer pause.Resume()
+	conn, err := dialControl(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	json.NewEncoder(conn).Encode(ControlRequest{Command: "pause"})
+	var res ControlResponse
+	if err = json.NewDecoder(bufio.NewReader(conn)).Decode(&res); err != nil || res.Message != "Paused" {
+		t.Errorf("pause answered %+v, %v", res, err)
+	}
+
+	if _, err = listenControl(path); err == nil {
+		t.Error("a second instance got the socket")
+	}
+}
+
+func TestListenControlSharedDir(t *testing.T) {
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", t.TempDir())
+	path := defaultControlPath()
+	if err := os.Mkdir(filepath.Dir(path), 0777); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(filepath.Dir(path), 0777)
+	if listener, err := listenControl(path); err == nil {
+		listener.(unixControlListener).Close()
+		t.Error("listened in a directory everyone can write to")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const controlPipeMode = windows.PIPE_TYPE_BYTE | windows.PIPE_READMODE_BYTE | windows.PIPE_WAIT | windows.PIPE_REJECT_REMOTE_CLIENTS
+
+// controlPipeSddl lets only the owner of the pipe and the system open it.
+const controlPipeSddl = "D:P(A;;GA;;;OW)(A;;GA;;;SY)"
+
+func defaultControlPath() string {
+	return `\\.\pipe\hitomi-go-` + os.Getenv("USERNAME")
+}
+
+type pipeControlListener struct {
+	path string
+	sa   *windows.SecurityAttributes
+	next windows.Handle
+}
+
+// listenControl creates the named pipe path, there is a new instance of it
+// for every connection.
+func listenControl(path string) (controlListener, error) {
+	sd, err := windows.SecurityDescriptorFromString(controlPipeSddl)
+	if err != nil {
+		return nil, err
+	}
+	l := &pipeControlListener{path: path, sa: &windows.SecurityAttributes{SecurityDescriptor: sd}}
+	l.sa.Length = uint32(unsafe.Sizeof(*l.sa))
+	l.next, err = l.create(windows.FILE_FLAG_FIRST_PIPE_INSTANCE)
+	if err == windows.ERROR_ACCESS_DENIED {
+		return nil, errors.New("Another Instance Is Using It")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *pipeControlListener) create(flags uint32) (windows.Handle, error) {
+	name, err := windows.UTF16PtrFromString(l.path)
+	if err != nil {
+		return windows.InvalidHandle, err
+	}
+	return windows.CreateNamedPipe(name, windows.PIPE_ACCESS_DUPLEX|flags, controlPipeMode, windows.PIPE_UNLIMITED_INSTANCES, 4096, 4096, 0, l.sa)
+}
+
+func (l *pipeControlListener) Accept() (io.ReadWriteCloser, error) {
+	handle := l.next
+	l.next = windows.InvalidHandle
+	if handle == windows.InvalidHandle {
+		var err error
+		if handle, err = l.create(0); err != nil {
+			return nil, err
+		}
+	}
+	if err := windows.ConnectNamedPipe(handle, nil); err != nil && err != windows.ERROR_PIPE_CONNECTED {
+		windows.CloseHandle(handle)
+		return nil, err
+	}
+	return pipeConn{os.NewFile(uintptr(handle), l.path)}, nil
+}
+
+// pipeConn waits for ctl to read the answer before it closes the pipe.
+type pipeConn struct {
+	*os.File
+}
+
+func (c pipeConn) Close() error {
+	windows.FlushFileBuffers(windows.Handle(c.Fd()))
+	return c.File.Close()
+}
+
+// dialControl opens the named pipe path, waiting while all its instances
+// are busy.
+func dialControl(path string) (io.ReadWriteCloser, error) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err == nil {
+			return f, nil
+		}
+		var errno windows.Errno
+		if !errors.As(err, &errno) || errno != windows.ERROR_PIPE_BUSY || time.Now().After(deadline) {
+			return nil, err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
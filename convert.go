@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+)
+
+func ConvertWorker() {
+	for job := range convertQueue {
+		ConvertHandler(job)
+	}
+}
+
+func NeedsConvert(conf Conf) bool {
+	return conf.ConvertTo != "" || conf.MaxWidth > 0 || conf.MaxDimension > 0
+}
+
+func ConvertHandler(job WriteJob) {
+	if job.Job.Task.ctx.Err() != nil {
+		PutBody(job.body)
+		buffered.Release(job.Reserved)
+		job.Job.Task.wg.Done()
+		return
+	}
+	content, err := ConvertImage(job.Content, job.Job.Conf)
+	if err != nil {
+		PutBody(job.body)
+		buffered.Release(job.Reserved)
+		ImageFail(job.Job, "Convert Image Fail: "+job.FileName+" Because "+err.Error())
+		return
+	}
+	job.Content = content
+	writeQueue <- job
+}
+
+func ConvertExt(format string) string {
+	switch format {
+	case "jpeg", "jpg":
+		return ".jpg"
+	case "png":
+		return ".png"
+	}
+	return ""
+}
+
+// ResizeFormat is the format a page of format is saved as when it is only
+// resized, webp can be decoded but not encoded.
+func ResizeFormat(format string) string {
+	if format == "webp" {
+		return "jpeg"
+	}
+	return format
+}
+
+// AvifOnly tells if img is only offered as avif, it is kept as it is since
+// there is no avif decoder to convert it with.
+func AvifOnly(img Image) bool {
+	return img.HasAvif == 1 && img.HasWebp == 0
+}
+
+// ConvertImage downscales the image to MaxWidth/MaxDimension of conf, the
+// one of the job, and re-encodes it as ConvertTo, or as its own format when
+// that is empty. An avif image is returned unchanged.
+func ConvertImage(content []byte, conf Conf) ([]byte, error) {
+	if IsAvif(content) {
+		return content, nil
+	}
+	format := conf.ConvertTo
+	img, srcFormat, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	if format == "" {
+		format = ResizeFormat(srcFormat)
+		if ConvertExt(format) == "" {
+			return content, nil
+		}
+	}
+	resized := Downscale(img, conf.MaxWidth, conf.MaxDimension)
+	if resized == img && ConvertExt(srcFormat) == ConvertExt(format) {
+		return content, nil
+	}
+	return EncodeImage(resized, format, conf.ConvertQuality)
+}
+
+func Downscale(img image.Image, maxWidth int, maxDimension int) image.Image {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	scale := 1.0
+	if maxWidth > 0 && w > maxWidth {
+		scale = float64(maxWidth) / float64(w)
+	}
+	if longest := w; maxDimension > 0 {
+		if h > longest {
+			longest = h
+		}
+		if s := float64(maxDimension) / float64(longest); s < scale {
+			scale = s
+		}
+	}
+	if scale >= 1 {
+		return img
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, int(float64(w)*scale+0.5), int(float64(h)*scale+0.5)))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+func EncodeImage(img image.Image, format string, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg", "jpg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	case "png":
+		err = png.Encode(&buf, img)
+	default:
+		err = errors.New("Unsupported Format: " + format)
+	}
+	return buf.Bytes(), err
+}
+
+// PreferOriginal switches pages to their original jpg/png, so a resized page
+// can be saved in the format it has been downloaded in.
+func PreferOriginal(files []Image) {
+	for i := range files {
+		files[i].HasAvif = 0
+		files[i].HasWebp = 0
+	}
+}
+
+// PrepareFiles picks the variant of every page which conf can convert.
+func PrepareFiles(files []Image, conf Conf) {
+	if conf.ConvertTo != "" {
+		PreferDecodable(files)
+	} else if NeedsConvert(conf) {
+		PreferOriginal(files)
+	}
+}
+
+// PreferDecodable switches avif pages to their webp variant, as there is no
+// avif decoder to convert them with. Pages without one stay avif.
+func PreferDecodable(files []Image) {
+	for i := range files {
+		if files[i].HasAvif == 1 && files[i].HasWebp == 1 {
+			files[i].HasAvif = 0
+		}
+	}
+}
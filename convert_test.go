@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"testing"
+)
+
+// testWebp is gopher-doc.1bpp.lossless.webp of golang.org/x/image, 75x100.
+const testWebp = "UklGRrIBAABXRUJQVlA4TKUBAAAvSsAYAA8w//M///MfeJAkbXvaSG7m8Q3GfYSBJekwQztm/IcZlgwnmWImn2BK7aFmBtnVir6q//8VOkFE/xm4baTIu8c48ArEo6+B3zFKYln3pqClSCKX0begFTAXFOLXHSyF8cCNcZEG4OywuA4KVVfJCiArU7GAgJI8+lJP/OKMT/fBAjevg1cYB7YVkFuWga2lyPi5I0HFy5YTpWIHg0RZpkniRVW9odHAKOwosWuOGdxIyn2OvaCDvhg/we6TwadPBPbqBV58MsLmMJ8yZnOWk8SRz4N+QoyPL+MnamzMvcE1rHNEr91F9GKZPVUcS9w7PhhH36suB9qPeYb/oLk6cuTiJ0wOK3m5h1cKjW6EVZCYMK7dxcKCBdgP9HkKr9gkAO2P8GKZGWVdIAatQa+1IDpt6qyorVwdy01xdW8Jkfk6xjEXmVQQ+HQdFr6OKhIN34dXWq0+0qr6EJSCeeVLH9+gvGTLyqM65PQ44ihzlTXxQKjKbAvshXgir7Lil9w4L2bvMycmjQcqXaMCO6BlY28i+FOLzbfI1vEqxAhotocAAA=="
+
+func TestConvertImageUsesJobConf(t *testing.T) {
+	defer func(width int) { conf.MaxWidth = width }(conf.MaxWidth)
+	conf.MaxWidth = 0
+
+	// a job or profile sets MaxWidth, the global config doesn't
+	content, err := ConvertImage(testPng(t, 200, 100), Conf{MaxWidth: 50, ConvertQuality: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 50 || config.Height != 25 {
+		t.Errorf("converted to %dx%d, want 50x25", config.Width, config.Height)
+	}
+}
+
+func TestConvertImageResizesWebp(t *testing.T) {
+	webp, err := base64.StdEncoding.DecodeString(testWebp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resizeOnly := Conf{MaxWidth: 30, ConvertQuality: 90}
+	content, err := ConvertImage(webp, resizeOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpeg" || config.Width != 30 || config.Height != 40 {
+		t.Errorf("converted to %s %dx%d, want jpeg 30x40", format, config.Width, config.Height)
+	}
+	if got := ImageFileName(Image{Name: "1.webp"}, resizeOnly); got != "1.jpg" {
+		t.Errorf("ImageFileName = %s, want 1.jpg", got)
+	}
+}
+
+func TestConvertImageKeepsAvif(t *testing.T) {
+	avif := []byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00mif1miaf")
+	toJpeg := Conf{ConvertTo: "jpeg", MaxWidth: 30, ConvertQuality: 90}
+	content, err := ConvertImage(avif, toJpeg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, avif) {
+		t.Error("avif page has been changed")
+	}
+	files := []Image{{Name: "01.jpg", HasAvif: 1}, {Name: "02.jpg", HasAvif: 1, HasWebp: 1}}
+	PrepareFiles(files, toJpeg)
+	for i, want := range []string{"01.avif", "02.jpg"} {
+		if got := ImageFileName(files[i], toJpeg); got != want {
+			t.Errorf("ImageFileName(%+v) = %s, want %s", files[i], got, want)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const defaultListen = "127.0.0.1:8080"
+
+var pprofFlag = flag.Bool("pprof", false, "serve net/http/pprof under /debug/pprof/ in serve mode")
+
+// Daemon keeps running and downloads the galleries added to it one after
+// another. A batch ends, with its summary and notifications, whenever the
+// queue runs empty.
+type Daemon struct {
+	lock    sync.Mutex
+	cond    *sync.Cond
+	pending JobQueue
+	current string
+	// since is when current was started.
+	since   time.Time
+	syncDue bool
+	stopped chan struct{}
+}
+
+var daemon *Daemon
+
+func NewDaemon() *Daemon {
+	d := &Daemon{stopped: make(chan struct{})}
+	d.cond = sync.NewCond(&d.lock)
+	return d
+}
+
+// Add queues jobs, search urls are expanded first so every gallery can be
+// reordered or cancelled on its own. Galleries queued already are left out.
+func (d *Daemon) Add(jobs []JobSpec) []PendingJob {
+	jobs = ClaimJobs(ExpandJobs(jobs))
+	d.lock.Lock()
+	added := make([]PendingJob, 0, len(jobs))
+	for _, job := range jobs {
+		added = append(added, d.pending.Push(job))
+	}
+	d.lock.Unlock()
+	d.cond.Signal()
+	SaveQueueLogged()
+	return added
+}
+
+func (d *Daemon) Len() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.pending.Len()
+}
+
+// Run downloads the queued jobs, and syncs the subscriptions when a sync is
+// due, only while Schedule.Run matches. It returns after an interrupt.
+func (d *Daemon) Run() {
+	defer close(d.stopped)
+	var window cron.Schedule
+	if conf.Schedule.Run != "" {
+		window, _ = ParseCron(conf.Schedule.Run)
+	}
+	go func() {
+		<-appCtx.Done()
+		d.cond.Broadcast()
+	}()
+	busy := false
+	for {
+		d.lock.Lock()
+		for d.pending.Len() == 0 && !d.syncDue && !Interrupted() {
+			d.cond.Wait()
+		}
+		d.lock.Unlock()
+		if pause.Wait(appCtx) != nil || Interrupted() {
+			if busy {
+				Finish()
+			}
+			return
+		}
+		if window != nil && !CronMatches(window, time.Now()) {
+			next := window.Next(time.Now())
+			log.Println("Outside Run Schedule, Waiting Until " + next.Format("2006-01-02 15:04"))
+			select {
+			case <-time.After(time.Until(next)):
+			case <-appCtx.Done():
+			}
+			continue
+		}
+		busy = true
+
+		d.lock.Lock()
+		if d.syncDue {
+			d.syncDue = false
+			d.current, d.since = subscriptionsFile, time.Now()
+			d.lock.Unlock()
+			if subs, err := LoadSubscriptions(subscriptionsFile); err != nil {
+				log.Println("Read " + subscriptionsFile + " Fail: " + err.Error())
+			} else {
+				SyncOnce(subs)
+			}
+		} else {
+			item, _ := d.pending.Pop()
+			d.current, d.since = item.Job.Url, time.Now()
+			d.lock.Unlock()
+			DownloadJobs([]JobSpec{item.Job})
+		}
+
+		d.lock.Lock()
+		d.current = ""
+		idle := d.pending.Len() == 0 && !d.syncDue
+		d.lock.Unlock()
+		if idle || Interrupted() {
+			Finish()
+			busy = false
+		}
+	}
+}
+
+// Wait blocks until Run has returned.
+func (d *Daemon) Wait() {
+	<-d.stopped
+}
+
+// ScheduleSync requests a subscription sync at every match of expr.
+func (d *Daemon) ScheduleSync(expr string) {
+	schedule, err := ParseCron(expr)
+	if err != nil {
+		log.Println("Schedule Sync Fail: " + err.Error())
+		return
+	}
+	for {
+		next := schedule.Next(time.Now())
+		log.Println("Next Sync At " + next.Format("2006-01-02 15:04"))
+		time.Sleep(time.Until(next))
+		d.lock.Lock()
+		d.syncDue = true
+		d.lock.Unlock()
+		d.cond.Signal()
+	}
+}
+
+// StartDaemon starts downloading whatever gets added to daemon.
+func StartDaemon() {
+	daemon = NewDaemon()
+	go daemon.Run()
+}
+
+// Serve runs the sync schedule, the control socket and the HTTP server on
+// Listen of the started daemon.
+func Serve() {
+	if conf.Schedule.Sync != "" {
+		go daemon.ScheduleSync(conf.Schedule.Sync)
+	}
+
+	if conf.ControlSocket != "off" {
+		go daemon.ServeControl()
+	}
+	listen := conf.Listen
+	if listen == "" {
+		listen = defaultListen
+	}
+	if listen == "off" {
+		daemon.Wait()
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", MetricsHandler)
+	mux.HandleFunc("/api/add", daemon.AddHandler)
+	mux.HandleFunc("/api/status", daemon.StatusHandler)
+	mux.HandleFunc("/healthz", daemon.HealthHandler)
+	mux.HandleFunc("/api/pending/", daemon.PendingHandler)
+	mux.HandleFunc("/api/pause", daemon.ControlHandler)
+	mux.HandleFunc("/api/resume", daemon.ControlHandler)
+	mux.HandleFunc("/api/skip", daemon.ControlHandler)
+	token, err := AddToken()
+	if err != nil {
+		CommonError("Read " + addTokenFile + " Fail: " + err.Error())
+	}
+	mux.HandleFunc("/add", daemon.AddPageHandler(token))
+	mux.HandleFunc("/userscript.user.js", UserscriptHandler(token))
+	mux.HandleFunc("/bookmarklet", UserscriptHandler(token))
+	if *pprofFlag {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		log.Println("Profiling Enabled On /debug/pprof/")
+	}
+	if !AuthRequired() && !IsLocalListen(listen) {
+		log.Println("Warning: Listening On " + listen + " Without ApiToken Or ApiUser, Anyone Who Can Reach It Can Control The Downloads")
+	}
+	server := &http.Server{Addr: listen, Handler: ApiAuth(mux)}
+	go func() {
+		<-appCtx.Done()
+		server.Shutdown(context.Background())
+	}()
+	if conf.ListenCert != "" {
+		log.Println("Listening On " + listen + " (TLS)")
+		err = server.ListenAndServeTLS(conf.ListenCert, conf.ListenKey)
+	} else {
+		log.Println("Listening On " + listen)
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		CommonError("Serve Fail: " + err.Error())
+	}
+	daemon.Wait()
+}
+
+// AddHandler queues the POSTed jobs, a JSON list like the jobs file or one
+// url per line.
+func (d *Daemon) AddHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	jobs, err := ReadAddRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if priority := r.URL.Query().Get("priority"); priority != "" {
+		if !ValidPriority(priority) {
+			http.Error(w, "priority must be high, normal or low", http.StatusBadRequest)
+			return
+		}
+		for i := range jobs {
+			jobs[i].Priority = priority
+		}
+	}
+	added := d.Add(jobs)
+	log.Println("Queued " + strconv.Itoa(len(added)) + " Galleries From " + r.RemoteAddr)
+	writeJson(w, added)
+}
+
+// PendingHandler changes the pending gallery /api/pending/{id}: DELETE
+// cancels it, POST with {"priority": "high"} or {"position": 0} reorders it.
+func (d *Daemon) PendingHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/pending/"))
+	if err != nil {
+		http.Error(w, "Invalid Id", http.StatusBadRequest)
+		return
+	}
+	var change struct {
+		Priority *string `json:"priority"`
+		Position *int    `json:"position"`
+	}
+	switch r.Method {
+	case http.MethodDelete:
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if change.Priority != nil && !ValidPriority(*change.Priority) {
+			http.Error(w, "priority must be high, normal or low", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	d.lock.Lock()
+	var found bool
+	switch {
+	case r.Method == http.MethodDelete:
+		var item PendingJob
+		if item, found = d.pending.Remove(id); found {
+			ReleaseGallery(item.Job.Url)
+			log.Println("Cancelled: " + item.Job.Url)
+		}
+	case change.Position != nil:
+		found = d.pending.Move(id, *change.Position)
+	case change.Priority != nil:
+		found = d.pending.SetPriority(id, *change.Priority)
+	default:
+		_, found = d.pending.Find(id)
+	}
+	pending := d.pending.Items()
+	d.lock.Unlock()
+	if !found {
+		http.Error(w, "Not Pending", http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodDelete || change.Position != nil || change.Priority != nil {
+		SaveQueueLogged()
+	}
+	writeJson(w, pending)
+}
+
+func ReadAddRequest(r *http.Request) ([]JobSpec, error) {
+	var jobs []JobSpec
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&jobs); err != nil {
+			return nil, err
+		}
+	} else {
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				jobs = append(jobs, JobSpec{Url: line})
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(jobs) == 0 {
+		return nil, errors.New("No Jobs")
+	}
+	for i, job := range jobs {
+		if err := job.Validate(); err != nil {
+			return nil, errors.New("Job " + strconv.Itoa(i+1) + ": " + err.Error())
+		}
+	}
+	return jobs, nil
+}
+
+type DaemonStatus struct {
+	Paused  bool         `json:"paused"`
+	Current string       `json:"current"`
+	Pending []PendingJob `json:"pending"`
+	Summary Summary      `json:"summary"`
+}
+
+func (d *Daemon) Status() DaemonStatus {
+	d.lock.Lock()
+	status := DaemonStatus{Paused: pause.Paused(), Current: d.current, Pending: d.pending.Items()}
+	d.lock.Unlock()
+	status.Summary = summary
+	status.Summary.Finish()
+	return status
+}
+
+func (d *Daemon) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, d.Status())
+}
+
+// ControlHandler runs /api/pause, /api/resume and /api/skip like ctl does.
+func (d *Daemon) ControlHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	res, err := d.Control(ControlRequest{Command: strings.TrimPrefix(r.URL.Path, "/api/")})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, res)
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Write Response Fail: " + err.Error())
+	}
+}
+
+// AddRemote queues urls on the server running on Listen, with the priority
+// of --priority.
+func AddRemote(urls []string) error {
+	if len(urls) == 0 {
+		return errors.New("No Urls Given")
+	}
+	if !ValidPriority(*priorityFlag) {
+		return errors.New("Invalid Priority: " + *priorityFlag)
+	}
+	addUrl := ApiUrl("/api/add")
+	if *priorityFlag != "" {
+		addUrl += "?priority=" + url.QueryEscape(*priorityFlag)
+	}
+	req, err := http.NewRequest("POST", addUrl, strings.NewReader(strings.Join(urls, "\n")))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	SetApiAuth(req)
+	client, err := ApiClient()
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return errors.New(strings.TrimSpace(string(body)))
+	}
+	var added []PendingJob
+	if err = json.Unmarshal(body, &added); err != nil {
+		return err
+	}
+	log.Println("Queued " + strconv.Itoa(len(added)) + " Galleries")
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPendingHandlerSavesQueue(t *testing.T) {
+	setupPipeline(t)
+	defer func(d *Daemon) {
+		daemon = d
+		SaveQueueLogged()
+	}(daemon)
+	daemon = NewDaemon()
+	var ids []int
+	for _, url := range []string{"https://hitomi.la/galleries/1.html", "https://hitomi.la/galleries/2.html", "https://hitomi.la/galleries/3.html"} {
+		ids = append(ids, daemon.pending.Push(JobSpec{Url: url}).Id)
+	}
+
+	saved := func() []string {
+		data, err := ioutil.ReadFile(queueFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var jobs []SavedJob
+		if err = json.Unmarshal(data, &jobs); err != nil {
+			t.Fatal(err)
+		}
+		var urls []string
+		for _, job := range jobs {
+			urls = append(urls, strings.TrimSuffix(strings.TrimPrefix(job.Url, "https://hitomi.la/galleries/"), ".html"))
+		}
+		return urls
+	}
+	change := func(method string, id int, body string) {
+		w := httptest.NewRecorder()
+		daemon.PendingHandler(w, httptest.NewRequest(method, "/api/pending/"+strconv.Itoa(id), strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %d: status %d", method, id, w.Code)
+		}
+	}
+
+	change(http.MethodDelete, ids[1], "")
+	if got := strings.Join(saved(), ","); got != "1,3" {
+		t.Errorf("%s after cancel = %s, want 1,3", queueFile, got)
+	}
+	change(http.MethodPost, ids[2], `{"position": 0}`)
+	if got := strings.Join(saved(), ","); got != "3,1" {
+		t.Errorf("%s after move = %s, want 3,1", queueFile, got)
+	}
+	change(http.MethodPost, ids[0], `{"priority": "high"}`)
+	if got := strings.Join(saved(), ","); got != "1,3" {
+		t.Errorf("%s after priority = %s, want 1,3", queueFile, got)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+)
+
+// IdSource is implemented by sources whose urls carry the id of their
+// gallery, so different urls of one gallery are known to be the same.
+type IdSource interface {
+	GalleryId(url string) string
+}
+
+func (HitomiSource) GalleryId(url string) string {
+	return GalleryId(url)
+}
+
+func (NhentaiSource) GalleryId(url string) string {
+	if match := nhentaiUrlRegexp.FindStringSubmatch(url); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// GalleryKey tells the gallery of url apart from every other one, whichever
+// of its urls it is given by.
+func GalleryKey(url string) string {
+	source := SourceOf(url)
+	if ider, ok := source.(IdSource); ok {
+		if id := ider.GalleryId(url); id != "" {
+			return source.Name() + ":" + id
+		}
+	}
+	url = strings.SplitN(strings.TrimSpace(url), "#", 2)[0]
+	return source.Name() + ":" + strings.TrimSuffix(url, "/")
+}
+
+// queuedGalleries are the keys of the galleries waiting or downloading,
+// whether they came from list.txt, the api, the control socket, the
+// clipboard or a subscription.
+var queuedGalleries = struct {
+	lock sync.Mutex
+	keys map[string]bool
+}{keys: make(map[string]bool)}
+
+// ClaimJobs leaves out the jobs whose gallery is queued already, from any
+// source or earlier in jobs, and marks the others queued until they are
+// released.
+func ClaimJobs(jobs []JobSpec) []JobSpec {
+	claimed := make([]JobSpec, 0, len(jobs))
+	queuedGalleries.lock.Lock()
+	defer queuedGalleries.lock.Unlock()
+	for _, job := range jobs {
+		key := GalleryKey(job.Url)
+		if queuedGalleries.keys[key] {
+			log.Println("Skip Gallery (Queued Already): " + job.Url)
+			continue
+		}
+		queuedGalleries.keys[key] = true
+		claimed = append(claimed, job)
+	}
+	return claimed
+}
+
+// ReleaseGallery lets the gallery of url be queued again, once it is done,
+// failed or cancelled.
+func ReleaseGallery(url string) {
+	key := GalleryKey(url)
+	queuedGalleries.lock.Lock()
+	delete(queuedGalleries.keys, key)
+	queuedGalleries.lock.Unlock()
+}
+
+func ReleaseJobs(jobs []JobSpec) {
+	for _, job := range jobs {
+		ReleaseGallery(job.Url)
+	}
+}
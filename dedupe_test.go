@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestGalleryKey(t *testing.T) {
+	same := [][]string{
+		{
+			"https://hitomi.la/galleries/1234.html",
+			"https://hitomi.la/reader/1234.html#3",
+			"https://hitomi.la/doujinshi/some-title-japanese-1234.html?page=2",
+		},
+		{
+			"https://nhentai.net/g/1234/",
+			"https://nhentai.net/g/1234",
+		},
+	}
+	for _, urls := range same {
+		for _, u := range urls[1:] {
+			if GalleryKey(u) != GalleryKey(urls[0]) {
+				t.Errorf("GalleryKey(%q) = %q, want %q", u, GalleryKey(u), GalleryKey(urls[0]))
+			}
+		}
+	}
+	if GalleryKey(same[0][0]) == GalleryKey(same[1][0]) {
+		t.Error("the hitomi and nhentai galleries with the same id have the same key")
+	}
+}
+
+func TestClaimJobs(t *testing.T) {
+	first := ClaimJobs(UrlJobs([]string{
+		"https://hitomi.la/galleries/9012.html",
+		"https://hitomi.la/reader/9012.html",
+		"https://hitomi.la/galleries/9013.html",
+	}))
+	defer ReleaseJobs(first)
+	if len(first) != 2 || first[0].Url != "https://hitomi.la/galleries/9012.html" || first[1].Url != "https://hitomi.la/galleries/9013.html" {
+		t.Errorf("claimed %+v, want 9012 and 9013 once", first)
+	}
+
+	// the same galleries arriving from another source meanwhile
+	if second := ClaimJobs(UrlJobs([]string{"https://hitomi.la/doujinshi/title-japanese-9013.html"})); len(second) != 0 {
+		t.Errorf("claimed %+v again while it is queued", second)
+	}
+
+	ReleaseGallery("https://hitomi.la/galleries/9013.html")
+	third := ClaimJobs(UrlJobs([]string{"https://hitomi.la/doujinshi/title-japanese-9013.html"}))
+	defer ReleaseJobs(third)
+	if len(third) != 1 {
+		t.Error("a released gallery can't be queued again")
+	}
+}
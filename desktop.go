@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// windowsToast shows a toast with the title and message of the environment,
+// which spares quoting them into the script.
+const windowsToast = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode($env:HITOMI_NOTIFY_TITLE)) | Out-Null
+$text.Item(1).AppendChild($template.CreateTextNode($env:HITOMI_NOTIFY_MESSAGE)) | Out-Null
+$toast = [Windows.UI.Notifications.ToastNotification]::new($template)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('hitomi').Show($toast)`
+
+// desktopNotifyCommand builds the command of DesktopNotify, replaced by the
+// tests.
+var desktopNotifyCommand = DesktopNotifyCommand
+
+// DesktopNotifyCommand is the command showing a desktop notification on this
+// system, when its tool is installed.
+func DesktopNotifyCommand(title string, message string) (*exec.Cmd, error) {
+	cmd := DesktopCommand(runtime.GOOS, title, message)
+	if _, err := exec.LookPath(cmd.Path); err != nil {
+		return nil, errors.New("No Notification Tool Found: " + cmd.Path)
+	}
+	return cmd, nil
+}
+
+// DesktopCommand is the command showing a desktop notification on goos: a
+// toast on Windows, the notification center on macOS and libnotify's
+// notify-send elsewhere.
+func DesktopCommand(goos string, title string, message string) *exec.Cmd {
+	switch goos {
+	case "windows":
+		cmd := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", windowsToast)
+		cmd.Env = append(os.Environ(), "HITOMI_NOTIFY_TITLE="+title, "HITOMI_NOTIFY_MESSAGE="+message)
+		return cmd
+	case "darwin":
+		return exec.Command("osascript", "-e", "display notification "+appleScriptQuote(message)+" with title "+appleScriptQuote(title))
+	}
+	return exec.Command("notify-send", "--app-name=hitomi", title, message)
+}
+
+func appleScriptQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// DesktopNotify shows a desktop notification in the background when
+// Notify.Desktop is set.
+func DesktopNotify(title string, message string) {
+	if !conf.Notify.Desktop {
+		return
+	}
+	notifyWg.Add(1)
+	go func() {
+		defer notifyWg.Done()
+		cmd, err := desktopNotifyCommand(title, message)
+		if err == nil {
+			var out []byte
+			if out, err = cmd.CombinedOutput(); err != nil && len(out) > 0 {
+				err = errors.New(strings.TrimSpace(string(out)))
+			}
+		}
+		if err != nil {
+			log.Println("Desktop Notify Fail: " + title + " Because " + err.Error())
+		}
+	}()
+}
+
+// BatchMessage is the text of the notification sent when a batch finishes.
+func BatchMessage(batch Summary) string {
+	message := strconv.FormatInt(batch.GalleriesSucceeded, 10) + " Galleries Downloaded"
+	if batch.GalleriesFailed > 0 {
+		message += ", " + strconv.FormatInt(batch.GalleriesFailed, 10) + " Failed"
+	}
+	if batch.GalleriesSkipped > 0 {
+		message += ", " + strconv.FormatInt(batch.GalleriesSkipped, 10) + " Skipped"
+	}
+	if batch.Bytes > 0 {
+		message += " (" + FormatBytes(float64(batch.Bytes)) + ")"
+	}
+	return message
+}
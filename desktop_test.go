@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestDesktopCommand(t *testing.T) {
+	title, message := `Done "Quoted" \ Title`, "3 Galleries Downloaded; rm -rf $HOME"
+	for _, c := range []struct {
+		goos string
+		args []string
+		env  []string
+	}{
+		{"linux", []string{"notify-send", "--app-name=hitomi", title, message}, nil},
+		{"freebsd", []string{"notify-send", "--app-name=hitomi", title, message}, nil},
+		{"darwin", []string{"osascript", "-e", `display notification "3 Galleries Downloaded; rm -rf $HOME" with title "Done \"Quoted\" \\ Title"`}, nil},
+		{"windows", []string{"powershell.exe", "-NoProfile", "-NonInteractive", "-Command", windowsToast}, []string{"HITOMI_NOTIFY_TITLE=" + title, "HITOMI_NOTIFY_MESSAGE=" + message}},
+	} {
+		cmd := DesktopCommand(c.goos, title, message)
+		if strings.Join(cmd.Args, "\n") != strings.Join(c.args, "\n") {
+			t.Errorf("%s: %q, want %q", c.goos, cmd.Args, c.args)
+		}
+		if c.env == nil && cmd.Env != nil {
+			t.Errorf("%s: Env = %q", c.goos, cmd.Env)
+		}
+		if len(c.env) > 0 && (len(cmd.Env) < len(c.env) || strings.Join(cmd.Env[len(cmd.Env)-len(c.env):], "\n") != strings.Join(c.env, "\n")) {
+			t.Errorf("%s: Env ends in %q, want %q", c.goos, cmd.Env, c.env)
+		}
+	}
+}
+
+func TestDesktopNotify(t *testing.T) {
+	defer func(command func(string, string) (*exec.Cmd, error), desktop bool) {
+		desktopNotifyCommand, conf.Notify.Desktop = command, desktop
+	}(desktopNotifyCommand, conf.Notify.Desktop)
+	var got []string
+	desktopNotifyCommand = func(title string, message string) (*exec.Cmd, error) {
+		got = append(got, title+": "+message)
+		return nil, errors.New("No Desktop In Tests")
+	}
+
+	conf.Notify.Desktop = false
+	DesktopNotify("Off", "not shown")
+	conf.Notify.Desktop = true
+	DesktopNotify("Batch Finished", "1 Galleries Downloaded")
+	notifyWg.Wait()
+	if len(got) != 1 || got[0] != "Batch Finished: 1 Galleries Downloaded" {
+		t.Errorf("notified %q", got)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// fallbackDelay is how long the addresses of the preferred family get to
+// connect before the other family is tried alongside, like the happy
+// eyeballs of RFC 8305.
+var fallbackDelay = 300 * time.Millisecond
+
+// SplitFamilies splits addrs into the ones of the family to try first and the
+// ones of the other family to fall back to. With preference "ipv4" or "ipv6"
+// that family comes first, otherwise the family of the first address.
+func SplitFamilies(addrs []string, preference string) (primary []string, fallback []string) {
+	ipv4First := len(addrs) > 0 && isIpv4(addrs[0])
+	switch preference {
+	case "ipv4":
+		ipv4First = true
+	case "ipv6":
+		ipv4First = false
+	}
+	for _, addr := range addrs {
+		if isIpv4(addr) == ipv4First {
+			primary = append(primary, addr)
+		} else {
+			fallback = append(fallback, addr)
+		}
+	}
+	if len(primary) == 0 {
+		return fallback, nil
+	}
+	return primary, fallback
+}
+
+func isIpv4(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.To4() != nil
+}
+
+// DialParallel connects to the primary addresses one after another, and once
+// they failed or took longer than fallbackDelay to the fallback ones
+// alongside. The first connection wins, a later one is closed.
+func DialParallel(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), network string, port string, primary []string, fallback []string) (net.Conn, error) {
+	if len(fallback) == 0 {
+		return dialSerial(ctx, dial, network, port, primary)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan dialResult, 2)
+	start := func(addrs []string) {
+		go func() {
+			conn, err := dialSerial(ctx, dial, network, port, addrs)
+			results <- dialResult{conn, err}
+		}()
+	}
+	start(primary)
+	timer := time.NewTimer(fallbackDelay)
+	defer timer.Stop()
+	pending, fellBack := 1, false
+	var firstErr error
+	for {
+		select {
+		case <-timer.C:
+			if !fellBack {
+				start(fallback)
+				pending, fellBack = pending+1, true
+			}
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return result.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			if !fellBack {
+				start(fallback)
+				pending, fellBack = pending+1, true
+			} else if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+func dialSerial(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), network string, port string, addrs []string) (net.Conn, error) {
+	var err error
+	for _, addr := range addrs {
+		var conn net.Conn
+		if conn, err = dial(ctx, network, net.JoinHostPort(addr, port)); err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSplitFamilies(t *testing.T) {
+	addrs := []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2"}
+	for _, c := range []struct {
+		preference        string
+		primary, fallback []string
+	}{
+		{"", []string{"2001:db8::1", "2001:db8::2"}, []string{"192.0.2.1", "192.0.2.2"}},
+		{"ipv4", []string{"192.0.2.1", "192.0.2.2"}, []string{"2001:db8::1", "2001:db8::2"}},
+		{"ipv6", []string{"2001:db8::1", "2001:db8::2"}, []string{"192.0.2.1", "192.0.2.2"}},
+	} {
+		primary, fallback := SplitFamilies(addrs, c.preference)
+		if !reflect.DeepEqual(primary, c.primary) || !reflect.DeepEqual(fallback, c.fallback) {
+			t.Errorf("SplitFamilies with %q = %v, %v", c.preference, primary, fallback)
+		}
+	}
+	if primary, fallback := SplitFamilies([]string{"192.0.2.1"}, "ipv6"); len(primary) != 1 || fallback != nil {
+		t.Errorf("a single family preferred against = %v, %v", primary, fallback)
+	}
+}
+
+func TestDialParallelFallsBack(t *testing.T) {
+	defer func(delay time.Duration) { fallbackDelay = delay }(fallbackDelay)
+	fallbackDelay = 10 * time.Millisecond
+
+	// the ipv6 addresses hang until the dial is given up, ipv4 connects
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, _ := net.SplitHostPort(addr)
+		if !isIpv4(host) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		conn, _ := net.Pipe()
+		return conn, nil
+	}
+	conn, err := DialParallel(context.Background(), dial, "tcp", "443", []string{"2001:db8::1"}, []string{"192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	refused := errors.New("refused")
+	fails := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, refused
+	}
+	if _, err = DialParallel(context.Background(), fails, "tcp", "443", []string{"2001:db8::1"}, []string{"192.0.2.1"}); err != refused {
+		t.Errorf("err = %v, want the first one", err)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// defaultDnsCacheTtl is how long, in seconds, the addresses of a host are
+// reused before looking it up again.
+const defaultDnsCacheTtl = 300
+
+// Resolver looks hosts up with Lookup and keeps their addresses for
+// DnsCacheTtl, so a gallery's hundreds of requests don't each ask again.
+type Resolver struct {
+	Lookup func(ctx context.Context, host string) ([]string, error)
+	lock   sync.Mutex
+	cache  map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+var resolver = NewCachingResolver(net.DefaultResolver.LookupHost)
+
+func NewCachingResolver(lookup func(ctx context.Context, host string) ([]string, error)) *Resolver {
+	return &Resolver{Lookup: lookup, cache: map[string]dnsEntry{}}
+}
+
+// NewResolver resolves with server: the system resolver when it is empty,
+// DNS over HTTPS when it is an https url, otherwise a plain DNS server at
+// "host" or "host:port".
+func NewResolver(server string) (*Resolver, error) {
+	if server == "" {
+		return NewCachingResolver(net.DefaultResolver.LookupHost), nil
+	}
+	if strings.HasPrefix(server, "https://") {
+		doh := &DohResolver{
+			Url:    server,
+			Client: &http.Client{Timeout: time.Duration(conf.ConnectTimeout) * time.Second},
+		}
+		return NewCachingResolver(doh.LookupHost), nil
+	}
+	addr, err := DnsServerAddr(server)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: time.Duration(conf.ConnectTimeout) * time.Second}
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+	return NewCachingResolver(r.LookupHost), nil
+}
+
+// DnsServerAddr adds the default port 53 to a DnsServer without one.
+func DnsServerAddr(server string) (string, error) {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server, nil
+	}
+	if strings.ContainsAny(server, "/ ") || server == "" {
+		return "", errors.New("Invalid DNS Server: " + server)
+	}
+	return net.JoinHostPort(strings.Trim(server, "[]"), "53"), nil
+}
+
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if conf.DnsCacheTtl <= 0 {
+		return r.Lookup(ctx, host)
+	}
+	ttl := time.Duration(conf.DnsCacheTtl) * time.Second
+	r.lock.Lock()
+	entry, ok := r.cache[host]
+	r.lock.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+	addrs, err := r.Lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	r.lock.Lock()
+	r.cache[host] = dnsEntry{addrs: addrs, expires: time.Now().Add(ttl)}
+	r.lock.Unlock()
+	return addrs, nil
+}
+
+// DialContext wraps dial so host names are resolved by r, connecting to
+// their addresses as NetworkPreference says.
+func (r *Resolver) DialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+		addrs, err := r.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		if len(addrs) == 0 {
+			return nil, errors.New("No Address For " + host)
+		}
+		primary, fallback := SplitFamilies(addrs, conf.NetworkPreference)
+		return DialParallel(ctx, dial, network, port, primary, fallback)
+	}
+}
+
+// DohResolver looks hosts up with DNS over HTTPS (RFC 8484) at Url, like
+// https://cloudflare-dns.com/dns-query.
+type DohResolver struct {
+	Url    string
+	Client *http.Client
+}
+
+func (d *DohResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	var addrs []string
+	var lastErr error
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		found, err := d.query(ctx, host, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		addrs = append(addrs, found...)
+	}
+	if len(addrs) == 0 {
+		if lastErr == nil {
+			lastErr = errors.New("No Such Host: " + host)
+		}
+		return nil, lastErr
+	}
+	return addrs, nil
+}
+
+func (d *DohResolver) query(ctx context.Context, host string, qtype dnsmessage.Type) ([]string, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, err
+	}
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packet, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", d.Url, bytes.NewReader(packet))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, StatusError(res.StatusCode)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	var answer dnsmessage.Message
+	if err = answer.Unpack(body); err != nil {
+		return nil, err
+	}
+	if answer.RCode != dnsmessage.RCodeSuccess {
+		return nil, errors.New("DNS " + strings.TrimPrefix(answer.RCode.String(), "RCode") + " For " + host)
+	}
+	var addrs []string
+	for _, resource := range answer.Answers {
+		switch body := resource.Body.(type) {
+		case *dnsmessage.AResource:
+			addrs = append(addrs, net.IP(body.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			addrs = append(addrs, net.IP(body.AAAA[:]).String())
+		}
+	}
+	return addrs, nil
+}
+
+// DnsServerProblem tells what is wrong with a DnsServer, or "" when nothing
+// is.
+func DnsServerProblem(server string) string {
+	if server == "" || strings.HasPrefix(server, "https://") {
+		return ""
+	}
+	if _, err := DnsServerAddr(server); err != nil || strings.Contains(server, "://") {
+		return "must be \"host\", \"host:port\" or an https url, got " + strconv.Quote(server)
+	}
+	return ""
+}
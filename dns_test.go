@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestResolverCache(t *testing.T) {
+	defer func(ttl int) { conf.DnsCacheTtl = ttl }(conf.DnsCacheTtl)
+	lookups := 0
+	r := NewCachingResolver(func(ctx context.Context, host string) ([]string, error) {
+		lookups++
+		return []string{"127.0.0.1"}, nil
+	})
+
+	conf.DnsCacheTtl = 300
+	for i := 0; i < 3; i++ {
+		if _, err := r.LookupHost(context.Background(), "ltn.hitomi.la"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("%d lookups with the cache on, want 1", lookups)
+	}
+
+	conf.DnsCacheTtl = -1
+	r.LookupHost(context.Background(), "ltn.hitomi.la")
+	if lookups != 2 {
+		t.Errorf("%d lookups with the cache off, want 2", lookups)
+	}
+}
+
+func TestDohResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var query dnsmessage.Message
+		if err := query.Unpack(body); err != nil || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		question := query.Questions[0]
+		answer := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true},
+			Questions: query.Questions,
+		}
+		header := dnsmessage.ResourceHeader{Name: question.Name, Type: question.Type, Class: dnsmessage.ClassINET, TTL: 60}
+		switch {
+		case question.Name.String() != "ltn.hitomi.la.":
+			answer.RCode = dnsmessage.RCodeNameError
+		case question.Type == dnsmessage.TypeA:
+			answer.Answers = append(answer.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: [4]byte{203, 0, 113, 7}}})
+		case question.Type == dnsmessage.TypeAAAA:
+			answer.Answers = append(answer.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AAAAResource{AAAA: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}}})
+		}
+		packet, _ := answer.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(packet)
+	}))
+	defer server.Close()
+
+	doh := &DohResolver{Url: server.URL, Client: server.Client()}
+	addrs, err := doh.LookupHost(context.Background(), "ltn.hitomi.la")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"203.0.113.7", "2001:db8::1"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("addrs = %v, want %v", addrs, want)
+	}
+	if _, err = doh.LookupHost(context.Background(), "blocked.example"); err == nil {
+		t.Error("no error for a name the server doesn't know")
+	}
+}
+
+func TestDnsServerProblem(t *testing.T) {
+	for server, ok := range map[string]bool{
+		"":                                     true,
+		"1.1.1.1":                              true,
+		"1.1.1.1:5353":                         true,
+		"[2606:4700:4700::1111]:53":            true,
+		"https://cloudflare-dns.com/dns-query": true,
+		"tls://1.1.1.1":                        false,
+		"1.1.1.1/53":                           false,
+	} {
+		if problem := DnsServerProblem(server); (problem == "") != ok {
+			t.Errorf("DnsServerProblem(%q) = %q", server, problem)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// SiteCheck compares one part of a script of the site which the image urls
+// are built from with what FrontendImageUrl does.
+type SiteCheck struct {
+	// Script is the file on MetadataHost, like "common.js"
+	Script string
+	Name   string
+	// Check tells how the script differs, "" when it matches
+	Check func(script string) string
+}
+
+var siteChecks = []SiteCheck{
+	{"common.js", "number of frontends", numberIs(`number_of_frontends\s*=\s*(\w+)`, frontends)},
+	{"common.js", "frontend threshold", numberIs(`g\s*<\s*(\w+)\s*\)`, frontendThreshold)},
+	{"common.js", "hash path", contains(regexp.QuoteMeta(`\/[0-9a-f]\/([0-9a-f]{2})\/`))},
+	{"gg.js", "gg.js frontend table", lacks(`\bm\s*:\s*function`)},
+}
+
+func numberIs(pattern string, want int) func(string) string {
+	re := regexp.MustCompile(pattern)
+	return func(script string) string {
+		m := re.FindStringSubmatch(script)
+		if m == nil {
+			return "not found"
+		}
+		n, err := strconv.ParseInt(m[1], 0, 64)
+		if err != nil || int(n) != want {
+			return "site has " + m[1] + ", this version uses " + strconv.Itoa(want)
+		}
+		return ""
+	}
+}
+
+func contains(pattern string) func(string) string {
+	re := regexp.MustCompile(pattern)
+	return func(script string) string {
+		if !re.MatchString(script) {
+			return "not found"
+		}
+		return ""
+	}
+}
+
+func lacks(pattern string) func(string) string {
+	re := regexp.MustCompile(pattern)
+	return func(script string) string {
+		if re.MatchString(script) {
+			return "site uses it, this version doesn't"
+		}
+		return ""
+	}
+}
+
+type SiteCheckResult struct {
+	SiteCheck
+	Problem string
+}
+
+// CheckSite fetches the scripts of siteChecks and runs them. A missing
+// script is checked as empty.
+func CheckSite(ctx context.Context) ([]SiteCheckResult, error) {
+	scripts := map[string]string{}
+	var results []SiteCheckResult
+	for _, check := range siteChecks {
+		script, ok := scripts[check.Script]
+		if !ok {
+			code, body, err := Get(ctx, MetadataUrl(check.Script))
+			if err != nil {
+				return nil, err
+			}
+			if code != http.StatusOK && code != http.StatusNotFound {
+				return nil, StatusError(code)
+			}
+			if code == http.StatusOK {
+				script = string(body)
+			}
+			scripts[check.Script] = script
+		}
+		results = append(results, SiteCheckResult{check, check.Check(script)})
+	}
+	return results, nil
+}
+
+// Doctor prints the result of every site check and exits with ExitFailed
+// when the site changed.
+func Doctor() {
+	results, err := CheckSite(appCtx)
+	if err != nil {
+		CommonError("Site Check Fail: " + err.Error())
+	}
+	code := ExitOk
+	for _, result := range results {
+		if result.Problem == "" {
+			fmt.Println("OK      " + result.Script + " " + result.Name)
+			continue
+		}
+		fmt.Println("Changed " + result.Script + " " + result.Name + ": " + result.Problem)
+		code = ExitFailed
+	}
+	if code != ExitOk {
+		fmt.Println("Image urls will likely 404, look for an update of this program")
+	}
+	Exit(code)
+}
+
+// WarnSiteChanges logs every site check that fails, so a changed site shows
+// up before all images do. With --offline-metadata the site isn't asked.
+func WarnSiteChanges() {
+	if *offlineMetadata != "" {
+		return
+	}
+	results, err := CheckSite(appCtx)
+	if err != nil {
+		log.Println("Site Check Fail: " + err.Error())
+		return
+	}
+	changed := false
+	for _, result := range results {
+		if result.Problem != "" {
+			log.Println("Site Changed: " + result.Script + " " + result.Name + " Because " + result.Problem)
+			changed = true
+		}
+	}
+	if changed {
+		log.Println("Image Urls Will Likely 404, Run doctor And Look For An Update")
+	}
+}
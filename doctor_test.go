@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+const testCommonJs = `function subdomain_from_url(url, base) {
+        var retval = 'b';
+        if (base) {
+                retval = base;
+        }
+        var number_of_frontends = 3;
+        var b = 16;
+        var r = /\/[0-9a-f]\/([0-9a-f]{2})\//;
+        var m = r.exec(url);
+        if (!m) {
+                return 'a';
+        }
+        var g = parseInt(m[1], b);
+        if (!isNaN(g)) {
+                var o = 0;
+                if (g < 0x7c) {
+                        o = 1;
+                }
+                retval = String.fromCharCode(97 + o) + retval;
+        }
+        return url.replace(/\/\/..?\.hitomi\.la\//, '//'+retval+'.hitomi.la/');
+}`
+
+func TestCheckSite(t *testing.T) {
+	routes := map[string]http.HandlerFunc{"/common.js": serveString(testCommonJs)}
+	mockSite(t, routes)
+	results, err := CheckSite(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if result.Problem != "" {
+			t.Errorf("%s %s: %s", result.Script, result.Name, result.Problem)
+		}
+	}
+
+	routes["/gg.js"] = serveString(`gg = { m: function(g) { var o = 0; switch (g) { case 3: o = 1; break; } return o; }, b: '1644940804/' };`)
+	results, err = CheckSite(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := map[string]string{}
+	for _, result := range results {
+		if result.Problem != "" {
+			changed[result.Name] = result.Problem
+		}
+	}
+	if len(changed) != 1 || changed["gg.js frontend table"] == "" {
+		t.Errorf("changed = %v, want only the gg.js frontend table", changed)
+	}
+}
+
+func TestSiteCheckNumbers(t *testing.T) {
+	check := numberIs(`g\s*<\s*(\w+)\s*\)`, frontendThreshold)
+	if problem := check("if (g < 0x88) {"); problem != "site has 0x88, this version uses 124" {
+		t.Errorf("problem = %q", problem)
+	}
+	if problem := check("if (g < 124) {"); problem != "" {
+		t.Errorf("decimal threshold: %q", problem)
+	}
+	if problem := check(""); problem != "not found" {
+		t.Errorf("missing threshold: %q", problem)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/bits"
+	"sort"
+	"strconv"
+)
+
+// maxDupeDistance is the largest distance dupes can look for: pages which
+// differ in at most 3 bits share one of the 4 16 bit quarters of their
+// hashes, so only pages sharing a quarter have to be compared.
+const maxDupeDistance = 3
+
+var distanceFlag = flag.Int("distance", 2, "dupes: how many bits the perceptual hashes of pages may differ in, at most 3")
+
+type DupePage struct {
+	Dir   string `json:"dir"`
+	Name  string `json:"name"`
+	Phash string `json:"phash"`
+	hash  uint64
+}
+
+type DupesReport struct {
+	// Groups are the visually identical pages of more than one gallery.
+	Groups [][]DupePage `json:"groups"`
+	Pages  int          `json:"pages"`
+	// Hashed are the pages without a perceptual hash in their manifest,
+	// which had to be read and hashed.
+	Hashed     int `json:"hashed"`
+	Unreadable int `json:"unreadable"`
+}
+
+// FindDupes groups the pages of the saved galleries whose perceptual hashes
+// differ in at most distance bits, keeping the groups which span more than
+// one gallery. Flat pages, like blank ones, are left out.
+func FindDupes(distance int) (DupesReport, error) {
+	var report DupesReport
+	if distance < 0 || distance > maxDupeDistance {
+		return report, errors.New("Distance Must Be Between 0 And " + strconv.Itoa(maxDupeDistance))
+	}
+	lister, ok := storage.(Lister)
+	if !ok {
+		return report, errors.New("Dupes Needs Storage local, zip Or tar")
+	}
+	dirs, err := lister.Galleries()
+	if err != nil {
+		return report, err
+	}
+	var pages []DupePage
+	for _, dir := range dirs {
+		manifest, err := ReadManifest(dir)
+		if err != nil {
+			log.Println("Read Manifest Fail: " + dir + " Because " + err.Error())
+			report.Unreadable++
+			continue
+		}
+		for _, file := range manifest.Files {
+			phash := file.Phash
+			if phash == "" {
+				content, err := storage.Read(dir + "/" + file.Name)
+				if err == nil {
+					phash, err = PerceptualHash(content)
+				}
+				if err != nil {
+					continue
+				}
+				report.Hashed++
+				fmt.Print(".")
+			}
+			hash, err := strconv.ParseUint(phash, 16, 64)
+			if err != nil || hash == 0 || hash == ^uint64(0) {
+				continue
+			}
+			pages = append(pages, DupePage{Dir: dir, Name: file.Name, Phash: phash, hash: hash})
+		}
+	}
+	if report.Hashed > 0 {
+		fmt.Println()
+	}
+	report.Pages = len(pages)
+	report.Groups = groupDupes(pages, distance)
+	return report, nil
+}
+
+func groupDupes(pages []DupePage, distance int) [][]DupePage {
+	parent := make([]int, len(pages))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+	buckets := make(map[uint64][]int)
+	for i, page := range pages {
+		for quarter := uint(0); quarter < 4; quarter++ {
+			key := uint64(quarter)<<16 | page.hash>>(16*quarter)&0xffff
+			for _, j := range buckets[key] {
+				if bits.OnesCount64(page.hash^pages[j].hash) <= distance {
+					parent[root(i)] = root(j)
+				}
+			}
+			buckets[key] = append(buckets[key], i)
+		}
+	}
+	members := make(map[int][]DupePage)
+	for i, page := range pages {
+		members[root(i)] = append(members[root(i)], page)
+	}
+	var groups [][]DupePage
+	for _, group := range members {
+		for _, page := range group[1:] {
+			if page.Dir != group[0].Dir {
+				groups = append(groups, group)
+				break
+			}
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i]) != len(groups[j]) {
+			return len(groups[i]) > len(groups[j])
+		}
+		return groups[i][0].Dir+"/"+groups[i][0].Name < groups[j][0].Dir+"/"+groups[j][0].Name
+	})
+	return groups
+}
+
+func PrintDupes() error {
+	report, err := FindDupes(*distanceFlag)
+	if err != nil {
+		return err
+	}
+	if *jsonFlag {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	fmt.Println("Pages: " + strconv.Itoa(report.Pages))
+	if report.Unreadable > 0 {
+		fmt.Println("Unreadable Manifests: " + strconv.Itoa(report.Unreadable))
+	}
+	fmt.Println("Visually Identical Pages In Different Galleries: " + strconv.Itoa(len(report.Groups)))
+	for _, group := range report.Groups {
+		fmt.Println()
+		for _, page := range group {
+			fmt.Println("  " + page.Dir + "/" + page.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFindDupes(t *testing.T) {
+	defer func(s Storage) { storage = s }(storage)
+	storage = &LocalStorage{Root: t.TempDir()}
+	save := func(dir string, files ...ManifestFile) {
+		data, _ := json.Marshal(Manifest{Pages: len(files), Files: files})
+		if err := storage.Write(dir+"/"+manifestFile, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a page the original and its upscale share, differing in two bits
+	save("japanese/Original", ManifestFile{Name: "01.webp", Phash: "f0f0f0f0f0f0f0f0"}, ManifestFile{Name: "02.webp", Phash: "0000000000000000"})
+	save("japanese/Upscale", ManifestFile{Name: "01.png", Phash: "f0f0f0f0f0f0f0f3"}, ManifestFile{Name: "02.png", Phash: "0000000000000000"})
+	// a page repeated within one gallery only, and an unrelated one
+	save("japanese/Other", ManifestFile{Name: "01.webp", Phash: "123456789abcdef0"}, ManifestFile{Name: "02.webp", Phash: "123456789abcdef0"}, ManifestFile{Name: "03.webp", Phash: "0f0f0f0f0f0f0f0f"})
+	storage.Write("japanese/Unhashed/"+manifestFile, []byte(`{"files":[{"name":"01.webp"}]}`))
+
+	report, err := FindDupes(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Groups) != 1 {
+		t.Fatalf("groups = %+v, want one", report.Groups)
+	}
+	group := report.Groups[0]
+	if len(group) != 2 || group[0].Dir != "japanese/Original" || group[1].Dir != "japanese/Upscale" {
+		t.Errorf("group = %+v", group)
+	}
+	if report, err = FindDupes(1); err != nil || len(report.Groups) != 0 {
+		t.Errorf("with distance 1 the upscale is a dupe: %+v %v", report.Groups, err)
+	}
+	if _, err = FindDupes(maxDupeDistance + 1); err == nil {
+		t.Error("a distance above maxDupeDistance is accepted")
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"path"
+	"strings"
+	"sync"
+)
+
+// duplicateShare is the percentage of pages a gallery must share with a
+// saved one to count as its duplicate.
+const duplicateShare = 90
+
+// DuplicateIndex knows which saved gallery every page hash belongs to.
+type DuplicateIndex struct {
+	once  sync.Once
+	lock  sync.Mutex
+	pages map[string]string
+}
+
+var duplicates = &DuplicateIndex{pages: make(map[string]string)}
+
+// PageHash is the hash hitomi names the image of url after, "" when url
+// doesn't have one.
+func PageHash(url string) string {
+	hash := strings.TrimSuffix(path.Base(url), path.Ext(url))
+	if len(hash) != 64 || strings.Trim(hash, "0123456789abcdef") != "" {
+		return ""
+	}
+	return hash
+}
+
+// load reads the manifests of the storage the first time a gallery is
+// checked.
+func (d *DuplicateIndex) load() {
+	d.once.Do(func() {
+		lister, ok := storage.(Lister)
+		if !ok {
+			return
+		}
+		dirs, err := lister.Galleries()
+		if err != nil {
+			log.Println("List Galleries Fail: " + err.Error())
+			return
+		}
+		for _, dir := range dirs {
+			if manifest, err := ReadManifest(dir); err == nil {
+				d.Add(dir, manifest)
+			}
+		}
+	})
+}
+
+func (d *DuplicateIndex) Add(dir string, manifest Manifest) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, file := range manifest.Files {
+		if hash := PageHash(file.Url); hash != "" {
+			d.pages[hash] = dir
+		}
+	}
+}
+
+// Find returns the saved gallery other than savePath holding at least
+// duplicateShare percent of the pages of gallery, and that percentage.
+func (d *DuplicateIndex) Find(gallery Gallery, savePath string) (string, int) {
+	if len(gallery.Files) == 0 {
+		return "", 0
+	}
+	d.load()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	shared := make(map[string]int)
+	for _, img := range gallery.Files {
+		if dir, ok := d.pages[img.Hash]; ok && dir != savePath {
+			shared[dir]++
+		}
+	}
+	best, most := "", 0
+	for dir, n := range shared {
+		if n > most || n == most && dir < best {
+			best, most = dir, n
+		}
+	}
+	percent := most * 100 / len(gallery.Files)
+	if percent < duplicateShare {
+		return "", percent
+	}
+	return best, percent
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const ehentaiApi = "https://api.e-hentai.org/api.php"
+
+// ehentaiCandidates is how many search results are compared with the gallery.
+const ehentaiCandidates = 10
+
+var (
+	ehentaiGalleryRegexp = regexp.MustCompile(`https://e(?:-|x)hentai\.org/g/(\d+)/([0-9a-f]{10})/`)
+	ehentaiViewerRegexp  = regexp.MustCompile(`https://e(?:-|x)hentai\.org/s/[0-9a-f]{10}/\d+-(\d+)`)
+	ehentaiImageRegexp   = regexp.MustCompile(`<img id="img" src="([^"]+)"`)
+)
+
+type EHentaiConf struct {
+	// Fallback looks pages gone from hitomi up on e-hentai
+	Fallback bool
+	// ExHentai searches exhentai.org, which needs the Cookies of an account
+	ExHentai bool
+	// Cookies like ipb_member_id, ipb_pass_hash and igneous, only sent to
+	// e-hentai
+	Cookies map[string]string
+}
+
+// ehentaiMatch is the e-hentai gallery found for a hitomi gallery, with the
+// viewer url of every page.
+type ehentaiMatch struct {
+	once    sync.Once
+	viewers map[int]string
+	err     error
+}
+
+var ehentaiMatches = struct {
+	lock    sync.Mutex
+	matches map[string]*ehentaiMatch
+}{matches: make(map[string]*ehentaiMatch)}
+
+// IsGone tells if err is a response saying the file is not there anymore.
+func IsGone(err error) bool {
+	var status StatusError
+	if !errors.As(err, &status) {
+		return false
+	}
+	return status == http.StatusNotFound || status == http.StatusGone
+}
+
+func ehentaiHost() string {
+	if conf.EHentai.ExHentai {
+		return "https://exhentai.org"
+	}
+	return "https://e-hentai.org"
+}
+
+// ehentaiGet requests url with the e-hentai cookies, body is posted as JSON
+// when there is one.
+func ehentaiGet(ctx context.Context, u string, body interface{}) ([]byte, error) {
+	method := "GET"
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		method, reader = "POST", bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", UserAgent(""))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(conf.EHentai.Cookies) > 0 {
+		cookies := make([]string, 0, len(conf.EHentai.Cookies))
+		for name, value := range conf.EHentai.Cookies {
+			cookies = append(cookies, name+"="+value)
+		}
+		sort.Strings(cookies)
+		req.Header.Set("Cookie", strings.Join(cookies, "; "))
+	}
+	res, err := ImageDo(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, StatusError(res.StatusCode)
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+// FindEHentaiGallery searches e-hentai for the title of gallery and returns
+// the viewer urls of the first result with as many pages.
+func FindEHentaiGallery(ctx context.Context, gallery Gallery) (map[int]string, error) {
+	page, err := ehentaiGet(ctx, ehentaiHost()+"/?f_search="+url.QueryEscape(gallery.Title), nil)
+	if err != nil {
+		return nil, err
+	}
+	var gidlist [][]interface{}
+	seen := make(map[string]bool)
+	for _, match := range ehentaiGalleryRegexp.FindAllStringSubmatch(string(page), -1) {
+		if seen[match[1]] || len(gidlist) == ehentaiCandidates {
+			continue
+		}
+		seen[match[1]] = true
+		gid, _ := strconv.Atoi(match[1])
+		gidlist = append(gidlist, []interface{}{gid, match[2]})
+	}
+	if len(gidlist) == 0 {
+		return nil, errors.New("No E-Hentai Gallery Found For " + gallery.Title)
+	}
+	data, err := ehentaiGet(ctx, ehentaiApi, map[string]interface{}{"method": "gdata", "gidlist": gidlist, "namespace": 1})
+	if err != nil {
+		return nil, err
+	}
+	var api struct {
+		GMetadata []struct {
+			Gid       int    `json:"gid"`
+			Token     string `json:"token"`
+			FileCount string `json:"filecount"`
+			Expunged  bool   `json:"expunged"`
+		} `json:"gmetadata"`
+	}
+	if err = json.Unmarshal(data, &api); err != nil {
+		return nil, err
+	}
+	for _, candidate := range api.GMetadata {
+		if candidate.Expunged || candidate.FileCount != strconv.Itoa(gallery.PageCount) {
+			continue
+		}
+		return ehentaiViewers(ctx, ehentaiHost()+"/g/"+strconv.Itoa(candidate.Gid)+"/"+candidate.Token+"/", gallery.PageCount)
+	}
+	return nil, errors.New("No E-Hentai Gallery With " + strconv.Itoa(gallery.PageCount) + " Pages Found For " + gallery.Title)
+}
+
+// ehentaiViewers reads the viewer urls of the pages from the thumbnail pages
+// of the gallery at galleryUrl.
+func ehentaiViewers(ctx context.Context, galleryUrl string, pages int) (map[int]string, error) {
+	viewers := make(map[int]string)
+	for p := 0; len(viewers) < pages; p++ {
+		page, err := ehentaiGet(ctx, galleryUrl+"?p="+strconv.Itoa(p), nil)
+		if err != nil {
+			return nil, err
+		}
+		found := len(viewers)
+		for _, match := range ehentaiViewerRegexp.FindAllStringSubmatch(string(page), -1) {
+			n, _ := strconv.Atoi(match[1])
+			viewers[n] = match[0]
+		}
+		if len(viewers) == found {
+			break
+		}
+	}
+	return viewers, nil
+}
+
+// EHentaiImageUrl is the url of page of gallery on e-hentai, the gallery is
+// looked up the first time one of its pages is asked for.
+func EHentaiImageUrl(ctx context.Context, gallery Gallery, page int) (string, error) {
+	ehentaiMatches.lock.Lock()
+	match, ok := ehentaiMatches.matches[gallery.Id]
+	if !ok {
+		match = &ehentaiMatch{}
+		ehentaiMatches.matches[gallery.Id] = match
+	}
+	ehentaiMatches.lock.Unlock()
+	match.once.Do(func() {
+		match.viewers, match.err = FindEHentaiGallery(ctx, gallery)
+		if match.err == nil {
+			log.Println("Found On E-Hentai: " + gallery.Title)
+		}
+	})
+	if match.err != nil {
+		return "", match.err
+	}
+	viewer, ok := match.viewers[page]
+	if !ok {
+		return "", errors.New("Page " + strconv.Itoa(page) + " Not Found On E-Hentai")
+	}
+	html, err := ehentaiGet(ctx, viewer, nil)
+	if err != nil {
+		return "", err
+	}
+	img := ehentaiImageRegexp.FindSubmatch(html)
+	if img == nil {
+		return "", errors.New("No Image On " + viewer)
+	}
+	return string(img[1]), nil
+}
+
+// DownloadEHentaiImage downloads the page of job from e-hentai instead,
+// under the extension of the file there.
+func DownloadEHentaiImage(ctx context.Context, job Job) error {
+	u, err := EHentaiImageUrl(ctx, job.Gallery, job.Image.Page)
+	if err != nil {
+		return err
+	}
+	alternate := job
+	name := strings.TrimSuffix(job.Image.Name, path.Ext(job.Image.Name))
+	alternate.Image = Image{Name: name + path.Ext(u), Url: u, Page: job.Image.Page, Pages: job.Image.Pages}
+	return DownloadImage(ctx, alternate, job.SavePath+"/"+ImageFileName(alternate.Image, job.Conf))
+}
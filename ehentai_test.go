@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestEHentaiImageUrl(t *testing.T) {
+	var cookie string
+	mockSite(t, map[string]http.HandlerFunc{
+		"/": func(w http.ResponseWriter, r *http.Request) {
+			cookie = r.Header.Get("Cookie")
+			if r.URL.Query().Get("f_search") != "Test Gallery" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(`<a href="https://e-hentai.org/g/11/0123456789/">short</a>` +
+				`<a href="https://e-hentai.org/g/12/abcdef0123/">same</a>`))
+		},
+		"/api.php": func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				Method  string          `json:"method"`
+				GidList [][]interface{} `json:"gidlist"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Method != "gdata" || len(req.GidList) != 2 {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"gmetadata":[{"gid":11,"token":"0123456789","filecount":"1"},` +
+				`{"gid":12,"token":"abcdef0123","filecount":"2"}]}`))
+		},
+		"/g/12/abcdef0123/": func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("p") != "0" {
+				return
+			}
+			w.Write([]byte(`<a href="https://e-hentai.org/s/1111111111/12-1"></a><a href="https://e-hentai.org/s/2222222222/12-2"></a>`))
+		},
+		"/s/2222222222/12-2": serveString(`<img id="img" src="https://abc.hath.network/h/key/2.jpg" style="">`),
+	})
+	defer func(e EHentaiConf) { conf.EHentai = e }(conf.EHentai)
+	conf.EHentai = EHentaiConf{Fallback: true, Cookies: map[string]string{"ipb_member_id": "1", "ipb_pass_hash": "x"}}
+
+	gallery := Gallery{Id: "ehentai-test", Title: "Test Gallery", PageCount: 2}
+	u, err := EHentaiImageUrl(context.Background(), gallery, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != "https://abc.hath.network/h/key/2.jpg" {
+		t.Errorf("EHentaiImageUrl = %s", u)
+	}
+	if cookie != "ipb_member_id=1; ipb_pass_hash=x" {
+		t.Errorf("Cookie = %q", cookie)
+	}
+	if _, err = EHentaiImageUrl(context.Background(), gallery, 3); err == nil {
+		t.Error("want an error for a page the gallery doesn't have")
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"image"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yeka/zip"
+)
+
+const epubOpf = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="id" prefix="rendition: http://www.idpf.org/vocab/rendition/#">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+`
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles>
+<rootfile full-path="content.opf" media-type="application/oebps-package+xml"/>
+</rootfiles>
+</container>
+`
+
+var epubMediaTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".avif": "image/avif",
+}
+
+var epubLanguages = map[string]string{
+	"japanese":   "ja",
+	"english":    "en",
+	"chinese":    "zh",
+	"korean":     "ko",
+	"spanish":    "es",
+	"french":     "fr",
+	"german":     "de",
+	"russian":    "ru",
+	"italian":    "it",
+	"portuguese": "pt",
+	"thai":       "th",
+	"vietnamese": "vi",
+	"indonesian": "id",
+}
+
+// ArchiveBook is what the EPUB or ComicInfo.xml of a gallery dir is
+// described with.
+type ArchiveBook struct {
+	Id      string
+	Title   string
+	Lang    string
+	Authors []string
+	// Pages are the image names in reading order.
+	Pages      []string
+	Url        string
+	Type       string
+	Date       string
+	Tags       []string
+	Characters []string
+}
+
+// Describer is implemented by storages which need to know the gallery
+// behind a dir before it is finalized.
+type Describer interface {
+	Describe(dir string, gallery Gallery, pages []string)
+}
+
+func (s *ZipStorage) Describe(dir string, gallery Gallery, pages []string) {
+	title := gallery.JpTitle
+	if title == "" {
+		title = gallery.Title
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.books[dir] = ArchiveBook{
+		Id:         gallery.Id,
+		Title:      title,
+		Lang:       epubLanguages[gallery.Lang],
+		Authors:    append(append([]string{}, gallery.Artists...), gallery.Groups...),
+		Pages:      pages,
+		Url:        gallery.Url,
+		Type:       gallery.Type,
+		Date:       gallery.Date,
+		Tags:       TagNames(gallery.Tags),
+		Characters: gallery.Characters,
+	}
+}
+
+func IsImageName(name string) bool {
+	_, ok := epubMediaTypes[strings.ToLower(path.Ext(name))]
+	return ok
+}
+
+// epubGenerated tells the files which are written again on every Finalize.
+func epubGenerated(name string) bool {
+	return name == "mimetype" || strings.HasPrefix(name, "META-INF/") || name == "content.opf" || name == "nav.xhtml" ||
+		(strings.HasPrefix(name, "page-") && strings.HasSuffix(name, ".xhtml"))
+}
+
+// writeMimetype has to be the first, uncompressed entry of an EPUB.
+func (a *zipArchive) writeMimetype() error {
+	header := &zip.FileHeader{Name: "mimetype", Method: zip.Store}
+	header.SetModTime(time.Now())
+	w, err := a.writer.CreateHeader(header)
+	if err == nil {
+		_, err = io.WriteString(w, "application/epub+zip")
+	}
+	return err
+}
+
+func (a *zipArchive) recordSize(name string, r io.Reader) {
+	if !IsImageName(name) {
+		return
+	}
+	if config, _, err := image.DecodeConfig(r); err == nil {
+		a.sizes[name] = image.Point{X: config.Width, Y: config.Height}
+	}
+}
+
+// readingOrder is book.Pages when it covers every image of the archive,
+// otherwise the images sorted by name.
+func (a *zipArchive) readingOrder(book ArchiveBook) []string {
+	var images []string
+	for name := range a.names {
+		if IsImageName(name) {
+			images = append(images, name)
+		}
+	}
+	covered := 0
+	for _, name := range book.Pages {
+		if _, ok := a.names[name]; ok {
+			covered++
+		}
+	}
+	if covered == len(images) && covered == len(book.Pages) {
+		return book.Pages
+	}
+	sort.Strings(images)
+	return images
+}
+
+// writeEpub writes the package document, navigation and one fixed-layout
+// page per image.
+func (a *zipArchive) writeEpub(book ArchiveBook, direction string) error {
+	pages := a.readingOrder(book)
+	if book.Title == "" {
+		book.Title = "Untitled"
+	}
+	if book.Lang == "" {
+		book.Lang = "und"
+	}
+	if direction == "" {
+		direction = "rtl"
+	}
+
+	var opf bytes.Buffer
+	opf.WriteString(epubOpf)
+	opf.WriteString(`<dc:identifier id="id">urn:hitomi:` + html.EscapeString(book.Id) + "</dc:identifier>\n")
+	opf.WriteString("<dc:title>" + html.EscapeString(book.Title) + "</dc:title>\n")
+	opf.WriteString("<dc:language>" + book.Lang + "</dc:language>\n")
+	for _, author := range book.Authors {
+		opf.WriteString("<dc:creator>" + html.EscapeString(author) + "</dc:creator>\n")
+	}
+	opf.WriteString(`<meta property="dcterms:modified">` + time.Now().UTC().Format("2006-01-02T15:04:05Z") + "</meta>\n")
+	opf.WriteString(`<meta property="rendition:layout">pre-paginated</meta>` + "\n")
+	opf.WriteString(`<meta property="rendition:spread">landscape</meta>` + "\n")
+	if len(pages) > 0 {
+		opf.WriteString(`<meta name="cover" content="img-0"/>` + "\n")
+	}
+	opf.WriteString("</metadata>\n<manifest>\n")
+	opf.WriteString(`<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>` + "\n")
+
+	var spine bytes.Buffer
+	for i, name := range pages {
+		n := strconv.Itoa(i)
+		pageName := epubPageName(i)
+		properties := ""
+		if i == 0 {
+			properties = ` properties="cover-image"`
+		}
+		opf.WriteString(`<item id="img-` + n + `" href="` + epubHref(name) + `" media-type="` + epubMediaTypes[strings.ToLower(path.Ext(name))] + `"` + properties + "/>\n")
+		opf.WriteString(`<item id="page-` + n + `" href="` + pageName + `" media-type="application/xhtml+xml"/>` + "\n")
+		if IsSpread(a.sizes[name]) {
+			spine.WriteString(`<itemref idref="page-` + n + `" properties="rendition:page-spread-center"/>` + "\n")
+		} else {
+			spine.WriteString(`<itemref idref="page-` + n + `"/>` + "\n")
+		}
+		if err := a.writeGenerated(pageName, epubPage(i, name, a.sizes[name])); err != nil {
+			return err
+		}
+	}
+	others := make([]string, 0, len(a.names))
+	for name := range a.names {
+		if !IsImageName(name) {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	for i, name := range others {
+		opf.WriteString(`<item id="file-` + strconv.Itoa(i) + `" href="` + epubHref(name) + `" media-type="application/octet-stream"/>` + "\n")
+	}
+	opf.WriteString("</manifest>\n")
+	opf.WriteString(`<spine page-progression-direction="` + direction + `">` + "\n")
+	opf.Write(spine.Bytes())
+	opf.WriteString("</spine>\n</package>\n")
+
+	nav := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>` + html.EscapeString(book.Title) + `</title></head>
+<body><nav epub:type="toc"><ol><li><a href="` + epubPageName(0) + `">` + html.EscapeString(book.Title) + `</a></li></ol></nav></body>
+</html>
+`
+	for _, file := range []struct{ name, content string }{
+		{"META-INF/container.xml", epubContainer},
+		{"content.opf", opf.String()},
+		{"nav.xhtml", nav},
+	} {
+		if err := a.writeGenerated(file.name, file.content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *zipArchive) writeGenerated(name string, content string) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+	header.SetModTime(time.Now())
+	w, err := a.writer.CreateHeader(header)
+	if err == nil {
+		_, err = io.WriteString(w, content)
+	}
+	return err
+}
+
+func epubPageName(i int) string {
+	return "page-" + strconv.Itoa(i+1) + ".xhtml"
+}
+
+func epubHref(name string) string {
+	return html.EscapeString((&url.URL{Path: name}).EscapedPath())
+}
+
+func epubPage(i int, name string, size image.Point) string {
+	if size.X == 0 || size.Y == 0 {
+		size = image.Point{X: 1000, Y: 1414}
+	}
+	width, height := strconv.Itoa(size.X), strconv.Itoa(size.Y)
+	title := "Page " + strconv.Itoa(i+1)
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+<title>` + title + `</title>
+<meta name="viewport" content="width=` + width + `, height=` + height + `"/>
+<style>html, body { margin: 0; padding: 0; } img { width: ` + width + `px; height: ` + height + `px; }</style>
+</head>
+<body><img src="` + epubHref(name) + `" alt="` + title + `"/></body>
+</html>
+`
+}
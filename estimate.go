@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+var speedFlag = flag.Int("speed", 0, "estimate: download speed in KiB/s to estimate the time at, default MaxSpeed")
+
+type Estimate struct {
+	Galleries int `json:"galleries"`
+	// Unreadable galleries are not counted in the rest.
+	Unreadable int   `json:"unreadable"`
+	Filtered   int   `json:"filtered"`
+	Pages      int   `json:"pages"`
+	Bytes      int64 `json:"estimated_bytes"`
+	// Speed is in bytes per second, Seconds how long Bytes take at it.
+	Speed   int64   `json:"bytes_per_second,omitempty"`
+	Seconds float64 `json:"estimated_seconds,omitempty"`
+	// measured are the pages of the galleries EstimateSize could measure.
+	measured      int
+	measuredBytes int64
+}
+
+// EstimateBatch resolves the urls of args, or of the job file or list.txt
+// like a download would, and adds up their pages and estimated sizes.
+func EstimateBatch(args []string) Estimate {
+	jobs := UrlJobs(args)
+	if len(args) == 0 {
+		if fileName := FindJobs(); fileName != "" {
+			var err error
+			if jobs, err = ReadJobs(fileName); err != nil {
+				CommonError("Read Jobs Fail: " + fileName + " Because " + err.Error())
+			}
+		} else {
+			jobs = UrlJobs(ReadList("list.txt"))
+		}
+	}
+	var estimate Estimate
+	for _, job := range ExpandJobs(jobs) {
+		if Interrupted() {
+			break
+		}
+		gallery, err := GalleryInfo(appCtx, job.Url)
+		if err != nil {
+			log.Println("Read Gallery Info Fail: " + job.Url + " Because " + err.Error())
+			estimate.Unreadable++
+			continue
+		}
+		if filter != nil {
+			if match, err := filter.Match(gallery); err == nil && !match {
+				estimate.Filtered++
+				continue
+			}
+		}
+		gallery = job.Apply(gallery)
+		PrepareFiles(gallery.Files, job.GalleryConf(conf))
+		estimate.Galleries++
+		estimate.Pages += len(gallery.Files)
+		if size := EstimateSize(gallery); size > 0 {
+			estimate.measured += len(gallery.Files)
+			estimate.measuredBytes += size
+		}
+		fmt.Print(".")
+	}
+	fmt.Println()
+	estimate.Bytes = estimate.measuredBytes
+	if estimate.measured > 0 {
+		// the pages which couldn't be measured are as large as the rest on average
+		estimate.Bytes += estimate.measuredBytes / int64(estimate.measured) * int64(estimate.Pages-estimate.measured)
+	}
+	speed := *speedFlag
+	if speed == 0 {
+		speed = conf.MaxSpeed
+	}
+	if speed > 0 {
+		estimate.Speed = int64(speed) * 1024
+		estimate.Seconds = float64(estimate.Bytes) / float64(estimate.Speed)
+	}
+	return estimate
+}
+
+func (e Estimate) Print() {
+	if *jsonFlag {
+		data, _ := json.Marshal(e)
+		fmt.Println(string(data))
+		return
+	}
+	fmt.Println("Galleries: " + strconv.Itoa(e.Galleries))
+	if e.Unreadable > 0 {
+		fmt.Println("Unreadable: " + strconv.Itoa(e.Unreadable))
+	}
+	if e.Filtered > 0 {
+		fmt.Println("Filtered: " + strconv.Itoa(e.Filtered))
+	}
+	fmt.Println("Pages: " + strconv.Itoa(e.Pages))
+	if e.Bytes == 0 {
+		fmt.Println("Estimated Size: Unknown, No Page Could Be Measured")
+		return
+	}
+	fmt.Println("Estimated Size: ~" + FormatBytes(float64(e.Bytes)))
+	speeds := []int64{e.Speed}
+	if e.Speed == 0 {
+		speeds = []int64{1024 * 1024, 10 * 1024 * 1024}
+	}
+	for _, speed := range speeds {
+		duration := time.Duration(float64(e.Bytes) / float64(speed) * float64(time.Second))
+		fmt.Println("Time At " + FormatBytes(float64(speed)) + "/s: ~" + duration.Round(time.Second).String())
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestEstimateBatch(t *testing.T) {
+	setupPipeline(t)
+	defer func(speed int) { *speedFlag = speed }(*speedFlag)
+	*speedFlag = 1
+	page := strings.Repeat("x", 2048)
+	mockSite(t, map[string]http.HandlerFunc{
+		"/galleries/1234.js":               serveString(testGalleryJs),
+		"/webp/e/d2/" + testHash + ".webp": serveString(page),
+	})
+
+	estimate := EstimateBatch([]string{"https://hitomi.la/galleries/1234.html", "https://hitomi.la/galleries/404.html"})
+	if estimate.Galleries != 1 || estimate.Unreadable != 1 || estimate.Pages != 1 {
+		t.Errorf("estimate = %+v, want 1 gallery of 1 page and 1 unreadable", estimate)
+	}
+	if estimate.Bytes != int64(len(page)) {
+		t.Errorf("Bytes = %d, want %d", estimate.Bytes, len(page))
+	}
+	if estimate.Speed != 1024 || estimate.Seconds != 2 {
+		t.Errorf("%v seconds at %d bytes/s, want 2 at 1024", estimate.Seconds, estimate.Speed)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var outputFlag = flag.String("output", "text", "text, or json for newline-delimited JSON events on stdout (the log stays on stderr)")
+
+// Events of the --output json stream.
+const (
+	StreamGalleryStart = "gallery_start"
+	StreamImageDone    = "image_done"
+	StreamGalleryDone  = "gallery_done"
+	StreamError        = "error"
+	StreamBatchDone    = "batch_done"
+)
+
+// StreamEvent is one line of the --output json stream.
+type StreamEvent struct {
+	Event   string          `json:"event"`
+	Time    time.Time       `json:"time"`
+	Gallery *WebhookGallery `json:"gallery,omitempty"`
+	Image   *StreamImage    `json:"image,omitempty"`
+	Url     string          `json:"url,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Summary *Summary        `json:"summary,omitempty"`
+}
+
+type StreamImage struct {
+	GalleryId string `json:"gallery_id"`
+	Name      string `json:"name"`
+	Size      int64  `json:"size,omitempty"`
+	Sha256    string `json:"sha256,omitempty"`
+	Skipped   bool   `json:"skipped,omitempty"`
+	// Done of Pages of the gallery are finished now.
+	Done  int64 `json:"done,omitempty"`
+	Pages int   `json:"pages,omitempty"`
+}
+
+var eventStream struct {
+	lock sync.Mutex
+	out  io.Writer
+}
+
+// StartEventStream moves everything else printed to stdout out of the way
+// of the events.
+func StartEventStream() {
+	eventStream.out = os.Stdout
+	if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+		os.Stdout = devNull
+	}
+}
+
+func StreamEnabled() bool {
+	return eventStream.out != nil
+}
+
+func WriteEvent(event StreamEvent) {
+	if !StreamEnabled() {
+		return
+	}
+	event.Time = time.Now()
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	eventStream.lock.Lock()
+	defer eventStream.lock.Unlock()
+	eventStream.out.Write(append(data, '\n'))
+}
+
+// StreamWebhookEvent writes the stream event matching a webhook event.
+func StreamWebhookEvent(event WebhookEvent) {
+	stream := StreamEvent{Gallery: event.Gallery, Error: event.Error, Summary: event.Summary}
+	switch event.Event {
+	case EventGalleryStarted:
+		stream.Event = StreamGalleryStart
+	case EventGalleryCompleted, EventGalleryFailed:
+		stream.Event = StreamGalleryDone
+	case EventBatchFinished:
+		stream.Event = StreamBatchDone
+	default:
+		return
+	}
+	WriteEvent(stream)
+}
+
+func StreamImageEvent(job Job, file ManifestFile, skipped bool) {
+	if !StreamEnabled() {
+		return
+	}
+	WriteEvent(StreamEvent{Event: StreamImageDone, Image: &StreamImage{
+		GalleryId: job.Gallery.Id,
+		Name:      file.Name,
+		Size:      file.Size,
+		Sha256:    file.Sha256,
+		Skipped:   skipped,
+		Done:      atomic.LoadInt64(&job.Task.done),
+		Pages:     len(job.Gallery.Files),
+	}})
+}
+
+// StreamFailure writes the error event of a recorded failure.
+func StreamFailure(f Failure) {
+	event := StreamEvent{Event: StreamError, Url: f.Url, Error: f.Reason}
+	if f.Image != "" {
+		event.Image = &StreamImage{GalleryId: f.Id, Name: f.Image}
+	}
+	WriteEvent(event)
+}
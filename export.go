@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var deleteFlag = flag.Bool("delete", false, "export: remove every gallery folder once its archive is written")
+
+type ExportReport struct {
+	Exported int `json:"exported"`
+	// Skipped are the galleries which have an archive already.
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+	Deleted int `json:"deleted"`
+}
+
+// Export packs every gallery folder below SavePath into a CBZ next to it,
+// like Storage "zip" writes it, with the ComicInfo.xml of its manifest.json
+// and metadata.json. With --delete the folders are removed afterwards.
+func Export(deleteFolders bool) (ExportReport, error) {
+	var report ExportReport
+	localConf, zipConf := conf, conf
+	localConf.Storage, zipConf.Storage = "local", "zip"
+	localConf.CAS = CASConf{}
+	from, err := NewStorage(localConf)
+	if err != nil {
+		return report, err
+	}
+	to, err := NewStorage(zipConf)
+	if err != nil {
+		return report, err
+	}
+	folders, archives := from.(*LocalStorage), to.(*ZipStorage)
+	dirs, err := folders.Galleries()
+	if err != nil {
+		return report, err
+	}
+	for _, dir := range dirs {
+		if Interrupted() {
+			break
+		}
+		if archives.HasGallery(dir) {
+			log.Println("Skip Export (Archive Exists): " + dir)
+			report.Skipped++
+			continue
+		}
+		if err := ExportGallery(folders, archives, dir); err != nil {
+			log.Println("Export Gallery Fail: " + dir + " Because " + err.Error())
+			report.Failed++
+			continue
+		}
+		log.Println("Exported: " + archives.archivePath(dir))
+		report.Exported++
+		if !deleteFolders {
+			continue
+		}
+		if err := folders.RemoveGallery(dir); err != nil {
+			log.Println("Remove Gallery Fail: " + dir + " Because " + err.Error())
+			continue
+		}
+		report.Deleted++
+	}
+	return report, nil
+}
+
+// ExportGallery writes the files of the gallery folder dir into its archive
+// and checks they are all in it.
+func ExportGallery(from *LocalStorage, to *ZipStorage, dir string) error {
+	var manifest Manifest
+	data, err := from.Read(dir + "/" + manifestFile)
+	if err == nil {
+		err = json.Unmarshal(data, &manifest)
+	}
+	if err != nil {
+		return err
+	}
+	infos, err := ioutil.ReadDir(filepath.Join(from.Root, filepath.FromSlash(dir)))
+	if err != nil {
+		return err
+	}
+	var names, pages []string
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp") || name == comicInfoName {
+			continue
+		}
+		names = append(names, name)
+		if IsImageName(name) {
+			pages = append(pages, name)
+		}
+	}
+	if len(pages) == 0 {
+		return errors.New("No Pages")
+	}
+	sort.Strings(pages)
+	sizes := make(map[string]int64, len(names))
+	for _, name := range names {
+		content, err := from.Read(dir + "/" + name)
+		if err == nil {
+			err = to.Write(dir+"/"+name, content)
+		}
+		if err != nil {
+			to.Finalize(dir)
+			to.RemoveGallery(dir)
+			return err
+		}
+		sizes[name] = int64(len(content))
+	}
+	to.Describe(dir, ExportedGallery(from, dir, manifest), pages)
+	if err = to.Finalize(dir); err != nil {
+		return err
+	}
+	for name, size := range sizes {
+		if info, err := to.Stat(dir + "/" + name); err != nil || info.Size() != size {
+			return errors.New(name + " Is Missing In The Archive")
+		}
+	}
+	return nil
+}
+
+// ExportedGallery is the gallery of dir as stored in its metadata.json, or
+// as far as its manifest tells without one.
+func ExportedGallery(from *LocalStorage, dir string, manifest Manifest) Gallery {
+	var gallery Gallery
+	if data, err := from.Read(dir + "/metadata.json"); err == nil && json.Unmarshal(data, &gallery) == nil {
+		if gallery.Url == "" {
+			gallery.Url = manifest.Url
+		}
+		return gallery
+	}
+	gallery = Gallery{
+		Id:      manifest.Id,
+		Title:   manifest.Title,
+		Lang:    manifest.Language,
+		Type:    manifest.Type,
+		Artists: manifest.Artists,
+		Groups:  manifest.Groups,
+		Parodys: manifest.Series,
+		Url:     manifest.Url,
+	}
+	for _, tag := range manifest.Tags {
+		gallery.Tags = append(gallery.Tags, Tag{Tag: tag})
+	}
+	return gallery
+}
+
+func PrintExport() error {
+	report, err := Export(*deleteFlag)
+	if err != nil {
+		return err
+	}
+	if *jsonFlag {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	fmt.Println("Exported: " + strconv.Itoa(report.Exported))
+	fmt.Println("Skipped (Archive Exists): " + strconv.Itoa(report.Skipped))
+	fmt.Println("Failed: " + strconv.Itoa(report.Failed))
+	if *deleteFlag {
+		fmt.Println("Folders Removed: " + strconv.Itoa(report.Deleted))
+	}
+	return nil
+}
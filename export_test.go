@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	defer func(c Conf) { conf = c }(conf)
+	conf.SavePath = t.TempDir()
+	conf.CAS = CASConf{}
+	conf.ZipPassword = ""
+	folders := &LocalStorage{Root: conf.SavePath, Mode: 0644}
+	save := func(dir string, manifest Manifest, metadata *Gallery) {
+		files := map[string][]byte{"01.png": testPng(t, 70, 100), "02.png": testPng(t, 140, 100)}
+		data, _ := json.Marshal(manifest)
+		files[manifestFile] = data
+		if metadata != nil {
+			data, _ = json.Marshal(metadata)
+			files["metadata.json"] = data
+		}
+		files[progressFile] = []byte("{}\n")
+		for name, content := range files {
+			if err := folders.Write(dir+"/"+name, content); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	save("japanese/With Metadata", Manifest{Id: "1234", Title: "From Manifest"},
+		&Gallery{Id: "1234", Title: "From Metadata", Type: "manga", Date: "2020-03-04 00:00:00-06", Artists: NameList{"someone"}})
+	save("japanese/Manifest Only", Manifest{Id: "5678", Title: "Manifest Only", Artists: []string{"other"}, Tags: []string{"female:glasses"}}, nil)
+
+	report, err := Export(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Exported != 2 || report.Failed != 0 || report.Deleted != 0 {
+		t.Errorf("report = %+v", report)
+	}
+	to, err := NewStorage(Conf{SavePath: conf.SavePath, Storage: "zip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for dir, want := range map[string]ComicInfo{
+		"japanese/With Metadata": {Title: "From Metadata", Writer: "someone", Year: 2020, PageCount: 2},
+		"japanese/Manifest Only": {Title: "Manifest Only", Writer: "other", Tags: "female:glasses", PageCount: 2},
+	} {
+		data, err := to.Read(dir + "/" + comicInfoName)
+		if err != nil {
+			t.Fatalf("%s: %v", dir, err)
+		}
+		var info ComicInfo
+		if err = xml.Unmarshal(data, &info); err != nil {
+			t.Fatal(err)
+		}
+		if info.Title != want.Title || info.Writer != want.Writer || info.Year != want.Year || info.Tags != want.Tags || info.PageCount != want.PageCount {
+			t.Errorf("%s: ComicInfo = %+v", dir, info)
+		}
+		if len(info.Pages) != 2 || !info.Pages[1].DoublePage {
+			t.Errorf("%s: pages = %+v", dir, info.Pages)
+		}
+		if _, err = to.Stat(dir + "/" + manifestFile); err != nil {
+			t.Errorf("%s: %s is not in the archive", dir, manifestFile)
+		}
+		if _, err = to.Stat(dir + "/" + progressFile); err == nil {
+			t.Errorf("%s: %s is in the archive", dir, progressFile)
+		}
+	}
+
+	// galleries with an archive already keep their folders, even with --delete
+	if report, err = Export(true); err != nil || report.Skipped != 2 || report.Deleted != 0 {
+		t.Errorf("export again = %+v %v", report, err)
+	}
+	if err = os.Remove(filepath.Join(conf.SavePath, "japanese", "Manifest Only.cbz")); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = Export(true); err != nil || report.Exported != 1 || report.Deleted != 1 {
+		t.Errorf("export with --delete = %+v %v", report, err)
+	}
+	if _, err = os.Stat(filepath.Join(conf.SavePath, "japanese", "Manifest Only")); !os.IsNotExist(err) {
+		t.Error("the exported folder was not deleted")
+	}
+	if _, err = os.Stat(filepath.Join(conf.SavePath, "japanese", "With Metadata")); err != nil {
+		t.Error("the folder of a skipped gallery was deleted")
+	}
+}
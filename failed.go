@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+const failedListFile = "failed.txt"
+const failedJsonFile = "failed.json"
+
+// Failure is a gallery, or a single image of it when Hash is set, which
+// could not be downloaded.
+type Failure struct {
+	Url    string `json:"url"`
+	Id     string `json:"id,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Image  string `json:"image,omitempty"`
+	Hash   string `json:"hash,omitempty"`
+	Reason string `json:"reason"`
+}
+
+var failures []Failure
+var failuresLock sync.Mutex
+
+// retryImages limits a gallery url to the image hashes listed in it.
+var retryImages map[string]map[string]struct{}
+
+func RecordFailure(f Failure) {
+	StreamFailure(f)
+	failuresLock.Lock()
+	defer failuresLock.Unlock()
+	failures = append(failures, f)
+}
+
+func RecordImageFailure(job Job, reason string) {
+	RecordFailure(Failure{
+		Url:    job.Gallery.Url,
+		Id:     job.Gallery.Id,
+		Title:  job.Gallery.Title,
+		Image:  job.Image.Name,
+		Hash:   job.Image.Hash,
+		Reason: reason,
+	})
+}
+
+// SaveFailures writes failed.txt, usable as list.txt, and failed.json with
+// reasons. Both are removed when nothing failed.
+func SaveFailures() error {
+	failuresLock.Lock()
+	defer failuresLock.Unlock()
+	if len(failures) == 0 {
+		for _, fileName := range []string{failedListFile, failedJsonFile} {
+			if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	}
+	urls := make([]string, 0, len(failures))
+	for _, f := range failures {
+		urls = append(urls, f.Url)
+	}
+	urls = Unique(urls)
+	if err := ioutil.WriteFile(failedListFile, []byte(strings.Join(urls, Eol())+Eol()), 0644); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(failures, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(failedJsonFile, data, 0644)
+}
+
+// LoadFailures reads failed.json and returns the gallery urls to retry.
+// Galleries with only image failures are limited to those images.
+func LoadFailures() ([]string, error) {
+	data, err := ioutil.ReadFile(failedJsonFile)
+	if err != nil {
+		return nil, err
+	}
+	var list []Failure
+	if err = json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	urls := make([]string, 0, len(list))
+	whole := make(map[string]bool)
+	retryImages = make(map[string]map[string]struct{})
+	for _, f := range list {
+		urls = append(urls, f.Url)
+		if f.Hash == "" {
+			whole[f.Url] = true
+			continue
+		}
+		if retryImages[f.Url] == nil {
+			retryImages[f.Url] = make(map[string]struct{})
+		}
+		retryImages[f.Url][f.Hash] = struct{}{}
+	}
+	for url := range whole {
+		delete(retryImages, url)
+	}
+	return Unique(urls), nil
+}
+
+func FilterRetryImages(gallery Gallery) Gallery {
+	hashes, ok := retryImages[gallery.Url]
+	if !ok {
+		return gallery
+	}
+	files := make([]Image, 0, len(hashes))
+	for _, img := range gallery.Files {
+		if _, ok := hashes[img.Hash]; ok {
+			files = append(files, img)
+		}
+	}
+	gallery.Files = files
+	return gallery
+}
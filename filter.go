@@ -0,0 +1,479 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter is a compiled gallery filter expression such as
+// `pages > 15 && lang in ["japanese","english"] && !tags.contains("ai generated")`.
+type Filter struct {
+	source string
+	eval   evalFunc
+}
+
+type evalFunc func(env map[string]interface{}) (interface{}, error)
+
+type token struct {
+	kind string
+	text string
+	pos  int
+}
+
+type filterParser struct {
+	tokens []token
+	pos    int
+}
+
+func CompileFilter(source string) (*Filter, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	eval, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != "eof" {
+		return nil, p.errorf("Unexpected %q", p.peek().text)
+	}
+	return &Filter{source: source, eval: eval}, nil
+}
+
+func (f *Filter) Match(gallery Gallery) (bool, error) {
+	v, err := f.eval(FilterEnv(gallery))
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("Filter %q Does Not Evaluate To A Bool", f.source)
+	}
+	return b, nil
+}
+
+func FilterEnv(gallery Gallery) map[string]interface{} {
+	tags := make([]interface{}, 0, len(gallery.Tags))
+	for _, tag := range gallery.Tags {
+		tags = append(tags, tag.Name())
+	}
+	return map[string]interface{}{
+		"id":              gallery.Id,
+		"title":           gallery.Title,
+		"jptitle":         gallery.JpTitle,
+		"lang":            gallery.Lang,
+		"type":            gallery.Type,
+		"date":            gallery.Date,
+		"pages":           float64(gallery.TotalPages()),
+		"tags":            tags,
+		"translated_tags": stringList(TranslatedTagNames(gallery.Tags)),
+		"artists":         stringList(gallery.Artists),
+		"groups":          stringList(gallery.Groups),
+		"series":          stringList(gallery.Parodys),
+		"characters":      stringList(gallery.Characters),
+	}
+}
+
+func stringList(list []string) []interface{} {
+	items := make([]interface{}, 0, len(list))
+	for _, s := range list {
+		items = append(items, s)
+	}
+	return items
+}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{"number", string(runes[start:i]), start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{"ident", string(runes[start:i]), start})
+		case r == '"' || r == '\'':
+			start := i
+			var buf strings.Builder
+			for i++; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				buf.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("Filter: Unterminated String At %d", start)
+			}
+			i++
+			tokens = append(tokens, token{"string", buf.String(), start})
+		default:
+			two := ""
+			if i+1 < len(runes) {
+				two = string(runes[i : i+2])
+			}
+			switch two {
+			case "&&", "||", "==", "!=", "<=", ">=":
+				tokens = append(tokens, token{"op", two, i})
+				i += 2
+				continue
+			}
+			if strings.ContainsRune("!<>()[],.", r) {
+				tokens = append(tokens, token{"op", string(r), i})
+				i++
+				continue
+			}
+			return nil, fmt.Errorf("Filter: Unexpected Character %q At %d", r, i)
+		}
+	}
+	return append(tokens, token{"eof", "end of expression", len(runes)}), nil
+}
+
+func (p *filterParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != "eof" {
+		p.pos++
+	}
+	return t
+}
+
+func (p *filterParser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == "op" || t.kind == "ident") && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("Expected %q But Got %q", text, p.peek().text)
+	}
+	return nil
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("Filter: "+format+" At %d", append(args, p.peek().pos)...)
+}
+
+func (p *filterParser) parseOr() (evalFunc, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		l := left
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = func(env map[string]interface{}) (interface{}, error) {
+			a, err := evalBool(l, env)
+			if err != nil || a {
+				return a, err
+			}
+			return evalBool(r, env)
+		}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (evalFunc, error) {
+	left, err := p.parseCompare()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		l := left
+		r, err := p.parseCompare()
+		if err != nil {
+			return nil, err
+		}
+		left = func(env map[string]interface{}) (interface{}, error) {
+			a, err := evalBool(l, env)
+			if err != nil || !a {
+				return a, err
+			}
+			return evalBool(r, env)
+		}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseCompare() (evalFunc, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	switch t.text {
+	case "==", "!=", "<", "<=", ">", ">=", "in":
+		if t.kind != "op" && t.kind != "ident" {
+			return left, nil
+		}
+		p.next()
+	default:
+		return left, nil
+	}
+	right, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	op := t.text
+	return func(env map[string]interface{}) (interface{}, error) {
+		a, err := left(env)
+		if err != nil {
+			return nil, err
+		}
+		b, err := right(env)
+		if err != nil {
+			return nil, err
+		}
+		return compareValues(op, a, b)
+	}, nil
+}
+
+func (p *filterParser) parseUnary() (evalFunc, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(env map[string]interface{}) (interface{}, error) {
+			b, err := evalBool(operand, env)
+			return !b, err
+		}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *filterParser) parsePostfix() (evalFunc, error) {
+	operand, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(".") {
+		method := p.next()
+		if method.kind != "ident" {
+			return nil, p.errorf("Expected Method Name")
+		}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		receiver := operand
+		name := method.text
+		switch name {
+		case "contains", "startsWith", "endsWith":
+		default:
+			return nil, fmt.Errorf("Filter: Unknown Method %q", name)
+		}
+		operand = func(env map[string]interface{}) (interface{}, error) {
+			recv, err := receiver(env)
+			if err != nil {
+				return nil, err
+			}
+			a, err := arg(env)
+			if err != nil {
+				return nil, err
+			}
+			return callMethod(name, recv, a)
+		}
+	}
+	return operand, nil
+}
+
+func (p *filterParser) parsePrimary() (evalFunc, error) {
+	t := p.next()
+	switch t.kind {
+	case "number":
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Filter: Bad Number %q", t.text)
+		}
+		return constant(n), nil
+	case "string":
+		return constant(t.text), nil
+	case "ident":
+		switch t.text {
+		case "true":
+			return constant(true), nil
+		case "false":
+			return constant(false), nil
+		}
+		name := t.text
+		return func(env map[string]interface{}) (interface{}, error) {
+			v, ok := env[name]
+			if !ok {
+				return nil, fmt.Errorf("Filter: Unknown Variable %q", name)
+			}
+			return v, nil
+		}, nil
+	case "op":
+		switch t.text {
+		case "(":
+			inner, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return inner, p.expect(")")
+		case "[":
+			var items []evalFunc
+			for !p.accept("]") {
+				if len(items) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				item, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+			return func(env map[string]interface{}) (interface{}, error) {
+				list := make([]interface{}, 0, len(items))
+				for _, item := range items {
+					v, err := item(env)
+					if err != nil {
+						return nil, err
+					}
+					list = append(list, v)
+				}
+				return list, nil
+			}, nil
+		}
+	}
+	if t.kind != "eof" {
+		// point the error at t, next doesn't move past the end
+		p.pos--
+	}
+	return nil, p.errorf("Unexpected %q", t.text)
+}
+
+func constant(v interface{}) evalFunc {
+	return func(map[string]interface{}) (interface{}, error) {
+		return v, nil
+	}
+}
+
+func evalBool(f evalFunc, env map[string]interface{}) (bool, error) {
+	v, err := f(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("Filter: Expected Bool But Got %v", v)
+	}
+	return b, nil
+}
+
+func compareValues(op string, a, b interface{}) (interface{}, error) {
+	if op == "in" {
+		return callMethod("contains", b, a)
+	}
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		if !ok {
+			return nil, fmt.Errorf("Filter: Cannot Compare %v With %v", a, b)
+		}
+		switch op {
+		case "==":
+			return x == y, nil
+		case "!=":
+			return x != y, nil
+		case "<":
+			return x < y, nil
+		case "<=":
+			return x <= y, nil
+		case ">":
+			return x > y, nil
+		case ">=":
+			return x >= y, nil
+		}
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return nil, fmt.Errorf("Filter: Cannot Compare %v With %v", a, b)
+		}
+		switch op {
+		case "==":
+			return strings.EqualFold(x, y), nil
+		case "!=":
+			return !strings.EqualFold(x, y), nil
+		case "<":
+			return x < y, nil
+		case "<=":
+			return x <= y, nil
+		case ">":
+			return x > y, nil
+		case ">=":
+			return x >= y, nil
+		}
+	case bool:
+		y, ok := b.(bool)
+		if ok && op == "==" {
+			return x == y, nil
+		}
+		if ok && op == "!=" {
+			return x != y, nil
+		}
+	}
+	return nil, fmt.Errorf("Filter: Cannot Apply %s To %v And %v", op, a, b)
+}
+
+func callMethod(name string, recv, arg interface{}) (interface{}, error) {
+	switch r := recv.(type) {
+	case []interface{}:
+		if name != "contains" {
+			break
+		}
+		for _, item := range r {
+			if eq, err := compareValues("==", item, arg); err == nil && eq.(bool) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case string:
+		s, ok := arg.(string)
+		if !ok {
+			break
+		}
+		r, s = strings.ToLower(r), strings.ToLower(s)
+		switch name {
+		case "contains":
+			return strings.Contains(r, s), nil
+		case "startsWith":
+			return strings.HasPrefix(r, s), nil
+		case "endsWith":
+			return strings.HasSuffix(r, s), nil
+		}
+	}
+	return nil, errors.New("Filter: Cannot Call " + name + " On " + fmt.Sprint(recv))
+}
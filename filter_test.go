@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFilterMatch(t *testing.T) {
+	gallery := Gallery{
+		Id:      "1234",
+		Title:   "Test Gallery",
+		Lang:    "japanese",
+		Type:    "manga",
+		Artists: NameList{"someone"},
+		Tags:    []Tag{{Tag: "glasses", Female: true}, {Tag: "full color"}},
+		Files:   make([]Image, 20),
+	}
+	for _, c := range []struct {
+		expr string
+		want bool
+	}{
+		{`pages > 15`, true},
+		{`pages > 15 && lang in ["japanese", "english"]`, true},
+		{`!tags.contains("ai generated")`, true},
+		{`tags.contains("female:glasses") || false`, true},
+		{`type == "doujinshi"`, false},
+		{`title.startsWith("Test") && (artists.contains("someone") || pages < 5)`, true},
+		{`lang in ['korean']`, false},
+	} {
+		filter, err := CompileFilter(c.expr)
+		if err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+			continue
+		}
+		if got, err := filter.Match(gallery); err != nil || got != c.want {
+			t.Errorf("%s = %v %v, want %v", c.expr, got, err, c.want)
+		}
+	}
+
+	// PageCount is the size before pages were left out
+	gallery.PageCount, gallery.Files = 20, gallery.Files[:1]
+	filter, err := CompileFilter(`pages == 20`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := filter.Match(gallery); err != nil || !got {
+		t.Errorf("pages with PageCount 20 and 1 file: %v %v, want 20", got, err)
+	}
+}
+
+func TestCompileFilterErrors(t *testing.T) {
+	for _, c := range []struct {
+		expr string
+		// at is where the error points
+		at string
+	}{
+		{``, "At 0"},
+		{`  `, "At 2"},
+		{`pages >`, "At 7"},
+		{`pages > 15 &&`, "At 13"},
+		{`pages > 15 ||   `, "At 16"},
+		{`lang in [`, "At 9"},
+		{`(pages > 1`, "At 10"},
+		{`pages > )`, "At 8"},
+		{`pages 15`, "At 6"},
+		{`title == "open`, "At 9"},
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("%q panics: %v", c.expr, r)
+				}
+			}()
+			_, err := CompileFilter(c.expr)
+			if err == nil {
+				t.Errorf("%q compiles", c.expr)
+			} else if !strings.HasSuffix(err.Error(), c.at) {
+				t.Errorf("%q: %v, want it %s", c.expr, err, c.at)
+			}
+		}()
+	}
+}
+
+func TestFilterSeesWholeGallery(t *testing.T) {
+	savePath := setupPipeline(t)
+	defer func(f *Filter, covers bool) { filter, *coversOnly = f, covers }(filter, *coversOnly)
+	var err error
+	if filter, err = CompileFilter(`pages == 2`); err != nil {
+		t.Fatal(err)
+	}
+	*coversOnly = true
+
+	js := strings.NewReplacer(`"1234"`, `"2468"`, "Test Gallery", "Two Page Gallery").Replace(testGalleryJs)
+	js = strings.Replace(js, `}]}`, `},{"name":"02.jpg","hash":"`+strings.Repeat("0", 64)+`","haswebp":0,"hasavif":0,"width":10,"height":10}]}`, 1)
+	js = strings.Replace(js, `"haswebp":1`, `"haswebp":0`, 1)
+	mockSite(t, map[string]http.HandlerFunc{
+		"/galleries/2468.js":                serveString(js),
+		"/images/e/d2/" + testHash + ".jpg": serveString("\xff\xd8\xff\xe0 page"),
+	})
+	RunJobs(UrlJobs([]string{"https://hitomi.la/galleries/2468.html"}))
+
+	dir, err := GalleryPath(Gallery{Id: "2468", Title: "Two Page Gallery", Type: "manga", Lang: "japanese"}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(savePath, dir, "01.jpg")); err != nil {
+		t.Errorf("the cover of a gallery matching the filter was not downloaded: %v", err)
+	}
+}
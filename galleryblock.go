@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"html"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	blockTitleRegexp = regexp.MustCompile(`(?s)<h1[^>]*>\s*<a[^>]*>(.*?)</a>`)
+	blockDateRegexp  = regexp.MustCompile(`(?s)<p class="date"[^>]*>(.*?)</p>`)
+	blockLinkRegexp  = regexp.MustCompile(`href="/(artist|group|series|character|tag|type)/([^"]+?)-all\.html"`)
+	blockLangRegexp  = regexp.MustCompile(`href="/index-([^"]+?)\.html"`)
+	readerImgRegexp  = regexp.MustCompile(`<div class="img-url">([^<]+)</div>`)
+)
+
+// GalleryBlockInfo recovers what it can when the galleries js is not
+// available: metadata from the galleryblock, and the page list from the
+// reader page when it still lists image urls.
+func GalleryBlockInfo(ctx context.Context, id string) (gallery Gallery, err error) {
+	code, resp, err := Get(ctx, MetadataUrl("galleryblock/"+id+".html"))
+	if err != nil {
+		return gallery, err
+	}
+	if code != 200 {
+		return gallery, errors.New("Galleryblock Status Code " + strconv.Itoa(code))
+	}
+	gallery = ParseGalleryBlock(string(resp))
+	gallery.Id = id
+	if gallery.Title == "" {
+		return gallery, errors.New("No Title In Galleryblock")
+	}
+
+	code, resp, err = Get(ctx, "https://hitomi.la/reader/"+id+".html")
+	if err == nil && code == 200 {
+		gallery.Files = ParseReaderImages(string(resp))
+	}
+	return gallery, nil
+}
+
+func ParseGalleryBlock(block string) (gallery Gallery) {
+	if m := blockTitleRegexp.FindStringSubmatch(block); m != nil {
+		gallery.Title = strings.TrimSpace(html.UnescapeString(m[1]))
+	}
+	if m := blockDateRegexp.FindStringSubmatch(block); m != nil {
+		gallery.Date = strings.TrimSpace(html.UnescapeString(m[1]))
+	}
+	if m := blockLangRegexp.FindStringSubmatch(block); m != nil {
+		gallery.Lang = m[1]
+	}
+	for _, m := range blockLinkRegexp.FindAllStringSubmatch(block, -1) {
+		name, err := url.PathUnescape(m[2])
+		if err != nil {
+			name = m[2]
+		}
+		switch m[1] {
+		case "artist":
+			gallery.Artists = append(gallery.Artists, name)
+		case "group":
+			gallery.Groups = append(gallery.Groups, name)
+		case "series":
+			gallery.Parodys = append(gallery.Parodys, name)
+		case "character":
+			gallery.Characters = append(gallery.Characters, name)
+		case "type":
+			gallery.Type = name
+		case "tag":
+			tag := Tag{Tag: name}
+			if strings.HasPrefix(name, "female:") {
+				tag = Tag{Tag: strings.TrimPrefix(name, "female:"), Female: true}
+			} else if strings.HasPrefix(name, "male:") {
+				tag = Tag{Tag: strings.TrimPrefix(name, "male:"), Male: true}
+			}
+			gallery.Tags = append(gallery.Tags, tag)
+		}
+	}
+	return gallery
+}
+
+func ParseReaderImages(page string) []Image {
+	var files []Image
+	for _, m := range readerImgRegexp.FindAllStringSubmatch(page, -1) {
+		u := strings.TrimSpace(html.UnescapeString(m[1]))
+		if strings.HasPrefix(u, "//") {
+			u = "https:" + u
+		}
+		files = append(files, Image{Name: path.Base(u), Url: u})
+	}
+	return files
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// quarantineDir is where GalleryFailAction "quarantine" moves failed
+// galleries, below the storage root.
+const quarantineDir = ".quarantine"
+
+// GalleryRemover is implemented by storages which can take a saved gallery
+// away again, for a gallery that mostly failed, or move it into place from
+// the staging directory.
+type GalleryRemover interface {
+	HasGallery(dir string) bool
+	RemoveGallery(dir string) error
+	// MoveGallery moves the gallery dir to to, it fails when to exists.
+	MoveGallery(dir string, to string) error
+}
+
+// GalleryFailed tells if more than GalleryFailPercent of the images failed,
+// so the gallery is failed as a whole instead of kept partial.
+func GalleryFailed(failed int64, total int, conf Conf) bool {
+	return conf.GalleryFailPercent > 0 && failed > 0 && failed*100 > int64(conf.GalleryFailPercent)*int64(total)
+}
+
+// NewGallery tells if nothing of the gallery at savePath is saved yet, as
+// far as the storage can tell, so a failure may discard it. Galleries being
+// repaired or resumed are kept.
+func NewGallery(savePath string) bool {
+	if overwriteExisting {
+		return false
+	}
+	remover, ok := storage.(GalleryRemover)
+	return !ok || !remover.HasGallery(savePath)
+}
+
+// FailGallery records the gallery as failed for retry-failed and removes or
+// quarantines what was saved of it, as GalleryFailAction says, when it was
+// created by this run.
+func FailGallery(gallery Gallery, title string, savePath string, failed int64, created bool, conf Conf) {
+	reason := strconv.FormatInt(failed, 10) + " Of " + strconv.Itoa(len(gallery.Files)) + " Images Failed"
+	if err := storage.Finalize(savePath); err != nil {
+		log.Println("Finalize Gallery Fail: " + title + " Because " + err.Error())
+	}
+	if (conf.GalleryFailAction == "remove" || conf.GalleryFailAction == "quarantine") && !created {
+		log.Println("Keep Failed Gallery (Saved Before This Run): " + savePath)
+	} else if conf.GalleryFailAction == "remove" || conf.GalleryFailAction == "quarantine" {
+		if remover, ok := storage.(GalleryRemover); !ok {
+			log.Println("Discard Gallery Fail: " + savePath + " Because Storage " + conf.Storage + " Can't Remove Galleries")
+		} else if conf.GalleryFailAction == "remove" {
+			if err := remover.RemoveGallery(savePath); err != nil {
+				log.Println("Remove Gallery Fail: " + savePath + " Because " + err.Error())
+			} else {
+				log.Println("Removed Failed Gallery: " + savePath)
+			}
+		} else {
+			to := quarantineDir + "/" + strings.TrimPrefix(savePath, incompleteDir+"/")
+			for i := 2; remover.HasGallery(to); i++ {
+				to = quarantineDir + "/" + strings.TrimPrefix(savePath, incompleteDir+"/") + " (" + strconv.Itoa(i) + ")"
+			}
+			if err := remover.MoveGallery(savePath, to); err != nil {
+				log.Println("Quarantine Gallery Fail: " + savePath + " Because " + err.Error())
+			} else {
+				log.Println("Quarantined Failed Gallery: " + to)
+			}
+		}
+	}
+	fmt.Println()
+	log.Println("Gallery Failed: " + title + " Because " + reason)
+	atomic.AddInt64(&summary.GalleriesFailed, 1)
+	RecordFailure(Failure{Url: gallery.Url, Id: gallery.Id, Title: gallery.Title, Reason: reason})
+	NotifyGallery(GalleryResult{Gallery: gallery, SavePath: savePath, Err: reason})
+}
+
+func moveFile(from string, to string) error {
+	if _, err := os.Stat(from); os.IsNotExist(err) {
+		return nil
+	}
+	if _, err := os.Lstat(to); err == nil {
+		return errors.New(to + " Exists Already")
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	return os.Rename(from, to)
+}
+
+func exists(fileName string) bool {
+	_, err := os.Stat(fileName)
+	return err == nil
+}
+
+func (s *LocalStorage) HasGallery(dir string) bool {
+	return exists(filepath.Join(s.Root, filepath.FromSlash(dir)))
+}
+
+func (s *LocalStorage) RemoveGallery(dir string) error {
+	return os.RemoveAll(filepath.Join(s.Root, filepath.FromSlash(dir)))
+}
+
+func (s *LocalStorage) MoveGallery(dir string, to string) error {
+	return moveFile(filepath.Join(s.Root, filepath.FromSlash(dir)), filepath.Join(s.Root, filepath.FromSlash(to)))
+}
+
+func (s *ZipStorage) HasGallery(dir string) bool {
+	return exists(s.archivePath(dir))
+}
+
+func (s *ZipStorage) RemoveGallery(dir string) error {
+	return os.Remove(s.archivePath(dir))
+}
+
+func (s *ZipStorage) MoveGallery(dir string, to string) error {
+	return moveFile(s.archivePath(dir), s.archivePath(to))
+}
+
+func (s *TarStorage) HasGallery(dir string) bool {
+	return exists(s.archivePath(dir))
+}
+
+func (s *TarStorage) RemoveGallery(dir string) error {
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+	s.closeReader(dir)
+	return os.Remove(s.archivePath(dir))
+}
+
+func (s *TarStorage) MoveGallery(dir string, to string) error {
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+	s.closeReader(dir)
+	return moveFile(s.archivePath(dir), s.archivePath(to))
+}
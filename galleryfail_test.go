@@ -0,0 +1,188 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGalleryFailed(t *testing.T) {
+	for _, c := range []struct {
+		percent int
+		failed  int64
+		total   int
+		want    bool
+	}{
+		{0, 10, 10, false},
+		{50, 5, 10, false},
+		{50, 6, 10, true},
+		{10, 1, 5, true},
+		{100, 10, 10, false},
+	} {
+		if got := GalleryFailed(c.failed, c.total, Conf{GalleryFailPercent: c.percent}); got != c.want {
+			t.Errorf("%d of %d failed with GalleryFailPercent %d: %v, want %v", c.failed, c.total, c.percent, got, c.want)
+		}
+	}
+}
+
+func TestFailGalleryQuarantines(t *testing.T) {
+	savePath := setupPipeline(t)
+	defer func(percent int, action string) {
+		conf.GalleryFailPercent, conf.GalleryFailAction = percent, action
+	}(conf.GalleryFailPercent, conf.GalleryFailAction)
+	conf.GalleryFailPercent, conf.GalleryFailAction = 40, "quarantine"
+
+	// two pages, the second is missing everywhere
+	js := strings.NewReplacer(`"1234"`, `"3456"`, "Test Gallery", "Failing Gallery").Replace(testGalleryJs)
+	js = strings.Replace(js, `}]}`, `},{"name":"02.jpg","hash":"`+strings.Repeat("0", 64)+`","haswebp":0,"hasavif":0,"width":10,"height":10}]}`, 1)
+	js = strings.Replace(js, `"haswebp":1`, `"haswebp":0`, 1)
+	mockSite(t, map[string]http.HandlerFunc{
+		"/galleries/3456.js":                serveString(js),
+		"/images/e/d2/" + testHash + ".jpg": serveString("\xff\xd8\xff\xe0 page"),
+	})
+	failed := len(failures)
+	RunJobs(UrlJobs([]string{"https://hitomi.la/galleries/3456.html"}))
+
+	dir, err := GalleryPath(Gallery{Id: "3456", Title: "Failing Gallery", Type: "manga", Lang: "japanese"}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(savePath, dir)); !os.IsNotExist(err) {
+		t.Error("the failed gallery is still in the library")
+	}
+	if _, err = ioutil.ReadFile(filepath.Join(savePath, quarantineDir, dir, "01.jpg")); err != nil {
+		t.Errorf("the saved page was not quarantined: %v", err)
+	}
+	whole := false
+	for _, f := range failures[failed:] {
+		whole = whole || f.Url == "https://hitomi.la/galleries/3456.html" && f.Hash == ""
+	}
+	if !whole {
+		t.Error("the gallery was not recorded as failed as a whole")
+	}
+}
+
+func TestFailGalleryKeepsExisting(t *testing.T) {
+	savePath := setupPipeline(t)
+	defer func(percent int, action string) {
+		conf.GalleryFailPercent, conf.GalleryFailAction = percent, action
+	}(conf.GalleryFailPercent, conf.GalleryFailAction)
+	conf.GalleryFailPercent, conf.GalleryFailAction = 40, "remove"
+
+	js := strings.NewReplacer(`"1234"`, `"3457"`, "Test Gallery", "Library Gallery").Replace(testGalleryJs)
+	js = strings.Replace(js, `}]}`, `},{"name":"02.jpg","hash":"`+strings.Repeat("0", 64)+`","haswebp":0,"hasavif":0,"width":10,"height":10}]}`, 1)
+	js = strings.Replace(js, `"haswebp":1`, `"haswebp":0`, 1)
+	mockSite(t, map[string]http.HandlerFunc{
+		"/galleries/3457.js": serveString(js),
+	})
+	gallery, err := GalleryInfo(appCtx, "https://hitomi.la/galleries/3457.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gallery.Url = "https://hitomi.la/galleries/3457.html"
+	PrepareFiles(gallery.Files, conf)
+	dir, err := GalleryPath(gallery, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := filepath.Join(savePath, dir, "01.jpg")
+	if err = storage.Write(dir+"/01.jpg", []byte("\xff\xd8\xff\xe0 page")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name   string
+		repair bool
+	}{
+		{"repair", true},
+		{"resume", false},
+	} {
+		// only the broken page is downloaded, and it fails
+		broken := gallery
+		broken.Files = gallery.Files[1:]
+		overwriteExisting = c.repair
+		DownloadGallery(broken, 0, 1, conf)
+		overwriteExisting = false
+		if _, err = os.Stat(saved); err != nil {
+			t.Errorf("a failed %s discarded the saved gallery: %v", c.name, err)
+		}
+	}
+}
+
+func TestFailGalleryActions(t *testing.T) {
+	savePath := setupPipeline(t)
+	for _, c := range []struct {
+		action  string
+		created bool
+		// kept and quarantined tell where the page is left
+		kept        bool
+		quarantined bool
+	}{
+		{"", true, true, false},
+		{"keep", true, true, false},
+		{"remove", true, false, false},
+		{"remove", false, true, false},
+		{"quarantine", true, false, true},
+		{"quarantine", false, true, false},
+	} {
+		dir := "japanese/Fail Action " + c.action + " " + strconv.FormatBool(c.created)
+		if err := storage.Write(dir+"/01.jpg", []byte("\xff\xd8\xff\xe0 page")); err != nil {
+			t.Fatal(err)
+		}
+		gallery := Gallery{Id: "3458", Title: "Fail Action", Files: make([]Image, 2)}
+		FailGallery(gallery, gallery.Title, dir, 1, c.created, Conf{GalleryFailAction: c.action, Storage: "local"})
+
+		_, err := os.Stat(filepath.Join(savePath, dir, "01.jpg"))
+		if kept := err == nil; kept != c.kept {
+			t.Errorf("%q created %v: kept %v, want %v", c.action, c.created, kept, c.kept)
+		}
+		_, err = os.Stat(filepath.Join(savePath, quarantineDir, dir, "01.jpg"))
+		if quarantined := err == nil; quarantined != c.quarantined {
+			t.Errorf("%q created %v: quarantined %v, want %v", c.action, c.created, quarantined, c.quarantined)
+		}
+	}
+}
+
+func TestMoveGalleryKeepsTarget(t *testing.T) {
+	for _, kind := range []string{"local", "zip"} {
+		s, err := NewStorage(Conf{Storage: kind, SavePath: t.TempDir()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for dir, page := range map[string]string{"staged/Gallery": "staged", "library/Gallery": "saved"} {
+			if err = s.Write(dir+"/01.jpg", []byte(page)); err != nil {
+				t.Fatal(err)
+			}
+			s.Finalize(dir)
+		}
+		remover := s.(GalleryRemover)
+		if err = remover.MoveGallery("staged/Gallery", "library/Gallery"); err == nil {
+			t.Errorf("%s: moved onto a saved gallery", kind)
+		}
+		for dir, page := range map[string]string{"staged/Gallery": "staged", "library/Gallery": "saved"} {
+			if content, err := s.Read(dir + "/01.jpg"); err != nil || string(content) != page {
+				t.Errorf("%s: %s holds %q %v, want %q", kind, dir, content, err, page)
+			}
+		}
+	}
+}
+
+func TestQuarantineTwice(t *testing.T) {
+	savePath := setupPipeline(t)
+	dir := "japanese/Quarantined Twice"
+	for _, page := range []string{"first", "second"} {
+		if err := storage.Write(dir+"/01.jpg", []byte(page)); err != nil {
+			t.Fatal(err)
+		}
+		FailGallery(Gallery{Id: "3459", Title: "Quarantined Twice", Files: make([]Image, 2)}, "Quarantined Twice", dir, 1, true, Conf{GalleryFailAction: "quarantine", Storage: "local"})
+	}
+	for to, page := range map[string]string{dir: "first", dir + " (2)": "second"} {
+		if content, err := ioutil.ReadFile(filepath.Join(savePath, quarantineDir, to, "01.jpg")); err != nil || string(content) != page {
+			t.Errorf("%s holds %q %v, want %q", to, content, err, page)
+		}
+	}
+}
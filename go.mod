@@ -3,7 +3,17 @@ module hitomi
 go 1.15
 
 require (
-	github.com/klauspost/compress v1.11.4 // indirect
-	github.com/valyala/fasthttp v1.18.0 // indirect
-	golang.org/x/image v0.0.0-20201208152932-35266b937fa6 // indirect
+	github.com/BurntSushi/toml v0.3.1
+	github.com/charmbracelet/bubbletea v0.13.4
+	github.com/klauspost/compress v1.11.4
+	github.com/pkg/sftp v1.13.0
+	github.com/robfig/cron/v3 v3.0.1
+	github.com/valyala/fasthttp v1.18.0
+	github.com/yeka/zip v0.0.0-20231116150916-03d6312748a9
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
+	golang.org/x/image v0.0.0-20201208152932-35266b937fa6
+	golang.org/x/net v0.0.0-20201021035429-f5854403a974
+	golang.org/x/sys v0.0.0-20211007075335-d3039528d8ac
+	gopkg.in/yaml.v3 v3.0.1
+	modernc.org/sqlite v1.14.0
 )
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"image"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// hashQueue takes the written pages to the hash workers, so the writer can
+// go on with the next page meanwhile.
+var hashQueue chan WriteJob
+
+func HashWorker() {
+	for job := range hashQueue {
+		HashHandler(job)
+	}
+}
+
+// HashHandler hashes a written page for the manifest, which the duplicate
+// index and verify are fed from, and finishes the page.
+func HashHandler(job WriteJob) {
+	defer buffered.Release(job.Reserved)
+	defer PutBody(job.body)
+	file := ManifestFile{
+		Name:   strings.TrimPrefix(job.FileName, job.Job.SavePath+"/"),
+		Size:   int64(len(job.Content)),
+		Sha256: Sha256Sum(job.Content),
+		Url:    ImageUrl(job.Job.Image),
+	}
+	if job.Job.Conf.PerceptualHash {
+		if hash, err := PerceptualHash(job.Content); err == nil {
+			file.Phash = hash
+		}
+	}
+	ImageDone(job.Job, file)
+}
+
+// PerceptualHash is the 64 bit difference hash of an image in hex: every bit
+// tells if a pixel of its 9x8 grayscale thumbnail is brighter than the next
+// one, so the same page re-encoded or resized hashes (nearly) the same.
+func PerceptualHash(content []byte) (string, error) {
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+	thumb := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.ApproxBiLinear.Scale(thumb, thumb.Bounds(), img, img.Bounds(), draw.Src, nil)
+	var hash [8]byte
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if thumb.GrayAt(x, y).Y > thumb.GrayAt(x+1, y).Y {
+				hash[y] |= 1 << uint(7-x)
+			}
+		}
+	}
+	return hex.EncodeToString(hash[:]), nil
+}
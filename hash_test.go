@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// gradientPng is a horizontal gradient, getting darker to the right unless
+// rising.
+func gradientPng(t *testing.T, width int, height int, rising bool) []byte {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := 255 - x*255/width
+			if rising {
+				v = x * 255 / width
+			}
+			img.SetGray(x, y, color.Gray{Y: uint8(v)})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPerceptualHash(t *testing.T) {
+	hash := func(content []byte) string {
+		h, err := PerceptualHash(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	falling := hash(gradientPng(t, 180, 160, false))
+	if falling != "ffffffffffffffff" {
+		t.Errorf("falling gradient hashes to %s", falling)
+	}
+	if resized := hash(gradientPng(t, 90, 80, false)); resized != falling {
+		t.Errorf("resized page hashes to %s, want %s", resized, falling)
+	}
+	if rising := hash(gradientPng(t, 180, 160, true)); rising != "0000000000000000" {
+		t.Errorf("rising gradient hashes to %s", rising)
+	}
+	if _, err := PerceptualHash([]byte("not an image")); err == nil {
+		t.Error("hashed something which isn't an image")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// healthStall is how long the gallery being downloaded may go without a
+// saved image before /healthz calls the server wedged.
+const healthStall = 10 * time.Minute
+
+// metadataCheckTtl is how long a check of the metadata host is reused, so
+// frequent probes don't turn into requests to the site.
+const metadataCheckTtl = 30 * time.Second
+
+// lastDownload is the UnixNano of the last image saved.
+var lastDownload int64
+
+type Health struct {
+	Ok       bool     `json:"ok"`
+	Problems []string `json:"problems,omitempty"`
+	Paused   bool     `json:"paused"`
+	// Pending are the galleries waiting, Images the queued images.
+	Pending           int        `json:"pending"`
+	Images            int        `json:"images"`
+	Current           string     `json:"current,omitempty"`
+	LastDownload      *time.Time `json:"last_download,omitempty"`
+	MetadataHost      string     `json:"metadata_host"`
+	MetadataReachable bool       `json:"metadata_reachable"`
+}
+
+type metadataCheck struct {
+	lock    sync.Mutex
+	checked time.Time
+	err     error
+}
+
+var metadataReachable metadataCheck
+
+// Check tells if the metadata host answers at all, any status counts, reusing
+// the last result for metadataCheckTtl.
+func (c *metadataCheck) Check(ctx context.Context) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.checked.IsZero() && time.Since(c.checked) < metadataCheckTtl {
+		return c.err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	_, _, c.err = fetcher.Fetch(ctx, MetadataUrl(""))
+	c.checked = time.Now()
+	return c.err
+}
+
+// Health is unhealthy when the metadata host can't be reached or the current
+// gallery saved no image for healthStall while not paused.
+func (d *Daemon) Health(ctx context.Context, now time.Time) Health {
+	d.lock.Lock()
+	health := Health{Paused: pause.Paused(), Pending: d.pending.Len(), Current: d.current}
+	since := d.since
+	d.lock.Unlock()
+	health.Images = len(queue)
+	health.MetadataHost = MetadataUrl("")
+	if last := atomic.LoadInt64(&lastDownload); last > 0 {
+		t := time.Unix(0, last)
+		health.LastDownload = &t
+		if t.After(since) {
+			since = t
+		}
+	}
+	if err := metadataReachable.Check(ctx); err != nil {
+		health.Problems = append(health.Problems, "Metadata Host Unreachable: "+err.Error())
+	} else {
+		health.MetadataReachable = true
+	}
+	if health.Current != "" && !health.Paused && now.Sub(since) > healthStall {
+		health.Problems = append(health.Problems, "No Image Saved For "+now.Sub(since).Round(time.Second).String())
+	}
+	health.Ok = len(health.Problems) == 0
+	return health
+}
+
+// HealthHandler serves /healthz, with 503 when the server is unhealthy.
+func (d *Daemon) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	health := d.Health(r.Context(), time.Now())
+	if !health.Ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJson(w, health)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	mockSite(t, map[string]http.HandlerFunc{})
+	metadataReachable = metadataCheck{}
+	defer func(last int64) { atomic.StoreInt64(&lastDownload, last) }(atomic.LoadInt64(&lastDownload))
+	atomic.StoreInt64(&lastDownload, 0)
+
+	now := time.Now()
+	d := NewDaemon()
+	if health := d.Health(context.Background(), now); !health.Ok || !health.MetadataReachable {
+		t.Errorf("idle server is unhealthy: %+v", health)
+	}
+
+	d.current, d.since = "https://hitomi.la/galleries/1234.html", now.Add(-healthStall-time.Minute)
+	if health := d.Health(context.Background(), now); health.Ok {
+		t.Error("a gallery without a saved image for longer than healthStall is healthy")
+	}
+	atomic.StoreInt64(&lastDownload, now.Add(-time.Minute).UnixNano())
+	health := d.Health(context.Background(), now)
+	if !health.Ok || health.LastDownload == nil {
+		t.Errorf("a gallery saving images is unhealthy: %+v", health)
+	}
+
+	atomic.StoreInt64(&lastDownload, 0)
+	w := httptest.NewRecorder()
+	d.HealthHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d for a wedged server, want 503", w.Code)
+	}
+	if err := json.NewDecoder(w.Body).Decode(&health); err != nil || health.Ok || len(health.Problems) != 1 {
+		t.Errorf("body %+v, %v", health, err)
+	}
+}
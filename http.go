@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"golang.org/x/net/proxy"
+)
+
+const defaultUserAgent = "Mozilla/5.0 (Windows NT 6.1; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/87.0.4280.88 Safari/537.36"
+
+// defaultMetadataHost serves the gallery info and the scripts, the images are
+// on subdomains of defaultImageDomain.
+const (
+	defaultMetadataHost = "ltn.hitomi.la"
+	defaultImageDomain  = "hitomi.la"
+)
+
+// frontends is the number of image servers, a*.hitomi.la to c*.hitomi.la.
+const frontends = 3
+
+// frontendThreshold splits the hash directories between the frontends, the
+// ones below it are on the second.
+const frontendThreshold = 0x7c
+
+var userAgentCounter uint32
+
+// MetadataUrl is the url of p on MetadataHost, which may be a url itself, like
+// the "http://127.0.0.1:8081" of a local reverse proxy.
+func MetadataUrl(p string) string {
+	host := conf.MetadataHost
+	if host == "" {
+		host = defaultMetadataHost
+	}
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	return strings.TrimSuffix(host, "/") + "/" + p
+}
+
+// ImageHost is the host subDomain of ImageDomain.
+func ImageHost(subDomain string) string {
+	domain := conf.ImageDomain
+	if domain == "" {
+		domain = defaultImageDomain
+	}
+	return subDomain + "." + domain
+}
+
+var (
+	hostSlotsLock sync.Mutex
+	hostSlots     = map[string]chan struct{}{}
+)
+
+// RequestHeaders returns the headers sent with every request: the defaults,
+// then the configured Headers and Cookies on top.
+func RequestHeaders(referer string, galleryId string) map[string]string {
+	headers := map[string]string{
+		"User-Agent": UserAgent(galleryId),
+	}
+	if referer != "" {
+		headers["Referer"] = referer
+	}
+	for key, value := range conf.Headers {
+		headers[key] = value
+	}
+	if len(conf.Cookies) > 0 {
+		cookies := make([]string, 0, len(conf.Cookies))
+		for name, value := range conf.Cookies {
+			cookies = append(cookies, name+"="+value)
+		}
+		sort.Strings(cookies)
+		headers["Cookie"] = strings.Join(cookies, "; ")
+	}
+	return headers
+}
+
+// UserAgent rotates through UserAgents per request, or per gallery when
+// UserAgentRotation is "gallery".
+func UserAgent(galleryId string) string {
+	if len(conf.UserAgents) == 0 {
+		return defaultUserAgent
+	}
+	var i uint32
+	if conf.UserAgentRotation == "gallery" && galleryId != "" {
+		h := fnv.New32a()
+		h.Write([]byte(galleryId))
+		i = h.Sum32()
+	} else {
+		i = atomic.AddUint32(&userAgentCounter, 1)
+	}
+	return conf.UserAgents[i%uint32(len(conf.UserAgents))]
+}
+
+func NewTransport(proxyUrl *url.URL) *http.Transport {
+	dialer := &net.Dialer{Timeout: time.Duration(conf.ConnectTimeout) * time.Second}
+	dial := resolver.DialContext(dialer.DialContext)
+	transport := &http.Transport{
+		DialContext:           dial,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   time.Duration(conf.ConnectTimeout) * time.Second,
+		ResponseHeaderTimeout: time.Duration(conf.ReadTimeout) * time.Second,
+		MaxIdleConnsPerHost:   conf.ThreadNum,
+	}
+	if proxyUrl != nil {
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	} else if len(conf.Tls.ServerNames) > 0 {
+		// the transport sends the host as SNI itself
+		transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return DialTls(ctx, dial, network, addr)
+		}
+	}
+	return transport
+}
+
+// MetadataDialer is the dialer of the metadata client, going through Socks
+// when it is set. Hosts with ServerNames get their TLS connection from it,
+// fasthttp keeps those as they are.
+func MetadataDialer() (fasthttp.DialFunc, error) {
+	dialer := &net.Dialer{Timeout: time.Duration(conf.ConnectTimeout) * time.Second}
+	dial := resolver.DialContext(dialer.DialContext)
+	if conf.Socks != "" {
+		socks, err := proxy.SOCKS5("tcp", conf.Socks, nil, dialer)
+		if err != nil {
+			return nil, err
+		}
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return socks.Dial(network, addr)
+		}
+	}
+	return func(addr string) (net.Conn, error) {
+		if host, port, err := net.SplitHostPort(addr); err == nil && port == "443" && ServerName(conf.Tls.ServerNames, host) != "" {
+			return DialTls(context.Background(), dial, "tcp", addr)
+		}
+		return dial(context.Background(), "tcp", addr)
+	}, nil
+}
+
+// ImageContext bounds a single image request by RequestTimeout.
+func ImageContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if conf.RequestTimeout > 0 {
+		return context.WithTimeout(parent, time.Duration(conf.RequestTimeout)*time.Second)
+	}
+	return context.WithCancel(parent)
+}
+
+type stallReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+	stalled int32
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if atomic.LoadInt32(&s.stalled) == 1 {
+		return n, errors.New("Read Timeout")
+	}
+	s.timer.Reset(s.timeout)
+	return n, err
+}
+
+// WatchStall cancels the request when its body makes no progress for
+// ReadTimeout. The returned func stops watching.
+func WatchStall(body io.Reader, cancel context.CancelFunc) (io.Reader, func()) {
+	if conf.ReadTimeout <= 0 {
+		return body, func() {}
+	}
+	s := &stallReader{r: body, timeout: time.Duration(conf.ReadTimeout) * time.Second}
+	s.timer = time.AfterFunc(s.timeout, func() {
+		atomic.StoreInt32(&s.stalled, 1)
+		cancel()
+	})
+	return s, func() { s.timer.Stop() }
+}
+
+type StatusError int
+
+func (e StatusError) Error() string {
+	return "Status Code " + strconv.Itoa(int(e))
+}
+
+// IsFrontendError tells if err is one where another frontend often still
+// has the file.
+func IsFrontendError(err error) bool {
+	var notImage NotImageError
+	if errors.As(err, &notImage) {
+		return true
+	}
+	var status StatusError
+	if !errors.As(err, &status) {
+		return false
+	}
+	return status == http.StatusNotFound || status == http.StatusServiceUnavailable
+}
+
+// IsMissing tells if err is an answer which another try of the same url gets
+// again: not found, gone, or a page instead of an image.
+func IsMissing(err error) bool {
+	var notImage NotImageError
+	return IsGone(err) || errors.As(err, &notImage)
+}
+
+// AcquireHost blocks until host has less than MaxConnsPerHost requests
+// running and returns the func to free the slot.
+func AcquireHost(ctx context.Context, host string) (func(), error) {
+	if conf.MaxConnsPerHost < 1 {
+		return func() {}, nil
+	}
+	hostSlotsLock.Lock()
+	slots, ok := hostSlots[host]
+	if !ok {
+		slots = make(chan struct{}, conf.MaxConnsPerHost)
+		hostSlots[host] = slots
+	}
+	hostSlotsLock.Unlock()
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	var once sync.Once
+	return func() { once.Do(func() { <-slots }) }, nil
+}
+
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+// Fetcher gets the pages and scripts of the site, everything but the
+// images. Tests replace it with one serving canned responses.
+type Fetcher interface {
+	Fetch(ctx context.Context, url string) (int, []byte, error)
+}
+
+var fetcher Fetcher = FastHttpFetcher{Client: &Client}
+
+// Get fetches url with fetcher.
+func Get(ctx context.Context, url string) (int, []byte, error) {
+	if err := requestLimiter.WaitUrl(ctx, url); err != nil {
+		return 0, nil, err
+	}
+	return fetcher.Fetch(ctx, url)
+}
+
+// Validators are what a cached response is revalidated with.
+type Validators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// ConditionalFetcher is a Fetcher which can revalidate a cached response,
+// it answers 304 without a body when the response is unchanged.
+type ConditionalFetcher interface {
+	FetchIfModified(ctx context.Context, url string, cached Validators) (int, []byte, Validators, error)
+}
+
+// GetIfModified revalidates the response cached with validators when
+// fetcher can, otherwise it fetches url again.
+func GetIfModified(ctx context.Context, url string, cached Validators) (int, []byte, Validators, error) {
+	if err := requestLimiter.WaitUrl(ctx, url); err != nil {
+		return 0, nil, Validators{}, err
+	}
+	if f, ok := fetcher.(ConditionalFetcher); ok {
+		return f.FetchIfModified(ctx, url, cached)
+	}
+	code, body, err := fetcher.Fetch(ctx, url)
+	return code, body, Validators{}, err
+}
+
+// FastHttpFetcher fetches with the fasthttp metadata client, giving up when
+// ctx is done.
+type FastHttpFetcher struct {
+	Client *fasthttp.Client
+}
+
+func (f FastHttpFetcher) Fetch(ctx context.Context, url string) (int, []byte, error) {
+	code, body, _, err := f.FetchIfModified(ctx, url, Validators{})
+	return code, body, err
+}
+
+func (f FastHttpFetcher) FetchIfModified(ctx context.Context, url string, cached Validators) (int, []byte, Validators, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, nil, Validators{}, err
+	}
+	type result struct {
+		code       int
+		body       []byte
+		validators Validators
+		err        error
+	}
+	done := make(chan result, 1)
+	go func() {
+		code, body, validators, err := f.fetch(ctx, url, cached)
+		done <- result{code, body, validators, err}
+	}()
+	select {
+	case r := <-done:
+		return r.code, r.body, r.validators, r.err
+	case <-ctx.Done():
+		return 0, nil, Validators{}, ctx.Err()
+	}
+}
+
+func (f FastHttpFetcher) fetch(ctx context.Context, url string, cached Validators) (int, []byte, Validators, error) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+	req.SetRequestURI(url)
+	req.Header.SetMethod("GET")
+	for key, value := range RequestHeaders("https://hitomi.la/", "") {
+		req.Header.Set(key, value)
+	}
+	if cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	if cached.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.LastModified)
+	}
+	deadline, ok := ctx.Deadline()
+	if conf.RequestTimeout > 0 {
+		if timeout := time.Now().Add(time.Duration(conf.RequestTimeout) * time.Second); !ok || timeout.Before(deadline) {
+			deadline, ok = timeout, true
+		}
+	}
+	var err error
+	if ok {
+		err = f.Client.DoDeadline(req, res, deadline)
+	} else {
+		err = f.Client.Do(req, res)
+	}
+	if err != nil {
+		return 0, nil, Validators{}, err
+	}
+	body := append([]byte(nil), res.Body()...)
+	validators := Validators{
+		ETag:         string(res.Header.Peek("ETag")),
+		LastModified: string(res.Header.Peek("Last-Modified")),
+	}
+	return res.StatusCode(), body, validators, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// rewriteTransport sends every request to the test server instead of the
+// host in its url, so the urls built for the live site stay untouched.
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// testFetcher is the Fetcher of the tests, fetching through a net/http
+// client pointed at the test server.
+type testFetcher struct {
+	client *http.Client
+}
+
+func (f testFetcher) Fetch(ctx context.Context, url string) (int, []byte, error) {
+	code, body, _, err := f.FetchIfModified(ctx, url, Validators{})
+	return code, body, err
+}
+
+func (f testFetcher) FetchIfModified(ctx context.Context, url string, cached Validators) (int, []byte, Validators, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, nil, Validators{}, err
+	}
+	if cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	res, err := f.client.Do(req)
+	if err != nil {
+		return 0, nil, Validators{}, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	return res.StatusCode, body, Validators{ETag: res.Header.Get("ETag")}, err
+}
+
+// mockSite serves the canned responses of routes by path and sends both the
+// metadata and the image requests to it until the test ends, with an empty
+// metadata cache.
+func mockSite(t *testing.T, routes map[string]http.HandlerFunc) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := routes[r.URL.Path]; ok {
+			route(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	target, _ := url.Parse(server.URL)
+	client := &http.Client{Transport: rewriteTransport{target}}
+	previousFetcher, previousImageClient, previousCacheDir := fetcher, ImageClient, metadataCacheDir
+	fetcher = testFetcher{client}
+	ImageClient = client
+	metadataCacheDir = t.TempDir()
+	t.Cleanup(func() {
+		fetcher, ImageClient, metadataCacheDir = previousFetcher, previousImageClient, previousCacheDir
+		server.Close()
+	})
+	return server
+}
+
+func serveString(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}
+}
+
+// failFirst answers with code the first n times, then with body.
+func failFirst(n int, code int, body []byte) http.HandlerFunc {
+	var lock sync.Mutex
+	return func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		fail := n > 0
+		n--
+		lock.Unlock()
+		if fail {
+			w.WriteHeader(code)
+			return
+		}
+		w.Write(body)
+	}
+}
+
+func TestGetUsesFetcher(t *testing.T) {
+	mockSite(t, map[string]http.HandlerFunc{
+		"/galleries/1.js": serveString("var galleryinfo = {}"),
+	})
+	code, body, err := Get(context.Background(), "https://ltn.hitomi.la/galleries/1.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 200 || string(body) != "var galleryinfo = {}" {
+		t.Errorf("got %d %q", code, body)
+	}
+}
+
+func TestGetCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := (FastHttpFetcher{Client: &Client}).Fetch(ctx, "https://ltn.hitomi.la/galleries/1.js"); err != context.Canceled {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
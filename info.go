@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var jsonFlag = flag.Bool("json", false, "info, stats, dupes, export, estimate: print JSON instead of text")
+
+// infoSamples is how many pages are measured to estimate the gallery size.
+const infoSamples = 3
+
+type InfoResult struct {
+	Id             string   `json:"id"`
+	Url            string   `json:"url"`
+	Title          string   `json:"title"`
+	JpTitle        string   `json:"japanese_title,omitempty"`
+	Language       string   `json:"language"`
+	Type           string   `json:"type"`
+	Date           string   `json:"date"`
+	Artists        []string `json:"artists"`
+	Groups         []string `json:"groups"`
+	Series         []string `json:"series"`
+	Characters     []string `json:"characters"`
+	Tags           []string `json:"tags"`
+	TranslatedTags []string `json:"translated_tags,omitempty"`
+	Pages          int      `json:"pages"`
+	EstimatedBytes int64    `json:"estimated_bytes"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// Info prints the metadata of every gallery url or id without downloading,
+// counting the ones which can't be read in summary.MetadataFailed.
+func Info(args []string) error {
+	if len(args) == 0 {
+		return errors.New("Usage: info <url-or-id>...")
+	}
+	for _, arg := range args {
+		url := arg
+		if _, err := strconv.Atoi(arg); err == nil {
+			url = GalleryUrl(arg)
+		}
+		result, err := GalleryInfoResult(url)
+		if err != nil {
+			summary.MetadataFailed++
+			result = InfoResult{Id: GalleryId(url), Url: url, Error: err.Error()}
+			if !*jsonFlag {
+				fmt.Fprintln(os.Stderr, "Read Gallery Info Fail: "+url+" Because "+err.Error())
+				continue
+			}
+		}
+		if *jsonFlag {
+			data, _ := json.Marshal(result)
+			fmt.Println(string(data))
+		} else {
+			PrintInfo(result)
+		}
+	}
+	return nil
+}
+
+func GalleryInfoResult(url string) (InfoResult, error) {
+	gallery, err := GalleryInfo(appCtx, url)
+	if err != nil {
+		return InfoResult{}, err
+	}
+	result := InfoResult{
+		Id:         gallery.Id,
+		Url:        url,
+		Title:      gallery.Title,
+		JpTitle:    gallery.JpTitle,
+		Language:   gallery.Lang,
+		Type:       gallery.Type,
+		Date:       gallery.Date,
+		Artists:    gallery.Artists,
+		Groups:     gallery.Groups,
+		Series:     gallery.Parodys,
+		Characters: gallery.Characters,
+		Tags:       TagNames(gallery.Tags),
+		Pages:      len(gallery.Files),
+	}
+	if tagTranslation != nil {
+		result.TranslatedTags = TranslatedTagNames(gallery.Tags)
+	}
+	PrepareFiles(gallery.Files, conf)
+	result.EstimatedBytes = EstimateSize(gallery)
+	return result, nil
+}
+
+// EstimateSize measures a few pages with HEAD requests and scales them up
+// to the whole gallery, 0 when none can be measured.
+func EstimateSize(gallery Gallery) int64 {
+	n := len(gallery.Files)
+	if n == 0 {
+		return 0
+	}
+	var total int64
+	measured := 0
+	for i := 0; i < infoSamples && i < n; i++ {
+		img := gallery.Files[i*(n-1)/(infoSamples-1)]
+		req, err := http.NewRequestWithContext(appCtx, "HEAD", ImageUrl(img), nil)
+		if err != nil {
+			continue
+		}
+		for key, value := range RequestHeaders(GallerySource(gallery).Referer(gallery), gallery.Id) {
+			req.Header.Set(key, value)
+		}
+		res, err := ImageDo(req)
+		if err != nil {
+			continue
+		}
+		res.Body.Close()
+		if res.StatusCode == http.StatusOK && res.ContentLength > 0 {
+			total += res.ContentLength
+			measured++
+		}
+	}
+	if measured == 0 {
+		return 0
+	}
+	return total / int64(measured) * int64(n)
+}
+
+func PrintInfo(r InfoResult) {
+	line := func(name string, value string) {
+		if value != "" {
+			fmt.Println(name + ": " + value)
+		}
+	}
+	line("Id", r.Id)
+	line("Url", r.Url)
+	line("Title", r.Title)
+	line("Japanese Title", r.JpTitle)
+	line("Language", r.Language)
+	line("Type", r.Type)
+	line("Date", r.Date)
+	line("Artists", strings.Join(r.Artists, ", "))
+	line("Groups", strings.Join(r.Groups, ", "))
+	line("Series", strings.Join(r.Series, ", "))
+	line("Characters", strings.Join(r.Characters, ", "))
+	line("Tags", strings.Join(r.Tags, ", "))
+	line("Translated Tags", strings.Join(r.TranslatedTags, ", "))
+	line("Pages", strconv.Itoa(r.Pages))
+	if r.EstimatedBytes > 0 {
+		line("Estimated Size", "~"+FormatBytes(float64(r.EstimatedBytes)))
+	}
+	fmt.Println()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultConfig = `# hitomi-go config, every key is optional except SavePath
+# any key can also be set with a HITOMI_ environment variable, e.g. HITOMI_SAVEPATH
+
+# where galleries are saved
+SavePath: ./download/
+# socks5 proxy as "host:port", empty for none
+Socks: ""
+# proxies to spread image downloads across, "host:port" for socks5 or "http://host:port"
+Proxies: []
+# times to retry a failed image
+Retry: 3
+# download threads, 0 for one per cpu
+ThreadNum: 0
+
+# local (loose files), zip (.cbz per gallery), epub, tar, tar.zst, s3, webdav or sftp
+Storage: local
+# ByLanguage, ByArtist, BySeries or ByType
+Layout: ByLanguage
+# custom layout instead, e.g. "{{.Language}}/{{.Title}}"
+PathTemplate: ""
+# save metadata.json next to the images
+SaveMetadata: false
+
+# only download galleries matching this expression, e.g. pages > 15 && lang == "japanese"
+Filter: ""
+# re-encode pages as jpeg or png, empty to keep them as they are
+ConvertTo: ""
+# shrink pages wider / larger than this many pixels, 0 to keep their size
+MaxWidth: 0
+MaxDimension: 0
+# remove EXIF/XMP metadata from jpg/png pages
+StripMetadata: false
+
+# timeouts in seconds
+ConnectTimeout: 30
+ReadTimeout: 60
+RequestTimeout: 0
+GalleryTimeout: 0
+
+# extra headers and cookies sent with every request
+Headers: {}
+Cookies: {}
+`
+
+// Init writes a commented default config and an empty list.txt, never
+// overwriting existing files.
+func Init() error {
+	fileName := *configFlag
+	if fileName == "" {
+		fileName = "config.yaml"
+	}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+	default:
+		return errors.New("Init Writes A YAML Config, Use A .yaml Name Instead Of " + fileName)
+	}
+	existing := []string{fileName}
+	if *configFlag == "" {
+		existing = configNames
+	}
+	for _, name := range existing {
+		if _, err := os.Stat(name); err == nil {
+			return errors.New(name + " Already Exists")
+		}
+	}
+	if err := ioutil.WriteFile(fileName, []byte(defaultConfig), 0644); err != nil {
+		return err
+	}
+	log.Println("Config Written: " + fileName)
+	if _, err := os.Stat("list.txt"); os.IsNotExist(err) {
+		if err = ioutil.WriteFile("list.txt", nil, 0644); err != nil {
+			return err
+		}
+		log.Println("List Written: list.txt")
+	}
+	return nil
+}
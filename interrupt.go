@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// appCtx is done after the first interrupt: no new gallery or image is
+// started and the run finishes with its summary. A second interrupt exits
+// at once.
+var appCtx, stopApp = context.WithCancel(context.Background())
+
+func HandleInterrupt() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Println("Interrupted, Stopping (Interrupt Again To Exit Now)")
+		stopApp()
+		<-signals
+		os.Exit(130)
+	}()
+}
+
+// Interrupted tells if the run is stopping because of an interrupt.
+func Interrupted() bool {
+	return appCtx.Err() != nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var jobsFlag = flag.String("jobs", "", "path of a jobs file (.json or .yaml) to read instead of list.txt")
+
+var jobsNames = []string{"jobs.json", "jobs.yaml", "jobs.yml"}
+
+// JobSpec is one entry of a jobs file: a gallery or search url with the
+// options to download it with.
+type JobSpec struct {
+	Url string `json:"url" yaml:"url"`
+	// Pages like "1-10,15", empty for all
+	Pages string `json:"pages" yaml:"pages"`
+	// Folder below SavePath, may use the PathTemplate fields
+	Folder string `json:"folder" yaml:"folder"`
+	// Format is the preferred variant: "avif", "webp" or "original"
+	Format string `json:"format" yaml:"format"`
+	// ConvertTo overrides the ConvertTo of the config
+	ConvertTo string `json:"convert" yaml:"convert"`
+	// Priority is "high", "normal" or "low", higher ones are downloaded first
+	Priority string `json:"priority,omitempty" yaml:"priority"`
+}
+
+// FindJobs returns the --jobs path or the jobs file in the working
+// directory, empty when there is none.
+func FindJobs() string {
+	if *jobsFlag != "" {
+		return *jobsFlag
+	}
+	for _, name := range jobsNames {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+func ReadJobs(fileName string) ([]JobSpec, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []JobSpec
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".json":
+		err = json.Unmarshal(data, &jobs)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &jobs)
+	default:
+		return nil, errors.New("Unknown Jobs Format: " + fileName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, job := range jobs {
+		if err := job.Validate(); err != nil {
+			return nil, errors.New("Job " + strconv.Itoa(i+1) + ": " + err.Error())
+		}
+	}
+	return jobs, nil
+}
+
+func (s JobSpec) Validate() error {
+	if s.Url == "" {
+		return errors.New("url is empty")
+	}
+	if _, err := ParsePages(s.Pages, 1); err != nil {
+		return errors.New("pages " + err.Error())
+	}
+	switch s.Format {
+	case "", "avif", "webp", "original":
+	default:
+		return errors.New("format must be avif, webp or original, got " + strconv.Quote(s.Format))
+	}
+	if !ValidPriority(s.Priority) {
+		return errors.New("priority must be high, normal or low, got " + strconv.Quote(s.Priority))
+	}
+	if s.ConvertTo != "" && ConvertExt(s.ConvertTo) == "" {
+		return errors.New("convert must be jpeg or png, got " + strconv.Quote(s.ConvertTo))
+	}
+	if s.Folder != "" {
+		if _, err := CompilePathTemplate(s.Folder, ""); err != nil {
+			return errors.New("folder " + err.Error())
+		}
+	}
+	return nil
+}
+
+// UrlJobs turns a plain url list into jobs with the options of the config.
+func UrlJobs(urls []string) []JobSpec {
+	jobs := make([]JobSpec, 0, len(urls))
+	for _, u := range urls {
+		jobs = append(jobs, JobSpec{Url: u})
+	}
+	return jobs
+}
+
+// ExpandJobs expands search urls, every gallery found keeps the options of
+// its job. A gallery listed twice is only downloaded for its first job.
+func ExpandJobs(jobs []JobSpec) []JobSpec {
+	expanded := make([]JobSpec, 0, len(jobs))
+	seen := make(map[string]struct{})
+	for _, job := range jobs {
+		for _, u := range ExpandUrls([]string{job.Url}) {
+			if _, ok := seen[u]; ok {
+				continue
+			}
+			seen[u] = struct{}{}
+			job.Url = u
+			expanded = append(expanded, job)
+		}
+	}
+	return expanded
+}
+
+// GalleryConf is conf with the options of the job applied.
+func (s JobSpec) GalleryConf(conf Conf) Conf {
+	if s.ConvertTo != "" {
+		conf.ConvertTo = s.ConvertTo
+	}
+	if s.Folder != "" {
+		conf.PathTemplate = s.Folder
+	}
+	return conf
+}
+
+// Apply selects the pages and variant of the job.
+func (s JobSpec) Apply(gallery Gallery) Gallery {
+	if s.Pages != "" {
+		pages, _ := ParsePages(s.Pages, len(gallery.Files))
+		files := make([]Image, 0, len(pages))
+		for _, page := range pages {
+			files = append(files, gallery.Files[page-1])
+		}
+		gallery.Files = files
+	}
+	switch s.Format {
+	case "webp":
+		PreferDecodable(gallery.Files)
+	case "original":
+		PreferOriginal(gallery.Files)
+	}
+	return gallery
+}
+
+// ParsePages parses a page range like "1-10,15,20-" into the sorted page
+// numbers it selects out of total pages.
+func ParsePages(spec string, total int) ([]int, error) {
+	selected := make(map[int]struct{})
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			from, to = strings.TrimSpace(part[:i]), strings.TrimSpace(part[i+1:])
+		}
+		first, last := 1, total
+		var err error
+		if from != "" {
+			if first, err = strconv.Atoi(from); err != nil || first < 1 {
+				return nil, errors.New("has an invalid page: " + strconv.Quote(part))
+			}
+		}
+		if to != "" {
+			if last, err = strconv.Atoi(to); err != nil || last < first {
+				return nil, errors.New("has an invalid range: " + strconv.Quote(part))
+			}
+		}
+		for page := first; page <= last && page <= total; page++ {
+			selected[page] = struct{}{}
+		}
+	}
+	pages := make([]int, 0, len(selected))
+	for page := range selected {
+		pages = append(pages, page)
+	}
+	sort.Ints(pages)
+	return pages, nil
+}
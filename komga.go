@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type KomgaConf struct {
+	Url       string
+	User      string
+	Password  string
+	ApiKey    string
+	LibraryId string
+	// SetMetadata fills in the book and series metadata from the gallery
+	// once the scan has picked the book up.
+	SetMetadata bool
+}
+
+// Komga scans the library a little while after galleries were added, so a
+// batch of galleries only triggers one scan.
+type Komga struct {
+	Conf    KomgaConf
+	lock    sync.Mutex
+	timer   *time.Timer
+	pending []komgaGallery
+	wg      sync.WaitGroup
+}
+
+type komgaGallery struct {
+	Gallery  Gallery
+	SavePath string
+}
+
+const komgaScanDelay = 10 * time.Second
+
+var komga *Komga
+
+var komgaClient = &http.Client{Timeout: time.Minute}
+
+type komgaBook struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	SeriesId string `json:"seriesId"`
+}
+
+// Added queues a scan for a gallery written to savePath.
+func (k *Komga) Added(gallery Gallery, savePath string) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.pending = append(k.pending, komgaGallery{Gallery: gallery, SavePath: savePath})
+	if k.timer == nil {
+		k.timer = time.AfterFunc(komgaScanDelay, k.Flush)
+	} else {
+		k.timer.Reset(komgaScanDelay)
+	}
+}
+
+// Flush scans right away for everything added so far.
+func (k *Komga) Flush() {
+	k.lock.Lock()
+	pending := k.pending
+	k.pending = nil
+	if k.timer != nil {
+		k.timer.Stop()
+	}
+	k.lock.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	if err := k.Scan(); err != nil {
+		log.Println("Komga Scan Fail: " + err.Error())
+		return
+	}
+	log.Println("Komga Scan Started For " + strconv.Itoa(len(pending)) + " Galleries")
+	if !k.Conf.SetMetadata {
+		return
+	}
+	for _, added := range pending {
+		k.wg.Add(1)
+		go func(added komgaGallery) {
+			defer k.wg.Done()
+			if err := k.UpdateMetadata(added.Gallery, added.SavePath); err != nil {
+				log.Println("Komga Metadata Fail: " + added.Gallery.Title + " Because " + err.Error())
+			}
+		}(added)
+	}
+}
+
+// Wait flushes and waits for the metadata updates to finish.
+func (k *Komga) Wait() {
+	k.Flush()
+	k.wg.Wait()
+}
+
+func (k *Komga) Scan() error {
+	_, err := k.Do("POST", "/api/v1/libraries/"+url.PathEscape(k.Conf.LibraryId)+"/scan", nil, nil)
+	return err
+}
+
+// UpdateMetadata waits for the scan to find the book of the gallery, then
+// sets its metadata and the language and reading direction of its series.
+func (k *Komga) UpdateMetadata(gallery Gallery, savePath string) error {
+	name := path.Base(savePath)
+
+	var book komgaBook
+	for tries := 0; ; tries++ {
+		var err error
+		if book, err = k.FindBook(name); err == nil {
+			break
+		}
+		if tries >= 12 {
+			return err
+		}
+		time.Sleep(5 * time.Second)
+	}
+
+	title := gallery.JpTitle
+	if title == "" {
+		title = gallery.Title
+	}
+	authors := make([]map[string]string, 0, len(gallery.Artists)+len(gallery.Groups))
+	for _, artist := range gallery.Artists {
+		authors = append(authors, map[string]string{"name": artist, "role": "writer"})
+	}
+	for _, group := range gallery.Groups {
+		authors = append(authors, map[string]string{"name": group, "role": "publisher"})
+	}
+	tags := make([]string, 0, len(gallery.Tags))
+	for _, tag := range gallery.Tags {
+		tags = append(tags, tag.Name())
+	}
+	metadata := map[string]interface{}{
+		"title":   title,
+		"authors": authors,
+		"tags":    tags,
+		"links":   []map[string]string{{"label": "hitomi.la", "url": gallery.Url}},
+	}
+	if len(gallery.Date) >= 10 {
+		metadata["releaseDate"] = gallery.Date[:10]
+	}
+	if _, err := k.Do("PATCH", "/api/v1/books/"+url.PathEscape(book.Id)+"/metadata", nil, metadata); err != nil {
+		return err
+	}
+
+	series := map[string]interface{}{"readingDirection": "RIGHT_TO_LEFT"}
+	if conf.ReadingDirection == "ltr" {
+		series["readingDirection"] = "LEFT_TO_RIGHT"
+	}
+	if lang, ok := epubLanguages[gallery.Lang]; ok {
+		series["language"] = lang
+	}
+	_, err := k.Do("PATCH", "/api/v1/series/"+url.PathEscape(book.SeriesId)+"/metadata", nil, series)
+	return err
+}
+
+func (k *Komga) FindBook(name string) (komgaBook, error) {
+	query := url.Values{"library_id": {k.Conf.LibraryId}, "search": {name}, "size": {"50"}}
+	data, err := k.Do("GET", "/api/v1/books", query, nil)
+	if err != nil {
+		return komgaBook{}, err
+	}
+	var page struct {
+		Content []komgaBook `json:"content"`
+	}
+	if err = json.Unmarshal(data, &page); err != nil {
+		return komgaBook{}, err
+	}
+	for _, book := range page.Content {
+		if book.Name == name {
+			return book, nil
+		}
+	}
+	return komgaBook{}, errors.New("Book Not Found: " + name)
+}
+
+func (k *Komga) Do(method string, p string, query url.Values, body interface{}) ([]byte, error) {
+	u := strings.TrimRight(k.Conf.Url, "/") + p
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if k.Conf.ApiKey != "" {
+		req.Header.Set("X-API-Key", k.Conf.ApiKey)
+	} else if k.Conf.User != "" {
+		req.SetBasicAuth(k.Conf.User, k.Conf.Password)
+	}
+	res, err := komgaClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 != 2 {
+		return nil, errors.New("Komga Status Code " + strconv.Itoa(res.StatusCode) + ": " + strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	_ "modernc.org/sqlite"
+)
+
+const libraryFile = "library.db"
+
+var reindexFlag = flag.Bool("reindex", false, "search-local: rebuild "+libraryFile+" from the saved galleries first")
+
+// Library is the full-text index of the saved galleries. Once library.db
+// exists every finished gallery is added to it.
+type Library struct {
+	db   *sql.DB
+	lock sync.Mutex
+}
+
+var library *Library
+
+// libraryColumns maps the search namespaces to the columns of the index.
+var libraryColumns = map[string]string{
+	"title":    "title",
+	"language": "language",
+	"lang":     "language",
+	"type":     "type",
+	"artist":   "artists",
+	"group":    "groups",
+	"series":   "series",
+	"parody":   "series",
+	"tag":      "tags",
+	"female":   "tags",
+	"male":     "tags",
+}
+
+func OpenLibrary(fileName string) (*Library, error) {
+	db, err := sql.Open("sqlite", fileName)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS galleries USING fts5(
+		dir UNINDEXED, id UNINDEXED, title, language, type, artists, "groups", series, tags)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Library{db: db}, nil
+}
+
+func (l *Library) Index(dir string, manifest Manifest) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	tx, err := l.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM galleries WHERE dir = ?`, dir); err == nil {
+		_, err = tx.Exec(`INSERT INTO galleries (dir, id, title, language, type, artists, "groups", series, tags)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			dir, manifest.Id, manifest.Title, manifest.Language, manifest.Type,
+			strings.Join(manifest.Artists, "\n"), strings.Join(manifest.Groups, "\n"),
+			strings.Join(manifest.Series, "\n"), strings.Join(append(manifest.Tags, manifest.TranslatedTags...), "\n"))
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Rebuild indexes every gallery of the storage again.
+func (l *Library) Rebuild() error {
+	lister, ok := storage.(Lister)
+	if !ok {
+		return errors.New("Indexing Needs Storage local, zip Or tar")
+	}
+	dirs, err := lister.Galleries()
+	if err != nil {
+		return err
+	}
+	if _, err = l.db.Exec(`DELETE FROM galleries`); err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		manifest, err := ReadManifest(dir)
+		if err != nil {
+			log.Println("Read Manifest Fail: " + dir + " Because " + err.Error())
+			continue
+		}
+		if manifest.Language == "" {
+			fillFromMetadata(dir, &manifest)
+		}
+		if err = l.Index(dir, manifest); err != nil {
+			return err
+		}
+	}
+	log.Println(strconv.Itoa(len(dirs)) + " Galleries Indexed In " + libraryFile)
+	return nil
+}
+
+func (l *Library) Search(query string) ([]string, error) {
+	match, err := LibraryMatch(query)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := l.db.Query(`SELECT dir FROM galleries WHERE galleries MATCH ? ORDER BY rank`, match)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var dirs []string
+	for rows.Next() {
+		var dir string
+		if err = rows.Scan(&dir); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs, rows.Err()
+}
+
+// LibraryMatch turns a query like `artist:foo tag:full_color -female:loli
+// some title` into an FTS5 expression. Underscores stand for spaces like in
+// the site's search.
+func LibraryMatch(query string) (string, error) {
+	var positive, negative []string
+	for _, term := range strings.Fields(query) {
+		exclude := strings.HasPrefix(term, "-")
+		term = strings.TrimPrefix(term, "-")
+		column := ""
+		value := term
+		if i := strings.Index(term, ":"); i > 0 {
+			namespace := strings.ToLower(term[:i])
+			var ok bool
+			if column, ok = libraryColumns[namespace]; !ok {
+				return "", errors.New("Unknown Namespace: " + namespace)
+			}
+			value = term[i+1:]
+			if namespace == "female" || namespace == "male" {
+				value = term
+			}
+		}
+		value = strings.Replace(value, "_", " ", -1)
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		phrase := `"` + strings.Replace(value, `"`, `""`, -1) + `"`
+		if column != "" {
+			phrase = column + " : " + phrase
+		}
+		if exclude {
+			negative = append(negative, phrase)
+		} else {
+			positive = append(positive, phrase)
+		}
+	}
+	if len(positive) == 0 {
+		return "", errors.New("Search Needs At Least One Term To Match")
+	}
+	match := strings.Join(positive, " AND ")
+	for _, phrase := range negative {
+		match += " NOT " + phrase
+	}
+	return match, nil
+}
+
+// StoragePath is where dir is on disk.
+func StoragePath(dir string) string {
+	if local, ok := localStorage(); ok {
+		return filepath.Join(local.Root, filepath.FromSlash(dir))
+	}
+	switch s := storage.(type) {
+	case *ZipStorage:
+		return s.archivePath(dir)
+	case *TarStorage:
+		return s.archivePath(dir)
+	}
+	return dir
+}
+
+// SearchLocal prints the paths of the saved galleries matching query.
+func SearchLocal(query string) error {
+	var err error
+	rebuild := *reindexFlag
+	if library == nil {
+		if library, err = OpenLibrary(libraryFile); err != nil {
+			return err
+		}
+		rebuild = true
+	}
+	if rebuild {
+		if err = library.Rebuild(); err != nil {
+			return err
+		}
+	}
+	dirs, err := library.Search(query)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		fmt.Println(StoragePath(dir))
+	}
+	return nil
+}
@@ -1,45 +1,183 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/valyala/fasthttp"
-	"github.com/valyala/fasthttp/fasthttpproxy"
 	_ "golang.org/x/image/webp"
 )
 
 type Conf struct {
-	SavePath  string
-	Socks     string
-	Retry     int
-	ThreadNum int
+	SavePath         string
+	Socks            string
+	Retry            int
+	ThreadNum        int
+	Storage          string
+	S3               S3Conf
+	WebDAV           WebDAVConf
+	SFTP             SFTPConf
+	CAS              CASConf
+	Filter           string
+	Duplicates       string
+	TagTranslation   string
+	GalleryTimeout   int
+	SummaryFile      string
+	ConvertTo        string
+	ConvertQuality   int
+	ConvertThreadNum int
+	HashThreadNum    int
+	MaxWidth         int
+	MaxDimension     int
+	StripMetadata    bool
+	// PerceptualHash adds the perceptual hash of every page to the manifest
+	PerceptualHash bool
+	// NumberPages saves the pages as zero-padded numbers in gallery order
+	NumberPages       bool
+	FileMode          string
+	ZipPassword       string
+	ReadingDirection  string
+	VolumePages       int
+	Komga             KomgaConf
+	EHentai           EHentaiConf
+	Notify            NotifyConf
+	Webhooks          []WebhookConf
+	Listen            string
+	ListenCert        string
+	ListenKey         string
+	ApiToken          string
+	ApiUser           string
+	ApiPassword       string
+	AddToken          string
+	ControlSocket     string
+	Schedule          ScheduleConf
+	MaxSpeed          int
+	MaxBufferedBytes  int64
+	Layout            string
+	TitlePreference   string
+	PathTemplate      string
+	SaveMetadata      bool
+	Headers           map[string]string
+	Cookies           map[string]string
+	UserAgents        []string
+	UserAgentRotation string
+	Proxies           []string
+	ProxyMaxFailures  int
+	ConnectTimeout    int
+	ReadTimeout       int
+	RequestTimeout    int
+	ImageTimeout      int
+	MetadataMaxAge    int
+	// MetadataThreads is how many gallery infos are fetched at once ahead of
+	// the downloads
+	MetadataThreads int
+	// GalleryFailPercent fails a gallery as a whole when more than that
+	// percentage of its images failed, GalleryFailAction is "keep",
+	// "remove" or "quarantine" for what was saved of it
+	GalleryFailPercent int
+	GalleryFailAction  string
+	// RequestRetry repeats a failed request of an image, Retry when 0,
+	// ImageAttempts bounds the requests of an image over all its urls and
+	// GalleryMaxFailures gives up a gallery once that many images failed
+	RequestRetry       int
+	ImageAttempts      int
+	GalleryMaxFailures int
+	// StageIncomplete downloads new galleries below .incomplete in SavePath
+	// and moves them into place once all their pages are saved
+	StageIncomplete bool
+	// DnsServer is "host:port" of a DNS server or an https url of a DNS
+	// over HTTPS resolver, "" for the system one
+	DnsServer   string
+	DnsCacheTtl int
+	// NetworkPreference is "ipv4" or "ipv6" to connect over that family
+	// first, "auto" (default) for the family the DNS answers with first
+	NetworkPreference string
+	// MetadataHost serves the gallery info instead of ltn.hitomi.la, as a
+	// host or a url, and the images are on subdomains of ImageDomain instead
+	// of hitomi.la
+	MetadataHost    string
+	ImageDomain     string
+	Tls             TlsConf
+	MaxConnsPerHost int
+	// RequestsPerSecond limits the requests to a host, keyed by host,
+	// "*.domain" or "*"
+	RequestsPerSecond map[string]float64
+	RateLimitHits     int
+	RateLimitCooldown int
+	// Profiles are named sets of keys applied on top with --profile
+	Profiles map[string]json.RawMessage
 }
 
 type Gallery struct {
-	Id      string  `json:"id"`
-	Title   string  `json:"title"`
-	JpTitle string  `json:"japanese_title"`
-	Lang    string  `json:"language"`
-	Files   []Image `json:"files"`
-	Url     string
+	Id            string   `json:"id"`
+	Title         string   `json:"title"`
+	JpTitle       string   `json:"japanese_title"`
+	Lang          string   `json:"language"`
+	Type          string   `json:"type"`
+	Date          string   `json:"date"`
+	Artists       NameList `json:"artists"`
+	Groups        NameList `json:"groups"`
+	Parodys       NameList `json:"parodys"`
+	Characters    NameList `json:"characters"`
+	Files         []Image  `json:"files"`
+	VideoFileName string   `json:"videofilename,omitempty"`
+	Tags          []Tag    `json:"tags"`
+	Source        string   `json:"source,omitempty"`
+	// PageCount is the number of pages before any were left out
+	PageCount int `json:"-"`
+	Url       string
 }
 
+// TotalPages is the size of the whole gallery, even with pages left out.
+func (g Gallery) TotalPages() int {
+	if g.PageCount == 0 {
+		return len(g.Files)
+	}
+	return g.PageCount
+}
+
+// NameList reads lists like [{"artist": "name", "url": "/artist/name-all.html"}]
+// as plain names.
+type NameList []string
+
+type Tag struct {
+	Tag         string   `json:"tag"`
+	Female      JsonFlag `json:"female"`
+	Male        JsonFlag `json:"male"`
+	Translation string   `json:"translation,omitempty"`
+}
+
+type JsonFlag bool
+
 type Image struct {
 	Name    string `json:"name"`
 	Hash    string `json:"hash"`
 	HasWebp int    `json:"haswebp"`
 	HasAvif int    `json:"hasavif"`
+	Url     string `json:"url,omitempty"`
+	// Page is the number of the page in the gallery
+	Page int `json:"-"`
+	// Pages is the number of pages of the gallery
+	Pages int `json:"-"`
 }
 
 type Job struct {
@@ -47,217 +185,982 @@ type Job struct {
 	Gallery  Gallery
 	SavePath string
 	Conf     Conf
+	Task     *GalleryTask
 }
 
 type WriteJob struct {
 	Content  []byte
 	FileName string
+	Job      Job
+	// Reserved is what the job holds of MaxBufferedBytes.
+	Reserved int64
+	// body is the pooled buffer the image was downloaded into, given back
+	// once written.
+	body *bytes.Buffer
+}
+
+// QueuedGallery is a gallery ready to download with the config of its job.
+type QueuedGallery struct {
+	Gallery Gallery
+	Conf    Conf
+	// More is set on the volumes of a gallery but the last
+	More bool
+}
+
+type GalleryTask struct {
+	ctx      context.Context
+	wg       sync.WaitGroup
+	done     int64
+	failed   int64
+	lock     sync.Mutex
+	files    []ManifestFile
+	previous map[string]ManifestFile
+	// previousPages is the page count of the old manifest.
+	previousPages int
+	// giveUp stops the gallery, leaving its other images undone.
+	giveUp   func()
+	progress *GalleryProgress
 }
 
 var conf Conf
+var filter *Filter
+var storage Storage
 var Client fasthttp.Client
-var downloadingCount int64
-var downloadStartCount int64
+var ImageClient *http.Client
+
+// overwriteExisting downloads images again even when they are saved already.
+var overwriteExisting bool
+
+var coversOnly = flag.Bool("covers-only", false, "only download the first page of each gallery")
 
 var queue chan Job
-var galleryQueue chan Gallery
 var writeQueue chan WriteJob
+var convertQueue chan WriteJob
 
 func main() {
-	confByte, err := ioutil.ReadFile("./config.json")
-	if err != nil {
-		CommonError(err)
+	flag.Parse()
+	StartAsService()
+	if flag.Arg(0) == "init" {
+		if err := Init(); err != nil {
+			CommonError(err)
+		}
+		return
+	}
+	if flag.Arg(0) == "service" {
+		if err := ServiceCommand(flag.Args()[1:]); err != nil {
+			CommonError("Service Fail: " + err.Error())
+		}
+		return
+	}
+	if flag.Arg(0) == "install-service" {
+		if err := InstallService(flag.Arg(1)); err != nil {
+			CommonError("Install Service Fail: " + err.Error())
+		}
+		return
+	}
+	if flag.Arg(0) == "completion" {
+		script, err := Completion(flag.Arg(1))
+		if err != nil {
+			CommonError(err)
+		}
+		fmt.Print(script)
+		return
+	}
+	switch *outputFlag {
+	case "text":
+	case "json":
+		StartEventStream()
+	default:
+		CommonError("Unknown Output: " + *outputFlag + ", Use text Or json")
+	}
+	LoadConfig()
+	if flag.Arg(0) == "ctl" {
+		if err := Ctl(flag.Args()[1:]); err != nil {
+			CommonError(err)
+		}
+		return
+	}
+	if flag.Arg(0) == "userscript" {
+		token, err := AddToken()
+		if err != nil {
+			CommonError("Read " + addTokenFile + " Fail: " + err.Error())
+		}
+		fmt.Print(Userscript(ListenHost(), token))
+		return
+	}
+	if flag.Arg(0) == "add" {
+		if err := AddRemote(flag.Args()[1:]); err != nil {
+			CommonError("Add Fail: " + err.Error())
+		}
+		return
+	}
+	Setup()
+
+	switch flag.Arg(0) {
+	case "verify", "info", "search-local", "stats", "dupes", "doctor", "estimate":
+	default:
+		CleanSavePath()
+	}
+	switch flag.Arg(0) {
+	case "retry-failed":
+		galleryUrls, err := LoadFailures()
+		if err != nil {
+			if os.IsNotExist(err) {
+				CommonError(failedJsonFile + " Not Found")
+			}
+			CommonError(err)
+		}
+		Run(galleryUrls)
+		Finish()
+	case "sync":
+		Sync(subscriptionsFile)
+	case "verify":
+		Verify()
+	case "repair":
+		Repair()
+		Finish()
+	case "info":
+		if err := Info(flag.Args()[1:]); err != nil {
+			CommonError(err)
+		}
+		os.Exit(summary.ExitCode())
+	case "search-local":
+		if err := SearchLocal(strings.Join(flag.Args()[1:], " ")); err != nil {
+			CommonError(err)
+		}
+		return
+	case "doctor":
+		Doctor()
+	case "estimate":
+		EstimateBatch(flag.Args()[1:]).Print()
+		return
+	case "stats":
+		if err := PrintStats(); err != nil {
+			CommonError(err)
+		}
+		return
+	case "dupes":
+		if err := PrintDupes(); err != nil {
+			CommonError(err)
+		}
+		return
+	case "export":
+		if err := PrintExport(); err != nil {
+			CommonError(err)
+		}
+		return
+	case "serve":
+		go WarnSiteChanges()
+		StartDaemon()
+		jobs, err := LoadQueue()
+		if err != nil {
+			CommonError("Read " + queueFile + " Fail: " + err.Error())
+		}
+		daemon.Add(jobs)
+		NotifySystemdReady()
+		if *watchClipboard {
+			if err := WatchClipboard(); err != nil {
+				CommonError("Watch Clipboard Fail: " + err.Error())
+			}
+		}
+		if *tuiFlag {
+			go Serve()
+			RunTui()
+			return
+		}
+		Serve()
+	default:
+		go WarnSiteChanges()
+		jobs, err := LoadQueue()
+		if err != nil {
+			CommonError("Read " + queueFile + " Fail: " + err.Error())
+		}
+		if jobs == nil {
+			if fileName := FindJobs(); fileName != "" {
+				if jobs, err = ReadJobs(fileName); err != nil {
+					CommonError("Read Jobs Fail: " + fileName + " Because " + err.Error())
+				}
+			} else if _, err := os.Stat("list.txt"); err == nil || !*watchClipboard {
+				jobs = UrlJobs(ReadList("list.txt"))
+			}
+		}
+		if *tuiFlag || *watchClipboard {
+			StartDaemon()
+			daemon.Add(jobs)
+			if *watchClipboard {
+				if err := WatchClipboard(); err != nil {
+					CommonError("Watch Clipboard Fail: " + err.Error())
+				}
+			}
+			if *tuiFlag {
+				RunTui()
+				return
+			}
+			daemon.Wait()
+			break
+		}
+		RunJobs(jobs)
+		Finish()
+	}
+	Exit(summary.ExitCode())
+}
+
+func LoadConfig() {
+	var err error
+	if fileName := FindConfig(); fileName != "" {
+		if err = ReadConfig(fileName, &conf); err != nil {
+			CommonError("Read Config Fail: " + fileName + " Because " + err.Error())
+		}
+	} else if *profileFlag != "" {
+		CommonError("No Config File Found For Profile " + *profileFlag)
+	} else {
+		log.Println("No Config File Found, Using Defaults And Environment")
 	}
-	if err = json.Unmarshal(confByte, &conf); err != nil {
+	if err = ApplyEnv(&conf); err != nil {
 		CommonError(err)
 	}
+	if problems := ValidateConfig(conf); len(problems) > 0 {
+		for _, problem := range problems {
+			log.Println("Invalid Config: " + problem)
+		}
+		CommonError("Fix The Config And Try Again")
+	}
 	if conf.ThreadNum < 1 || conf.ThreadNum > runtime.NumCPU() {
 		conf.ThreadNum = runtime.NumCPU()
 	}
-	list, err := ioutil.ReadFile("list.txt")
-	if err != nil {
-		if os.IsNotExist(err) {
-			CommonError("list.txt Not Found")
+	if conf.ConnectTimeout < 1 {
+		conf.ConnectTimeout = 30
+	}
+	if conf.ReadTimeout < 1 {
+		conf.ReadTimeout = 60
+	}
+	if conf.ImageTimeout < 1 {
+		conf.ImageTimeout = 600
+	}
+	if conf.MetadataMaxAge == 0 {
+		conf.MetadataMaxAge = defaultMetadataMaxAge
+	}
+	if conf.MetadataThreads < 1 {
+		conf.MetadataThreads = defaultMetadataThreads
+	}
+	if conf.DnsCacheTtl == 0 {
+		conf.DnsCacheTtl = defaultDnsCacheTtl
+	}
+	if conf.RateLimitHits > 0 {
+		throttle.Threshold = conf.RateLimitHits
+	}
+	if conf.RateLimitCooldown > 0 {
+		throttle.Cooldown = time.Duration(conf.RateLimitCooldown) * time.Second
+	}
+	if conf.ConvertThreadNum < 1 {
+		conf.ConvertThreadNum = conf.ThreadNum
+	}
+	if conf.HashThreadNum < 1 {
+		conf.HashThreadNum = conf.ThreadNum
+	}
+	if conf.ConvertQuality < 1 || conf.ConvertQuality > 100 {
+		conf.ConvertQuality = 90
+	}
+}
+
+func Setup() {
+	var err error
+	if conf.TagTranslation != "" {
+		if tagTranslation, err = LoadTagTranslation(conf.TagTranslation); err != nil {
+			CommonError("Load TagTranslation Fail: " + err.Error())
 		}
+	}
+	if conf.Filter != "" {
+		if filter, err = CompileFilter(conf.Filter); err != nil {
+			CommonError(err)
+		}
+	}
+	if storage, err = NewStorage(conf); err != nil {
 		CommonError(err)
 	}
-	listStr := strings.TrimSpace(string(list))
-	if listStr == "" {
-		CommonError("Empty List")
+	if resolver, err = NewResolver(conf.DnsServer); err != nil {
+		CommonError(err)
+	}
+	if tlsConfig, err = NewTlsConfig(conf.Tls); err != nil {
+		CommonError("Load Tls.CaFile Fail: " + err.Error())
 	}
-	galleryUrls := Unique(strings.Split(listStr, Eol()))
+	if conf.Tls.InsecureSkipVerify {
+		log.Println("Tls.InsecureSkipVerify Is Set, Certificates Are Not Verified")
+	}
+	Client.TLSConfig = tlsConfig
+	if Client.Dial, err = MetadataDialer(); err != nil {
+		CommonError(err)
+	}
+	Client.ReadTimeout = time.Duration(conf.ReadTimeout) * time.Second
+	var socksUrl *url.URL
 	if conf.Socks != "" {
-		Client.Dial = fasthttpproxy.FasthttpSocksDialer(conf.Socks)
+		socksUrl = &url.URL{Scheme: "socks5", Host: conf.Socks}
+	}
+	ImageClient = &http.Client{Transport: NewTransport(socksUrl)}
+	if len(conf.Proxies) > 0 {
+		if conf.ProxyMaxFailures < 1 {
+			conf.ProxyMaxFailures = 5
+		}
+		if proxyPool, err = NewProxyPool(conf.Proxies, conf.ProxyMaxFailures); err != nil {
+			CommonError(err)
+		}
+		go proxyPool.HealthCheck(time.Minute)
+	}
+	if conf.Komga.Url != "" {
+		komga = &Komga{Conf: conf.Komga}
+	}
+	if _, err = os.Stat(libraryFile); err == nil {
+		if library, err = OpenLibrary(libraryFile); err != nil {
+			log.Println("Open " + libraryFile + " Fail: " + err.Error())
+		}
+	}
+	if len(conf.Schedule.Speed) > 0 {
+		go RunSpeedSchedule(conf.Schedule.Speed)
+	} else {
+		bandwidth.SetRate(int64(conf.MaxSpeed) * 1024)
+	}
+	if conf.MaxBufferedBytes > 0 {
+		buffered.SetMax(conf.MaxBufferedBytes)
 	}
 	queue = make(chan Job, conf.ThreadNum)
-	galleryQueue = make(chan Gallery, conf.ThreadNum)
 	writeQueue = make(chan WriteJob, conf.ThreadNum*100)
 	runtime.GOMAXPROCS(conf.ThreadNum)
 
 	for i := 0; i < conf.ThreadNum; i++ {
-		go func() {
-			DownloadImageWorker()
-		}()
+		go DownloadImageWorker()
 	}
 
-	go func() {
-		WriteWorker()
-	}()
+	go WriteWorker()
+
+	hashQueue = make(chan WriteJob, conf.HashThreadNum)
+	for i := 0; i < conf.HashThreadNum; i++ {
+		go HashWorker()
+	}
+
+	convertQueue = make(chan WriteJob, conf.ConvertThreadNum)
+	for i := 0; i < conf.ConvertThreadNum; i++ {
+		go ConvertWorker()
+	}
+	HandleInterrupt()
+}
+
+func Run(galleryUrls []string) {
+	RunJobs(UrlJobs(galleryUrls))
+}
+
+// RunJobs downloads jobs, leaving out the galleries queued already.
+func RunJobs(jobs []JobSpec) {
+	DownloadJobs(ClaimJobs(ExpandJobs(jobs)))
+}
 
+// DownloadJobs downloads the claimed jobs and releases them.
+func DownloadJobs(jobs []JobSpec) {
+	// the jobs an interrupt left unfinished
+	defer ReleaseJobs(jobs)
+	SortJobs(jobs)
+	StartBatch(jobs)
+	galleryQueue := make(chan QueuedGallery, conf.ThreadNum)
 	go func() {
-		for _, url := range galleryUrls {
-			gallery, err := GalleryInfo(url)
+		defer close(galleryQueue)
+		ctx, cancel := context.WithCancel(appCtx)
+		defer cancel()
+		for fetched := range PrefetchGalleries(ctx, jobs, conf.MetadataThreads) {
+			if Interrupted() {
+				return
+			}
+			job, url := fetched.Job, fetched.Job.Url
+			gallery, err := fetched.Gallery, fetched.Err
 			if err != nil {
 				log.Println("Read Gallery Info Fail: " + url + " Because " + err.Error())
-			} else {
-				gallery.Url = url
-				galleryQueue <- gallery
+				atomic.AddInt64(&summary.GalleriesFailed, 1)
+				atomic.AddInt64(&summary.MetadataFailed, 1)
+				RecordFailure(Failure{Url: url, Reason: "Read Gallery Info Fail: " + err.Error()})
+				NotifyGallery(GalleryResult{Gallery: Gallery{Url: url}, Err: "Read Gallery Info Fail: " + err.Error()})
+				FinishJob(url)
+				continue
+			}
+			gallery.Url = url
+			if len(gallery.Files) == 0 && gallery.VideoFileName == "" {
+				log.Println("Read Gallery Info Fail: " + url + " Because No Page List")
+				atomic.AddInt64(&summary.GalleriesFailed, 1)
+				atomic.AddInt64(&summary.MetadataFailed, 1)
+				RecordFailure(Failure{Url: url, Id: gallery.Id, Title: gallery.Title, Reason: "No Page List"})
+				NotifyGallery(GalleryResult{Gallery: gallery, Err: "No Page List"})
+				FinishJob(url)
+				continue
+			}
+			// the filter sees the whole gallery, before pages are left out
+			if filter != nil {
+				match, err := filter.Match(gallery)
+				if err != nil {
+					log.Println("Filter Gallery Fail: " + url + " Because " + err.Error())
+					atomic.AddInt64(&summary.GalleriesFailed, 1)
+					RecordFailure(Failure{Url: url, Id: gallery.Id, Title: gallery.Title, Reason: "Filter Gallery Fail: " + err.Error()})
+					FinishJob(url)
+					continue
+				}
+				if !match {
+					log.Println("Skip Gallery (Filtered): " + url)
+					atomic.AddInt64(&summary.GalleriesSkipped, 1)
+					FinishJob(url)
+					continue
+				}
+			}
+			gallery = FilterRetryImages(job.Apply(gallery))
+			galleryConf := job.GalleryConf(conf)
+			PrepareFiles(gallery.Files, galleryConf)
+			if *coversOnly && len(gallery.Files) > 1 {
+				gallery.Files = gallery.Files[:1]
+			}
+			for _, queued := range SplitVolumes(gallery, galleryConf) {
+				select {
+				case galleryQueue <- queued:
+				case <-appCtx.Done():
+					return
+				}
 			}
 		}
 	}()
 
-	for i := 0; ; i++ {
-		gallery := <-galleryQueue
-		DownloadGallery(gallery, i, len(galleryUrls), conf)
-		if gallery.Url == galleryUrls[len(galleryUrls)-1] {
+	i := 0
+	for queued := range galleryQueue {
+		if Interrupted() {
 			break
 		}
+		DownloadGallery(queued.Gallery, i, len(jobs), queued.Conf)
+		if queued.More {
+			if Interrupted() {
+				// the later volumes are not among the remaining pages
+				InterruptJob(queued.Gallery.Url, nil)
+			}
+			continue
+		}
+		if !Interrupted() {
+			FinishJob(queued.Gallery.Url)
+		}
+		i++
 	}
+}
 
-	for {
-		if downloadingCount == 0 {
-			fmt.Println()
-			log.Println("Download Finish")
-			break
+func Finish() {
+	if komga != nil {
+		komga.Wait()
+	}
+	fmt.Println()
+	log.Println("Download Finish")
+	summary.Finish()
+	batch := summary
+	EmitEvent(WebhookEvent{Event: EventBatchFinished, Summary: &batch})
+	DesktopNotify("Download Finished", BatchMessage(batch))
+	WaitNotify()
+	summary.Print()
+	if conf.SummaryFile != "" {
+		if err := summary.Save(conf.SummaryFile); err != nil {
+			log.Println("Save Summary Fail: " + err.Error())
+		}
+	}
+	if err := SaveFailures(); err != nil {
+		log.Println("Save Failed List Fail: " + err.Error())
+	} else if len(failures) > 0 {
+		log.Println(strconv.Itoa(len(failures)) + " Failures Saved to " + failedJsonFile + ", Run With retry-failed to Retry Them")
+	}
+}
+
+func ReadList(fileName string) []string {
+	list, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			CommonError(fileName + " Not Found")
 		}
+		CommonError(err)
+	}
+	listStr := strings.TrimSpace(string(list))
+	if listStr == "" {
+		CommonError("Empty List")
 	}
-	_, _ = fmt.Scanf("wait")
+	return Unique(strings.Split(listStr, Eol()))
 }
 
 func DownloadGallery(gallery Gallery, index int, total int, conf Conf) {
-	lang := gallery.Lang
 	title := gallery.JpTitle
-	if lang == "" {
-		lang = "null"
-	}
 	if title == "" {
 		title = gallery.Title
 	}
 	fmt.Println()
 	log.Println("Start Download (" + strconv.Itoa(index+1) + "/" + strconv.Itoa(total) + "): " + title)
-	savePath := conf.SavePath + lang + "/" + ValidFileName(title)
-	if err := os.MkdirAll(savePath, os.ModeDir); err != nil {
-		if os.IsExist(err) {
-			savePath = conf.SavePath + lang + "/" + ValidFileName(title) + " - " + gallery.Id
-			if err := os.MkdirAll(savePath, os.ModeDir); err != nil {
-				log.Print(err)
+	savePath, err := GalleryPath(gallery, conf)
+	if err != nil {
+		log.Println("Gallery Path Fail: " + title + " Because " + err.Error())
+		atomic.AddInt64(&summary.GalleriesFailed, 1)
+		RecordFailure(Failure{Url: gallery.Url, Id: gallery.Id, Title: gallery.Title, Reason: "Gallery Path Fail: " + err.Error()})
+		NotifyGallery(GalleryResult{Gallery: gallery, Err: "Gallery Path Fail: " + err.Error()})
+		return
+	}
+	savePath = ClaimGalleryPath(gallery, savePath)
+
+	if !overwriteExisting {
+		if other := SavedElsewhere(savePath, conf); other != "" {
+			log.Println("Skip Gallery (Saved As " + other + "): " + title)
+			atomic.AddInt64(&summary.GalleriesSkipped, 1)
+			return
+		}
+	}
+
+	if conf.Duplicates != "" {
+		if dir, percent := duplicates.Find(gallery, savePath); dir != "" {
+			msg := title + " Shares " + strconv.Itoa(percent) + "% Of Its Pages With " + dir
+			if conf.Duplicates == "skip" {
+				log.Println("Skip Duplicate: " + msg)
+				atomic.AddInt64(&summary.GalleriesSkipped, 1)
 				return
 			}
+			log.Println("Probable Duplicate: " + msg)
 		}
-		log.Print(err)
+	}
+
+	EmitEvent(WebhookEvent{Event: EventGalleryStarted, Gallery: NewWebhookGallery(gallery, savePath, 0)})
+
+	ctx, skip := context.WithCancel(appCtx)
+	defer skip()
+	if conf.GalleryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(conf.GalleryTimeout)*time.Second)
+		defer cancel()
+	}
+	if IsVideo(gallery) {
+		if err := DownloadVideo(ctx, gallery, savePath, conf); err != nil {
+			log.Println("Download Video Fail: " + title + " Because " + err.Error())
+			atomic.AddInt64(&summary.GalleriesFailed, 1)
+			RecordFailure(Failure{Url: gallery.Url, Id: gallery.Id, Title: gallery.Title, Reason: "Download Video Fail: " + err.Error()})
+			NotifyGallery(GalleryResult{Gallery: gallery, SavePath: savePath, Err: "Download Video Fail: " + err.Error()})
+			return
+		}
+		atomic.AddInt64(&summary.GalleriesSucceeded, 1)
+		result := GalleryResult{Gallery: gallery, SavePath: savePath}
+		if info, err := storage.Stat(savePath + "/" + ValidFileName(gallery.VideoFileName)); err == nil {
+			result.Bytes = info.Size()
+		}
+		NotifyGallery(result)
+		return
+	}
+	finalPath := savePath
+	savePath = StagedPath(savePath, conf)
+	created := NewGallery(savePath)
+	progress, err := ReadProgress(savePath)
+	if err != nil {
+		log.Println("Read Progress Fail: " + savePath + " Because " + err.Error())
+	}
+	task := &GalleryTask{ctx: ctx, giveUp: skip, progress: progress, previous: make(map[string]ManifestFile)}
+	if manifest, err := ReadManifest(savePath); err == nil {
+		for _, file := range manifest.Files {
+			task.previous[file.Name] = file
+		}
+		task.previousPages = manifest.Pages
+	}
+	active := &ActiveGallery{Title: title, Pages: len(gallery.Files), Task: task, cancel: skip}
+	SetActiveGallery(active)
+	defer ClearActiveGallery(active)
+	task.wg.Add(len(gallery.Files))
+	go func() {
+		for i, img := range gallery.Files {
+			job := Job{
+				Image:    img,
+				Gallery:  gallery,
+				SavePath: savePath,
+				Conf:     conf,
+				Task:     task,
+			}
+			select {
+			case queue <- job:
+			case <-ctx.Done():
+				task.wg.Add(i - len(gallery.Files))
+				return
+			}
+		}
+	}()
+
+	finished := make(chan struct{})
+	go func() {
+		task.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		progress.Close()
+		fmt.Println()
+		if failed := atomic.LoadInt64(&task.failed); conf.GalleryMaxFailures > 0 && failed >= int64(conf.GalleryMaxFailures) && !Interrupted() {
+			// the images in flight finish before what was saved is discarded
+			<-finished
+			FailGallery(gallery, title, savePath, failed, created, conf)
+			return
+		}
+		if active.Skipped() {
+			log.Println("Skip Gallery: " + title + " (" +
+				strconv.FormatInt(atomic.LoadInt64(&task.done), 10) + "/" + strconv.Itoa(len(gallery.Files)) + " Images Done)")
+			atomic.AddInt64(&summary.GalleriesSkipped, 1)
+			return
+		}
+		reason := "Timeout After " + strconv.Itoa(conf.GalleryTimeout) + "s"
+		if Interrupted() {
+			reason = "Interrupted"
+			InterruptJob(gallery.Url, RemainingPages(gallery, task, conf))
+		}
+		log.Println("Gallery Partial: " + title + " Because " + reason + " (" +
+			strconv.FormatInt(atomic.LoadInt64(&task.done), 10) + "/" + strconv.Itoa(len(gallery.Files)) + " Images Done)")
+		atomic.AddInt64(&summary.GalleriesFailed, 1)
+		RecordFailure(Failure{Url: gallery.Url, Id: gallery.Id, Title: gallery.Title, Reason: reason})
+		NotifyGallery(GalleryResult{Gallery: gallery, SavePath: savePath, Err: reason})
 		return
 	}
-	for _, img := range gallery.Files {
-		job := Job{
-			Image:    img,
-			Gallery:  gallery,
-			SavePath: savePath,
-			Conf:     conf,
+	progress.Close()
+	if failed := atomic.LoadInt64(&task.failed); GalleryFailed(failed, len(gallery.Files), conf) {
+		FailGallery(gallery, title, savePath, failed, created, conf)
+		return
+	}
+	manifest, manifestErr := SaveManifest(gallery, savePath, task)
+	if manifestErr != nil {
+		log.Println("Save Manifest Fail: " + title + " Because " + manifestErr.Error())
+	} else if atomic.LoadInt64(&task.failed) == 0 {
+		if err := progress.Remove(); err != nil {
+			log.Println("Remove Progress Fail: " + savePath + " Because " + err.Error())
+		}
+	}
+	if conf.SaveMetadata {
+		if err := SaveMetadata(gallery, savePath); err != nil {
+			log.Println("Save Metadata Fail: " + title + " Because " + err.Error())
+		}
+	}
+	if conf.NumberPages {
+		if err := SavePageMap(gallery, savePath, conf); err != nil {
+			log.Println("Save Page Map Fail: " + title + " Because " + err.Error())
+		}
+	}
+	if describer, ok := storage.(Describer); ok {
+		pages := make([]string, 0, len(gallery.Files))
+		for _, img := range gallery.Files {
+			pages = append(pages, ImageFileName(img, conf))
+		}
+		describer.Describe(savePath, gallery, pages)
+	}
+	if err := storage.Finalize(savePath); err != nil {
+		log.Println("Finalize Gallery Fail: " + title + " Because " + err.Error())
+	}
+	if manifestErr == nil && atomic.LoadInt64(&task.failed) == 0 {
+		if moved, err := Unstage(savePath, finalPath, manifest); err != nil {
+			log.Println("Move Gallery Fail: " + savePath + " Because " + err.Error())
+		} else if moved {
+			savePath = finalPath
+		}
+	}
+	if manifestErr == nil && savePath == finalPath {
+		if conf.Duplicates != "" {
+			duplicates.Add(savePath, manifest)
+		}
+		if library != nil {
+			if err := library.Index(savePath, manifest); err != nil {
+				log.Println("Index Gallery Fail: " + title + " Because " + err.Error())
+			}
 		}
-		queue <- job
+	}
+	result := GalleryResult{Gallery: gallery, SavePath: savePath}
+	for _, file := range task.files {
+		result.Bytes += file.Size
+	}
+	if len(gallery.Files) > 0 {
+		result.Cover = ImageFileName(gallery.Files[0], conf)
+	}
+	if failed := atomic.LoadInt64(&task.failed); failed > 0 {
+		fmt.Println()
+		log.Println("Gallery Partial: " + title + " Because " + strconv.FormatInt(failed, 10) + " Images Failed")
+		atomic.AddInt64(&summary.GalleriesFailed, 1)
+		result.Err = strconv.FormatInt(failed, 10) + " Images Failed"
+		NotifyGallery(result)
+		return
+	}
+	atomic.AddInt64(&summary.GalleriesSucceeded, 1)
+	NotifyGallery(result)
+	if komga != nil {
+		komga.Added(gallery, savePath)
 	}
 }
 
 func DownloadImageWorker() {
-	for {
-		select {
-		case job := <-queue:
-			DownloadImageHandler(job)
-		default:
+	for job := range queue {
+		if pause.Wait(job.Task.ctx) != nil {
+			job.Task.wg.Done()
+			continue
 		}
-		if downloadingCount == 0 && downloadStartCount > 0 {
-			break
+		if job.Task.ctx.Err() != nil {
+			job.Task.wg.Done()
+			continue
 		}
+		atomic.AddInt64(&activeWorkers, 1)
+		DownloadImageHandler(job)
+		atomic.AddInt64(&activeWorkers, -1)
 	}
 }
 
 func DownloadImageHandler(job Job) {
 	fmt.Print(".")
-	atomic.AddInt64(&downloadingCount, 1)
-	atomic.AddInt64(&downloadStartCount, 1)
-	for tries := 1; ; tries++ {
-		req := fasthttp.AcquireRequest()
-		req.URI().Update(ImageUrl(job.Image))
-		req.Header.SetMethod("GET")
-		req.Header.Set("Referer", "https://hitomi.la/reader/"+job.Gallery.Id+".html")
-		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 6.1; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/87.0.4280.88 Safari/537.36")
-		res := fasthttp.AcquireResponse()
-		if err := Client.Do(req, res); err == nil && res.Header.StatusCode() == 200 && res.Header.ContentLength() > 0 {
-			fileName := job.Image.Name
-			if job.Image.HasAvif == 1 {
-				fileName = strings.Split(fileName, ".")[0] + ".avif"
-			} else if job.Image.HasWebp == 1 {
-				fileName = strings.Split(fileName, ".")[0] + ".webp"
+	name := ImageFileName(job.Image, job.Conf)
+	fileName := job.SavePath + "/" + name
+	if file, ok := job.Task.progress.Page(job.Image.Hash); ok && file.Name == name {
+		job.Task.AddFile(file)
+		ImageSkipped(job, file)
+		return
+	}
+	if info, err := storage.Stat(fileName); err == nil && !overwriteExisting {
+		file, err := job.Task.ExistingFile(name, fileName, info.Size())
+		if err == nil {
+			job.Task.AddFile(file)
+			ProgressAdd(job, file)
+		} else {
+			log.Println("Hash Image Fail: " + fileName + " Because " + err.Error())
+			file = ManifestFile{Name: name, Size: info.Size()}
+		}
+		ImageSkipped(job, file)
+		return
+	}
+	ctx, cancel := context.WithTimeout(job.Task.ctx, time.Duration(conf.ImageTimeout)*time.Second)
+	defer cancel()
+	var err error
+	attempts := 0
+	left := func() bool {
+		return job.Conf.ImageAttempts <= 0 || attempts < job.Conf.ImageAttempts
+	}
+	retries := RequestRetries(job.Conf)
+	for tries := 1; tries <= retries+1 && left(); tries++ {
+		attempts++
+		if err = DownloadImage(ctx, job, fileName); err == nil {
+			return
+		}
+		if job.Task.ctx.Err() != nil {
+			job.Task.wg.Done()
+			return
+		}
+		if ctx.Err() != nil {
+			ImageFail(job, "Download Image Fail: "+job.Image.Name+" Because ImageTimeout Of "+strconv.Itoa(conf.ImageTimeout)+"s Reached"+Eol()+"Last Error: "+err.Error())
+			return
+		}
+		if IsRateLimited(err) && throttle.Paused() {
+			tries--
+			attempts--
+		}
+		if IsMissing(err) {
+			// the url is wrong, trying it again won't help
+			break
+		}
+		if tries <= retries {
+			atomic.AddInt64(&summary.Retries, 1)
+		}
+	}
+	if IsFrontendError(err) {
+		for _, img := range AlternateImages(job.Image) {
+			if !left() {
+				break
+			}
+			attempts++
+			alternate := job
+			alternate.Image = img
+			if err = DownloadImage(ctx, alternate, job.SavePath+"/"+ImageFileName(img, job.Conf)); err == nil {
+				log.Println("Download Image From Alternate: " + img.Url)
+				return
 			}
-			writeJob := WriteJob{
-				Content:  res.Body(),
-				FileName: job.SavePath + "/" + fileName,
+			if job.Task.ctx.Err() != nil {
+				job.Task.wg.Done()
+				return
 			}
-			writeQueue <- writeJob
-			fasthttp.ReleaseResponse(res)
-			fasthttp.ReleaseRequest(req)
-			break
-		} else {
-			if tries > conf.Retry {
-				toPrint := "Download Image Fail: " + job.Image.Name + " Because Max Retry Times Reached"
-				if err != nil {
-					toPrint = toPrint + Eol() + "Last Error: " + err.Error()
-				}
-				if res.Header.StatusCode() != 200 {
-					toPrint = toPrint + Eol() + "Last Error: Status Code " + strconv.Itoa(res.Header.StatusCode())
-				}
-				log.Println(toPrint)
-				atomic.AddInt64(&downloadingCount, -1)
+			if ctx.Err() != nil {
 				break
 			}
-			continue
 		}
 	}
+	if conf.EHentai.Fallback && IsGone(err) && job.Image.Page > 0 && job.Gallery.Source == hitomi.Name() {
+		if err = DownloadEHentaiImage(ctx, job); err == nil {
+			log.Println("Download Image From E-Hentai: " + job.Image.Name)
+			return
+		}
+		if job.Task.ctx.Err() != nil {
+			job.Task.wg.Done()
+			return
+		}
+	}
+	reason := "Max Retry Times Reached"
+	if !left() {
+		reason = "ImageAttempts Of " + strconv.Itoa(job.Conf.ImageAttempts) + " Reached"
+	}
+	ImageFail(job, "Download Image Fail: "+job.Image.Name+" Because "+reason+Eol()+"Last Error: "+err.Error())
 }
 
-func WriteWorker() {
-	for {
-		select {
-		case job := <-writeQueue:
-			{
-				WriterHandler(job)
-			}
-		default:
-		}
-		if downloadingCount == 0 && downloadStartCount > 0 {
-			break
+// RequestRetries is how often a request of an image is repeated after a
+// failure, RequestRetry or else Retry.
+func RequestRetries(conf Conf) int {
+	if conf.RequestRetry > 0 {
+		return conf.RequestRetry
+	}
+	return conf.Retry
+}
+
+// DownloadImage streams the image straight into the storage when it supports
+// it and the page is neither converted nor stripped, otherwise the body is
+// handed to the convert/write queues.
+func DownloadImage(imageCtx context.Context, job Job, fileName string) error {
+	ctx, cancel := ImageContext(imageCtx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", ImageUrl(job.Image), nil)
+	if err != nil {
+		return err
+	}
+	for key, value := range RequestHeaders(GallerySource(job.Gallery).Referer(job.Gallery), job.Gallery.Id) {
+		req.Header.Set(key, value)
+	}
+	res, err := ImageDo(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return StatusError(res.StatusCode)
+	}
+	if res.ContentLength == 0 {
+		return NotImageError("Empty Body")
+	}
+	transfer := StartTransfer(job.Image.Name, res.ContentLength)
+	defer transfer.Finish()
+	stalled, stop := WatchStall(transfer.Reader(res.Body), cancel)
+	defer stop()
+	sniffed := bufio.NewReaderSize(stalled, sniffLen)
+	head, err := sniffed.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if err = CheckImageBody(res.Header.Get("Content-Type"), head); err != nil {
+		return err
+	}
+	var body io.Reader = sniffed
+
+	if sw, ok := storage.(StreamWriter); ok && !NeedsConvert(job.Conf) && !job.Conf.StripMetadata && !job.Conf.PerceptualHash {
+		hash := sha256.New()
+		n, err := sw.WriteStream(fileName, io.TeeReader(body, hash))
+		if err != nil {
+			return err
 		}
+		ImageDone(job, ManifestFile{
+			Name:   strings.TrimPrefix(fileName, job.SavePath+"/"),
+			Size:   n,
+			Sha256: hex.EncodeToString(hash.Sum(nil)),
+			Url:    ImageUrl(job.Image),
+		})
+		return nil
+	}
+
+	buf, err := ReadBody(body)
+	if err != nil {
+		return err
+	}
+	if err = buffered.Acquire(imageCtx, int64(buf.Len())); err != nil {
+		PutBody(buf)
+		return err
+	}
+	writeJob := WriteJob{
+		Content:  buf.Bytes(),
+		FileName: fileName,
+		Job:      job,
+		Reserved: int64(buf.Len()),
+		body:     buf,
+	}
+	next := writeQueue
+	if NeedsConvert(job.Conf) {
+		next = convertQueue
+	}
+	select {
+	case next <- writeJob:
+		return nil
+	case <-imageCtx.Done():
+		PutBody(buf)
+		buffered.Release(writeJob.Reserved)
+		return imageCtx.Err()
+	}
+}
+
+func WriteWorker() {
+	for job := range writeQueue {
+		WriterHandler(job)
 	}
 }
 
 func WriterHandler(job WriteJob) {
-	if err := ioutil.WriteFile(job.FileName, job.Content, os.ModeAppend); err != nil {
-		log.Print("Download Image Fail: " + job.FileName + " Because " + err.Error())
+	if job.Job.Task.ctx.Err() != nil {
+		PutBody(job.body)
+		buffered.Release(job.Reserved)
+		job.Job.Task.wg.Done()
+		return
+	}
+	if job.Job.Conf.StripMetadata {
+		job.Content = StripMetadata(job.Content)
+	}
+	if err := storage.Write(job.FileName, job.Content); err != nil {
+		PutBody(job.body)
+		buffered.Release(job.Reserved)
+		ImageFail(job.Job, "Download Image Fail: "+job.FileName+" Because "+err.Error())
+		return
+	}
+	hashQueue <- job
+}
+
+func ImageDone(job Job, file ManifestFile) {
+	atomic.StoreInt64(&lastDownload, time.Now().UnixNano())
+	atomic.AddInt64(&summary.ImagesDownloaded, 1)
+	atomic.AddInt64(&summary.Bytes, file.Size)
+	atomic.AddInt64(&job.Task.done, 1)
+	job.Task.AddFile(file)
+	ProgressAdd(job, file)
+	StreamImageEvent(job, file, false)
+	job.Task.wg.Done()
+}
+
+// ImageSkipped finishes a page saved by an earlier run.
+func ImageSkipped(job Job, file ManifestFile) {
+	atomic.AddInt64(&summary.ImagesSkipped, 1)
+	atomic.AddInt64(&job.Task.done, 1)
+	StreamImageEvent(job, file, true)
+	job.Task.wg.Done()
+}
+
+func ProgressAdd(job Job, file ManifestFile) {
+	if err := job.Task.progress.Add(job.Image.Hash, file); err != nil {
+		log.Println("Save Progress Fail: " + job.SavePath + " Because " + err.Error())
+	}
+}
+
+func ImageFail(job Job, msg string) {
+	log.Println(msg)
+	RecordImageFailure(job, strings.ReplaceAll(msg, Eol(), " "))
+	atomic.AddInt64(&summary.ImagesFailed, 1)
+	if failed := atomic.AddInt64(&job.Task.failed, 1); job.Conf.GalleryMaxFailures > 0 && failed == int64(job.Conf.GalleryMaxFailures) {
+		job.Task.giveUp()
 	}
-	atomic.AddInt64(&downloadingCount, -1)
+	job.Task.wg.Done()
 }
 
-func GalleryInfo(url string) (gallery Gallery, err error) {
-	pieces := strings.Split(url, "-")
-	last := pieces[len(pieces)-1]
-	id := strings.Split(last, ".")[0]
-	code, resp, err := Client.Get(nil, "https://ltn.hitomi.la/galleries/"+id+".js")
+func GalleryId(url string) string {
+	url = strings.SplitN(url, "#", 2)[0]
+	url = strings.SplitN(url, "?", 2)[0]
+	last := url[strings.LastIndex(url, "/")+1:]
+	last = strings.Split(last, ".")[0]
+	return last[strings.LastIndex(last, "-")+1:]
+}
+
+func GalleryUrl(id string) string {
+	return "https://hitomi.la/galleries/" + id + ".html"
+}
+
+func GalleryJsInfo(ctx context.Context, id string) (gallery Gallery, err error) {
+	resp, err := GalleryJs(ctx, id)
 	if err != nil {
 		return gallery, err
 	}
-	if code != 200 {
-		return gallery, errors.New(strconv.Itoa(code))
-	}
 	resp = bytes.ReplaceAll(resp, []byte("var galleryinfo = "), []byte(""))
 	err = json.Unmarshal(resp, &gallery)
 	if err != nil {
@@ -266,9 +1169,95 @@ func GalleryInfo(url string) (gallery Gallery, err error) {
 	return gallery, nil
 }
 
+func (f *JsonFlag) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	*f = JsonFlag(s == "1" || s == "true")
+	return nil
+}
+
+func (l *NameList) UnmarshalJSON(data []byte) error {
+	var items []interface{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	names := make(NameList, 0, len(items))
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			names = append(names, v)
+		case map[string]interface{}:
+			for key, value := range v {
+				if name, ok := value.(string); ok && key != "url" {
+					names = append(names, name)
+					break
+				}
+			}
+		}
+	}
+	*l = names
+	return nil
+}
+
+func SaveMetadata(gallery Gallery, savePath string) error {
+	data, err := json.MarshalIndent(gallery, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.Write(savePath+"/metadata.json", data)
+}
+
+func (t Tag) Name() string {
+	if t.Female {
+		return "female:" + t.Tag
+	}
+	if t.Male {
+		return "male:" + t.Tag
+	}
+	return t.Tag
+}
+
+func ImageFileName(img Image, conf Conf) string {
+	fileName := img.Name
+	if ext := ConvertExt(conf.ConvertTo); ext != "" && !AvifOnly(img) {
+		fileName = strings.Split(fileName, ".")[0] + ext
+	} else if img.HasAvif == 1 {
+		fileName = strings.Split(fileName, ".")[0] + ".avif"
+	} else if img.HasWebp == 1 {
+		fileName = strings.Split(fileName, ".")[0] + ".webp"
+	} else if NeedsConvert(conf) && strings.HasSuffix(fileName, ".webp") {
+		fileName = strings.TrimSuffix(fileName, ".webp") + ConvertExt(ResizeFormat("webp"))
+	}
+	if conf.NumberPages && img.Page > 0 {
+		fileName = PageNumber(img.Page, img.Pages) + filepath.Ext(fileName)
+	}
+	return fileName
+}
+
+// PageNumber is page zero-padded to at least three digits, and to as many as
+// pages has, so the names sort in order.
+func PageNumber(page int, pages int) string {
+	digits := len(strconv.Itoa(pages))
+	if digits < 3 {
+		digits = 3
+	}
+	number := strconv.Itoa(page)
+	if len(number) < digits {
+		number = strings.Repeat("0", digits-len(number)) + number
+	}
+	return number
+}
+
 func ImageUrl(img Image) string {
+	return FrontendImageUrl(img, -1)
+}
+
+// FrontendImageUrl is the url of img on the frontend o, or on the one its
+// hash maps to when o is negative.
+func FrontendImageUrl(img Image, o int) string {
+	if img.Url != "" {
+		return img.Url
+	}
 	var retval string
-	subDomain := "a"
 	directory := "images"
 
 	h1 := img.Hash[len(img.Hash)-1:]
@@ -287,15 +1276,42 @@ func ImageUrl(img Image) string {
 		retval = "b"
 	}
 
-	g, err := strconv.ParseInt(h2, 16, 64)
-	if err == nil {
-		o := 0
-		if g < 0x7c {
+	if o < 0 {
+		o = 0
+		g, err := strconv.ParseInt(h2, 16, 64)
+		if err == nil && g < frontendThreshold {
 			o = 1
 		}
-		subDomain = string(97+o) + retval
 	}
-	return "https://" + subDomain + ".hitomi.la/" + directory + "/" + h1 + "/" + h2 + "/" + img.Hash + ext
+	subDomain := string(rune(97+o)) + retval
+	return "https://" + ImageHost(subDomain) + "/" + directory + "/" + h1 + "/" + h2 + "/" + img.Hash + ext
+}
+
+// AlternateImages lists where else img may be found when its frontend fails:
+// the other frontends, then the original variant on every frontend.
+func AlternateImages(img Image) []Image {
+	if img.Url != "" {
+		return nil
+	}
+	variants := []Image{img}
+	if img.HasAvif == 1 || img.HasWebp == 1 {
+		original := img
+		original.HasAvif = 0
+		original.HasWebp = 0
+		variants = append(variants, original)
+	}
+	primary := ImageUrl(img)
+	var alternates []Image
+	for _, variant := range variants {
+		for o := 0; o < frontends; o++ {
+			if u := FrontendImageUrl(variant, o); u != primary {
+				alternate := variant
+				alternate.Url = u
+				alternates = append(alternates, alternate)
+			}
+		}
+	}
+	return alternates
 }
 
 func Unique(strSlice []string) []string {
@@ -328,6 +1344,14 @@ func Eol() string {
 
 func CommonError(msg interface{}) {
 	log.Println(msg)
-	_, _ = fmt.Scanf("wait")
-	os.Exit(1)
+	Exit(ExitFatal)
+}
+
+// Exit keeps the console window open until enter is pressed when started
+// from a terminal, e.g. by double click on Windows.
+func Exit(code int) {
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		_, _ = fmt.Scanf("wait")
+	}
+	os.Exit(code)
 }
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const testHash = "1c2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c1d2e"
+
+const testGalleryJs = `var galleryinfo = {"id":"1234","title":"Test Gallery","japanese_title":null,` +
+	`"language":"japanese","type":"manga","date":"2020-01-01 00:00:00-06",` +
+	`"artists":[{"artist":"someone","url":"/artist/someone-all.html"}],` +
+	`"tags":[{"tag":"glasses","female":"1","url":"/tag/female:glasses-all.html"},{"tag":"full color","url":"/tag/full%20color-all.html"}],` +
+	`"files":[{"name":"01.jpg","hash":"` + testHash + `","haswebp":1,"hasavif":0,"width":10,"height":10}]}`
+
+const testGalleryBlock = `<div class="manga"><h1 class="lillie"><a href="/manga/test-1234.html">Test Gallery</a></h1>` +
+	`<div class="artist-list"><ul><li><a href="/artist/someone-all.html">someone</a></li></ul></div>` +
+	`<p class="date" data-posted="2020-01-01">2020-01-01</p></div>`
+
+func TestImageUrl(t *testing.T) {
+	img := Image{Name: "01.jpg", Hash: testHash}
+	want := "https://ab.hitomi.la/images/e/d2/" + testHash + ".jpg"
+	if got := ImageUrl(img); got != want {
+		t.Errorf("ImageUrl = %s, want %s", got, want)
+	}
+	img.HasWebp = 1
+	want = "https://aa.hitomi.la/webp/e/d2/" + testHash + ".webp"
+	if got := ImageUrl(img); got != want {
+		t.Errorf("ImageUrl = %s, want %s", got, want)
+	}
+	img.Hash = testHash[:61] + "05e"
+	want = "https://ba.hitomi.la/webp/e/05/" + img.Hash + ".webp"
+	if got := ImageUrl(img); got != want {
+		t.Errorf("ImageUrl = %s, want %s", got, want)
+	}
+}
+
+func TestHostOverrides(t *testing.T) {
+	defer func(host string, domain string) {
+		conf.MetadataHost, conf.ImageDomain = host, domain
+	}(conf.MetadataHost, conf.ImageDomain)
+	conf.MetadataHost, conf.ImageDomain = "", ""
+	if got := MetadataUrl("galleries/1234.js"); got != "https://ltn.hitomi.la/galleries/1234.js" {
+		t.Errorf("default MetadataUrl = %s", got)
+	}
+	conf.MetadataHost, conf.ImageDomain = "http://127.0.0.1:8081/", "mirror.example"
+	if got := MetadataUrl("galleries/1234.js"); got != "http://127.0.0.1:8081/galleries/1234.js" {
+		t.Errorf("MetadataUrl = %s", got)
+	}
+	want := "https://aa.mirror.example/webp/e/d2/" + testHash + ".webp"
+	if got := ImageUrl(Image{Name: "01.jpg", Hash: testHash, HasWebp: 1}); got != want {
+		t.Errorf("ImageUrl = %s, want %s", got, want)
+	}
+	conf.MetadataHost = "ltn.mirror.example"
+	if got := MetadataUrl(""); got != "https://ltn.mirror.example/" {
+		t.Errorf("MetadataUrl = %s", got)
+	}
+}
+
+func TestImageFileNameNumberPages(t *testing.T) {
+	numbered := Conf{NumberPages: true}
+	for _, c := range []struct {
+		img  Image
+		conf Conf
+		want string
+	}{
+		{Image{Name: "b.jpg", HasWebp: 1, Page: 2, Pages: 12}, Conf{}, "b.webp"},
+		{Image{Name: "b.jpg", HasWebp: 1, Page: 2, Pages: 12}, numbered, "002.webp"},
+		{Image{Name: "p.png", Page: 15, Pages: 1200}, numbered, "0015.png"},
+		{Image{Name: "a.jpg", HasAvif: 1, Page: 1200, Pages: 1200}, numbered, "1200.avif"},
+		{Image{Name: "a.jpg"}, numbered, "a.jpg"},
+	} {
+		if got := ImageFileName(c.img, c.conf); got != c.want {
+			t.Errorf("ImageFileName(%+v) = %s, want %s", c.img, got, c.want)
+		}
+	}
+}
+
+func TestAlternateImages(t *testing.T) {
+	img := Image{Name: "01.jpg", Hash: testHash, HasWebp: 1}
+	alternates := AlternateImages(img)
+	if len(alternates) != 2*frontends-1 {
+		t.Fatalf("got %d alternates, want %d", len(alternates), 2*frontends-1)
+	}
+	seen := map[string]bool{ImageUrl(img): true}
+	for _, alternate := range alternates {
+		if seen[alternate.Url] {
+			t.Errorf("duplicate alternate %s", alternate.Url)
+		}
+		seen[alternate.Url] = true
+	}
+}
+
+func TestGalleryId(t *testing.T) {
+	for url, want := range map[string]string{
+		"https://hitomi.la/galleries/1234.html":                "1234",
+		"https://hitomi.la/manga/some-title-1234.html#1":       "1234",
+		"https://hitomi.la/reader/1234.html?page=2":            "1234",
+		"https://hitomi.la/doujinshi/title-japanese-1234.html": "1234",
+	} {
+		if got := GalleryId(url); got != want {
+			t.Errorf("GalleryId(%s) = %s, want %s", url, got, want)
+		}
+	}
+}
+
+func TestGalleryJsInfo(t *testing.T) {
+	mockSite(t, map[string]http.HandlerFunc{
+		"/galleries/1234.js": serveString(testGalleryJs),
+	})
+	gallery, err := GalleryInfo(context.Background(), "https://hitomi.la/galleries/1234.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gallery.Title != "Test Gallery" || gallery.Type != "manga" {
+		t.Errorf("got %+v", gallery)
+	}
+	if len(gallery.Artists) != 1 || gallery.Artists[0] != "someone" {
+		t.Errorf("Artists = %v", gallery.Artists)
+	}
+	if len(gallery.Tags) != 2 || gallery.Tags[0].Name() != "female:glasses" || gallery.Tags[1].Name() != "full color" {
+		t.Errorf("Tags = %+v", gallery.Tags)
+	}
+	if len(gallery.Files) != 1 || gallery.Files[0].Hash != testHash || gallery.Files[0].HasWebp != 1 {
+		t.Errorf("Files = %+v", gallery.Files)
+	}
+}
+
+func TestGalleryInfoFallsBackToGalleryBlock(t *testing.T) {
+	mockSite(t, map[string]http.HandlerFunc{
+		"/galleryblock/1234.html": serveString(testGalleryBlock),
+		"/reader/1234.html":       serveString(`<div class="img-url">//aa.hitomi.la/galleries/1234/01.jpg</div>`),
+	})
+	gallery, err := GalleryInfo(context.Background(), "https://hitomi.la/galleries/1234.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gallery.Title != "Test Gallery" || gallery.Id != "1234" {
+		t.Errorf("got %+v", gallery)
+	}
+	if len(gallery.Files) != 1 {
+		t.Errorf("Files = %+v", gallery.Files)
+	}
+}
+
+func TestGalleryInfoNotFound(t *testing.T) {
+	mockSite(t, nil)
+	if _, err := GalleryInfo(context.Background(), "https://hitomi.la/galleries/1234.html"); err == nil {
+		t.Error("want an error for a missing gallery")
+	}
+}
+
+var setupOnce sync.Once
+
+// setupPipeline starts the workers once for all pipeline tests, saving to a
+// directory of its own. The working directory moves there too, since the
+// queue and failure lists are written to it.
+func setupPipeline(t *testing.T) string {
+	setupOnce.Do(func() {
+		dir, err := ioutil.TempDir("", "hitomi-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Chdir(dir); err != nil {
+			t.Fatal(err)
+		}
+		conf = Conf{
+			SavePath:         filepath.Join(dir, "out"),
+			ThreadNum:        2,
+			Retry:            2,
+			ConnectTimeout:   5,
+			ReadTimeout:      5,
+			ImageTimeout:     30,
+			ConvertThreadNum: 1,
+			ConvertQuality:   90,
+		}
+		Setup()
+	})
+	return conf.SavePath
+}
+
+func TestDownloadRetriesImage(t *testing.T) {
+	savePath := setupPipeline(t)
+	image := []byte("RIFF\x10\x00\x00\x00WEBPVP8 not really a webp")
+	mockSite(t, map[string]http.HandlerFunc{
+		"/galleries/1234.js":               serveString(testGalleryJs),
+		"/webp/e/d2/" + testHash + ".webp": failFirst(1, http.StatusServiceUnavailable, image),
+	})
+	retries := summary.Retries
+	RunJobs(UrlJobs([]string{"https://hitomi.la/galleries/1234.html"}))
+
+	dir, err := GalleryPath(Gallery{Id: "1234", Title: "Test Gallery", Type: "manga", Lang: "japanese"}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(savePath, dir, "01.webp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(image) {
+		t.Errorf("saved %q, want %q", data, image)
+	}
+	manifest, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != 1 || manifest.Files[0].Sha256 != Sha256Sum(image) {
+		t.Errorf("manifest files = %+v", manifest.Files)
+	}
+	if summary.Retries <= retries {
+		t.Error("the failed request was not retried")
+	}
+	if _, err = os.Stat(queueFile); !os.IsNotExist(err) {
+		t.Errorf("%s left behind after the batch finished", queueFile)
+	}
+}
+
+func TestDownloadRejectsHtmlPage(t *testing.T) {
+	savePath := setupPipeline(t)
+	original := []byte("\xff\xd8\xff\xe0 not really a jpg")
+	mockSite(t, map[string]http.HandlerFunc{
+		"/galleries/9012.js": serveString(strings.NewReplacer(`"1234"`, `"9012"`, "Test Gallery", "Removed Gallery").Replace(testGalleryJs)),
+		"/webp/e/d2/" + testHash + ".webp": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><body>This file has been removed</body></html>"))
+		},
+		"/images/e/d2/" + testHash + ".jpg": serveString(string(original)),
+	})
+	RunJobs(UrlJobs([]string{"https://hitomi.la/galleries/9012.html"}))
+
+	dir, err := GalleryPath(Gallery{Id: "9012", Title: "Removed Gallery", Type: "manga", Lang: "japanese"}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(savePath, dir, "01.webp")); !os.IsNotExist(err) {
+		t.Error("the html page was saved as the webp")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(savePath, dir, "01.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(original) {
+		t.Errorf("saved %q, want the original jpg", data)
+	}
+}
+
+func TestDownloadRecordsFailedImage(t *testing.T) {
+	setupPipeline(t)
+	mockSite(t, map[string]http.HandlerFunc{
+		"/galleries/5678.js": serveString(strings.NewReplacer(`"1234"`, `"5678"`, "Test Gallery", "Broken Gallery").Replace(testGalleryJs)),
+	})
+	failed := summary.ImagesFailed
+	RunJobs(UrlJobs([]string{"https://hitomi.la/galleries/5678.html"}))
+	if summary.ImagesFailed != failed+1 {
+		t.Errorf("ImagesFailed = %d, want %d", summary.ImagesFailed, failed+1)
+	}
+}
+
+func TestImageAttempts(t *testing.T) {
+	setupPipeline(t)
+	defer func(retry int, attempts int) {
+		conf.Retry, conf.ImageAttempts = retry, attempts
+	}(conf.Retry, conf.ImageAttempts)
+	var requests int32
+	missing := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.NotFound(w, r)
+	}
+	mockSite(t, map[string]http.HandlerFunc{
+		"/galleries/7890.js":                serveString(strings.NewReplacer(`"1234"`, `"7890"`, "Test Gallery", "Missing Gallery").Replace(testGalleryJs)),
+		"/webp/e/d2/" + testHash + ".webp":  missing,
+		"/images/e/d2/" + testHash + ".jpg": missing,
+	})
+
+	// a 404 isn't retried, every other url is tried once
+	conf.Retry, conf.ImageAttempts = 3, 0
+	RunJobs(UrlJobs([]string{"https://hitomi.la/galleries/7890.html"}))
+	if want := int32(1 + len(AlternateImages(Image{Name: "01.jpg", Hash: testHash, HasWebp: 1}))); requests != want {
+		t.Errorf("%d requests, want %d", requests, want)
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	conf.ImageAttempts = 2
+	RunJobs(UrlJobs([]string{"https://hitomi.la/galleries/7890.html"}))
+	if requests != 2 {
+		t.Errorf("%d requests with ImageAttempts 2", requests)
+	}
+}
+
+func TestGalleryMaxFailures(t *testing.T) {
+	setupPipeline(t)
+	defer func(max int) { conf.GalleryMaxFailures = max }(conf.GalleryMaxFailures)
+	conf.GalleryMaxFailures = 1
+	js := strings.NewReplacer(`"1234"`, `"7891"`, "Test Gallery", "Hopeless Gallery").Replace(testGalleryJs)
+	js = strings.Replace(js, `}]}`, `},{"name":"02.jpg","hash":"`+strings.Repeat("0", 64)+`","haswebp":0,"hasavif":0,"width":10,"height":10}]}`, 1)
+	mockSite(t, map[string]http.HandlerFunc{
+		"/galleries/7891.js": serveString(js),
+	})
+	failed := len(failures)
+	RunJobs(UrlJobs([]string{"https://hitomi.la/galleries/7891.html"}))
+	whole := false
+	for _, f := range failures[failed:] {
+		whole = whole || f.Url == "https://hitomi.la/galleries/7891.html" && f.Hash == ""
+	}
+	if !whole {
+		t.Error("the gallery was not given up as a whole")
+	}
+}
+
+func TestSameTitleGalleries(t *testing.T) {
+	savePath := setupPipeline(t)
+	routes := map[string]http.HandlerFunc{"/webp/e/d2/" + testHash + ".webp": serveString("RIFF\x10\x00\x00\x00WEBPVP8 page")}
+	for _, id := range []string{"2470", "2471"} {
+		routes["/galleries/"+id+".js"] = serveString(strings.NewReplacer(`"1234"`, `"`+id+`"`, "Test Gallery", "Same Title Gallery").Replace(testGalleryJs))
+	}
+	mockSite(t, routes)
+	RunJobs(UrlJobs([]string{"https://hitomi.la/galleries/2470.html"}))
+	RunJobs(UrlJobs([]string{"https://hitomi.la/galleries/2471.html"}))
+
+	first, err := GalleryPath(Gallery{Id: "2470", Title: "Same Title Gallery", Type: "manga", Lang: "japanese"}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for dir, id := range map[string]string{first: "2470", first + " - 2471": "2471"} {
+		if manifest, err := ReadManifest(dir); err != nil || manifest.Id != id {
+			t.Errorf("%s holds gallery %q %v, want %s", dir, manifest.Id, err, id)
+		}
+		if _, err := os.Stat(filepath.Join(savePath, dir, "01.webp")); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestClaimGalleryPath(t *testing.T) {
+	setupPipeline(t)
+	// two galleries of one title starting at once, neither has a manifest yet
+	paths := make(chan string, 2)
+	for _, id := range []string{"2480", "2481"} {
+		go func(id string) {
+			paths <- ClaimGalleryPath(Gallery{Id: id}, "japanese/Racing Gallery")
+		}(id)
+	}
+	got := []string{<-paths, <-paths}
+	sort.Strings(got)
+	if got[0] != "japanese/Racing Gallery" || !strings.HasPrefix(got[1], "japanese/Racing Gallery - 248") {
+		t.Errorf("claimed %q, want one folder each", got)
+	}
+	// the gallery holding a folder keeps getting it
+	if again := ClaimGalleryPath(Gallery{Id: strings.TrimPrefix(got[1], "japanese/Racing Gallery - ")}, "japanese/Racing Gallery"); again != got[1] {
+		t.Errorf("claimed %q again, want %q", again, got[1])
+	}
+
+	// a folder saved by an earlier run
+	if err := storage.Write("japanese/Saved Gallery/"+manifestFile, []byte(`{"id":"2490"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if got := ClaimGalleryPath(Gallery{Id: "2491"}, "japanese/Saved Gallery"); got != "japanese/Saved Gallery - 2491" {
+		t.Errorf("claimed %q beside a saved gallery", got)
+	}
+	if got := ClaimGalleryPath(Gallery{Id: "2490"}, "japanese/Saved Gallery"); got != "japanese/Saved Gallery" {
+		t.Errorf("the saved gallery claimed %q", got)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+const manifestFile = "manifest.json"
+
+// Manifest lists every file of a downloaded gallery with its hash, so a
+// later verify can tell missing, damaged or altered pages apart.
+type Manifest struct {
+	Id             string         `json:"id"`
+	Title          string         `json:"title"`
+	Url            string         `json:"url"`
+	Pages          int            `json:"pages"`
+	Language       string         `json:"language,omitempty"`
+	Type           string         `json:"type,omitempty"`
+	Artists        []string       `json:"artists,omitempty"`
+	Groups         []string       `json:"groups,omitempty"`
+	Series         []string       `json:"series,omitempty"`
+	Tags           []string       `json:"tags,omitempty"`
+	TranslatedTags []string       `json:"translated_tags,omitempty"`
+	Created        time.Time      `json:"created"`
+	Files          []ManifestFile `json:"files"`
+}
+
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+	Url    string `json:"url,omitempty"`
+	// Phash is the PerceptualHash of the page, with PerceptualHash set.
+	Phash string `json:"phash,omitempty"`
+}
+
+func TagNames(tags []Tag) []string {
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		names = append(names, tag.Name())
+	}
+	return names
+}
+
+func Sha256Sum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func ReadManifest(savePath string) (Manifest, error) {
+	var manifest Manifest
+	data, err := storage.Read(savePath + "/" + manifestFile)
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(data, &manifest)
+	return manifest, err
+}
+
+// diskStorages are the storages keeping their galleries under SavePath.
+var diskStorages = []string{"local", "zip", "epub", "tar", "tar.zst"}
+
+// SavedElsewhere returns the storage other than the configured one holding
+// a complete copy of the gallery at savePath, like its cbz when Storage went
+// from "zip" to "local", so switching doesn't download everything again.
+func SavedElsewhere(savePath string, conf Conf) string {
+	current := conf.Storage
+	if current == "" {
+		current = "local"
+	}
+	found := false
+	for _, name := range diskStorages {
+		found = found || name == current
+	}
+	if !found {
+		return ""
+	}
+	for _, name := range diskStorages {
+		if name == current {
+			continue
+		}
+		otherConf := conf
+		otherConf.Storage = name
+		otherConf.CAS = CASConf{}
+		other, err := NewStorage(otherConf)
+		if err != nil {
+			continue
+		}
+		data, err := other.Read(savePath + "/" + manifestFile)
+		if err != nil {
+			continue
+		}
+		var manifest Manifest
+		if json.Unmarshal(data, &manifest) == nil && manifest.Pages > 0 && len(manifest.Files) >= manifest.Pages {
+			return name
+		}
+	}
+	return ""
+}
+
+// SaveManifest writes the files of task on top of those of the old
+// manifest, so a run downloading only some pages keeps the rest listed.
+func SaveManifest(gallery Gallery, savePath string, task *GalleryTask) (Manifest, error) {
+	merged := make(map[string]ManifestFile, len(task.previous)+len(task.files))
+	for name, file := range task.previous {
+		merged[name] = file
+	}
+	for _, file := range task.files {
+		merged[file.Name] = file
+	}
+	files := make([]ManifestFile, 0, len(merged))
+	for _, file := range merged {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	pages := len(gallery.Files)
+	if task.previousPages > pages {
+		pages = task.previousPages
+	}
+	manifest := Manifest{
+		Id:       gallery.Id,
+		Title:    gallery.Title,
+		Url:      gallery.Url,
+		Pages:    pages,
+		Language: gallery.Lang,
+		Type:     gallery.Type,
+		Artists:  gallery.Artists,
+		Groups:   gallery.Groups,
+		Series:   gallery.Parodys,
+		Tags:     TagNames(gallery.Tags),
+		Created:  time.Now(),
+		Files:    files,
+	}
+	if tagTranslation != nil {
+		manifest.TranslatedTags = TranslatedTagNames(gallery.Tags)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	return manifest, storage.Write(savePath+"/"+manifestFile, data)
+}
+
+func (t *GalleryTask) AddFile(file ManifestFile) {
+	t.lock.Lock()
+	t.files = append(t.files, file)
+	t.lock.Unlock()
+}
+
+// ExistingFile describes a file kept from an earlier run, reusing its entry
+// of the old manifest when the size still matches instead of hashing it again.
+func (t *GalleryTask) ExistingFile(name string, fileName string, size int64) (ManifestFile, error) {
+	if file, ok := t.previous[name]; ok && file.Size == size {
+		return file, nil
+	}
+	content, err := storage.Read(fileName)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	return ManifestFile{Name: name, Size: int64(len(content)), Sha256: Sha256Sum(content)}, nil
+}
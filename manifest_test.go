@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSavedElsewhere(t *testing.T) {
+	root := t.TempDir()
+	save := func(storageName string, dir string, manifest Manifest) {
+		s, err := NewStorage(Conf{Storage: storageName, SavePath: root})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := json.Marshal(manifest)
+		if err = s.Write(dir+"/"+manifestFile, data); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Finalize(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	complete := Manifest{Pages: 2, Files: []ManifestFile{{Name: "1.webp"}, {Name: "2.webp"}}}
+	save("zip", "japanese/Archived", complete)
+	save("zip", "japanese/Partial", Manifest{Pages: 2, Files: complete.Files[:1]})
+	save("local", "japanese/Folder", complete)
+
+	for _, c := range []struct {
+		storage string
+		dir     string
+		want    string
+	}{
+		{"local", "japanese/Archived", "zip"},
+		{"", "japanese/Archived", "zip"},
+		{"local", "japanese/Partial", ""},
+		{"local", "japanese/Missing", ""},
+		{"zip", "japanese/Archived", ""},
+		{"zip", "japanese/Folder", "local"},
+		{"tar", "japanese/Folder", "local"},
+		{"s3", "japanese/Folder", ""},
+	} {
+		if got := SavedElsewhere(c.dir, Conf{Storage: c.storage, SavePath: root}); got != c.want {
+			t.Errorf("SavedElsewhere(%q) with Storage %q = %q, want %q", c.dir, c.storage, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// defaultMetadataMaxAge is how long, in seconds, cached gallery info is used
+// without asking the site whether it changed.
+const defaultMetadataMaxAge = 3600
+
+var refreshMetadata = flag.Bool("refresh-metadata", false, "revalidate all cached gallery info with the site, however recent it is")
+
+var offlineMetadata = flag.String("offline-metadata", "", "read gallery info only from the galleries js saved in this directory or the metadata cache, never from the site")
+
+// metadataCacheDir keeps the galleries js fetched before, with the validators
+// to revalidate them.
+var metadataCacheDir = filepath.Join("cache", "galleries")
+
+type CachedMetadata struct {
+	Validators
+	Fetched time.Time `json:"fetched"`
+	Body    string    `json:"body"`
+}
+
+func metadataCacheFile(id string) string {
+	return filepath.Join(metadataCacheDir, id+".json")
+}
+
+func ReadCachedMetadata(id string) (CachedMetadata, bool) {
+	var cached CachedMetadata
+	data, err := ioutil.ReadFile(metadataCacheFile(id))
+	if err != nil {
+		return cached, false
+	}
+	if err = json.Unmarshal(data, &cached); err != nil {
+		return cached, false
+	}
+	return cached, true
+}
+
+func SaveCachedMetadata(id string, cached CachedMetadata) error {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(metadataCacheDir, 0755); err != nil {
+		return err
+	}
+	fileName := metadataCacheFile(id)
+	if err = ioutil.WriteFile(fileName+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(fileName+".tmp", fileName)
+}
+
+// GalleryJs returns the galleries js of id, from the cache while it is younger
+// than MetadataMaxAge and otherwise revalidated with the site, which only
+// sends it again when it changed.
+func GalleryJs(ctx context.Context, id string) ([]byte, error) {
+	if *offlineMetadata != "" {
+		return OfflineGalleryJs(*offlineMetadata, id)
+	}
+	cached, ok := ReadCachedMetadata(id)
+	maxAge := time.Duration(conf.MetadataMaxAge) * time.Second
+	if ok && !*refreshMetadata && time.Since(cached.Fetched) < maxAge {
+		return []byte(cached.Body), nil
+	}
+	code, body, validators, err := GetIfModified(ctx, MetadataUrl("galleries/"+id+".js"), cached.Validators)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case code == 304 && ok:
+		body = []byte(cached.Body)
+	case code == 200:
+		cached = CachedMetadata{Validators: validators, Body: string(body)}
+	default:
+		return nil, errors.New(strconv.Itoa(code))
+	}
+	cached.Fetched = time.Now()
+	if err = SaveCachedMetadata(id, cached); err != nil {
+		log.Println("Cache Gallery Info Fail: " + id + " Because " + err.Error())
+	}
+	return body, nil
+}
+
+// OfflineGalleryJs reads the galleries js of id from dir, saved from the site
+// as "<id>.js" or copied from a metadata cache as "<id>.json", however old it
+// is, or else from the metadata cache.
+func OfflineGalleryJs(dir string, id string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, id+".js"))
+	if err == nil {
+		return data, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	var cached CachedMetadata
+	if data, err = ioutil.ReadFile(filepath.Join(dir, id+".json")); err == nil {
+		if err = json.Unmarshal(data, &cached); err != nil {
+			return nil, err
+		}
+		return []byte(cached.Body), nil
+	}
+	if cached, ok := ReadCachedMetadata(id); ok {
+		return []byte(cached.Body), nil
+	}
+	return nil, errors.New("No Saved Gallery Info In " + dir)
+}
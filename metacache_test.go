@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGalleryJsRevalidates(t *testing.T) {
+	var requests, unchanged int32
+	mockSite(t, map[string]http.HandlerFunc{
+		"/galleries/1234.js": func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				atomic.AddInt32(&unchanged, 1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Write([]byte(testGalleryJs))
+		},
+	})
+	defer func(maxAge int, refresh bool) {
+		conf.MetadataMaxAge, *refreshMetadata = maxAge, refresh
+	}(conf.MetadataMaxAge, *refreshMetadata)
+
+	conf.MetadataMaxAge, *refreshMetadata = 3600, false
+	for i := 0; i < 2; i++ {
+		if body, err := GalleryJs(context.Background(), "1234"); err != nil || string(body) != testGalleryJs {
+			t.Fatalf("GalleryJs = %q, %v", body, err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("%d requests for fresh metadata, want 1", requests)
+	}
+
+	*refreshMetadata = true
+	if body, err := GalleryJs(context.Background(), "1234"); err != nil || string(body) != testGalleryJs {
+		t.Fatalf("GalleryJs = %q, %v", body, err)
+	}
+	if requests != 2 || unchanged != 1 {
+		t.Errorf("%d requests, %d unchanged, want 2 and 1", requests, unchanged)
+	}
+}
+
+func TestOfflineMetadata(t *testing.T) {
+	mockSite(t, map[string]http.HandlerFunc{
+		"/galleries/1234.js": func(w http.ResponseWriter, r *http.Request) {
+			t.Error("the site was asked for gallery info with --offline-metadata")
+		},
+	})
+	dir := t.TempDir()
+	defer func(offline string) { *offlineMetadata = offline }(*offlineMetadata)
+	*offlineMetadata = dir
+
+	if _, err := GalleryJs(context.Background(), "1234"); err == nil {
+		t.Error("read gallery info which was never saved")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "1234.js"), []byte(testGalleryJs), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gallery, err := GalleryInfo(context.Background(), "https://hitomi.la/galleries/1234.html")
+	if err != nil || gallery.Id != "1234" || len(gallery.Files) != 1 {
+		t.Errorf("GalleryInfo = %+v, %v", gallery, err)
+	}
+}
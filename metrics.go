@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// activeWorkers counts the download workers busy with an image.
+var activeWorkers int64
+
+type metricsWriter struct {
+	b strings.Builder
+}
+
+func (m *metricsWriter) Metric(name string, kind string, help string) {
+	m.b.WriteString("# HELP " + name + " " + help + "\n")
+	m.b.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+// Sample writes one value, labels like `result="failed"`.
+func (m *metricsWriter) Sample(name string, labels string, value int64) {
+	m.b.WriteString(name)
+	if labels != "" {
+		m.b.WriteString("{" + labels + "}")
+	}
+	m.b.WriteString(" " + strconv.FormatInt(value, 10) + "\n")
+}
+
+// MetricsHandler serves the counters in the Prometheus text format.
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	var m metricsWriter
+	m.Metric("hitomi_galleries_total", "counter", "Galleries finished by result.")
+	m.Sample("hitomi_galleries_total", `result="succeeded"`, atomic.LoadInt64(&summary.GalleriesSucceeded))
+	m.Sample("hitomi_galleries_total", `result="failed"`, atomic.LoadInt64(&summary.GalleriesFailed))
+	m.Sample("hitomi_galleries_total", `result="skipped"`, atomic.LoadInt64(&summary.GalleriesSkipped))
+	m.Metric("hitomi_images_total", "counter", "Images finished by result.")
+	m.Sample("hitomi_images_total", `result="downloaded"`, atomic.LoadInt64(&summary.ImagesDownloaded))
+	m.Sample("hitomi_images_total", `result="skipped"`, atomic.LoadInt64(&summary.ImagesSkipped))
+	m.Sample("hitomi_images_total", `result="failed"`, atomic.LoadInt64(&summary.ImagesFailed))
+	m.Metric("hitomi_bytes_total", "counter", "Bytes of images and videos downloaded.")
+	m.Sample("hitomi_bytes_total", "", atomic.LoadInt64(&summary.Bytes))
+	m.Metric("hitomi_retries_total", "counter", "Download attempts repeated after a failure.")
+	m.Sample("hitomi_retries_total", "", atomic.LoadInt64(&summary.Retries))
+
+	m.Metric("hitomi_queue_depth", "gauge", "Items waiting in each queue.")
+	pending := 0
+	if daemon != nil {
+		pending = daemon.Len()
+	}
+	m.Sample("hitomi_queue_depth", `queue="galleries"`, int64(pending))
+	m.Sample("hitomi_queue_depth", `queue="images"`, int64(len(queue)))
+	m.Sample("hitomi_queue_depth", `queue="write"`, int64(len(writeQueue)))
+	m.Sample("hitomi_queue_depth", `queue="convert"`, int64(len(convertQueue)))
+	m.Metric("hitomi_buffered_bytes", "gauge", "Bytes of downloaded images waiting to be converted or written.")
+	m.Sample("hitomi_buffered_bytes", "", buffered.Used())
+	m.Metric("hitomi_workers", "gauge", "Download workers started.")
+	m.Sample("hitomi_workers", "", int64(conf.ThreadNum))
+	m.Metric("hitomi_active_workers", "gauge", "Download workers busy with an image.")
+	m.Sample("hitomi_active_workers", "", atomic.LoadInt64(&activeWorkers))
+	m.Metric("hitomi_rate_limited", "gauge", "1 while downloads are paused by the rate limit cooldown.")
+	limited := int64(0)
+	if throttle.Paused() {
+		limited = 1
+	}
+	m.Sample("hitomi_rate_limited", "", limited)
+
+	m.Metric("hitomi_paused", "gauge", "1 while downloads are paused with ctl pause.")
+	paused := int64(0)
+	if pause.Paused() {
+		paused = 1
+	}
+	m.Sample("hitomi_paused", "", paused)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write([]byte(m.b.String()))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var nhentaiUrlRegexp = regexp.MustCompile(`nhentai\.net/g/(\d+)`)
+
+// nhentaiExts are the extensions of the page types of the nhentai API.
+var nhentaiExts = map[string]string{"j": ".jpg", "p": ".png", "g": ".gif", "w": ".webp"}
+
+func init() {
+	RegisterSource(NhentaiSource{})
+}
+
+// NhentaiSource reads galleries like https://nhentai.net/g/123456/ from
+// the JSON API of nhentai, the pages are on its media server.
+type NhentaiSource struct{}
+
+type nhentaiGallery struct {
+	Id      json.Number `json:"id"`
+	MediaId string      `json:"media_id"`
+	Title   struct {
+		English  string `json:"english"`
+		Japanese string `json:"japanese"`
+		Pretty   string `json:"pretty"`
+	} `json:"title"`
+	Images struct {
+		Pages []struct {
+			T string `json:"t"`
+		} `json:"pages"`
+	} `json:"images"`
+	UploadDate int64 `json:"upload_date"`
+	Tags       []struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	} `json:"tags"`
+}
+
+func (NhentaiSource) Name() string {
+	return "nhentai"
+}
+
+func (NhentaiSource) Match(url string) bool {
+	return nhentaiUrlRegexp.MatchString(url)
+}
+
+func (NhentaiSource) GalleryInfo(ctx context.Context, url string) (gallery Gallery, err error) {
+	match := nhentaiUrlRegexp.FindStringSubmatch(url)
+	if match == nil {
+		return gallery, errors.New("Not An nhentai Gallery Url")
+	}
+	code, resp, err := Get(ctx, "https://nhentai.net/api/gallery/"+match[1])
+	if err != nil {
+		return gallery, err
+	}
+	if code != 200 {
+		return gallery, errors.New(strconv.Itoa(code))
+	}
+	var info nhentaiGallery
+	if err = json.Unmarshal(resp, &info); err != nil {
+		return gallery, err
+	}
+	return NhentaiGallery(info)
+}
+
+func NhentaiGallery(info nhentaiGallery) (gallery Gallery, err error) {
+	if info.MediaId == "" {
+		return gallery, errors.New("No Media Id")
+	}
+	gallery.Id = info.Id.String()
+	gallery.Title = info.Title.English
+	if gallery.Title == "" {
+		gallery.Title = info.Title.Pretty
+	}
+	gallery.JpTitle = info.Title.Japanese
+	if info.UploadDate > 0 {
+		gallery.Date = time.Unix(info.UploadDate, 0).UTC().Format("2006-01-02 15:04:05-07")
+	}
+	for _, tag := range info.Tags {
+		switch tag.Type {
+		case "tag":
+			gallery.Tags = append(gallery.Tags, Tag{Tag: tag.Name})
+		case "artist":
+			gallery.Artists = append(gallery.Artists, tag.Name)
+		case "group":
+			gallery.Groups = append(gallery.Groups, tag.Name)
+		case "parody":
+			gallery.Parodys = append(gallery.Parodys, tag.Name)
+		case "character":
+			gallery.Characters = append(gallery.Characters, tag.Name)
+		case "language":
+			if tag.Name != "translated" && tag.Name != "rewrite" {
+				gallery.Lang = tag.Name
+			}
+		case "category":
+			gallery.Type = tag.Name
+		}
+	}
+	width := len(strconv.Itoa(len(info.Images.Pages)))
+	for i, page := range info.Images.Pages {
+		ext, ok := nhentaiExts[page.T]
+		if !ok {
+			return gallery, errors.New("Unknown Page Type: " + page.T)
+		}
+		n := strconv.Itoa(i + 1)
+		gallery.Files = append(gallery.Files, Image{
+			Name: strings.Repeat("0", width-len(n)) + n + ext,
+			Url:  "https://i.nhentai.net/galleries/" + info.MediaId + "/" + n + ext,
+		})
+	}
+	return gallery, nil
+}
+
+func (NhentaiSource) Referer(gallery Gallery) string {
+	return "https://nhentai.net/g/" + gallery.Id + "/"
+}
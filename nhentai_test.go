@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+const testNhentaiJson = `{"id":177013,"media_id":"987654","title":{"english":"Test Gallery [English]",` +
+	`"japanese":"テスト","pretty":"Test Gallery"},"images":{"pages":[` +
+	`{"t":"j","w":1280,"h":1800},{"t":"p","w":1280,"h":1800},{"t":"j"},{"t":"j"},{"t":"j"},` +
+	`{"t":"j"},{"t":"j"},{"t":"j"},{"t":"j"},{"t":"w"}]},"upload_date":1577836800,"tags":[` +
+	`{"id":1,"type":"tag","name":"glasses"},{"id":2,"type":"artist","name":"someone"},` +
+	`{"id":3,"type":"language","name":"translated"},{"id":4,"type":"language","name":"english"},` +
+	`{"id":5,"type":"category","name":"doujinshi"}],"num_pages":10}`
+
+func TestNhentaiGalleryInfo(t *testing.T) {
+	mockSite(t, map[string]http.HandlerFunc{
+		"/api/gallery/177013": serveString(testNhentaiJson),
+	})
+	url := "https://nhentai.net/g/177013/"
+	if source := SourceOf(url); source.Name() != "nhentai" {
+		t.Fatalf("SourceOf(%s) = %s", url, source.Name())
+	}
+	gallery, err := GalleryInfo(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gallery.Id != "177013" || gallery.Title != "Test Gallery [English]" || gallery.JpTitle != "テスト" {
+		t.Errorf("got %+v", gallery)
+	}
+	if gallery.Lang != "english" || gallery.Type != "doujinshi" || gallery.Date != "2020-01-01 00:00:00+00" {
+		t.Errorf("Lang, Type, Date = %s, %s, %s", gallery.Lang, gallery.Type, gallery.Date)
+	}
+	if len(gallery.Artists) != 1 || len(gallery.Tags) != 1 || gallery.Tags[0].Name() != "glasses" {
+		t.Errorf("Artists, Tags = %v, %+v", gallery.Artists, gallery.Tags)
+	}
+	if len(gallery.Files) != 10 {
+		t.Fatalf("%d pages, want 10", len(gallery.Files))
+	}
+	if img := gallery.Files[1]; img.Name != "02.png" || ImageUrl(img) != "https://i.nhentai.net/galleries/987654/2.png" {
+		t.Errorf("page 2 = %s %s", img.Name, ImageUrl(img))
+	}
+	if img := gallery.Files[9]; img.Name != "10.webp" {
+		t.Errorf("page 10 = %s", img.Name)
+	}
+	if referer := GallerySource(gallery).Referer(gallery); referer != "https://nhentai.net/g/177013/" {
+		t.Errorf("Referer = %s", referer)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type NotifyConf struct {
+	DiscordWebhook string
+	// Desktop shows a desktop notification when a batch finishes or a
+	// gallery fails
+	Desktop bool
+}
+
+// GalleryResult is what a notification about a finished or failed gallery
+// is made from.
+type GalleryResult struct {
+	Gallery  Gallery
+	SavePath string
+	Bytes    int64
+	// Cover is the file name of the first page, empty when unknown.
+	Cover string
+	// Err is why the gallery failed, empty when it succeeded.
+	Err string
+}
+
+var notifyWg sync.WaitGroup
+
+var notifyClient = &http.Client{Timeout: time.Minute}
+
+// NotifyGallery sends the notifications in the background, WaitNotify waits
+// for them before exiting.
+func NotifyGallery(result GalleryResult) {
+	event := WebhookEvent{
+		Event:   EventGalleryCompleted,
+		Gallery: NewWebhookGallery(result.Gallery, result.SavePath, result.Bytes),
+	}
+	if result.Err != "" {
+		event.Event = EventGalleryFailed
+		event.Error = result.Err
+	}
+	EmitEvent(event)
+	if result.Err != "" {
+		title := result.Gallery.Title
+		if title == "" {
+			title = result.Gallery.Url
+		}
+		DesktopNotify("Gallery Failed", title+": "+result.Err)
+	}
+	if conf.Notify.DiscordWebhook == "" {
+		return
+	}
+	notifyWg.Add(1)
+	go func() {
+		defer notifyWg.Done()
+		if err := PostDiscord(conf.Notify.DiscordWebhook, result); err != nil {
+			log.Println("Discord Notify Fail: " + result.Gallery.Title + " Because " + err.Error())
+		}
+	}()
+}
+
+func WaitNotify() {
+	notifyWg.Wait()
+}
+
+// PostDiscord posts an embed with the title, page count, size and a
+// thumbnail of the cover. The cover is attached as hitomi doesn't let
+// Discord load it from the image servers.
+func PostDiscord(webhook string, result GalleryResult) error {
+	gallery := result.Gallery
+	title := gallery.JpTitle
+	if title == "" {
+		title = gallery.Title
+	}
+	if title == "" {
+		title = gallery.Url
+	}
+	embed := map[string]interface{}{
+		"title": title,
+		"url":   gallery.Url,
+		"color": 0x2ecc71,
+	}
+	fields := []map[string]interface{}{
+		{"name": "Pages", "value": strconv.Itoa(len(gallery.Files)), "inline": true},
+	}
+	if result.Bytes > 0 {
+		fields = append(fields, map[string]interface{}{"name": "Size", "value": FormatBytes(float64(result.Bytes)), "inline": true})
+	}
+	if result.Err != "" {
+		embed["color"] = 0xe74c3c
+		embed["description"] = "Failed: " + result.Err
+	}
+	embed["fields"] = fields
+
+	var thumbnail []byte
+	if result.Cover != "" {
+		if content, err := storage.Read(result.SavePath + "/" + result.Cover); err == nil {
+			if img, _, err := image.Decode(bytes.NewReader(content)); err == nil {
+				thumbnail, _ = EncodeImage(Downscale(img, 0, 320), "jpeg", 80)
+			}
+		}
+	}
+	if thumbnail != nil {
+		embed["thumbnail"] = map[string]string{"url": "attachment://cover.jpg"}
+	}
+	payload, err := json.Marshal(map[string]interface{}{"embeds": []interface{}{embed}})
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if err = form.WriteField("payload_json", string(payload)); err != nil {
+		return err
+	}
+	if thumbnail != nil {
+		w, err := form.CreateFormFile("files[0]", "cover.jpg")
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(thumbnail); err != nil {
+			return err
+		}
+	}
+	if err = form.Close(); err != nil {
+		return err
+	}
+
+	for tries := 0; ; tries++ {
+		res, err := notifyClient.Post(webhook, form.FormDataContentType(), bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return err
+		}
+		data, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode/100 == 2 {
+			return nil
+		}
+		// rate limited, wait as long as Discord asks for once
+		if res.StatusCode == http.StatusTooManyRequests && tries == 0 {
+			var limit struct {
+				RetryAfter float64 `json:"retry_after"`
+			}
+			json.Unmarshal(data, &limit)
+			time.Sleep(time.Duration(limit.RetryAfter*1000+100) * time.Millisecond)
+			continue
+		}
+		return errors.New("Discord Status Code " + strconv.Itoa(res.StatusCode) + ": " + strings.TrimSpace(string(data)))
+	}
+}
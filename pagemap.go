@@ -0,0 +1,32 @@
+package main
+
+import "encoding/json"
+
+const pageMapFile = "pages.json"
+
+// PageMapEntry ties a page saved under its number by NumberPages to the file
+// it is on the site.
+type PageMapEntry struct {
+	Page     int    `json:"page"`
+	Name     string `json:"name"`
+	Original string `json:"original"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+func PageMap(gallery Gallery, conf Conf) []PageMapEntry {
+	entries := make([]PageMapEntry, 0, len(gallery.Files))
+	for _, img := range gallery.Files {
+		entries = append(entries, PageMapEntry{Page: img.Page, Name: ImageFileName(img, conf), Original: img.Name, Hash: img.Hash})
+	}
+	return entries
+}
+
+// SavePageMap writes the PageMap of gallery next to its pages, so the
+// original names can be restored or matched against other tools.
+func SavePageMap(gallery Gallery, savePath string, conf Conf) error {
+	data, err := json.MarshalIndent(PageMap(gallery, conf), "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.Write(savePath+"/"+pageMapFile, data)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNumberPagesSavesPageMap(t *testing.T) {
+	savePath := setupPipeline(t)
+	defer func(number bool) { conf.NumberPages = number }(conf.NumberPages)
+	conf.NumberPages = true
+
+	js := strings.NewReplacer(`"1234"`, `"6789"`, "Test Gallery", "Numbered Gallery").Replace(testGalleryJs)
+	mockSite(t, map[string]http.HandlerFunc{
+		"/galleries/6789.js":               serveString(js),
+		"/webp/e/d2/" + testHash + ".webp": serveString("RIFF\x10\x00\x00\x00WEBPVP8 page"),
+	})
+	RunJobs(UrlJobs([]string{"https://hitomi.la/galleries/6789.html"}))
+
+	dir, err := GalleryPath(Gallery{Id: "6789", Title: "Numbered Gallery", Type: "manga", Lang: "japanese"}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(savePath, dir, "001.webp")); err != nil {
+		t.Errorf("the page was not saved under its number: %v", err)
+	}
+	data, err := storage.Read(dir + "/" + pageMapFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []PageMapEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		t.Fatal(err)
+	}
+	want := PageMapEntry{Page: 1, Name: "001.webp", Original: "01.jpg", Hash: testHash}
+	if len(entries) != 1 || entries[0] != want {
+		t.Errorf("page map = %+v, want [%+v]", entries, want)
+	}
+}
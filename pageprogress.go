@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// progressFile lists the pages of a gallery saved so far, a json line each,
+// appended as they are done. Resuming trusts it instead of stat-ing and
+// hashing every file of a huge gallery again.
+const progressFile = ".progress"
+
+type ProgressEntry struct {
+	// Hash is the hash of the page on the site.
+	Hash string `json:"hash"`
+	ManifestFile
+}
+
+// GalleryProgress is the progressFile of a gallery being downloaded, nil
+// when the storage doesn't keep galleries in folders.
+type GalleryProgress struct {
+	fileName string
+	mode     os.FileMode
+	pages    map[string]ManifestFile
+	lock     sync.Mutex
+	file     *os.File
+	closed   bool
+}
+
+// ReadProgress reads the progressFile of the gallery at savePath. It is
+// started over when existing files are overwritten, like by repair.
+func ReadProgress(savePath string) (*GalleryProgress, error) {
+	local, ok := localStorage()
+	if !ok {
+		return nil, nil
+	}
+	p := &GalleryProgress{
+		fileName: filepath.Join(local.Root, filepath.FromSlash(savePath), progressFile),
+		mode:     local.Mode,
+		pages:    make(map[string]ManifestFile),
+	}
+	if overwriteExisting {
+		if err := os.Remove(p.fileName); err != nil && !os.IsNotExist(err) {
+			return p, err
+		}
+		return p, nil
+	}
+	data, err := ioutil.ReadFile(p.fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return p, nil
+		}
+		return p, err
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var entry ProgressEntry
+		// a line cut short by a crash is left out
+		if json.Unmarshal(line, &entry) == nil && entry.Hash != "" && entry.Name != "" {
+			p.pages[entry.Hash] = entry.ManifestFile
+		}
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		// the next entry starts on a line of its own
+		if err = p.append([]byte("\n")); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// Page is the saved file of the page with hash, if it is done.
+func (p *GalleryProgress) Page(hash string) (ManifestFile, bool) {
+	if p == nil {
+		return ManifestFile{}, false
+	}
+	file, ok := p.pages[hash]
+	return file, ok
+}
+
+// Add records the page with hash as done.
+func (p *GalleryProgress) Add(hash string, file ManifestFile) error {
+	if p == nil || hash == "" {
+		return nil
+	}
+	data, err := json.Marshal(ProgressEntry{Hash: hash, ManifestFile: file})
+	if err != nil {
+		return err
+	}
+	return p.append(append(data, '\n'))
+}
+
+func (p *GalleryProgress) append(data []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return nil
+	}
+	if p.file == nil {
+		if err := os.MkdirAll(filepath.Dir(p.fileName), 0755); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(p.fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, p.mode)
+		if err != nil {
+			return err
+		}
+		p.file = file
+	}
+	_, err := p.file.Write(data)
+	return err
+}
+
+// Close stops recording pages, the gallery folder may be moved or removed
+// afterwards.
+func (p *GalleryProgress) Close() error {
+	if p == nil {
+		return nil
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.closed = true
+	if p.file == nil {
+		return nil
+	}
+	err := p.file.Close()
+	p.file = nil
+	return err
+}
+
+// Remove deletes the progressFile once the manifest lists every page.
+func (p *GalleryProgress) Remove() error {
+	if p == nil {
+		return nil
+	}
+	p.Close()
+	if err := os.Remove(p.fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
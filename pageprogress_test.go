@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGalleryProgress(t *testing.T) {
+	savePath := setupPipeline(t)
+	page := `{"name":"01.jpg","hash":"` + testHash + `","haswebp":0,"hasavif":0,"width":10,"height":10}`
+	missing := `{"name":"02.jpg","hash":"` + strings.Repeat("0", 64) + `","haswebp":0,"hasavif":0,"width":10,"height":10}`
+	gallery := func(id string, title string, files string) string {
+		js := strings.NewReplacer(`"1234"`, `"`+id+`"`, "Test Gallery", title).Replace(testGalleryJs)
+		return js[:strings.Index(js, `"files":[`)] + `"files":[` + files + `]}`
+	}
+	var requests int64
+	mockSite(t, map[string]http.HandlerFunc{
+		"/galleries/8901.js": serveString(gallery("8901", "Resumed Gallery", page)),
+		"/galleries/8902.js": serveString(gallery("8902", "Unfinished Gallery", page+","+missing)),
+		"/images/e/d2/" + testHash + ".jpg": func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&requests, 1)
+			w.Write([]byte("\xff\xd8\xff\xe0 page"))
+		},
+	})
+
+	resumed, err := GalleryPath(Gallery{Id: "8901", Title: "Resumed Gallery", Type: "manga", Lang: "japanese"}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(savePath, resumed)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// the last line was cut short by a crash
+	entry := `{"hash":"` + testHash + `","name":"01.jpg","size":10,"sha256":"from progress"}` + "\n" + `{"hash":"`
+	if err = ioutil.WriteFile(filepath.Join(dir, progressFile), []byte(entry), 0644); err != nil {
+		t.Fatal(err)
+	}
+	RunJobs(UrlJobs([]string{"https://hitomi.la/galleries/8901.html"}))
+	if requests != 0 {
+		t.Errorf("a page listed in %s was downloaded again", progressFile)
+	}
+	manifest, err := ReadManifest(resumed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != 1 || manifest.Files[0].Sha256 != "from progress" {
+		t.Errorf("manifest files = %+v", manifest.Files)
+	}
+	if _, err = os.Stat(filepath.Join(dir, progressFile)); !os.IsNotExist(err) {
+		t.Errorf("%s left behind in a complete gallery", progressFile)
+	}
+
+	RunJobs(UrlJobs([]string{"https://hitomi.la/galleries/8902.html"}))
+	partial, err := GalleryPath(Gallery{Id: "8902", Title: "Unfinished Gallery", Type: "manga", Lang: "japanese"}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress, err := ReadProgress(partial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, ok := progress.Page(testHash)
+	if !ok || file.Name != "01.jpg" || file.Sha256 != Sha256Sum([]byte("\xff\xd8\xff\xe0 page")) {
+		t.Errorf("%s of the partial gallery has %+v for the saved page", progressFile, file)
+	}
+	if _, ok = progress.Page(strings.Repeat("0", 64)); ok {
+		t.Errorf("%s lists the failed page", progressFile)
+	}
+}
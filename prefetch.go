@@ -0,0 +1,54 @@
+package main
+
+import "context"
+
+// defaultMetadataThreads is how many gallery infos are fetched at once ahead
+// of the downloads.
+const defaultMetadataThreads = 4
+
+type PrefetchedGallery struct {
+	Job     JobSpec
+	Gallery Gallery
+	Err     error
+}
+
+// PrefetchGalleries fetches the gallery info of jobs with up to n requests
+// at once and hands them out in the order of jobs. At most n are fetched
+// ahead of the one read last, so a long list isn't held in memory.
+func PrefetchGalleries(ctx context.Context, jobs []JobSpec, n int) <-chan PrefetchedGallery {
+	if n < 1 {
+		n = 1
+	}
+	out := make(chan PrefetchedGallery)
+	window := make(chan struct{}, n)
+	results := make(chan chan PrefetchedGallery, n)
+	go func() {
+		defer close(results)
+		for _, job := range jobs {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			result := make(chan PrefetchedGallery, 1)
+			results <- result
+			go func(job JobSpec) {
+				gallery, err := GalleryInfo(ctx, job.Url)
+				result <- PrefetchedGallery{Job: job, Gallery: gallery, Err: err}
+			}(job)
+		}
+	}()
+	go func() {
+		defer close(out)
+		for result := range results {
+			fetched := <-result
+			select {
+			case out <- fetched:
+			case <-ctx.Done():
+				return
+			}
+			<-window
+		}
+	}()
+	return out
+}
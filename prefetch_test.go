@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrefetchGalleries(t *testing.T) {
+	var lock sync.Mutex
+	running, most := 0, 0
+	routes := map[string]http.HandlerFunc{}
+	var jobs []JobSpec
+	for id := 1; id <= 8; id++ {
+		js := strings.NewReplacer(`"1234"`, `"`+strconv.Itoa(id)+`"`).Replace(testGalleryJs)
+		delay := time.Duration(8-id) * 5 * time.Millisecond
+		routes["/galleries/"+strconv.Itoa(id)+".js"] = func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			running++
+			if running > most {
+				most = running
+			}
+			lock.Unlock()
+			// the later galleries answer first
+			time.Sleep(delay)
+			lock.Lock()
+			running--
+			lock.Unlock()
+			w.Write([]byte(js))
+		}
+		jobs = append(jobs, JobSpec{Url: GalleryUrl(strconv.Itoa(id))})
+	}
+	jobs = append(jobs, JobSpec{Url: GalleryUrl("404")})
+	mockSite(t, routes)
+
+	i := 0
+	for fetched := range PrefetchGalleries(context.Background(), jobs, 3) {
+		if fetched.Job.Url != jobs[i].Url {
+			t.Fatalf("got %s at %d, want %s", fetched.Job.Url, i, jobs[i].Url)
+		}
+		if i < 8 && (fetched.Err != nil || fetched.Gallery.Id != strconv.Itoa(i+1)) {
+			t.Errorf("%s: gallery %q, %v", fetched.Job.Url, fetched.Gallery.Id, fetched.Err)
+		}
+		if i == 8 && fetched.Err == nil {
+			t.Error("no error for a missing gallery")
+		}
+		i++
+	}
+	if i != len(jobs) {
+		t.Errorf("%d galleries handed out, want %d", i, len(jobs))
+	}
+	if most < 2 || most > 3 {
+		t.Errorf("%d requests at once, want 2 to 3", most)
+	}
+}
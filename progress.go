@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Transfer is an image body being downloaded.
+type Transfer struct {
+	Name  string
+	Total int64
+	Start time.Time
+	done  int64
+}
+
+var (
+	transfersLock sync.Mutex
+	transfers     = map[*Transfer]struct{}{}
+)
+
+// StartTransfer registers a download of total bytes, -1 when unknown, until
+// Finish.
+func StartTransfer(name string, total int64) *Transfer {
+	t := &Transfer{Name: name, Total: total, Start: time.Now()}
+	transfersLock.Lock()
+	transfers[t] = struct{}{}
+	transfersLock.Unlock()
+	return t
+}
+
+func (t *Transfer) Finish() {
+	transfersLock.Lock()
+	delete(transfers, t)
+	transfersLock.Unlock()
+}
+
+func (t *Transfer) Done() int64 {
+	return atomic.LoadInt64(&t.done)
+}
+
+func (t *Transfer) Reader(r io.Reader) io.Reader {
+	return transferReader{r, t}
+}
+
+type transferReader struct {
+	r io.Reader
+	t *Transfer
+}
+
+func (r transferReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(&r.t.done, int64(n))
+	return n, err
+}
+
+// Transfers returns the running downloads, oldest first.
+func Transfers() []*Transfer {
+	transfersLock.Lock()
+	list := make([]*Transfer, 0, len(transfers))
+	for t := range transfers {
+		list = append(list, t)
+	}
+	transfersLock.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Start.Before(list[j].Start)
+	})
+	return list
+}
+
+// ActiveGallery is the gallery being downloaded.
+type ActiveGallery struct {
+	Title   string
+	Pages   int
+	Task    *GalleryTask
+	cancel  context.CancelFunc
+	skipped int32
+}
+
+var (
+	activeLock    sync.Mutex
+	activeGallery *ActiveGallery
+)
+
+func SetActiveGallery(active *ActiveGallery) {
+	activeLock.Lock()
+	activeGallery = active
+	activeLock.Unlock()
+}
+
+func ClearActiveGallery(active *ActiveGallery) {
+	activeLock.Lock()
+	if activeGallery == active {
+		activeGallery = nil
+	}
+	activeLock.Unlock()
+}
+
+func Active() *ActiveGallery {
+	activeLock.Lock()
+	defer activeLock.Unlock()
+	return activeGallery
+}
+
+// SkipActive stops the gallery being downloaded, the next one starts.
+func SkipActive() bool {
+	active := Active()
+	if active == nil {
+		return false
+	}
+	atomic.StoreInt32(&active.skipped, 1)
+	active.cancel()
+	return true
+}
+
+func (a *ActiveGallery) Skipped() bool {
+	return atomic.LoadInt32(&a.skipped) == 1
+}
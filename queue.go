@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"sort"
+)
+
+var priorityFlag = flag.String("priority", "", "priority of the urls given to add: high, normal or low")
+
+// PriorityRank orders the priorities "high", "normal" (or empty) and "low".
+func PriorityRank(priority string) int {
+	switch priority {
+	case "high":
+		return 1
+	case "low":
+		return -1
+	}
+	return 0
+}
+
+func ValidPriority(priority string) bool {
+	switch priority {
+	case "", "high", "normal", "low":
+		return true
+	}
+	return false
+}
+
+// SortJobs orders jobs by priority, keeping the order of equal ones.
+func SortJobs(jobs []JobSpec) {
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return PriorityRank(jobs[i].Priority) > PriorityRank(jobs[j].Priority)
+	})
+}
+
+type PendingJob struct {
+	Id  int     `json:"id"`
+	Job JobSpec `json:"job"`
+}
+
+// JobQueue is the priority queue of the daemon: higher priorities first,
+// then in the order added. It is not safe for concurrent use.
+type JobQueue struct {
+	items  []PendingJob
+	nextId int
+}
+
+func (q *JobQueue) Push(job JobSpec) PendingJob {
+	q.nextId++
+	item := PendingJob{Id: q.nextId, Job: job}
+	q.insert(item)
+	return item
+}
+
+// insert puts item behind the last one of the same or a higher priority.
+func (q *JobQueue) insert(item PendingJob) {
+	rank := PriorityRank(item.Job.Priority)
+	i := sort.Search(len(q.items), func(i int) bool {
+		return PriorityRank(q.items[i].Job.Priority) < rank
+	})
+	q.insertAt(i, item)
+}
+
+func (q *JobQueue) insertAt(i int, item PendingJob) {
+	q.items = append(q.items, PendingJob{})
+	copy(q.items[i+1:], q.items[i:])
+	q.items[i] = item
+}
+
+func (q *JobQueue) Pop() (PendingJob, bool) {
+	if len(q.items) == 0 {
+		return PendingJob{}, false
+	}
+	item := q.items[0]
+	q.items = q.items[1:]
+	return item, true
+}
+
+func (q *JobQueue) Remove(id int) (PendingJob, bool) {
+	for i, item := range q.items {
+		if item.Id == id {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			return item, true
+		}
+	}
+	return PendingJob{}, false
+}
+
+func (q *JobQueue) Find(id int) (PendingJob, bool) {
+	for _, item := range q.items {
+		if item.Id == id {
+			return item, true
+		}
+	}
+	return PendingJob{}, false
+}
+
+// SetPriority moves the job behind the others of its new priority.
+func (q *JobQueue) SetPriority(id int, priority string) bool {
+	item, ok := q.Remove(id)
+	if !ok {
+		return false
+	}
+	item.Job.Priority = priority
+	q.insert(item)
+	return true
+}
+
+// Move puts the job at position, taking the priority of the job it lands
+// in front of so the queue stays in priority order.
+func (q *JobQueue) Move(id int, position int) bool {
+	item, ok := q.Remove(id)
+	if !ok {
+		return false
+	}
+	if position < 0 {
+		position = 0
+	}
+	if position > len(q.items) {
+		position = len(q.items)
+	}
+	if position < len(q.items) {
+		item.Job.Priority = q.items[position].Job.Priority
+	} else if position > 0 {
+		item.Job.Priority = q.items[position-1].Job.Priority
+	}
+	q.insertAt(position, item)
+	return true
+}
+
+func (q *JobQueue) Len() int {
+	return len(q.items)
+}
+
+func (q *JobQueue) Items() []PendingJob {
+	return append([]PendingJob{}, q.items...)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequestLimiter holds requests back to the RequestsPerSecond of their host.
+// Hosts matched by the same key share one bucket, so "*.hitomi.la" limits
+// all image servers together.
+type RequestLimiter struct {
+	lock    sync.Mutex
+	buckets map[string]*requestBucket
+}
+
+type requestBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// burst is one second of requests, but at least one so a rate below one
+// request per second still lets a request through at once.
+func (b *requestBucket) burst() float64 {
+	if b.rate < 1 {
+		return 1
+	}
+	return b.rate
+}
+
+var requestLimiter = &RequestLimiter{buckets: map[string]*requestBucket{}}
+
+// HostRate finds the key of RequestsPerSecond applying to host: the host
+// itself, else the longest matching "*.domain", else "*".
+func HostRate(rates map[string]float64, host string) (string, float64) {
+	host = strings.ToLower(host)
+	if rate, ok := rates[host]; ok {
+		return host, rate
+	}
+	for domain := host; ; {
+		i := strings.Index(domain, ".")
+		if i < 0 {
+			break
+		}
+		domain = domain[i+1:]
+		if rate, ok := rates["*."+domain]; ok {
+			return "*." + domain, rate
+		}
+	}
+	if rate, ok := rates["*"]; ok {
+		return "*", rate
+	}
+	return "", 0
+}
+
+// Wait takes a request to host from its bucket, sleeping while it is in
+// debt.
+func (l *RequestLimiter) Wait(ctx context.Context, host string) error {
+	key, rate := HostRate(conf.RequestsPerSecond, host)
+	if rate <= 0 {
+		return nil
+	}
+	l.lock.Lock()
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok || b.rate != rate {
+		b = &requestBucket{rate: rate, last: now}
+		b.tokens = b.burst()
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst() {
+		b.tokens = b.burst()
+	}
+	b.last = now
+	b.tokens--
+	wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	l.lock.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitUrl is Wait for the host of rawUrl.
+func (l *RequestLimiter) WaitUrl(ctx context.Context, rawUrl string) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil
+	}
+	return l.Wait(ctx, u.Hostname())
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHostRate(t *testing.T) {
+	rates := map[string]float64{"ltn.hitomi.la": 2, "*.hitomi.la": 10, "*": 20}
+	for host, want := range map[string]string{
+		"ltn.hitomi.la":  "ltn.hitomi.la",
+		"aa.hitomi.la":   "*.hitomi.la",
+		"w1.a.hitomi.la": "*.hitomi.la",
+		"nhentai.net":    "*",
+	} {
+		if key, _ := HostRate(rates, host); key != want {
+			t.Errorf("HostRate(%q) = %q, want %q", host, key, want)
+		}
+	}
+	if key, rate := HostRate(map[string]float64{"ltn.hitomi.la": 2}, "aa.hitomi.la"); key != "" || rate != 0 {
+		t.Errorf("unlisted host limited by %q at %v", key, rate)
+	}
+}
+
+func TestRequestLimiter(t *testing.T) {
+	saved := conf.RequestsPerSecond
+	defer func() { conf.RequestsPerSecond = saved }()
+	conf.RequestsPerSecond = map[string]float64{"*.hitomi.la": 20}
+	limiter := &RequestLimiter{buckets: map[string]*requestBucket{}}
+
+	start := time.Now()
+	for i := 0; i < 25; i++ {
+		host := "aa.hitomi.la"
+		if i%2 == 1 {
+			host = "ba.hitomi.la"
+		}
+		if err := limiter.Wait(context.Background(), host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first 20 are the burst, the 5 after it share the bucket at 20/s
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("25 requests took %v, want at least 250ms", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.Wait(ctx, "aa.hitomi.la"); err == nil {
+		t.Error("Wait in debt ignored the canceled context")
+	}
+	if err := limiter.Wait(ctx, "nhentai.net"); err != nil {
+		t.Errorf("unlimited host: %v", err)
+	}
+}
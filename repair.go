@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync/atomic"
+)
+
+// Repair verifies every gallery and downloads only the pages which are
+// missing or corrupt, with fresh gallery info in case the hashes changed.
+func Repair() {
+	broken, err := VerifyAll()
+	if err != nil {
+		CommonError(err)
+	}
+	overwriteExisting = true
+	*refreshMetadata = true
+	for i, result := range broken {
+		if Interrupted() {
+			break
+		}
+		url := result.Manifest.Url
+		if url == "" && result.Manifest.Id != "" {
+			url = GalleryUrl(result.Manifest.Id)
+		}
+		if url == "" {
+			log.Println("Repair Fail: " + result.Dir + " Because No Url In Manifest")
+			continue
+		}
+		gallery, err := GalleryInfo(appCtx, url)
+		if err != nil {
+			log.Println("Read Gallery Info Fail: " + url + " Because " + err.Error())
+			atomic.AddInt64(&summary.MetadataFailed, 1)
+			RecordFailure(Failure{Url: url, Id: result.Manifest.Id, Title: result.Manifest.Title, Reason: "Read Gallery Info Fail: " + err.Error()})
+			continue
+		}
+		gallery.Url = url
+
+		// keep the gallery where it is, whatever the layout says now
+		galleryConf := conf
+		galleryConf.PathTemplate = "{{" + strconv.Quote(result.Dir) + "}}"
+		PrepareFiles(gallery.Files, galleryConf)
+		gallery.Files = RepairFiles(gallery.Files, result, galleryConf)
+		if len(gallery.Files) == 0 {
+			log.Println("Repair Fail: " + result.Dir + " Because The Broken Pages Are Not In The Gallery Anymore")
+			continue
+		}
+		fmt.Println()
+		log.Println("Repair " + strconv.Itoa(len(gallery.Files)) + " Pages: " + result.Dir)
+		DownloadGallery(gallery, i, len(broken), galleryConf)
+	}
+}
+
+// RepairFiles returns the pages which are missing, corrupt or were never
+// downloaded.
+func RepairFiles(files []Image, result VerifyResult, conf Conf) []Image {
+	broken := make(map[string]bool)
+	for _, name := range result.Missing {
+		broken[name] = true
+	}
+	for _, name := range result.Corrupt {
+		broken[name] = true
+	}
+	listed := make(map[string]bool)
+	for _, file := range result.Manifest.Files {
+		listed[file.Name] = true
+	}
+	var repair []Image
+	for _, img := range files {
+		name := ImageFileName(img, conf)
+		if broken[name] || !listed[name] {
+			repair = append(repair, img)
+		}
+	}
+	return repair
+}
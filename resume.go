@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const queueFile = "queue.json"
+
+var freshFlag = flag.Bool("fresh", false, "ignore "+queueFile+" left by an interrupted run and start over")
+
+// SavedJob is a job of queue.json. Remaining lists the hashes of the pages
+// still missing when the gallery was interrupted, empty for all.
+type SavedJob struct {
+	JobSpec
+	Remaining []string `json:"remaining,omitempty"`
+}
+
+// unfinished are the jobs of the running batch which are not done yet, kept
+// in queue.json together with the queue of the daemon so a crash or reboot
+// can continue where it stopped.
+var unfinished struct {
+	lock sync.Mutex
+	jobs []SavedJob
+}
+
+func StartBatch(jobs []JobSpec) {
+	unfinished.lock.Lock()
+	for _, job := range jobs {
+		unfinished.jobs = append(unfinished.jobs, SavedJob{JobSpec: job})
+	}
+	unfinished.lock.Unlock()
+	SaveQueueLogged()
+}
+
+// FinishJob takes the job of url off queue.json and lets it be queued
+// again.
+func FinishJob(url string) {
+	ReleaseGallery(url)
+	unfinished.lock.Lock()
+	for i, job := range unfinished.jobs {
+		if job.Url == url {
+			unfinished.jobs = append(unfinished.jobs[:i], unfinished.jobs[i+1:]...)
+			break
+		}
+	}
+	unfinished.lock.Unlock()
+	SaveQueueLogged()
+}
+
+// InterruptJob records the pages of url which are still missing.
+func InterruptJob(url string, remaining []string) {
+	unfinished.lock.Lock()
+	for i, job := range unfinished.jobs {
+		if job.Url == url {
+			unfinished.jobs[i].Remaining = remaining
+			break
+		}
+	}
+	unfinished.lock.Unlock()
+	SaveQueueLogged()
+}
+
+// SaveQueue writes the unfinished and pending jobs to queue.json, or removes
+// it when there are none.
+func SaveQueue() error {
+	unfinished.lock.Lock()
+	jobs := append([]SavedJob{}, unfinished.jobs...)
+	unfinished.lock.Unlock()
+	if daemon != nil {
+		daemon.lock.Lock()
+		for _, item := range daemon.pending.Items() {
+			jobs = append(jobs, SavedJob{JobSpec: item.Job})
+		}
+		daemon.lock.Unlock()
+	}
+	if len(jobs) == 0 {
+		if err := os.Remove(queueFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(jobs, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := queueFile + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, queueFile)
+}
+
+func SaveQueueLogged() {
+	if err := SaveQueue(); err != nil {
+		log.Println("Save " + queueFile + " Fail: " + err.Error())
+	}
+}
+
+// LoadQueue returns the jobs left in queue.json, nil when there is none or
+// --fresh is set. Interrupted galleries are limited to their missing pages.
+func LoadQueue() ([]JobSpec, error) {
+	if *freshFlag {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(queueFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var saved []SavedJob
+	if err = json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+	jobs := make([]JobSpec, 0, len(saved))
+	for _, job := range saved {
+		jobs = append(jobs, job.JobSpec)
+		if len(job.Remaining) == 0 {
+			continue
+		}
+		if retryImages == nil {
+			retryImages = make(map[string]map[string]struct{})
+		}
+		hashes := make(map[string]struct{})
+		for _, hash := range job.Remaining {
+			hashes[hash] = struct{}{}
+		}
+		retryImages[job.Url] = hashes
+	}
+	if len(jobs) > 0 {
+		log.Println("Resuming " + strconv.Itoa(len(jobs)) + " Jobs From " + queueFile + " (--fresh To Start Over)")
+	}
+	return jobs, nil
+}
+
+// RemainingPages are the hashes of the pages of gallery task has not saved.
+func RemainingPages(gallery Gallery, task *GalleryTask, conf Conf) []string {
+	task.lock.Lock()
+	done := make(map[string]bool, len(task.files))
+	for _, file := range task.files {
+		done[file.Name] = true
+	}
+	task.lock.Unlock()
+	var remaining []string
+	for _, img := range gallery.Files {
+		if !done[ImageFileName(img, conf)] {
+			remaining = append(remaining, img.Hash)
+		}
+	}
+	return remaining
+}
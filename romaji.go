@@ -0,0 +1,110 @@
+package main
+
+import "strings"
+
+var kanaRomaji = map[rune]string{
+	'あ': "a", 'い': "i", 'う': "u", 'え': "e", 'お': "o",
+	'か': "ka", 'き': "ki", 'く': "ku", 'け': "ke", 'こ': "ko",
+	'が': "ga", 'ぎ': "gi", 'ぐ': "gu", 'げ': "ge", 'ご': "go",
+	'さ': "sa", 'し': "shi", 'す': "su", 'せ': "se", 'そ': "so",
+	'ざ': "za", 'じ': "ji", 'ず': "zu", 'ぜ': "ze", 'ぞ': "zo",
+	'た': "ta", 'ち': "chi", 'つ': "tsu", 'て': "te", 'と': "to",
+	'だ': "da", 'ぢ': "ji", 'づ': "zu", 'で': "de", 'ど': "do",
+	'な': "na", 'に': "ni", 'ぬ': "nu", 'ね': "ne", 'の': "no",
+	'は': "ha", 'ひ': "hi", 'ふ': "fu", 'へ': "he", 'ほ': "ho",
+	'ば': "ba", 'び': "bi", 'ぶ': "bu", 'べ': "be", 'ぼ': "bo",
+	'ぱ': "pa", 'ぴ': "pi", 'ぷ': "pu", 'ぺ': "pe", 'ぽ': "po",
+	'ま': "ma", 'み': "mi", 'む': "mu", 'め': "me", 'も': "mo",
+	'や': "ya", 'ゆ': "yu", 'よ': "yo",
+	'ら': "ra", 'り': "ri", 'る': "ru", 'れ': "re", 'ろ': "ro",
+	'わ': "wa", 'ゐ': "i", 'ゑ': "e", 'を': "o", 'ん': "n",
+	'ゔ': "vu", 'ゕ': "ka", 'ゖ': "ke",
+	'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o",
+	'ゃ': "ya", 'ゅ': "yu", 'ょ': "yo", 'ゎ': "wa",
+}
+
+var kanaPunctuation = map[rune]string{
+	'、': ", ", '。': ". ", '・': " ", '「': "\"", '」': "\"", '『': "\"", '』': "\"",
+	'【': "[", '】': "]", '〜': "~", '　': " ",
+}
+
+// hiragana turns katakana into the matching hiragana.
+func hiragana(r rune) rune {
+	if r >= 'ァ' && r <= 'ヶ' {
+		return r - 0x60
+	}
+	return r
+}
+
+func isVowel(b byte) bool {
+	return strings.IndexByte("aeiou", b) >= 0
+}
+
+// Romaji transliterates the kana of s to Hepburn romaji. Kanji are kept as
+// they are, full width letters become ASCII.
+func Romaji(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	double := false
+	for i := 0; i < len(runes); i++ {
+		r := hiragana(runes[i])
+		if r == 'っ' {
+			double = true
+			continue
+		}
+		if r == 'ー' {
+			out := b.String()
+			if len(out) > 0 && isVowel(out[len(out)-1]) {
+				b.WriteByte(out[len(out)-1])
+			}
+			continue
+		}
+		syllable, ok := kanaRomaji[r]
+		if !ok {
+			double = false
+			if p, ok := kanaPunctuation[r]; ok {
+				b.WriteString(p)
+			} else if r >= '！' && r <= '～' {
+				b.WriteRune(r - '！' + '!')
+			} else {
+				b.WriteRune(r)
+			}
+			continue
+		}
+		if i+1 < len(runes) {
+			switch next := hiragana(runes[i+1]); next {
+			case 'ゃ', 'ゅ', 'ょ':
+				if len(syllable) > 1 && strings.HasSuffix(syllable, "i") {
+					base := strings.TrimSuffix(syllable, "i")
+					vowel := kanaRomaji[next][1:]
+					if strings.HasSuffix(base, "sh") || strings.HasSuffix(base, "ch") || base == "j" {
+						syllable = base + vowel
+					} else {
+						syllable = base + "y" + vowel
+					}
+					i++
+				}
+			case 'ぁ', 'ぃ', 'ぅ', 'ぇ', 'ぉ':
+				if len(syllable) > 1 {
+					syllable = syllable[:len(syllable)-1] + kanaRomaji[next]
+					i++
+				}
+			}
+		}
+		if double {
+			if strings.HasPrefix(syllable, "ch") {
+				b.WriteByte('t')
+			} else if !isVowel(syllable[0]) && syllable != "n" {
+				b.WriteByte(syllable[0])
+			}
+			double = false
+		}
+		if r == 'ん' && i+1 < len(runes) {
+			if next, ok := kanaRomaji[hiragana(runes[i+1])]; ok && (isVowel(next[0]) || next[0] == 'y') {
+				syllable = "n'"
+			}
+		}
+		b.WriteString(syllable)
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type S3Conf struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool
+	// Archive packs each gallery as "zip", "epub", "tar" or "tar.zst" below
+	// SavePath and uploads the archive instead of the loose images.
+	Archive string
+}
+
+type S3Storage struct {
+	Conf S3Conf
+}
+
+var s3Client = &http.Client{Timeout: 5 * time.Minute}
+
+func (s *S3Storage) Write(name string, content []byte) error {
+	return s.put(name, bytes.NewReader(content), int64(len(content)), Sha256Hex(content))
+}
+
+// WriteSized uploads r without hashing it first, as S3 allows over https.
+func (s *S3Storage) WriteSized(name string, r io.Reader, size int64) error {
+	return s.put(name, r, size, "UNSIGNED-PAYLOAD")
+}
+
+func (s *S3Storage) put(name string, body io.Reader, size int64, payloadHash string) error {
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	res, err := s.Send("PUT", name, body, size, payloadHash, contentType)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return errors.New("S3 Status Code " + strconv.Itoa(res.StatusCode) + ": " + strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (s *S3Storage) Exists(name string) bool {
+	_, err := s.Stat(name)
+	return err == nil
+}
+
+func (s *S3Storage) Stat(name string) (os.FileInfo, error) {
+	res, err := s.Do("HEAD", name, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode == 404 {
+		return nil, os.ErrNotExist
+	}
+	if res.StatusCode != 200 {
+		return nil, errors.New("S3 Status Code " + strconv.Itoa(res.StatusCode))
+	}
+	modTime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	return remoteFileInfo{name: path.Base(name), size: res.ContentLength, modTime: modTime}, nil
+}
+
+func (s *S3Storage) Read(name string) ([]byte, error) {
+	res, err := s.Do("GET", name, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return nil, os.ErrNotExist
+	}
+	if res.StatusCode != 200 {
+		return nil, errors.New("S3 Status Code " + strconv.Itoa(res.StatusCode))
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+func (s *S3Storage) Finalize(dir string) error {
+	return nil
+}
+
+func (s *S3Storage) Do(method string, name string, content []byte, contentType string) (*http.Response, error) {
+	return s.Send(method, name, bytes.NewReader(content), int64(len(content)), Sha256Hex(content), contentType)
+}
+
+func (s *S3Storage) Send(method string, name string, body io.Reader, size int64, payloadHash string, contentType string) (*http.Response, error) {
+	endpoint, err := url.Parse(s.Conf.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.TrimLeft(s.Conf.Prefix+name, "/")
+
+	u := *endpoint
+	if s.Conf.PathStyle {
+		u.Path = "/" + s.Conf.Bucket + "/" + key
+	} else {
+		u.Host = s.Conf.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = S3EscapePath(u.Path)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.Sign(req, payloadHash, time.Now().UTC())
+	return s3Client.Do(req)
+}
+
+func (s *S3Storage) Sign(req *http.Request, payloadHash string, now time.Time) {
+	region := s.Conf.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		signedHeaders = "content-type;" + signedHeaders
+		canonicalHeaders = "content-type:" + contentType + "\n" + canonicalHeaders
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		S3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + Sha256Hex([]byte(canonicalRequest))
+
+	key := HmacSha256([]byte("AWS4"+s.Conf.SecretKey), date)
+	key = HmacSha256(key, region)
+	key = HmacSha256(key, "s3")
+	key = HmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(HmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.Conf.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func S3EscapePath(p string) string {
+	return s3Escape(p, true)
+}
+
+// S3CanonicalQuery is the query sorted and escaped for signing, a key
+// without a value ends with "=".
+func S3CanonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(key, false)+"="+s3Escape(value, false))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func s3Escape(p string, keepSlash bool) string {
+	var buf strings.Builder
+	for _, b := range []byte(p) {
+		if b == '/' && keepSlash || b == '-' || b == '_' || b == '.' || b == '~' ||
+			('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9') {
+			buf.WriteByte(b)
+		} else {
+			buf.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{b})))
+		}
+	}
+	return buf.String()
+}
+
+func Sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func HmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// archivePather is implemented by the storages writing a gallery into a
+// single archive file.
+type archivePather interface {
+	archivePath(dir string) string
+}
+
+// S3ArchiveStorage packs each gallery into an archive below Root with
+// Archives, like Storage "zip" or "tar" does, and uploads the archive to
+// Remote once the gallery is finalized. The archive of a gallery saved
+// before is fetched back first, so it can be resumed or repaired.
+type S3ArchiveStorage struct {
+	Archives Storage
+	Remote   *S3Storage
+	Root     string
+	lock     sync.Mutex
+	fetched  map[string]bool
+}
+
+func (s *S3ArchiveStorage) dir(name string) string {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// key is the name of the archive of dir in the bucket.
+func (s *S3ArchiveStorage) key(dir string) (string, error) {
+	rel, err := filepath.Rel(s.Root, s.Archives.(archivePather).archivePath(dir))
+	return filepath.ToSlash(rel), err
+}
+
+// fetch downloads the archive of dir unless it is below Root already, from
+// an upload which failed or an earlier call.
+func (s *S3ArchiveStorage) fetch(dir string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.fetched[dir] {
+		return nil
+	}
+	fileName := s.Archives.(archivePather).archivePath(dir)
+	if _, err := os.Stat(fileName); err == nil {
+		s.fetched[dir] = true
+		return nil
+	}
+	key, err := s.key(dir)
+	if err != nil {
+		return err
+	}
+	res, err := s.Remote.Do("GET", key, nil, "")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		s.fetched[dir] = true
+		return nil
+	}
+	if res.StatusCode != 200 {
+		return errors.New("S3 Status Code " + strconv.Itoa(res.StatusCode))
+	}
+	if err = os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(fileName + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, res.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), fileName)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	s.fetched[dir] = true
+	return nil
+}
+
+func (s *S3ArchiveStorage) Write(name string, content []byte) error {
+	dir := s.dir(name)
+	if err := s.fetch(dir); err != nil {
+		return err
+	}
+	return s.Archives.Write(name, content)
+}
+
+func (s *S3ArchiveStorage) WriteSized(name string, r io.Reader, size int64) error {
+	dir := s.dir(name)
+	if err := s.fetch(dir); err != nil {
+		return err
+	}
+	switch w := s.Archives.(type) {
+	case SizedWriter:
+		return w.WriteSized(name, r, size)
+	case StreamWriter:
+		_, err := w.WriteStream(name, r)
+		return err
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return s.Archives.Write(name, content)
+}
+
+func (s *S3ArchiveStorage) Exists(name string) bool {
+	_, err := s.Stat(name)
+	return err == nil
+}
+
+func (s *S3ArchiveStorage) Stat(name string) (os.FileInfo, error) {
+	dir := s.dir(name)
+	if err := s.fetch(dir); err != nil {
+		return nil, err
+	}
+	return s.Archives.Stat(name)
+}
+
+func (s *S3ArchiveStorage) Read(name string) ([]byte, error) {
+	dir := s.dir(name)
+	if err := s.fetch(dir); err != nil {
+		return nil, err
+	}
+	return s.Archives.Read(name)
+}
+
+func (s *S3ArchiveStorage) Describe(dir string, gallery Gallery, pages []string) {
+	if describer, ok := s.Archives.(Describer); ok {
+		describer.Describe(dir, gallery, pages)
+	}
+}
+
+// Finalize closes the archive of dir and uploads it, it is removed below
+// Root once it is in the bucket.
+func (s *S3ArchiveStorage) Finalize(dir string) error {
+	if err := s.Archives.Finalize(dir); err != nil {
+		return err
+	}
+	fileName := s.Archives.(archivePather).archivePath(dir)
+	f, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err == nil {
+		var key string
+		if key, err = s.key(dir); err == nil {
+			err = s.Remote.WriteSized(key, f, info.Size())
+		}
+	}
+	f.Close()
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.fetched, dir)
+	return os.Remove(fileName)
+}
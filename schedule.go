@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// ScheduleConf holds cron expressions ("minute hour day month weekday") for
+// serve mode.
+type ScheduleConf struct {
+	// Sync is when to sync the subscriptions, e.g. "0 3 * * *"
+	Sync string
+	// Run matches the minutes in which queued galleries are started, e.g.
+	// "* 2-6 * * *" for 02:00 to 07:00, empty for always
+	Run string
+	// Speed lowers or raises MaxSpeed while its When matches, the first
+	// matching rule wins
+	Speed []SpeedRule
+}
+
+type SpeedRule struct {
+	When string
+	// MaxSpeed in KiB/s, 0 for no limit
+	MaxSpeed int
+}
+
+func ParseCron(expr string) (cron.Schedule, error) {
+	return cron.ParseStandard(expr)
+}
+
+// CronMatches tells if t falls in a minute matched by schedule.
+func CronMatches(schedule cron.Schedule, t time.Time) bool {
+	minute := t.Truncate(time.Minute)
+	return schedule.Next(minute.Add(-time.Second)).Equal(minute)
+}
+
+// Bandwidth caps the bytes per second of all image and video downloads
+// together.
+type Bandwidth struct {
+	lock   sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+var bandwidth = &Bandwidth{}
+
+// SetRate sets the cap in bytes per second, 0 for no limit.
+func (b *Bandwidth) SetRate(rate int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.rate = rate
+	b.tokens = 0
+	b.last = time.Now()
+}
+
+func (b *Bandwidth) Rate() int64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.rate
+}
+
+// Wait takes n bytes from the bucket, sleeping while it is in debt.
+func (b *Bandwidth) Wait(ctx context.Context, n int) error {
+	b.lock.Lock()
+	if b.rate <= 0 {
+		b.lock.Unlock()
+		return nil
+	}
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * float64(b.rate)
+	if b.tokens > float64(b.rate) {
+		b.tokens = float64(b.rate)
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	wait := time.Duration(-b.tokens / float64(b.rate) * float64(time.Second))
+	b.lock.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type limitedBody struct {
+	ctx  context.Context
+	body io.ReadCloser
+}
+
+func (l limitedBody) Read(p []byte) (int, error) {
+	n, err := l.body.Read(p)
+	if n > 0 {
+		if waitErr := bandwidth.Wait(l.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+func (l limitedBody) Close() error {
+	return l.body.Close()
+}
+
+// ScheduledSpeed is the MaxSpeed in KiB/s at t.
+func ScheduledSpeed(rules []SpeedRule, schedules []cron.Schedule, t time.Time) int {
+	for i, schedule := range schedules {
+		if CronMatches(schedule, t) {
+			return rules[i].MaxSpeed
+		}
+	}
+	return conf.MaxSpeed
+}
+
+// RunSpeedSchedule applies the Speed rules every minute.
+func RunSpeedSchedule(rules []SpeedRule) {
+	schedules := make([]cron.Schedule, len(rules))
+	for i, rule := range rules {
+		schedules[i], _ = ParseCron(rule.When)
+	}
+	speed := -1
+	for {
+		if next := ScheduledSpeed(rules, schedules, time.Now()); next != speed {
+			speed = next
+			bandwidth.SetRate(int64(speed) * 1024)
+			if speed > 0 {
+				log.Println("Speed Limit Set To " + strconv.Itoa(speed) + " KiB/s")
+			} else {
+				log.Println("Speed Limit Off")
+			}
+		}
+		time.Sleep(time.Until(time.Now().Truncate(time.Minute).Add(time.Minute)))
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxRangeIds is the most galleries one id range may expand to, to catch
+// typos like 1800000-18001000.
+const maxRangeIds = 100000
+
+func IsSearchUrl(u string) bool {
+	return strings.Contains(u, "hitomi.la/search.html?")
+}
+
+// ParseIdRange parses "1234567", "id:1234567" and ranges like
+// "1800000-1800100" or "id:1800000-id:1800100" into the first and last id.
+func ParseIdRange(s string) (int, int, bool) {
+	from, to := strings.TrimSpace(s), ""
+	if i := strings.Index(from, "-"); i >= 0 {
+		from, to = strings.TrimSpace(from[:i]), strings.TrimSpace(from[i+1:])
+	}
+	first, err := strconv.Atoi(strings.TrimPrefix(from, "id:"))
+	if err != nil || first < 1 {
+		return 0, 0, false
+	}
+	if to == "" {
+		if strings.Contains(s, "-") {
+			return 0, 0, false
+		}
+		return first, first, true
+	}
+	last, err := strconv.Atoi(strings.TrimPrefix(to, "id:"))
+	if err != nil || last < 1 {
+		return 0, 0, false
+	}
+	return first, last, true
+}
+
+// ExpandUrls replaces search urls with the gallery urls of their results and
+// id ranges with the gallery urls of every id in them.
+func ExpandUrls(urls []string) []string {
+	expanded := make([]string, 0, len(urls))
+	for _, u := range urls {
+		if first, last, ok := ParseIdRange(u); ok {
+			if last < first {
+				first, last = last, first
+			}
+			if last-first >= maxRangeIds {
+				log.Println("Expand Range Fail: " + u + " Because It Has More Than " + strconv.Itoa(maxRangeIds) + " Ids")
+				RecordFailure(Failure{Url: u, Reason: "Range Too Large"})
+				continue
+			}
+			if last > first {
+				log.Println("Range Expanded To " + strconv.Itoa(last-first+1) + " Galleries: " + u)
+			}
+			for id := first; id <= last; id++ {
+				expanded = append(expanded, GalleryUrl(strconv.Itoa(id)))
+			}
+			continue
+		}
+		if !IsSearchUrl(u) {
+			expanded = append(expanded, u)
+			continue
+		}
+		ids, err := SearchIds(u)
+		if err != nil {
+			log.Println("Search Fail: " + u + " Because " + err.Error())
+			RecordFailure(Failure{Url: u, Reason: "Search Fail: " + err.Error()})
+			continue
+		}
+		log.Println("Search Found " + strconv.Itoa(len(ids)) + " Galleries: " + u)
+		for _, id := range ids {
+			expanded = append(expanded, GalleryUrl(strconv.Itoa(id)))
+		}
+	}
+	return Unique(expanded)
+}
+
+// SearchIds resolves a search url the way the site's search.js does: every
+// term is a nozomi feed, positive terms are intersected and negative terms
+// ("-tag:x") are subtracted.
+func SearchIds(searchUrl string) ([]int, error) {
+	query := searchUrl[strings.Index(searchUrl, "?")+1:]
+	query = strings.SplitN(query, "#", 2)[0]
+	query, err := url.QueryUnescape(query)
+	if err != nil {
+		return nil, err
+	}
+	var positive, negative []string
+	for _, term := range strings.Fields(strings.ToLower(query)) {
+		term = strings.ReplaceAll(term, "_", " ")
+		if strings.HasPrefix(term, "-") {
+			negative = append(negative, term[1:])
+		} else {
+			positive = append(positive, term)
+		}
+	}
+	if len(positive) == 0 {
+		positive = append(positive, "language:all")
+	}
+
+	var result []int
+	for i, term := range positive {
+		ids, err := SearchTermIds(term)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			result = ids
+			continue
+		}
+		keep := make(map[int]struct{}, len(ids))
+		for _, id := range ids {
+			keep[id] = struct{}{}
+		}
+		result = filterIds(result, keep, true)
+	}
+	for _, term := range negative {
+		ids, err := SearchTermIds(term)
+		if err != nil {
+			return nil, err
+		}
+		drop := make(map[int]struct{}, len(ids))
+		for _, id := range ids {
+			drop[id] = struct{}{}
+		}
+		result = filterIds(result, drop, false)
+	}
+	return result, nil
+}
+
+func SearchTermIds(term string) ([]int, error) {
+	sides := strings.SplitN(term, ":", 2)
+	if len(sides) != 2 {
+		return nil, errors.New("Free Text Search Term Not Supported: " + term)
+	}
+	ns, tag := sides[0], sides[1]
+	switch ns {
+	case "female", "male":
+		return NozomiIds(appCtx, "tag/"+term+"-all")
+	case "language":
+		return NozomiIds(appCtx, "index-"+tag)
+	}
+	return NozomiIds(appCtx, ns+"/"+tag+"-all")
+}
+
+func filterIds(ids []int, set map[int]struct{}, keep bool) []int {
+	filtered := ids[:0:0]
+	for _, id := range ids {
+		if _, ok := set[id]; ok == keep {
+			filtered = append(filtered, id)
+		}
+	}
+	return filtered
+}
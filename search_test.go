@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseIdRange(t *testing.T) {
+	for s, want := range map[string][3]int{
+		"1234567":                               {1234567, 1234567, 1},
+		"id:1234567":                            {1234567, 1234567, 1},
+		"1800000-1800002":                       {1800000, 1800002, 1},
+		"id:1800000 - id:1800002":               {1800000, 1800002, 1},
+		"1800000-":                              {0, 0, 0},
+		"id:abc":                                {0, 0, 0},
+		"https://hitomi.la/galleries/1234.html": {0, 0, 0},
+		"female:glasses":                        {0, 0, 0},
+	} {
+		first, last, ok := ParseIdRange(s)
+		got := [3]int{first, last, 0}
+		if ok {
+			got[2] = 1
+		}
+		if got != want {
+			t.Errorf("ParseIdRange(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestExpandUrlsRanges(t *testing.T) {
+	got := ExpandUrls([]string{"id:12", "11-13", "https://hitomi.la/galleries/20.html"})
+	want := []string{
+		"https://hitomi.la/galleries/12.html",
+		"https://hitomi.la/galleries/11.html",
+		"https://hitomi.la/galleries/13.html",
+		"https://hitomi.la/galleries/20.html",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExpandUrls = %v, want %v", got, want)
+	}
+}
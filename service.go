@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"path/filepath"
+)
+
+// serviceName is what the Windows service is registered as.
+const serviceName = "hitomi"
+
+var serviceDir = flag.String("service-dir", "", "the working directory of the Windows service, set by service install")
+
+// ParseServiceCommand checks the args of "service" and returns the command:
+// install, remove, start or stop.
+func ParseServiceCommand(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("Usage: service install|remove|start|stop")
+	}
+	switch args[0] {
+	case "install", "remove", "start", "stop":
+		return args[0], nil
+	}
+	return "", errors.New("Unknown Service Command: " + args[0] + ", Use install, remove, start Or stop")
+}
+
+// ServiceArgs are the arguments the service is installed with, so it runs
+// "serve" in dir with config, when one is given.
+func ServiceArgs(dir string, config string) ([]string, error) {
+	args := []string{"--service-dir", dir}
+	if config != "" {
+		abs, err := filepath.Abs(config)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--config", abs)
+	}
+	return append(args, "serve"), nil
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "errors"
+
+func ServiceCommand(args []string) error {
+	return errors.New("Windows Services Need Windows, Use install-service For systemd")
+}
+
+// StartAsService does nothing outside of Windows.
+func StartAsService() {}
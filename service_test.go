@@ -0,0 +1,40 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseServiceCommand(t *testing.T) {
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"install"}, "install"},
+		{[]string{"remove"}, "remove"},
+		{[]string{"start", "extra"}, "start"},
+		{[]string{"stop"}, "stop"},
+		{nil, ""},
+		{[]string{"restart"}, ""},
+		{[]string{"Install"}, ""},
+	} {
+		got, err := ParseServiceCommand(c.args)
+		if got != c.want || (err == nil) != (c.want != "") {
+			t.Errorf("ParseServiceCommand(%q) = %q, %v, want %q", c.args, got, err, c.want)
+		}
+	}
+}
+
+func TestServiceArgs(t *testing.T) {
+	dir := t.TempDir()
+	args, err := ServiceArgs(dir, "")
+	if err != nil || strings.Join(args, " ") != "--service-dir "+dir+" serve" {
+		t.Errorf("without config: %q, %v", args, err)
+	}
+	config, _ := filepath.Abs("config.yaml")
+	args, err = ServiceArgs(dir, "config.yaml")
+	if err != nil || strings.Join(args, " ") != "--service-dir "+dir+" --config "+config+" serve" {
+		t.Errorf("with config: %q, %v", args, err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceStopTimeout is how long a stopping service waits for the gallery
+// being downloaded before Windows is told it stopped.
+const serviceStopTimeout = 20 * time.Second
+
+// ServiceCommand runs "service install|remove|start|stop" against the
+// service manager, which needs an administrator prompt.
+func ServiceCommand(args []string) error {
+	command, err := ParseServiceCommand(args)
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if command == "install" {
+		return installService(m)
+	}
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return errors.New("Service " + serviceName + " Not Installed: " + err.Error())
+	}
+	defer s.Close()
+	switch command {
+	case "remove":
+		err = s.Delete()
+	case "start":
+		err = s.Start()
+	case "stop":
+		_, err = s.Control(svc.Stop)
+	}
+	if err == nil {
+		log.Println("Service " + serviceName + ": " + command + " Done")
+	}
+	return err
+}
+
+// installService registers this program to run "serve" at boot in the
+// working directory with its config, restarting it a minute after it fails.
+func installService(m *mgr.Mgr) error {
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return errors.New("Service " + serviceName + " Exists Already")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	args, err := ServiceArgs(dir, *configFlag)
+	if err != nil {
+		return err
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "hitomi downloader",
+		Description: "Downloads the galleries added to it, see " + dir,
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err = s.SetRecoveryActions([]mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: time.Minute}}, 24*60*60); err != nil {
+		log.Println("Set Service Recovery Fail: " + err.Error())
+	}
+	log.Println("Installed Service " + serviceName + ", Start It With: hitomi.exe service start")
+	return nil
+}
+
+// StartAsService hands the program to the service manager when Windows
+// started it as a service, the run itself goes on as usual.
+func StartAsService() {
+	if ok, err := svc.IsWindowsService(); err != nil || !ok {
+		return
+	}
+	if *serviceDir != "" {
+		if err := os.Chdir(*serviceDir); err != nil {
+			log.Println("Change To " + *serviceDir + " Fail: " + err.Error())
+		}
+	}
+	go func() {
+		if err := svc.Run(serviceName, windowsService{}); err != nil {
+			log.Println("Run Service Fail: " + err.Error())
+		}
+	}()
+}
+
+type windowsService struct{}
+
+func (windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for request := range requests {
+		switch request.Cmd {
+		case svc.Interrogate:
+			status <- request.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending, WaitHint: uint32(serviceStopTimeout / time.Millisecond)}
+			log.Println("Service Stopping")
+			stopApp()
+			stopped := make(chan struct{})
+			go func() {
+				if daemon != nil {
+					daemon.Wait()
+				}
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-time.After(serviceStopTimeout):
+			}
+			return false, 0
+		}
+	}
+	return false, 0
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type SFTPConf struct {
+	Host       string
+	User       string
+	Password   string
+	KeyFile    string
+	KnownHosts string
+	// InsecureIgnoreHostKey connects without checking the host key.
+	InsecureIgnoreHostKey bool
+	Path                  string
+}
+
+type SFTPStorage struct {
+	Conf   SFTPConf
+	client *sftp.Client
+	lock   sync.Mutex
+}
+
+func (s *SFTPStorage) Connect() (*sftp.Client, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+	var auth []ssh.AuthMethod
+	if s.Conf.KeyFile != "" {
+		key, err := ioutil.ReadFile(s.Conf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if s.Conf.Password != "" {
+		auth = append(auth, ssh.Password(s.Conf.Password))
+	}
+	hostKeyCallback, err := HostKeyCallback(s.Conf)
+	if err != nil {
+		return nil, err
+	}
+	sshConn, err := ssh.Dial("tcp", s.Conf.Host, &ssh.ClientConfig{
+		User:            s.Conf.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(sshConn)
+	if err != nil {
+		sshConn.Close()
+		return nil, err
+	}
+	s.client = client
+	return client, nil
+}
+
+// HostKeyCallback checks the host key against KnownHosts, or
+// ~/.ssh/known_hosts when it is not set. Only InsecureIgnoreHostKey skips the
+// check.
+func HostKeyCallback(conf SFTPConf) (ssh.HostKeyCallback, error) {
+	if conf.InsecureIgnoreHostKey {
+		log.Println("Warning: SFTP InsecureIgnoreHostKey Is Set, The Host Key Of " + conf.Host + " Is Not Checked")
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	fileName := conf.KnownHosts
+	if fileName == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, errors.New("SFTP KnownHosts Is Not Set And There Is No Home Directory")
+		}
+		fileName = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(fileName)
+	if err != nil {
+		return nil, errors.New("Read SFTP KnownHosts Fail: " + err.Error())
+	}
+	return callback, nil
+}
+
+// Reset drops a broken connection so the next call reconnects.
+func (s *SFTPStorage) Reset(client *sftp.Client, err error) {
+	if _, ok := err.(*sftp.StatusError); ok || err == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.client == client {
+		s.client.Close()
+		s.client = nil
+	}
+}
+
+// Write uploads to "<name>.tmp" and renames it into place once complete.
+// A leftover temp file from an interrupted run is resumed instead of re-sent.
+func (s *SFTPStorage) Write(name string, content []byte) error {
+	client, err := s.Connect()
+	if err != nil {
+		return err
+	}
+	err = s.upload(client, path.Join(s.Conf.Path, name), bytes.NewReader(content), int64(len(content)))
+	s.Reset(client, err)
+	return err
+}
+
+// WriteSized uploads r, resuming an interrupted upload when r can seek like
+// an *os.File.
+func (s *SFTPStorage) WriteSized(name string, r io.Reader, size int64) error {
+	client, err := s.Connect()
+	if err != nil {
+		return err
+	}
+	err = s.upload(client, path.Join(s.Conf.Path, name), r, size)
+	s.Reset(client, err)
+	return err
+}
+
+func (s *SFTPStorage) upload(client *sftp.Client, target string, content io.Reader, size int64) error {
+	tmp := target + ".tmp"
+	if err := client.MkdirAll(path.Dir(target)); err != nil {
+		return err
+	}
+
+	var offset int64
+	seeker, resumable := content.(io.Seeker)
+	if info, err := client.Stat(tmp); err == nil && resumable && info.Size() <= size {
+		offset = info.Size()
+	}
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	f, err := client.OpenFile(tmp, flags)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err == nil && offset > 0 {
+		_, err = seeker.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		_, err = io.Copy(f, content)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = client.PosixRename(tmp, target); err != nil {
+		_ = client.Remove(target)
+		return client.Rename(tmp, target)
+	}
+	return nil
+}
+
+func (s *SFTPStorage) Exists(name string) bool {
+	_, err := s.Stat(name)
+	return err == nil
+}
+
+func (s *SFTPStorage) Stat(name string) (os.FileInfo, error) {
+	client, err := s.Connect()
+	if err != nil {
+		return nil, err
+	}
+	info, err := client.Stat(path.Join(s.Conf.Path, name))
+	if !os.IsNotExist(err) {
+		s.Reset(client, err)
+	}
+	return info, err
+}
+
+func (s *SFTPStorage) Read(name string) ([]byte, error) {
+	client, err := s.Connect()
+	if err != nil {
+		return nil, err
+	}
+	f, err := client.Open(path.Join(s.Conf.Path, name))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.Reset(client, err)
+		}
+		return nil, err
+	}
+	defer f.Close()
+	content, err := ioutil.ReadAll(f)
+	s.Reset(client, err)
+	return content, err
+}
+
+func (s *SFTPStorage) Finalize(dir string) error {
+	return nil
+}
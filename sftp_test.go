@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestHostKeyCallback(t *testing.T) {
+	newKey := func() ssh.PublicKey {
+		public, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := ssh.NewPublicKey(public)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	known, other := newKey(), newKey()
+	dir := t.TempDir()
+	knownHosts := filepath.Join(dir, "known_hosts")
+	if err := ioutil.WriteFile(knownHosts, []byte(knownhosts.Line([]string{"nas.local:2222"}, known)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", dir)
+	addr := &net.TCPAddr{IP: net.IPv4(192, 168, 1, 2), Port: 2222}
+
+	for _, c := range []struct {
+		name string
+		conf SFTPConf
+		key  ssh.PublicKey
+		// fails is where it fails: "" for nowhere, "load" or "check"
+		fails string
+	}{
+		{"known key", SFTPConf{KnownHosts: knownHosts}, known, ""},
+		{"other key", SFTPConf{KnownHosts: knownHosts}, other, "check"},
+		{"missing known_hosts", SFTPConf{KnownHosts: filepath.Join(dir, "missing")}, known, "load"},
+		{"no known_hosts in home", SFTPConf{}, known, "load"},
+		{"insecure", SFTPConf{InsecureIgnoreHostKey: true}, other, ""},
+	} {
+		callback, err := HostKeyCallback(c.conf)
+		if (err != nil) != (c.fails == "load") {
+			t.Errorf("%s: loading = %v", c.name, err)
+		}
+		if err != nil {
+			continue
+		}
+		err = callback("nas.local:2222", addr, c.key)
+		if (err != nil) != (c.fails == "check") {
+			t.Errorf("%s: checking the host key = %v", c.name, err)
+		}
+	}
+}
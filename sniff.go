@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+// sniffLen is how much of a body is looked at before it is accepted.
+const sniffLen = 512
+
+// NotImageError is a 200 response which isn't an image, like the html page
+// served for a removed file.
+type NotImageError string
+
+func (e NotImageError) Error() string {
+	return "Not An Image: " + string(e)
+}
+
+var imageMagics = [][]byte{
+	{0xff, 0xd8, 0xff},
+	[]byte("\x89PNG\r\n\x1a\n"),
+	[]byte("GIF87a"),
+	[]byte("GIF89a"),
+}
+
+// IsImageMagic tells if head starts like a jpg, png, gif, webp or avif.
+func IsImageMagic(head []byte) bool {
+	for _, magic := range imageMagics {
+		if bytes.HasPrefix(head, magic) {
+			return true
+		}
+	}
+	if len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP")) {
+		return true
+	}
+	// avif and the other ISO media files start with their ftyp box
+	return len(head) >= 8 && bytes.Equal(head[4:8], []byte("ftyp"))
+}
+
+// IsAvif tells if head starts with the ftyp box of an avif image.
+func IsAvif(head []byte) bool {
+	if len(head) < 12 || !bytes.Equal(head[4:8], []byte("ftyp")) {
+		return false
+	}
+	brand := string(head[8:12])
+	return brand == "avif" || brand == "avis"
+}
+
+// CheckImageBody rejects a response by its Content-Type and the first bytes
+// of its body when it is empty or text. Bodies starting like an image are
+// always accepted, unknown binary ones too.
+func CheckImageBody(contentType string, head []byte) error {
+	if len(head) == 0 {
+		return NotImageError("Empty Body")
+	}
+	if IsImageMagic(head) {
+		return nil
+	}
+	contentType = strings.ToLower(contentType)
+	if strings.HasPrefix(contentType, "text/") || strings.Contains(contentType, "html") || strings.Contains(contentType, "json") {
+		return NotImageError(strings.SplitN(contentType, ";", 2)[0])
+	}
+	if sniffed := http.DetectContentType(head); strings.HasPrefix(sniffed, "text/") {
+		return NotImageError(strings.SplitN(sniffed, ";", 2)[0] + " Body")
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestCheckImageBody(t *testing.T) {
+	for _, c := range []struct {
+		contentType string
+		head        string
+		ok          bool
+	}{
+		{"image/jpeg", "\xff\xd8\xff\xe0\x00\x10JFIF", true},
+		{"image/webp", "RIFF\x10\x00\x00\x00WEBPVP8 ", true},
+		{"image/avif", "\x00\x00\x00\x1cftypavif", true},
+		{"application/octet-stream", "\x89PNG\r\n\x1a\n", true},
+		// a wrong Content-Type on a real image doesn't matter
+		{"text/plain", "GIF89a", true},
+		{"application/octet-stream", "\x00\x01\x02\x03 some new format", true},
+		{"image/webp", "", false},
+		{"text/html; charset=utf-8", "<!DOCTYPE html><title>404</title>", false},
+		{"image/webp", "<html><body>removed</body></html>", false},
+		{"application/json", `{"error": "not found"}`, false},
+	} {
+		if err := CheckImageBody(c.contentType, []byte(c.head)); (err == nil) != c.ok {
+			t.Errorf("CheckImageBody(%q, %q) = %v", c.contentType, c.head, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// Source is a site galleries are downloaded from. It turns the urls it
+// matches into the gallery with the urls of its pages, the download
+// pipeline is the same for every source.
+type Source interface {
+	Name() string
+	// Match tells if url belongs to the source.
+	Match(url string) bool
+	// GalleryInfo reads the gallery at url. Its pages must have their Url
+	// set, only hitomi builds it from the hash when downloading.
+	GalleryInfo(ctx context.Context, url string) (Gallery, error)
+	// Referer is sent with the page requests of gallery.
+	Referer(gallery Gallery) string
+}
+
+// sources are the registered sources besides hitomi, which gets every url
+// none of them matches.
+var sources []Source
+
+var hitomi Source = HitomiSource{}
+
+func RegisterSource(source Source) {
+	sources = append(sources, source)
+}
+
+func SourceOf(url string) Source {
+	for _, source := range sources {
+		if source.Match(url) {
+			return source
+		}
+	}
+	return hitomi
+}
+
+// GallerySource is the source gallery was read from.
+func GallerySource(gallery Gallery) Source {
+	for _, source := range sources {
+		if source.Name() == gallery.Source {
+			return source
+		}
+	}
+	return hitomi
+}
+
+// GalleryInfo reads the gallery at url from the source it belongs to.
+func GalleryInfo(ctx context.Context, url string) (gallery Gallery, err error) {
+	source := SourceOf(url)
+	if gallery, err = source.GalleryInfo(ctx, url); err != nil {
+		return gallery, err
+	}
+	gallery.Source = source.Name()
+	gallery.PageCount = len(gallery.Files)
+	for i := range gallery.Files {
+		gallery.Files[i].Page = i + 1
+		gallery.Files[i].Pages = len(gallery.Files)
+	}
+	TranslateTags(&gallery)
+	return gallery, nil
+}
+
+type HitomiSource struct{}
+
+func (HitomiSource) Name() string {
+	return "hitomi"
+}
+
+func (HitomiSource) Match(url string) bool {
+	return true
+}
+
+// GalleryInfo reads the galleries js of url, or the galleryblock when that
+// fails.
+func (HitomiSource) GalleryInfo(ctx context.Context, url string) (Gallery, error) {
+	id := GalleryId(url)
+	gallery, err := GalleryJsInfo(ctx, id)
+	if err == nil {
+		return gallery, nil
+	}
+	if ctx.Err() != nil || *offlineMetadata != "" {
+		return gallery, err
+	}
+	block, blockErr := GalleryBlockInfo(ctx, id)
+	if blockErr != nil {
+		return gallery, err
+	}
+	log.Println("Read Gallery Info Fail: " + url + " Because " + err.Error() + ", Using Galleryblock Instead")
+	return block, nil
+}
+
+func (HitomiSource) Referer(gallery Gallery) string {
+	return "https://hitomi.la/reader/" + gallery.Id + ".html"
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestGalleryInfoDispatch(t *testing.T) {
+	mockSite(t, map[string]http.HandlerFunc{
+		"/galleries/1234.js":  serveString(testGalleryJs),
+		"/api/gallery/177013": serveString(testNhentaiJson),
+	})
+	for _, c := range []struct {
+		url    string
+		source string
+		id     string
+		pages  int
+	}{
+		{"https://hitomi.la/galleries/1234.html", "hitomi", "1234", 1},
+		{"https://hitomi.la/reader/1234.html#3", "hitomi", "1234", 1},
+		{"https://hitomi.la/doujinshi/test-gallery-japanese-1234.html?page=2", "hitomi", "1234", 1},
+		{"https://nhentai.net/g/177013/", "nhentai", "177013", 10},
+		{"http://nhentai.net/g/177013", "nhentai", "177013", 10},
+		{"https://nhentai.net/g/177013/4/", "nhentai", "177013", 10},
+	} {
+		if source := SourceOf(c.url); source.Name() != c.source {
+			t.Errorf("SourceOf(%s) = %s, want %s", c.url, source.Name(), c.source)
+			continue
+		}
+		gallery, err := GalleryInfo(context.Background(), c.url)
+		if err != nil {
+			t.Errorf("GalleryInfo(%s): %v", c.url, err)
+			continue
+		}
+		if gallery.Source != c.source || gallery.Id != c.id || gallery.PageCount != c.pages {
+			t.Errorf("GalleryInfo(%s) = %s %s with %d pages, want %s %s with %d", c.url, gallery.Source, gallery.Id, gallery.PageCount, c.source, c.id, c.pages)
+		}
+		if last := gallery.Files[len(gallery.Files)-1]; last.Page != c.pages || last.Pages != c.pages {
+			t.Errorf("GalleryInfo(%s) numbers its last page %d/%d", c.url, last.Page, last.Pages)
+		}
+	}
+}
+
+func TestGallerySource(t *testing.T) {
+	for source, want := range map[string]string{
+		"":        "hitomi",
+		"hitomi":  "hitomi",
+		"nhentai": "nhentai",
+		"unknown": "hitomi",
+	} {
+		if got := GallerySource(Gallery{Source: source}).Name(); got != want {
+			t.Errorf("GallerySource(%q) = %s, want %s", source, got, want)
+		}
+	}
+}
+
+func TestEHentaiUrls(t *testing.T) {
+	for _, c := range []struct {
+		url   string
+		gid   string
+		token string
+	}{
+		{"https://e-hentai.org/g/12/abcdef0123/", "12", "abcdef0123"},
+		{"https://exhentai.org/g/1234567/0123456789/?p=1", "1234567", "0123456789"},
+		{"https://e-hentai.org/g/12/abcdef012/", "", ""},
+		{"http://e-hentai.org/g/12/abcdef0123/", "", ""},
+		{"https://e-hentai.org/s/abcdef0123/12-1", "", ""},
+	} {
+		gid, token := "", ""
+		if match := ehentaiGalleryRegexp.FindStringSubmatch(c.url); match != nil {
+			gid, token = match[1], match[2]
+		}
+		if gid != c.gid || token != c.token {
+			t.Errorf("gallery %s = %q %q, want %q %q", c.url, gid, token, c.gid, c.token)
+		}
+	}
+
+	for url, want := range map[string]string{
+		"https://e-hentai.org/s/1111111111/12-1":   "1",
+		"https://exhentai.org/s/2222222222/12-345": "345",
+		"https://e-hentai.org/s/33333/12-2":        "",
+		"https://e-hentai.org/g/12/abcdef0123/":    "",
+	} {
+		page := ""
+		if match := ehentaiViewerRegexp.FindStringSubmatch(url); match != nil {
+			page = match[1]
+		}
+		if page != want {
+			t.Errorf("viewer %s = page %q, want %q", url, page, want)
+		}
+	}
+}
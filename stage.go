@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// incompleteDir is where StageIncomplete downloads galleries, below the
+// storage root, until all their pages are saved.
+const incompleteDir = ".incomplete"
+
+// StagedPath is where the gallery of savePath is downloaded to. With
+// StageIncomplete that is below incompleteDir, unless the storage can't move
+// galleries or the gallery is in the library already, it is updated in place
+// then.
+func StagedPath(savePath string, conf Conf) string {
+	if !conf.StageIncomplete {
+		return savePath
+	}
+	mover, ok := storage.(GalleryRemover)
+	if !ok || mover.HasGallery(savePath) {
+		return savePath
+	}
+	return incompleteDir + "/" + savePath
+}
+
+// Unstage moves the staged gallery to savePath once the manifest lists every
+// page of it.
+func Unstage(staged string, savePath string, manifest Manifest) (bool, error) {
+	if staged == savePath || len(manifest.Files) < manifest.Pages {
+		return false, nil
+	}
+	if err := storage.(GalleryRemover).MoveGallery(staged, savePath); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// skipHidden tells filepath.Walk to leave out the staged and quarantined
+// galleries below root, they are not part of the library.
+func skipHidden(root string, path string, info os.FileInfo) error {
+	if info.IsDir() && path != root && strings.HasPrefix(info.Name(), ".") && filepath.Dir(path) == filepath.Clean(root) {
+		return filepath.SkipDir
+	}
+	return nil
+}
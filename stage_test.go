@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStageIncomplete(t *testing.T) {
+	savePath := setupPipeline(t)
+	defer func(stage bool) { conf.StageIncomplete = stage }(conf.StageIncomplete)
+	conf.StageIncomplete = true
+
+	page := `{"name":"01.jpg","hash":"` + testHash + `","haswebp":0,"hasavif":0,"width":10,"height":10}`
+	missing := `{"name":"02.jpg","hash":"` + strings.Repeat("0", 64) + `","haswebp":0,"hasavif":0,"width":10,"height":10}`
+	gallery := func(id string, title string, files string) string {
+		js := strings.NewReplacer(`"1234"`, `"`+id+`"`, "Test Gallery", title).Replace(testGalleryJs)
+		return js[:strings.Index(js, `"files":[`)] + `"files":[` + files + `]}`
+	}
+	mockSite(t, map[string]http.HandlerFunc{
+		"/galleries/4567.js":                serveString(gallery("4567", "Complete Gallery", page)),
+		"/galleries/5678.js":                serveString(gallery("5678", "Partial Gallery", page+","+missing)),
+		"/images/e/d2/" + testHash + ".jpg": serveString("\xff\xd8\xff\xe0 page"),
+	})
+	RunJobs(UrlJobs([]string{"https://hitomi.la/galleries/4567.html", "https://hitomi.la/galleries/5678.html"}))
+
+	complete, err := GalleryPath(Gallery{Id: "4567", Title: "Complete Gallery", Type: "manga", Lang: "japanese"}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(savePath, complete, "01.jpg")); err != nil {
+		t.Errorf("the complete gallery was not moved into the library: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(savePath, incompleteDir, complete)); !os.IsNotExist(err) {
+		t.Error("the complete gallery is still staged")
+	}
+
+	partial, err := GalleryPath(Gallery{Id: "5678", Title: "Partial Gallery", Type: "manga", Lang: "japanese"}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(savePath, partial)); !os.IsNotExist(err) {
+		t.Error("the partial gallery is in the library")
+	}
+	if _, err = os.Stat(filepath.Join(savePath, incompleteDir, partial, "01.jpg")); err != nil {
+		t.Errorf("the partial gallery was not kept staged: %v", err)
+	}
+	dirs, err := storage.(*LocalStorage).Galleries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		if strings.HasPrefix(dir, incompleteDir) {
+			t.Errorf("Galleries lists the staged %s", dir)
+		}
+	}
+}
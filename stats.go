@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// statsTop is how many artists, tags and galleries are listed.
+const statsTop = 10
+
+type StatsGroup struct {
+	Name      string `json:"name"`
+	Galleries int    `json:"galleries"`
+	Bytes     int64  `json:"bytes"`
+}
+
+type StatsGallery struct {
+	Dir   string `json:"dir"`
+	Title string `json:"title"`
+	Pages int    `json:"pages"`
+	Bytes int64  `json:"bytes"`
+}
+
+type Stats struct {
+	Galleries  int            `json:"galleries"`
+	Files      int            `json:"files"`
+	Bytes      int64          `json:"bytes"`
+	Languages  []StatsGroup   `json:"languages"`
+	Artists    []StatsGroup   `json:"artists"`
+	Tags       []StatsGroup   `json:"tags"`
+	Largest    []StatsGallery `json:"largest"`
+	Unreadable int            `json:"unreadable"`
+}
+
+// CollectStats reads the manifest of every saved gallery. Galleries saved
+// before manifests listed the tags fall back to their metadata.json.
+func CollectStats() (Stats, error) {
+	var stats Stats
+	lister, ok := storage.(Lister)
+	if !ok {
+		return stats, errors.New("Stats Needs Storage local, zip Or tar")
+	}
+	dirs, err := lister.Galleries()
+	if err != nil {
+		return stats, err
+	}
+	languages := make(map[string]*StatsGroup)
+	artists := make(map[string]*StatsGroup)
+	tags := make(map[string]*StatsGroup)
+	add := func(groups map[string]*StatsGroup, name string, bytes int64) {
+		group, ok := groups[name]
+		if !ok {
+			group = &StatsGroup{Name: name}
+			groups[name] = group
+		}
+		group.Galleries++
+		group.Bytes += bytes
+	}
+	var galleries []StatsGallery
+	for _, dir := range dirs {
+		manifest, err := ReadManifest(dir)
+		if err != nil {
+			log.Println("Read Manifest Fail: " + dir + " Because " + err.Error())
+			stats.Unreadable++
+			continue
+		}
+		if manifest.Language == "" {
+			fillFromMetadata(dir, &manifest)
+		}
+		var bytes int64
+		for _, file := range manifest.Files {
+			bytes += file.Size
+		}
+		stats.Galleries++
+		stats.Files += len(manifest.Files)
+		stats.Bytes += bytes
+		language := manifest.Language
+		if language == "" {
+			language = "unknown"
+		}
+		add(languages, language, bytes)
+		for _, artist := range manifest.Artists {
+			add(artists, artist, bytes)
+		}
+		for _, tag := range manifest.Tags {
+			add(tags, tag, bytes)
+		}
+		galleries = append(galleries, StatsGallery{Dir: dir, Title: manifest.Title, Pages: manifest.Pages, Bytes: bytes})
+	}
+	stats.Languages = sortedGroups(languages, 0)
+	stats.Artists = sortedGroups(artists, statsTop)
+	stats.Tags = sortedGroups(tags, statsTop)
+	sort.Slice(galleries, func(i, j int) bool {
+		return galleries[i].Bytes > galleries[j].Bytes
+	})
+	if len(galleries) > statsTop {
+		galleries = galleries[:statsTop]
+	}
+	stats.Largest = galleries
+	return stats, nil
+}
+
+func fillFromMetadata(dir string, manifest *Manifest) {
+	data, err := storage.Read(dir + "/metadata.json")
+	if err != nil {
+		return
+	}
+	var gallery Gallery
+	if json.Unmarshal(data, &gallery) != nil {
+		return
+	}
+	manifest.Language = gallery.Lang
+	manifest.Artists = gallery.Artists
+	manifest.Tags = TagNames(gallery.Tags)
+}
+
+// sortedGroups orders groups by gallery count, then size, keeping the top
+// n, all when n is 0.
+func sortedGroups(groups map[string]*StatsGroup, n int) []StatsGroup {
+	list := make([]StatsGroup, 0, len(groups))
+	for _, group := range groups {
+		list = append(list, *group)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Galleries != list[j].Galleries {
+			return list[i].Galleries > list[j].Galleries
+		}
+		if list[i].Bytes != list[j].Bytes {
+			return list[i].Bytes > list[j].Bytes
+		}
+		return list[i].Name < list[j].Name
+	})
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+func PrintStats() error {
+	stats, err := CollectStats()
+	if err != nil {
+		return err
+	}
+	if *jsonFlag {
+		data, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	fmt.Println("Galleries: " + strconv.Itoa(stats.Galleries))
+	fmt.Println("Total: " + FormatBytes(float64(stats.Bytes)) + " in " + strconv.Itoa(stats.Files) + " Files")
+	if stats.Unreadable > 0 {
+		fmt.Println("Unreadable Manifests: " + strconv.Itoa(stats.Unreadable))
+	}
+	printGroups := func(title string, groups []StatsGroup) {
+		if len(groups) == 0 {
+			return
+		}
+		fmt.Println()
+		fmt.Println(title + ":")
+		for _, group := range groups {
+			fmt.Println("  " + padRight(strconv.Itoa(group.Galleries), 6) + padRight(FormatBytes(float64(group.Bytes)), 14) + group.Name)
+		}
+	}
+	printGroups("By Language", stats.Languages)
+	printGroups("Top Artists", stats.Artists)
+	printGroups("Top Tags", stats.Tags)
+	if len(stats.Largest) > 0 {
+		fmt.Println()
+		fmt.Println("Largest Galleries:")
+		for _, gallery := range stats.Largest {
+			fmt.Println("  " + padRight(FormatBytes(float64(gallery.Bytes)), 14) + gallery.Dir)
+		}
+	}
+	return nil
+}
+
+func padRight(s string, width int) string {
+	if len(s) >= width {
+		return s + " "
+	}
+	return s + strings.Repeat(" ", width-len(s))
+}
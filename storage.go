@@ -0,0 +1,591 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yeka/zip"
+)
+
+// Storage is a destination for downloaded images. Names are slash separated
+// and relative to the storage root, e.g. "japanese/title/001.webp".
+type Storage interface {
+	Write(name string, content []byte) error
+	Exists(name string) bool
+	Stat(name string) (os.FileInfo, error)
+	Read(name string) ([]byte, error)
+	// Finalize is called once every image of the gallery dir has been written.
+	Finalize(dir string) error
+}
+
+func NewStorage(conf Conf) (Storage, error) {
+	mode := os.FileMode(0644)
+	if conf.FileMode != "" {
+		m, err := strconv.ParseUint(conf.FileMode, 8, 32)
+		if err != nil {
+			return nil, errors.New("Invalid FileMode: " + conf.FileMode)
+		}
+		mode = os.FileMode(m)
+	}
+	switch conf.Storage {
+	case "", "local":
+		local := &LocalStorage{Root: conf.SavePath, Mode: mode}
+		if conf.CAS.Dir != "" {
+			return NewCasStorage(local, conf.CAS), nil
+		}
+		return local, nil
+	case "zip":
+		ext := ".cbz"
+		if conf.ZipPassword != "" {
+			ext = ".zip"
+		}
+		return &ZipStorage{
+			Root:      conf.SavePath,
+			Mode:      mode,
+			Ext:       ext,
+			Password:  conf.ZipPassword,
+			Direction: conf.ReadingDirection,
+			archives:  make(map[string]*zipArchive),
+			indexes:   make(map[string]map[string]os.FileInfo),
+			books:     make(map[string]ArchiveBook),
+		}, nil
+	case "epub":
+		return &ZipStorage{
+			Root:      conf.SavePath,
+			Mode:      mode,
+			Ext:       ".epub",
+			Epub:      true,
+			Direction: conf.ReadingDirection,
+			archives:  make(map[string]*zipArchive),
+			indexes:   make(map[string]map[string]os.FileInfo),
+			books:     make(map[string]ArchiveBook),
+		}, nil
+	case "tar", "tar.zst":
+		return &TarStorage{
+			Root:     conf.SavePath,
+			Mode:     mode,
+			Zstd:     conf.Storage == "tar.zst",
+			archives: make(map[string]*tarArchive),
+			indexes:  make(map[string]map[string]os.FileInfo),
+		}, nil
+	case "s3":
+		remote := &S3Storage{Conf: conf.S3}
+		if conf.S3.Archive == "" {
+			return remote, nil
+		}
+		archiveConf := conf
+		archiveConf.Storage, archiveConf.CAS = conf.S3.Archive, CASConf{}
+		archives, err := NewStorage(archiveConf)
+		if err != nil {
+			return nil, err
+		}
+		return &S3ArchiveStorage{Archives: archives, Remote: remote, Root: conf.SavePath, fetched: make(map[string]bool)}, nil
+	case "webdav":
+		return &WebDAVStorage{Conf: conf.WebDAV}, nil
+	case "sftp":
+		return &SFTPStorage{Conf: conf.SFTP}, nil
+	}
+	return nil, errors.New("Unknown Storage: " + conf.Storage)
+}
+
+// StreamWriter is implemented by storages which can write a body as it is
+// being downloaded instead of buffering it in memory first.
+type StreamWriter interface {
+	WriteStream(name string, r io.Reader) (int64, error)
+}
+
+// SizedWriter is implemented by storages which need the size of a body up
+// front, so a file on disk like a downloaded video can still be written
+// without reading it into memory.
+type SizedWriter interface {
+	WriteSized(name string, r io.Reader, size int64) error
+}
+
+var copyBufferPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, 32*1024)
+	},
+}
+
+type LocalStorage struct {
+	Root string
+	Mode os.FileMode
+}
+
+// localStorage is the LocalStorage behind storage, if there is one.
+func localStorage() (*LocalStorage, bool) {
+	switch s := storage.(type) {
+	case *LocalStorage:
+		return s, true
+	case *CasStorage:
+		return s.LocalStorage, true
+	}
+	return nil, false
+}
+
+// Write goes through "<name>.tmp" so an interrupted run never leaves a
+// truncated file under the final name.
+func (s *LocalStorage) Write(name string, content []byte) error {
+	fileName := filepath.Join(s.Root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	tmp := fileName + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, s.Mode); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, fileName)
+}
+
+func (s *LocalStorage) WriteStream(name string, r io.Reader) (int64, error) {
+	fileName := filepath.Join(s.Root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return 0, err
+	}
+	tmp := fileName + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, s.Mode)
+	if err != nil {
+		return 0, err
+	}
+	buf := copyBufferPool.Get().([]byte)
+	n, err := io.CopyBuffer(f, r, buf)
+	copyBufferPool.Put(buf)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && n == 0 {
+		err = errors.New("Empty Body")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return n, err
+	}
+	return n, os.Rename(tmp, fileName)
+}
+
+func (s *LocalStorage) Exists(name string) bool {
+	_, err := s.Stat(name)
+	return err == nil
+}
+
+func (s *LocalStorage) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(filepath.Join(s.Root, filepath.FromSlash(name)))
+}
+
+func (s *LocalStorage) Read(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(s.Root, filepath.FromSlash(name)))
+}
+
+func (s *LocalStorage) Finalize(dir string) error {
+	return nil
+}
+
+// ZipStorage writes each gallery dir into "<dir>.cbz" instead of a folder,
+// or into an AES encrypted "<dir>.zip" when Password is set.
+type ZipStorage struct {
+	Root     string
+	Mode     os.FileMode
+	Ext      string
+	Password string
+	// Epub turns every archive into a fixed-layout EPUB on Finalize.
+	Epub      bool
+	Direction string
+	lock      sync.Mutex
+	archives  map[string]*zipArchive
+	indexes   map[string]map[string]os.FileInfo
+	books     map[string]ArchiveBook
+}
+
+type zipArchive struct {
+	file    *os.File
+	writer  *zip.Writer
+	names   map[string]os.FileInfo
+	sizes   map[string]image.Point
+	storage *ZipStorage
+	// slot is held while writing to writer, the maps above are guarded by
+	// the lock of the storage.
+	slot chan struct{}
+	// broken are the entries cut off by a failed stream and not written
+	// again since, Finalize leaves them out.
+	broken map[string]bool
+}
+
+func (s *ZipStorage) split(name string) (string, string) {
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		return "", name
+	}
+	return name[:i], name[i+1:]
+}
+
+func (s *ZipStorage) archivePath(dir string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(dir)) + s.Ext
+}
+
+func (s *ZipStorage) header(name string, modTime time.Time) *zip.FileHeader {
+	header := &zip.FileHeader{Name: name, Method: zip.Store}
+	header.SetModTime(modTime)
+	if s.Password != "" {
+		header.SetPassword(s.Password)
+		header.SetEncryptionMethod(zip.AES256Encryption)
+	}
+	return header
+}
+
+// archive returns the archive of dir being written, opening it the first
+// time with the pages of the existing one copied over.
+func (s *ZipStorage) archive(dir string) (*zipArchive, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if archive, ok := s.archives[dir]; ok {
+		return archive, nil
+	}
+	fileName := s.archivePath(dir)
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(fileName+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, s.Mode)
+	if err != nil {
+		return nil, err
+	}
+	archive := s.newArchive(f)
+	if s.Epub {
+		err = archive.writeMimetype()
+	}
+	if err == nil {
+		err = archive.copyFrom(fileName, nil)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.archives[dir] = archive
+	delete(s.indexes, dir)
+	return archive, nil
+}
+
+func (s *ZipStorage) newArchive(f *os.File) *zipArchive {
+	return &zipArchive{
+		file:    f,
+		writer:  zip.NewWriter(f),
+		names:   make(map[string]os.FileInfo),
+		sizes:   make(map[string]image.Point),
+		storage: s,
+		slot:    make(chan struct{}, 1),
+		broken:  make(map[string]bool),
+	}
+}
+
+func (s *ZipStorage) Write(name string, content []byte) error {
+	dir, base := s.split(name)
+	archive, err := s.archive(dir)
+	if err != nil {
+		return err
+	}
+	archive.slot <- struct{}{}
+	defer func() { <-archive.slot }()
+	header := s.header(base, time.Now())
+	w, err := archive.writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(content); err != nil {
+		return err
+	}
+	header.UncompressedSize64 = uint64(len(content))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	archive.written(base, header, content)
+	return nil
+}
+
+// zipSniffLen is how much of a streamed page is kept to read its size from.
+const zipSniffLen = 256 * 1024
+
+// zipHoldChunk is how much more of a body is read into memory at a time
+// while another page of its archive is being written.
+const zipHoldChunk = 64 * 1024
+
+// WriteStream writes the body straight into the archive as it arrives. While
+// another page of the archive is being written it reads the body into memory
+// as far as MaxBufferedBytes allows, so the downloads of a gallery don't wait
+// on each other's network, and waits for its turn beyond that.
+func (s *ZipStorage) WriteStream(name string, r io.Reader) (int64, error) {
+	dir, base := s.split(name)
+	archive, err := s.archive(dir)
+	if err != nil {
+		return 0, err
+	}
+	var held bytes.Buffer
+	reserved, err := holdWhileBusy(archive.slot, r, &held)
+	if reserved > 0 {
+		defer buffered.Release(reserved)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer func() { <-archive.slot }()
+	header := s.header(base, time.Now())
+	w, err := archive.writer.CreateHeader(header)
+	if err != nil {
+		return 0, err
+	}
+	head := &prefixBuffer{max: zipSniffLen}
+	buf := copyBufferPool.Get().([]byte)
+	n, err := io.CopyBuffer(w, io.TeeReader(io.MultiReader(&held, r), head), buf)
+	copyBufferPool.Put(buf)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err != nil {
+		archive.broken[base] = true
+		return n, err
+	}
+	header.UncompressedSize64 = uint64(n)
+	archive.written(base, header, head.Bytes())
+	return n, nil
+}
+
+// holdWhileBusy takes slot, reading r into held in the meantime while the
+// buffered budget has room, and returns the bytes it reserved of it. On an
+// error the slot is not taken.
+func holdWhileBusy(slot chan struct{}, r io.Reader, held *bytes.Buffer) (int64, error) {
+	var reserved int64
+	for {
+		select {
+		case slot <- struct{}{}:
+			return reserved, nil
+		default:
+		}
+		if !buffered.TryAcquire(zipHoldChunk) {
+			slot <- struct{}{}
+			return reserved, nil
+		}
+		reserved += zipHoldChunk
+		if _, err := io.CopyN(held, r, zipHoldChunk); err == io.EOF {
+			slot <- struct{}{}
+			return reserved, nil
+		} else if err != nil {
+			return reserved, err
+		}
+	}
+}
+
+// written records the entry base once it is complete, head being at least
+// the start of its content.
+func (a *zipArchive) written(base string, header *zip.FileHeader, head []byte) {
+	a.names[base] = header.FileInfo()
+	delete(a.broken, base)
+	a.recordSize(base, bytes.NewReader(head))
+}
+
+// prefixBuffer keeps the first max bytes written to it.
+type prefixBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *prefixBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		b.Buffer.Write(p[:room])
+	}
+	return len(p), nil
+}
+
+func (s *ZipStorage) Exists(name string) bool {
+	_, err := s.Stat(name)
+	return err == nil
+}
+
+func (s *ZipStorage) Stat(name string) (os.FileInfo, error) {
+	dir, base := s.split(name)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	index, ok := s.indexes[dir]
+	if archive, writing := s.archives[dir]; writing {
+		index, ok = archive.names, true
+	}
+	if !ok {
+		index = make(map[string]os.FileInfo)
+		if r, err := zip.OpenReader(s.archivePath(dir)); err == nil {
+			for _, f := range r.File {
+				index[f.Name] = f.FileInfo()
+			}
+			r.Close()
+		}
+		s.indexes[dir] = index
+	}
+	if fi, ok := index[base]; ok {
+		return fi, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// Read reads from the archive as of the last Finalize.
+func (s *ZipStorage) Read(name string) ([]byte, error) {
+	dir, base := s.split(name)
+	r, err := zip.OpenReader(s.archivePath(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, os.ErrNotExist
+		}
+		return nil, err
+	}
+	defer r.Close()
+	for i := len(r.File) - 1; i >= 0; i-- {
+		if r.File[i].Name != base {
+			continue
+		}
+		if r.File[i].IsEncrypted() {
+			r.File[i].SetPassword(s.Password)
+		}
+		f, err := r.File[i].Open()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ioutil.ReadAll(f)
+	}
+	return nil, os.ErrNotExist
+}
+
+func (s *ZipStorage) Finalize(dir string) error {
+	s.lock.Lock()
+	archive, ok := s.archives[dir]
+	delete(s.archives, dir)
+	book, described := s.books[dir]
+	delete(s.books, dir)
+	s.lock.Unlock()
+	if !ok {
+		return nil
+	}
+	var err error
+	if len(archive.broken) > 0 {
+		if archive, err = s.rebuild(archive); err != nil {
+			return err
+		}
+	}
+	if s.Epub {
+		err = archive.writeEpub(book, s.Direction)
+	} else if described {
+		err = archive.writeComicInfo(book, s.Direction)
+	}
+	if closeErr := archive.writer.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := archive.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(archive.file.Name(), s.archivePath(dir))
+}
+
+// rebuild copies archive into a new one without its broken entries, as the
+// zip writer can't take back an entry once it is started.
+func (s *ZipStorage) rebuild(archive *zipArchive) (*zipArchive, error) {
+	old := archive.file.Name()
+	err := archive.writer.Close()
+	if closeErr := archive.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(strings.TrimSuffix(old, ".tmp")+".rebuild.tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, s.Mode)
+	if err != nil {
+		return nil, err
+	}
+	rebuilt := s.newArchive(f)
+	if s.Epub {
+		err = rebuilt.writeMimetype()
+	}
+	if err == nil {
+		err = rebuilt.copyFrom(old, archive.broken)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	os.Remove(old)
+	return rebuilt, nil
+}
+
+// copyFrom carries over the pages of an existing archive so that filling in
+// missing pages does not drop the ones downloaded by an earlier run. The
+// last entry of the names in broken is left out.
+func (a *zipArchive) copyFrom(fileName string, broken map[string]bool) error {
+	r, err := zip.OpenReader(fileName)
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+	last := make(map[string]int)
+	skipped := make(map[string]bool)
+	for i := len(r.File) - 1; i >= 0; i-- {
+		name := r.File[i].Name
+		if _, ok := last[name]; ok {
+			continue
+		}
+		if broken[name] && !skipped[name] {
+			// an older complete copy, if there is one, takes its place
+			skipped[name] = true
+			continue
+		}
+		last[name] = i
+	}
+	for i, f := range r.File {
+		// a rewritten file like metadata.json is stored again, keep the newest
+		if j, ok := last[f.Name]; !ok || j != i || (a.storage.Epub && epubGenerated(f.Name)) || (!a.storage.Epub && f.Name == comicInfoName) {
+			continue
+		}
+		if f.IsEncrypted() {
+			f.SetPassword(a.storage.Password)
+		}
+		src, err := f.Open()
+		if err != nil {
+			return err
+		}
+		dst, err := a.writer.CreateHeader(a.storage.header(f.Name, f.ModTime()))
+		if err == nil {
+			_, err = io.Copy(dst, src)
+		}
+		src.Close()
+		if err != nil {
+			return err
+		}
+		a.names[f.Name] = f.FileInfo()
+		if src, err := f.Open(); err == nil {
+			a.recordSize(f.Name, src)
+			src.Close()
+		}
+	}
+	return nil
+}
+
+type remoteFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi remoteFileInfo) Name() string       { return fi.name }
+func (fi remoteFileInfo) Size() int64        { return fi.size }
+func (fi remoteFileInfo) Mode() os.FileMode  { return 0644 }
+func (fi remoteFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi remoteFileInfo) IsDir() bool        { return false }
+func (fi remoteFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yeka/zip"
+)
+
+// cutReader fails after handing out its content, like a dropped download.
+type cutReader struct {
+	r io.Reader
+}
+
+func (c cutReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestZipWriteStream(t *testing.T) {
+	s, err := NewStorage(Conf{Storage: "zip", SavePath: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	zs := s.(*ZipStorage)
+	page := testPng(t, 70, 100)
+	if n, err := zs.WriteStream("gallery/01.png", bytes.NewReader(page)); err != nil || n != int64(len(page)) {
+		t.Fatalf("WriteStream = %d, %v", n, err)
+	}
+	if _, err = zs.WriteStream("gallery/02.png", cutReader{bytes.NewReader(page[:10])}); err == nil {
+		t.Fatal("no error for a cut off body")
+	}
+	if zs.Exists("gallery/02.png") {
+		t.Error("the cut off page counts as written")
+	}
+	// 03 is cut off at first and then downloaded again
+	zs.WriteStream("gallery/03.png", cutReader{bytes.NewReader(page[:10])})
+	if _, err = zs.WriteStream("gallery/03.png", bytes.NewReader(page)); err != nil {
+		t.Fatal(err)
+	}
+	if err = zs.Finalize("gallery"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.OpenReader(zs.archivePath("gallery"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	if len(names) != 2 || names[0] != "01.png" || names[1] != "03.png" {
+		t.Errorf("archive holds %v, want [01.png 03.png]", names)
+	}
+	for _, name := range []string{"gallery/01.png", "gallery/03.png"} {
+		if content, err := zs.Read(name); err != nil || !bytes.Equal(content, page) {
+			t.Errorf("%s: %d bytes, %v", name, len(content), err)
+		}
+	}
+}
+
+func TestStorePart(t *testing.T) {
+	defer func(s Storage) { storage = s }(storage)
+	video := bytes.Repeat([]byte("video "), 1000)
+	part := filepath.Join(t.TempDir(), "video.mp4.part")
+	if err := ioutil.WriteFile(part, video, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var put []byte
+	var length int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "MKCOL" {
+			w.WriteHeader(201)
+			return
+		}
+		length = r.ContentLength
+		put, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(201)
+	}))
+	defer server.Close()
+	storage = &WebDAVStorage{Conf: WebDAVConf{Url: server.URL}}
+	if err := StorePart("gallery/video.mp4", part, int64(len(video))); err != nil {
+		t.Fatal(err)
+	}
+	if length != int64(len(video)) || !bytes.Equal(put, video) {
+		t.Errorf("WebDAV PUT of %d bytes with Content-Length %d, want %d", len(put), length, len(video))
+	}
+
+	s, err := NewStorage(Conf{Storage: "tar", SavePath: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage = s
+	if err = StorePart("gallery/video.mp4", part, int64(len(video))); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.(*TarStorage).Finalize("gallery"); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := s.Read("gallery/video.mp4"); err != nil || !bytes.Equal(content, video) {
+		t.Errorf("tar holds %d bytes, %v, want %d", len(content), err, len(video))
+	}
+}
+
+// countingReader counts the bytes read from it so far.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+func TestZipWriteStreamKeepsToBudget(t *testing.T) {
+	defer buffered.SetMax(defaultMaxBufferedBytes)
+	buffered.SetMax(2 * zipHoldChunk)
+	s, err := NewStorage(Conf{Storage: "zip", SavePath: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	zs := s.(*ZipStorage)
+	archive, err := zs.archive("gallery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another page of the archive is being written
+	archive.slot <- struct{}{}
+
+	page := bytes.Repeat([]byte("page "), 100*1024)
+	body := &countingReader{r: bytes.NewReader(page)}
+	done := make(chan error, 1)
+	go func() {
+		_, err := zs.WriteStream("gallery/01.jpg", body)
+		done <- err
+	}()
+	for i := 0; i < 100 && buffered.Used() < 2*zipHoldChunk; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if used, read := buffered.Used(), atomic.LoadInt64(&body.n); used > 2*zipHoldChunk || read > used {
+		t.Errorf("%d bytes read and %d buffered while waiting, want at most %d", read, used, 2*zipHoldChunk)
+	}
+
+	<-archive.slot
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if used := buffered.Used(); used != 0 {
+		t.Errorf("%d bytes still buffered", used)
+	}
+	if err = zs.Finalize("gallery"); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := zs.Read("gallery/01.jpg"); err != nil || !bytes.Equal(content, page) {
+		t.Errorf("archive holds %d bytes, %v, want %d", len(content), err, len(page))
+	}
+}
+
+func TestTarRead(t *testing.T) {
+	for _, kind := range []string{"tar", "tar.zst"} {
+		s, err := NewStorage(Conf{Storage: kind, SavePath: t.TempDir()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts := s.(*TarStorage)
+		write := func(name string, content string) {
+			if err := ts.Write(name, []byte(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		write("gallery/01.jpg", "one")
+		write("gallery/02.jpg", "two")
+		write("gallery/03.jpg", "three")
+		write("gallery/02.jpg", "two again")
+		write("other/01.jpg", "other one")
+		ts.Finalize("gallery")
+		ts.Finalize("other")
+
+		read := func(want map[string]string, order ...string) {
+			for _, name := range order {
+				content, err := ts.Read(name)
+				if want[name] == "" {
+					if !os.IsNotExist(err) {
+						t.Errorf("%s: %s = %q, %v, want it missing", kind, name, content, err)
+					}
+				} else if err != nil || string(content) != want[name] {
+					t.Errorf("%s: %s = %q, %v, want %q", kind, name, content, err, want[name])
+				}
+			}
+		}
+		want := map[string]string{"gallery/01.jpg": "one", "gallery/02.jpg": "two again", "gallery/03.jpg": "three", "other/01.jpg": "other one"}
+		// the archive holds 01, 02, 03 and the newer 02
+		read(want, "gallery/01.jpg", "gallery/03.jpg", "gallery/02.jpg")
+		if ts.reader == nil || ts.reader.next != 4 {
+			t.Errorf("%s: reading the archive in order did not go through it once", kind)
+		}
+		read(want, "gallery/04.jpg", "gallery/01.jpg", "other/01.jpg", "gallery/03.jpg")
+
+		// a repair replaces the archive while it is being read
+		write("gallery/03.jpg", "three again")
+		ts.Finalize("gallery")
+		want["gallery/03.jpg"] = "three again"
+		read(want, "gallery/03.jpg", "gallery/02.jpg")
+		if err = ts.RemoveGallery("gallery"); err != nil {
+			t.Fatal(err)
+		}
+		read(map[string]string{}, "gallery/01.jpg")
+	}
+}
+
+func TestTarWriteCutOff(t *testing.T) {
+	s, err := NewStorage(Conf{Storage: "tar", SavePath: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := s.(*TarStorage)
+	page := testPng(t, 70, 100)
+	if err = ts.Write("gallery/01.png", page); err != nil {
+		t.Fatal(err)
+	}
+	if err = ts.WriteSized("gallery/02.png", cutReader{bytes.NewReader(page[:10])}, int64(len(page))); err == nil {
+		t.Fatal("no error for a cut off body")
+	}
+	if ts.Exists("gallery/02.png") {
+		t.Error("the cut off page counts as written")
+	}
+	// 03 is cut off at first and then downloaded again, 04 comes after both
+	ts.WriteSized("gallery/03.png", cutReader{bytes.NewReader(page[:10])}, int64(len(page)))
+	for _, name := range []string{"gallery/03.png", "gallery/04.png"} {
+		if err = ts.WriteSized(name, bytes.NewReader(page), int64(len(page))); err != nil {
+			t.Fatalf("%s after a cut off body: %v", name, err)
+		}
+	}
+	// another gallery is not held up by a body of this one
+	held := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- ts.WriteSized("gallery/05.png", io.MultiReader(bytes.NewReader(page[:10]), readAfter{held, bytes.NewReader(page[10:])}), int64(len(page)))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	other := make(chan error, 1)
+	go func() { other <- ts.Write("other/01.png", page) }()
+	select {
+	case err = <-other:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("a write to another archive waits on a slow body")
+	}
+	close(held)
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ts.Finalize("gallery"); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	ts.walk(ts.archivePath("gallery"), func(header *tar.Header, r io.Reader) error {
+		names = append(names, header.Name)
+		return nil
+	})
+	if strings.Join(names, " ") != "01.png 03.png 04.png 05.png" {
+		t.Errorf("archive holds %v, want [01.png 03.png 04.png 05.png]", names)
+	}
+	for _, name := range []string{"gallery/01.png", "gallery/03.png", "gallery/04.png", "gallery/05.png"} {
+		if content, err := ts.Read(name); err != nil || !bytes.Equal(content, page) {
+			t.Errorf("%s: %d bytes, %v", name, len(content), err)
+		}
+	}
+}
+
+// readAfter reads r once held is closed, like a slow download.
+type readAfter struct {
+	held chan struct{}
+	r    io.Reader
+}
+
+func (r readAfter) Read(p []byte) (int, error) {
+	<-r.held
+	return r.r.Read(p)
+}
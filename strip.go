@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadataChunks are the PNG chunks StripMetadata drops, the color
+// chunks like iCCP and gAMA are kept as they change how the page looks.
+var pngMetadataChunks = map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true, "eXIf": true, "tIME": true}
+
+// StripMetadata removes EXIF, XMP, IPTC and comments from a JPEG or PNG
+// without re-encoding it. Other formats, and files it can't parse, are
+// returned as they are.
+func StripMetadata(content []byte) []byte {
+	switch {
+	case bytes.HasPrefix(content, []byte{0xFF, 0xD8}):
+		if stripped, ok := stripJpeg(content); ok {
+			return stripped
+		}
+	case bytes.HasPrefix(content, pngSignature):
+		if stripped, ok := stripPng(content); ok {
+			return stripped
+		}
+	}
+	return content
+}
+
+// jpegMetadata tells if the segment of marker only holds metadata: APP1
+// (EXIF, XMP), APP3 to APP13 (IPTC among others), APP15 and comments. APP0
+// (JFIF), APP2 (ICC profile) and APP14 (Adobe color transform) are kept.
+func jpegMetadata(marker byte) bool {
+	return marker == 0xE1 || marker >= 0xE3 && marker <= 0xED || marker == 0xEF || marker == 0xFE
+}
+
+func stripJpeg(content []byte) ([]byte, bool) {
+	out := make([]byte, 0, len(content))
+	out = append(out, content[:2]...)
+	i := 2
+	for {
+		if i+1 >= len(content) || content[i] != 0xFF {
+			return nil, false
+		}
+		marker := content[i+1]
+		if marker == 0xFF {
+			i++
+			continue
+		}
+		// the entropy coded data follows the start of scan, it is kept whole
+		if marker == 0xDA {
+			return append(out, content[i:]...), true
+		}
+		if marker == 0x01 || marker >= 0xD0 && marker <= 0xD7 {
+			out = append(out, content[i:i+2]...)
+			i += 2
+			continue
+		}
+		if i+3 >= len(content) {
+			return nil, false
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(content[i+2:]))
+		if end > len(content) {
+			return nil, false
+		}
+		if !jpegMetadata(marker) {
+			out = append(out, content[i:end]...)
+		}
+		i = end
+	}
+}
+
+func stripPng(content []byte) ([]byte, bool) {
+	out := make([]byte, 0, len(content))
+	out = append(out, pngSignature...)
+	i := len(pngSignature)
+	for i < len(content) {
+		if i+8 > len(content) {
+			return nil, false
+		}
+		end := i + 12 + int(binary.BigEndian.Uint32(content[i:]))
+		if end > len(content) || end < i {
+			return nil, false
+		}
+		chunk := string(content[i+4 : i+8])
+		if !pngMetadataChunks[chunk] {
+			out = append(out, content[i:end]...)
+		}
+		i = end
+		if chunk == "IEND" {
+			break
+		}
+	}
+	return out, true
+}
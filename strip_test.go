@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	return img
+}
+
+func TestStripJpeg(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	clean := buf.Bytes()
+	exif := append([]byte{0xFF, 0xE1, 0x00, 0x0C}, "Exif\x00\x00GPS!"...)
+	comment := append([]byte{0xFF, 0xFE, 0x00, 0x09}, "comment"...)
+	dirty := append(append(append(append([]byte{}, clean[:2]...), exif...), comment...), clean[2:]...)
+
+	stripped := StripMetadata(dirty)
+	if !bytes.Equal(stripped, clean) {
+		t.Errorf("stripped %d bytes, want the %d of the clean file", len(stripped), len(clean))
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStripPng(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	clean := buf.Bytes()
+	text := append([]byte{0, 0, 0, 9}, "tEXtKey\x00value0000"...)
+	iend := len(clean) - 12
+	dirty := append(append(append([]byte{}, clean[:iend]...), text...), clean[iend:]...)
+
+	if stripped := StripMetadata(dirty); !bytes.Equal(stripped, clean) {
+		t.Errorf("stripped %d bytes, want the %d of the clean file", len(stripped), len(clean))
+	}
+}
+
+func TestStripKeepsOthers(t *testing.T) {
+	for _, content := range [][]byte{[]byte("RIFF....WEBPVP8 "), {0xFF, 0xD8, 0xFF}, []byte("\x89PNG\r\n\x1a\n\x00")} {
+		if got := StripMetadata(content); !bytes.Equal(got, content) {
+			t.Errorf("StripMetadata(%q) = %q", content, got)
+		}
+	}
+}
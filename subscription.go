@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const subscriptionsFile = "subscriptions.yaml"
+const subscriptionsStateFile = "subscriptions.state.json"
+
+type Subscriptions struct {
+	Interval string   `yaml:"interval"`
+	Language string   `yaml:"language"`
+	Artists  []string `yaml:"artists"`
+	Groups   []string `yaml:"groups"`
+	Series   []string `yaml:"series"`
+	Tags     []string `yaml:"tags"`
+}
+
+// Feeds returns the nozomi feed paths, e.g. "artist/name-all".
+func (s Subscriptions) Feeds() []string {
+	lang := s.Language
+	if lang == "" {
+		lang = "all"
+	}
+	var feeds []string
+	add := func(area string, names []string) {
+		for _, name := range names {
+			feeds = append(feeds, area+"/"+strings.ToLower(strings.TrimSpace(name))+"-"+lang)
+		}
+	}
+	add("artist", s.Artists)
+	add("group", s.Groups)
+	add("series", s.Series)
+	add("tag", s.Tags)
+	return feeds
+}
+
+func LoadSubscriptions(fileName string) (subs Subscriptions, err error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return subs, err
+	}
+	err = yaml.Unmarshal(data, &subs)
+	return subs, err
+}
+
+// Sync downloads galleries of every subscribed feed newer than the last seen
+// id, and repeats every Interval when it is set.
+func Sync(fileName string) {
+	for {
+		subs, err := LoadSubscriptions(fileName)
+		if err != nil {
+			if os.IsNotExist(err) {
+				CommonError(fileName + " Not Found")
+			}
+			CommonError(err)
+		}
+		var interval time.Duration
+		if subs.Interval != "" {
+			if interval, err = time.ParseDuration(subs.Interval); err != nil {
+				CommonError("Invalid Interval: " + subs.Interval)
+			}
+		}
+
+		SyncOnce(subs)
+		Finish()
+		if interval <= 0 {
+			return
+		}
+		log.Println("Next Sync At " + time.Now().Add(interval).Format("2006-01-02 15:04:05"))
+		time.Sleep(interval)
+	}
+}
+
+func SyncOnce(subs Subscriptions) {
+	state := make(map[string]int)
+	if data, err := ioutil.ReadFile(subscriptionsStateFile); err == nil {
+		if err = json.Unmarshal(data, &state); err != nil {
+			log.Println("Read " + subscriptionsStateFile + " Fail: " + err.Error())
+		}
+	}
+
+	var ids []int
+	newState := make(map[string]int)
+	for _, feed := range subs.Feeds() {
+		newState[feed] = state[feed]
+		feedIds, err := NozomiIds(appCtx, feed)
+		if err != nil {
+			log.Println("Read Feed Fail: " + feed + " Because " + err.Error())
+			continue
+		}
+		for _, id := range feedIds {
+			if id > state[feed] {
+				ids = append(ids, id)
+			}
+			if id > newState[feed] {
+				newState[feed] = id
+			}
+		}
+	}
+	sort.Ints(ids)
+
+	urls := make([]string, 0, len(ids))
+	for _, id := range ids {
+		urls = append(urls, GalleryUrl(strconv.Itoa(id)))
+	}
+	urls = Unique(urls)
+	log.Println(strconv.Itoa(len(urls)) + " New Galleries In " + strconv.Itoa(len(newState)) + " Subscriptions")
+	if len(urls) > 0 {
+		Run(urls)
+	}
+
+	data, err := json.MarshalIndent(newState, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(subscriptionsStateFile, data, 0644)
+	}
+	if err != nil {
+		log.Println("Save " + subscriptionsStateFile + " Fail: " + err.Error())
+	}
+}
+
+// NozomiIds reads a nozomi feed, a list of big endian int32 gallery ids.
+func NozomiIds(ctx context.Context, feed string) ([]int, error) {
+	code, resp, err := Get(ctx, MetadataUrl("n/"+(&url.URL{Path: feed}).EscapedPath()+".nozomi"))
+	if err != nil {
+		return nil, err
+	}
+	if code != 200 {
+		return nil, errors.New("Status Code " + strconv.Itoa(code))
+	}
+	ids := make([]int, 0, len(resp)/4)
+	for i := 0; i+4 <= len(resp); i += 4 {
+		ids = append(ids, int(binary.BigEndian.Uint32(resp[i:i+4])))
+	}
+	return ids, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+type Summary struct {
+	GalleriesSucceeded int64 `json:"galleries_succeeded"`
+	GalleriesFailed    int64 `json:"galleries_failed"`
+	GalleriesSkipped   int64 `json:"galleries_skipped"`
+	// MetadataFailed of the failed galleries couldn't even be read.
+	MetadataFailed   int64   `json:"metadata_failed"`
+	ImagesDownloaded int64   `json:"images_downloaded"`
+	ImagesSkipped    int64   `json:"images_skipped"`
+	ImagesFailed     int64   `json:"images_failed"`
+	Retries          int64   `json:"retries"`
+	Bytes            int64   `json:"bytes"`
+	Elapsed          float64 `json:"elapsed_seconds"`
+	Speed            float64 `json:"bytes_per_second"`
+
+	start time.Time
+}
+
+var summary = Summary{start: time.Now()}
+
+// Exit codes, so scripts can tell how a run went.
+const (
+	ExitOk       = 0
+	ExitFatal    = 1
+	ExitFailed   = 2
+	ExitMetadata = 3
+)
+
+// ExitCode is ExitMetadata when the info of a gallery couldn't be read,
+// ExitFailed when a gallery failed otherwise, or ExitOk.
+func (s *Summary) ExitCode() int {
+	if atomic.LoadInt64(&s.MetadataFailed) > 0 {
+		return ExitMetadata
+	}
+	if atomic.LoadInt64(&s.GalleriesFailed) > 0 {
+		return ExitFailed
+	}
+	return ExitOk
+}
+
+func (s *Summary) Finish() {
+	s.Elapsed = time.Since(s.start).Seconds()
+	if s.Elapsed > 0 {
+		s.Speed = float64(atomic.LoadInt64(&s.Bytes)) / s.Elapsed
+	}
+}
+
+func (s *Summary) Print() {
+	fmt.Println()
+	log.Println("Galleries: " + strconv.FormatInt(s.GalleriesSucceeded, 10) + " Succeeded, " +
+		strconv.FormatInt(s.GalleriesFailed, 10) + " Failed, " +
+		strconv.FormatInt(s.GalleriesSkipped, 10) + " Skipped")
+	log.Println("Images: " + strconv.FormatInt(s.ImagesDownloaded, 10) + " Downloaded, " +
+		strconv.FormatInt(s.ImagesSkipped, 10) + " Skipped, " +
+		strconv.FormatInt(s.ImagesFailed, 10) + " Failed")
+	log.Println("Total: " + FormatBytes(float64(s.Bytes)) + " in " +
+		time.Duration(s.Elapsed*float64(time.Second)).Round(time.Second).String() +
+		" (" + FormatBytes(s.Speed) + "/s)")
+}
+
+func (s *Summary) Save(fileName string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, data, 0644)
+}
+
+func FormatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return strconv.FormatFloat(n, 'f', 2, 64) + " " + units[i]
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultUnitFile is where install-service writes the unit without a path.
+const defaultUnitFile = "/etc/systemd/system/hitomi.service"
+
+var systemdFlag = flag.Bool("systemd", false, "serve: tell systemd when the daemon is ready and ping its watchdog")
+
+// SdNotify sends state to the socket of NOTIFY_SOCKET, like sd_notify(3). It
+// does nothing when systemd didn't start the program.
+func SdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		// an abstract socket
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// WatchdogInterval is how often systemd wants to be pinged, half of
+// WATCHDOG_USEC, and 0 when the watchdog is off or meant for another process.
+func WatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// NotifySystemdReady tells systemd that the daemon is up, pings its watchdog
+// until the program is interrupted and then tells it that it is stopping.
+func NotifySystemdReady() {
+	if !*systemdFlag {
+		return
+	}
+	if err := SdNotify("READY=1\nSTATUS=Waiting For Downloads"); err != nil {
+		log.Println("Notify systemd Fail: " + err.Error())
+		return
+	}
+	go func() {
+		var tick <-chan time.Time
+		if interval := WatchdogInterval(); interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-tick:
+				SdNotify("WATCHDOG=1")
+			case <-appCtx.Done():
+				SdNotify("STOPPING=1")
+				return
+			}
+		}
+	}()
+}
+
+// ServiceUnit is a systemd unit running exe as a supervised daemon in dir.
+func ServiceUnit(exe string, dir string, config string, userName string) string {
+	command := unitQuote(exe)
+	if config != "" {
+		command += " --config " + unitQuote(config)
+	}
+	unit := `[Unit]
+Description=hitomi downloader
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=notify
+ExecStart=` + command + ` --systemd serve
+WorkingDirectory=` + dir + `
+WatchdogSec=60
+Restart=on-failure
+`
+	if userName != "" {
+		unit += "User=" + userName + "\n"
+	}
+	return unit + `
+[Install]
+WantedBy=multi-user.target
+`
+}
+
+func unitQuote(s string) string {
+	if strings.ContainsAny(s, " \t\"'\\") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// InstallService writes the unit of this program, run in the working
+// directory with its config, to fileName, defaultUnitFile when it is "".
+func InstallService(fileName string) error {
+	if fileName == "" {
+		fileName = defaultUnitFile
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	config := *configFlag
+	if config != "" {
+		if config, err = filepath.Abs(config); err != nil {
+			return err
+		}
+	}
+	var userName string
+	if current, err := user.Current(); err == nil {
+		userName = current.Username
+	}
+	if _, err = os.Stat(fileName); err == nil {
+		return errors.New(fileName + " Exists Already")
+	}
+	if err = ioutil.WriteFile(fileName, []byte(ServiceUnit(exe, dir, config, userName)), 0644); err != nil {
+		return err
+	}
+	name := filepath.Base(fileName)
+	fmt.Println("Wrote " + fileName + ", start it with:")
+	fmt.Println("  systemctl daemon-reload && systemctl enable --now " + name)
+	return nil
+}
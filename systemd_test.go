@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skip("no unix datagram sockets: " + err.Error())
+	}
+	defer conn.Close()
+	defer os.Setenv("NOTIFY_SOCKET", os.Getenv("NOTIFY_SOCKET"))
+
+	os.Setenv("NOTIFY_SOCKET", "")
+	if err = SdNotify("READY=1"); err != nil {
+		t.Errorf("SdNotify without systemd = %v", err)
+	}
+	os.Setenv("NOTIFY_SOCKET", socket)
+	if err = SdNotify("READY=1"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1" {
+		t.Errorf("received %q, %v", buf[:n], err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Setenv("WATCHDOG_USEC", os.Getenv("WATCHDOG_USEC"))
+	defer os.Setenv("WATCHDOG_PID", os.Getenv("WATCHDOG_PID"))
+	os.Setenv("WATCHDOG_USEC", "60000000")
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if got := WatchdogInterval(); got != 30*time.Second {
+		t.Errorf("WatchdogInterval = %v, want 30s", got)
+	}
+	os.Setenv("WATCHDOG_PID", "1")
+	if got := WatchdogInterval(); got != 0 {
+		t.Errorf("WatchdogInterval of another process = %v", got)
+	}
+	os.Setenv("WATCHDOG_PID", "")
+	os.Setenv("WATCHDOG_USEC", "")
+	if got := WatchdogInterval(); got != 0 {
+		t.Errorf("WatchdogInterval without a watchdog = %v", got)
+	}
+}
+
+func TestServiceUnit(t *testing.T) {
+	unit := ServiceUnit("/opt/hitomi/hitomi", "/srv/hitomi", "/srv/my config.json", "hitomi")
+	for _, line := range []string{
+		"Type=notify",
+		`ExecStart=/opt/hitomi/hitomi --config "/srv/my config.json" --systemd serve`,
+		"WorkingDirectory=/srv/hitomi",
+		"User=hitomi",
+	} {
+		if !strings.Contains(unit, line+"\n") {
+			t.Errorf("unit lacks %q:\n%s", line, unit)
+		}
+	}
+}
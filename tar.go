@@ -0,0 +1,460 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// TarStorage writes each gallery dir into "<dir>.tar", or "<dir>.tar.zst"
+// when Zstd is set, for cold storage.
+type TarStorage struct {
+	Root     string
+	Mode     os.FileMode
+	Zstd     bool
+	lock     sync.Mutex
+	archives map[string]*tarArchive
+	indexes  map[string]map[string]os.FileInfo
+	readLock sync.Mutex
+	reader   *tarReader
+}
+
+type tarArchive struct {
+	// lock is held while an entry is written, s.lock only guards names and
+	// broken so other galleries don't wait on a slow body.
+	lock   sync.Mutex
+	file   *os.File
+	buf    *bufio.Writer
+	zstd   *zstd.Encoder
+	writer *tar.Writer
+	names  map[string]os.FileInfo
+	// broken are the entries cut off by a failed body, filled up with zeros
+	// and left out by Finalize
+	broken map[string]bool
+}
+
+func (s *TarStorage) ext() string {
+	if s.Zstd {
+		return ".tar.zst"
+	}
+	return ".tar"
+}
+
+func (s *TarStorage) archivePath(dir string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(dir)) + s.ext()
+}
+
+func (s *TarStorage) split(name string) (string, string) {
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		return "", name
+	}
+	return name[:i], name[i+1:]
+}
+
+func (s *TarStorage) Write(name string, content []byte) error {
+	return s.WriteSized(name, bytes.NewReader(content), int64(len(content)))
+}
+
+func (s *TarStorage) WriteSized(name string, r io.Reader, size int64) error {
+	dir, base := s.split(name)
+	s.lock.Lock()
+	archive, ok := s.archives[dir]
+	if !ok {
+		var err error
+		if archive, err = s.create(dir); err != nil {
+			s.lock.Unlock()
+			return err
+		}
+		s.archives[dir] = archive
+		delete(s.indexes, dir)
+	}
+	s.lock.Unlock()
+
+	archive.lock.Lock()
+	defer archive.lock.Unlock()
+	header := &tar.Header{
+		Name:    base,
+		Mode:    int64(s.Mode),
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := archive.writer.WriteHeader(header); err != nil {
+		return err
+	}
+	n, err := io.Copy(archive.writer, r)
+	if err == nil && n != size {
+		err = io.ErrUnexpectedEOF
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err != nil {
+		// the tar writer wants the whole entry before the next one
+		if n < size {
+			if _, padErr := io.CopyN(archive.writer, zeroReader{}, size-n); padErr != nil {
+				return padErr
+			}
+		}
+		archive.broken[base] = true
+		return err
+	}
+	archive.names[base] = header.FileInfo()
+	delete(archive.broken, base)
+	return nil
+}
+
+// zeroReader reads endless zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// create starts the archive of dir, carrying over the files of its existing
+// archive.
+func (s *TarStorage) create(dir string) (*tarArchive, error) {
+	fileName := s.archivePath(dir)
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return nil, err
+	}
+	archive, err := s.newArchive(fileName + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	if err = s.copyFrom(fileName, archive, nil); err != nil {
+		archive.file.Close()
+		return nil, err
+	}
+	return archive, nil
+}
+
+func (s *TarStorage) newArchive(fileName string) (*tarArchive, error) {
+	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, s.Mode)
+	if err != nil {
+		return nil, err
+	}
+	archive := &tarArchive{file: f, buf: bufio.NewWriter(f), names: make(map[string]os.FileInfo), broken: make(map[string]bool)}
+	var w io.Writer = archive.buf
+	if s.Zstd {
+		if archive.zstd, err = zstd.NewWriter(archive.buf, zstd.WithEncoderLevel(zstd.SpeedBetterCompression)); err != nil {
+			f.Close()
+			return nil, err
+		}
+		w = archive.zstd
+	}
+	archive.writer = tar.NewWriter(w)
+	return archive, nil
+}
+
+// close completes the archive file.
+func (a *tarArchive) close() error {
+	err := a.writer.Close()
+	if a.zstd != nil {
+		if closeErr := a.zstd.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if flushErr := a.buf.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// rebuild copies archive into a new one without its broken entries, as the
+// tar writer can't take back an entry once it is started.
+func (s *TarStorage) rebuild(archive *tarArchive) (*tarArchive, error) {
+	old := archive.file.Name()
+	if err := archive.close(); err != nil {
+		return nil, err
+	}
+	rebuilt, err := s.newArchive(strings.TrimSuffix(old, ".tmp") + ".rebuild.tmp")
+	if err != nil {
+		return nil, err
+	}
+	if err = s.copyFrom(old, rebuilt, archive.broken); err != nil {
+		rebuilt.file.Close()
+		os.Remove(rebuilt.file.Name())
+		return nil, err
+	}
+	os.Remove(old)
+	return rebuilt, nil
+}
+
+func (s *TarStorage) Exists(name string) bool {
+	_, err := s.Stat(name)
+	return err == nil
+}
+
+func (s *TarStorage) Stat(name string) (os.FileInfo, error) {
+	dir, base := s.split(name)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	index, ok := s.indexes[dir]
+	if archive, writing := s.archives[dir]; writing {
+		index, ok = archive.names, true
+	}
+	if !ok {
+		index = make(map[string]os.FileInfo)
+		_ = s.walk(s.archivePath(dir), func(header *tar.Header, r io.Reader) error {
+			index[header.Name] = header.FileInfo()
+			return nil
+		})
+		s.indexes[dir] = index
+	}
+	if fi, ok := index[base]; ok {
+		return fi, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// tarReader reads the entries of one archive in order, so reading every file
+// of a gallery one after another, like verify and repair do, goes through
+// the archive once instead of once per file.
+type tarReader struct {
+	dir     string
+	info    os.FileInfo
+	file    *os.File
+	decoder *zstd.Decoder
+	tar     *tar.Reader
+	// last is the position of the newest copy of every entry, next the
+	// position of the entry tar is at.
+	last map[string]int
+	next int
+}
+
+// Reads tells whether r still reads the archive of dir, which is info now.
+func (r *tarReader) Reads(dir string, info os.FileInfo) bool {
+	return r.dir == dir && os.SameFile(r.info, info) && r.info.Size() == info.Size() && r.info.ModTime().Equal(info.ModTime())
+}
+
+func (r *tarReader) Close() {
+	if r.decoder != nil {
+		r.decoder.Close()
+	}
+	r.file.Close()
+}
+
+// Read reads from the archive as of the last Finalize.
+func (s *TarStorage) Read(name string) ([]byte, error) {
+	dir, base := s.split(name)
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+	info, err := os.Stat(s.archivePath(dir))
+	if err != nil {
+		s.closeReader("")
+		return nil, err
+	}
+	r := s.reader
+	if r == nil || !r.Reads(dir, info) {
+		s.closeReader("")
+		last := make(map[string]int)
+		i := 0
+		if err = s.walk(s.archivePath(dir), func(header *tar.Header, _ io.Reader) error {
+			last[header.Name] = i
+			i++
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		r = &tarReader{dir: dir, info: info, last: last}
+	}
+	at, ok := r.last[base]
+	if !ok {
+		s.reader = r
+		return nil, os.ErrNotExist
+	}
+	if r.file == nil || at < r.next {
+		if r.file != nil {
+			r.Close()
+		}
+		if err = s.open(r); err != nil {
+			return nil, err
+		}
+	}
+	s.reader = r
+	for {
+		_, err := r.tar.Next()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			s.closeReader("")
+			return nil, err
+		}
+		r.next++
+		if r.next-1 == at {
+			content, err := ioutil.ReadAll(r.tar)
+			if err != nil {
+				s.closeReader("")
+			}
+			return content, err
+		}
+	}
+}
+
+// open starts r over at the first entry of its archive.
+func (s *TarStorage) open(r *tarReader) error {
+	f, err := os.Open(s.archivePath(r.dir))
+	if err != nil {
+		return err
+	}
+	var tr io.Reader = f
+	r.decoder = nil
+	if s.Zstd {
+		if r.decoder, err = zstd.NewReader(bufio.NewReader(f)); err != nil {
+			f.Close()
+			return err
+		}
+		tr = r.decoder
+	}
+	r.file, r.tar, r.next = f, tar.NewReader(tr), 0
+	return nil
+}
+
+// closeReader closes the open reader when it reads the archive of dir, or
+// whichever archive it reads when dir is "", before the archive is replaced.
+func (s *TarStorage) closeReader(dir string) {
+	if s.reader == nil || dir != "" && s.reader.dir != dir {
+		return
+	}
+	if s.reader.file != nil {
+		s.reader.Close()
+	}
+	s.reader = nil
+}
+
+func (s *TarStorage) Finalize(dir string) error {
+	s.lock.Lock()
+	archive, ok := s.archives[dir]
+	delete(s.archives, dir)
+	s.lock.Unlock()
+	if !ok {
+		return nil
+	}
+	archive.lock.Lock()
+	defer archive.lock.Unlock()
+	var err error
+	if len(archive.broken) > 0 {
+		if archive, err = s.rebuild(archive); err != nil {
+			return err
+		}
+	}
+	if err = archive.close(); err != nil {
+		return err
+	}
+	s.readLock.Lock()
+	defer s.readLock.Unlock()
+	s.closeReader(dir)
+	return os.Rename(archive.file.Name(), s.archivePath(dir))
+}
+
+func (s *TarStorage) Galleries() ([]string, error) {
+	var dirs []string
+	err := filepath.Walk(s.Root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := skipHidden(s.Root, p, info); err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(p, s.ext()) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Root, strings.TrimSuffix(p, s.ext()))
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, filepath.ToSlash(rel))
+		return nil
+	})
+	return dirs, err
+}
+
+// walk calls fn for every entry of the archive fileName, a missing archive
+// has no entries.
+func (s *TarStorage) walk(fileName string, fn func(header *tar.Header, r io.Reader) error) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = bufio.NewReader(f)
+	if s.Zstd {
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer decoder.Close()
+		r = decoder
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// copyFrom carries over the files of an existing archive, keeping only the
+// newest copy of a file which was written again. The last entry of the names
+// in broken is left out.
+func (s *TarStorage) copyFrom(fileName string, archive *tarArchive, broken map[string]bool) error {
+	positions := make(map[string][]int)
+	i := 0
+	if err := s.walk(fileName, func(header *tar.Header, r io.Reader) error {
+		positions[header.Name] = append(positions[header.Name], i)
+		i++
+		return nil
+	}); err != nil {
+		return err
+	}
+	last := make(map[string]int, len(positions))
+	for name, at := range positions {
+		if broken[name] {
+			at = at[:len(at)-1]
+		}
+		if len(at) > 0 {
+			last[name] = at[len(at)-1]
+		}
+	}
+	i = 0
+	return s.walk(fileName, func(header *tar.Header, r io.Reader) error {
+		defer func() { i++ }()
+		if at, ok := last[header.Name]; !ok || at != i {
+			return nil
+		}
+		if err := archive.writer.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(archive.writer, r); err != nil {
+			return err
+		}
+		archive.names[header.Name] = header.FileInfo()
+		return nil
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+var layouts = map[string]string{
+	"ByLanguage": "{{.Language}}/{{.Title}}",
+	"ByArtist":   `{{first .Artists "unknown"}}/{{.Title}} [{{.Id}}]`,
+	"BySeries":   `{{first .Series "original"}}/{{.Title}} [{{.Id}}]`,
+	"ByType":     `{{or .Type "unknown"}}/{{.Language}}/{{.Title}}`,
+}
+
+// TemplateData is what PathTemplate is executed with. Every value has
+// already been made safe for use as a file name.
+type TemplateData struct {
+	Id         string
+	Title      string
+	EnTitle    string
+	JpTitle    string
+	Language   string
+	Type       string
+	Date       string
+	Year       string
+	Artists    []string
+	Groups     []string
+	Series     []string
+	Characters []string
+	Tags       []string
+	// TranslatedTags are the Tags in the language of TagTranslation.
+	TranslatedTags []string
+	// Pages is the size of the whole gallery, even with pages left out.
+	Pages int
+}
+
+var templateFuncs = template.FuncMap{
+	"join": func(list []string, sep string) string {
+		return strings.Join(list, sep)
+	},
+	"first": func(list []string, fallback string) string {
+		if len(list) == 0 {
+			return fallback
+		}
+		return list[0]
+	},
+}
+
+// CompilePathTemplate compiles text, or the preset of layout when text is
+// empty.
+func CompilePathTemplate(text string, layout string) (*template.Template, error) {
+	if text == "" {
+		if layout == "" {
+			layout = "ByLanguage"
+		}
+		var ok bool
+		if text, ok = layouts[layout]; !ok {
+			return nil, errors.New("Unknown Layout: " + layout)
+		}
+	}
+	return template.New("path").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// PreferredTitle is the title of gallery chosen by TitlePreference, the
+// other one when it is missing.
+func PreferredTitle(gallery Gallery, preference string) string {
+	if preference == "english" && gallery.Title != "" || gallery.JpTitle == "" {
+		return gallery.Title
+	}
+	if preference == "romaji" {
+		return Romaji(gallery.JpTitle)
+	}
+	return gallery.JpTitle
+}
+
+func NewTemplateData(gallery Gallery, conf Conf) TemplateData {
+	title := PreferredTitle(gallery, conf.TitlePreference)
+	lang := gallery.Lang
+	if lang == "" {
+		lang = "null"
+	}
+	year := ""
+	if len(gallery.Date) >= 4 {
+		year = gallery.Date[:4]
+	}
+	tags := make([]string, 0, len(gallery.Tags))
+	for _, tag := range gallery.Tags {
+		tags = append(tags, tag.Name())
+	}
+	return TemplateData{
+		Id:             gallery.Id,
+		Title:          ValidFileName(title),
+		EnTitle:        ValidFileName(gallery.Title),
+		JpTitle:        ValidFileName(gallery.JpTitle),
+		Language:       ValidFileName(lang),
+		Type:           ValidFileName(gallery.Type),
+		Date:           ValidFileName(gallery.Date),
+		Year:           year,
+		Artists:        validFileNames(gallery.Artists),
+		Groups:         validFileNames(gallery.Groups),
+		Series:         validFileNames(gallery.Parodys),
+		Characters:     validFileNames(gallery.Characters),
+		Tags:           validFileNames(tags),
+		TranslatedTags: validFileNames(TranslatedTagNames(gallery.Tags)),
+		Pages:          gallery.TotalPages(),
+	}
+}
+
+// GalleryPath is the slash separated directory of the gallery relative to
+// the storage root, as set by the PathTemplate or Layout of conf.
+func GalleryPath(gallery Gallery, conf Conf) (string, error) {
+	pathTemplate, err := CompilePathTemplate(conf.PathTemplate, conf.Layout)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := pathTemplate.Execute(&buf, NewTemplateData(gallery, conf)); err != nil {
+		return "", err
+	}
+	p := strings.ReplaceAll(buf.String(), "\\", "/")
+	for _, piece := range strings.Split(p, "/") {
+		if piece == "." || piece == ".." {
+			return "", errors.New("Folder Name " + strconv.Quote(piece) + " Not Allowed In " + strconv.Quote(p))
+		}
+	}
+	return strings.TrimPrefix(path.Clean("/"+p), "/"), nil
+}
+
+// claimedPaths are the gallery folders taken in this run, by the id of the
+// gallery they were given to.
+var claimedPaths = struct {
+	lock sync.Mutex
+	ids  map[string]string
+}{ids: make(map[string]string)}
+
+// ClaimGalleryPath is savePath, or "<savePath> - <id>" when another gallery
+// of the same title has it already, in this run or by its manifest.json.
+// Galleries downloading at the same time don't get the same folder.
+func ClaimGalleryPath(gallery Gallery, savePath string) string {
+	if gallery.Id == "" {
+		return savePath
+	}
+	var saved string
+	if manifest, err := ReadManifest(savePath); err == nil {
+		saved = manifest.Id
+	}
+	claimedPaths.lock.Lock()
+	defer claimedPaths.lock.Unlock()
+	owner, claimed := claimedPaths.ids[savePath]
+	if !claimed {
+		owner = saved
+	}
+	if owner != "" && owner != gallery.Id {
+		savePath += " - " + gallery.Id
+	}
+	claimedPaths.ids[savePath] = gallery.Id
+	return savePath
+}
+
+func validFileNames(list []string) []string {
+	valid := make([]string, 0, len(list))
+	for _, s := range list {
+		valid = append(valid, ValidFileName(s))
+	}
+	return valid
+}
@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestGalleryPathByType(t *testing.T) {
+	typeConf := Conf{Layout: "ByType"}
+	for _, c := range []struct {
+		gallery Gallery
+		want    string
+	}{
+		{Gallery{Title: "Cg Set", Type: "artistcg", Lang: "japanese"}, "artistcg/japanese/Cg Set"},
+		{Gallery{Title: "Story", Type: "manga", Lang: "english"}, "manga/english/Story"},
+		{Gallery{Title: "No Type", Lang: "korean"}, "unknown/korean/No Type"},
+	} {
+		got, err := GalleryPath(c.gallery, typeConf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("GalleryPath(%q) = %q, want %q", c.gallery.Title, got, c.want)
+		}
+	}
+
+	typeConf.PathTemplate = "{{.Type}}/{{.Title}}"
+	if got, _ := GalleryPath(Gallery{Title: "Pics", Type: "imageset"}, typeConf); got != "imageset/Pics" {
+		t.Errorf("PathTemplate with .Type = %q", got)
+	}
+}
+
+func TestGalleryPathPages(t *testing.T) {
+	pagesConf := Conf{PathTemplate: "{{.Title}} ({{.Pages}}p)"}
+	gallery := Gallery{Title: "Story", Files: make([]Image, 3)}
+	if got, _ := GalleryPath(gallery, pagesConf); got != "Story (3p)" {
+		t.Errorf("GalleryPath with 3 files = %q", got)
+	}
+	// PageCount is the size before pages were left out
+	gallery.PageCount = 20
+	if got, _ := GalleryPath(gallery, pagesConf); got != "Story (20p)" {
+		t.Errorf("GalleryPath with PageCount 20 = %q", got)
+	}
+}
+
+func TestGalleryPathDotNames(t *testing.T) {
+	for _, c := range []struct {
+		title string
+		ok    bool
+	}{
+		{"..", false},
+		{".", false},
+		{"...", true},
+		{"..Title", true},
+		{"a/..", true},
+	} {
+		got, err := GalleryPath(Gallery{Title: c.title, Lang: "japanese"}, Conf{})
+		if (err == nil) != c.ok {
+			t.Errorf("GalleryPath(%q) = %q, %v", c.title, got, err)
+		}
+	}
+	if _, err := GalleryPath(Gallery{Title: "Story", Lang: "japanese"}, Conf{PathTemplate: "../{{.Title}}"}); err == nil {
+		t.Error("a PathTemplate leaving SavePath is accepted")
+	}
+}
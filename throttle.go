@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Throttle pauses every image request for Cooldown once Threshold rate
+// limited responses (429/403) arrive within Window.
+type Throttle struct {
+	Threshold int
+	Window    time.Duration
+	Cooldown  time.Duration
+	lock      sync.Mutex
+	hits      []time.Time
+	until     time.Time
+}
+
+var throttle = &Throttle{Threshold: 5, Window: 10 * time.Second, Cooldown: time.Minute}
+
+func IsRateLimited(err error) bool {
+	var status StatusError
+	if !errors.As(err, &status) {
+		return false
+	}
+	return status == http.StatusTooManyRequests || status == http.StatusForbidden
+}
+
+// Hit records a rate limited response and starts the cooldown when there
+// have been too many of them.
+func (t *Throttle) Hit() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	if now.Before(t.until) {
+		return
+	}
+	hits := t.hits[:0]
+	for _, hit := range t.hits {
+		if now.Sub(hit) < t.Window {
+			hits = append(hits, hit)
+		}
+	}
+	t.hits = append(hits, now)
+	if len(t.hits) < t.Threshold {
+		return
+	}
+	t.hits = t.hits[:0]
+	t.until = now.Add(t.Cooldown)
+	go t.Countdown(t.until)
+}
+
+// Paused tells if a cooldown is running, so a rate limited request can be
+// retried without using up its retries.
+func (t *Throttle) Paused() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return time.Now().Before(t.until)
+}
+
+// Wait blocks until the cooldown is over.
+func (t *Throttle) Wait(ctx context.Context) error {
+	for {
+		t.lock.Lock()
+		wait := time.Until(t.until)
+		t.lock.Unlock()
+		if wait <= 0 {
+			return nil
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (t *Throttle) Countdown(until time.Time) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for left := time.Until(until); left > 0; left = time.Until(until) {
+		fmt.Printf("\rRate Limited, Resuming In %3ds", int(left.Seconds()+0.5))
+		<-ticker.C
+	}
+	fmt.Println("\rRate Limited, Resuming Now    ")
+}
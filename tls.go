@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+)
+
+type TlsConf struct {
+	// CaFile is a PEM bundle of root certificates trusted besides the
+	// system ones, like the one of a debugging proxy.
+	CaFile string
+	// InsecureSkipVerify accepts any certificate.
+	InsecureSkipVerify bool
+	// ServerNames maps a host, "*.domain" or "*" to the name sent as SNI and
+	// checked against the certificate instead of the host, for domain
+	// fronting.
+	ServerNames map[string]string
+}
+
+// tlsConfig is what every https connection is made with.
+var tlsConfig *tls.Config
+
+func NewTlsConfig(c TlsConf) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CaFile == "" {
+		return config, nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	pem, err := ioutil.ReadFile(c.CaFile)
+	if err != nil {
+		return nil, err
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("No Certificate In " + c.CaFile)
+	}
+	config.RootCAs = pool
+	return config, nil
+}
+
+// ServerName is the name ServerNames sends as SNI for host, the exact host
+// first, then the longest matching "*.domain", then "*". It is "" when host
+// keeps its own.
+func ServerName(names map[string]string, host string) string {
+	if name, ok := names[host]; ok {
+		return name
+	}
+	for domain := host; strings.Contains(domain, "."); {
+		domain = domain[strings.Index(domain, ".")+1:]
+		if name, ok := names["*."+domain]; ok {
+			return name
+		}
+	}
+	return names["*"]
+}
+
+// DialTls connects to addr with dial and makes a TLS connection with its
+// ServerName over it.
+func DialTls(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), network string, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	config := tlsConfig.Clone()
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName = ServerName(conf.Tls.ServerNames, host); config.ServerName == "" {
+		config.ServerName = host
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok && conf.ConnectTimeout > 0 {
+		deadline = time.Now().Add(time.Duration(conf.ConnectTimeout) * time.Second)
+	}
+	conn.SetDeadline(deadline)
+	tlsConn := tls.Client(conn, config)
+	if err = tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestServerName(t *testing.T) {
+	names := map[string]string{"ltn.hitomi.la": "front.example", "*.hitomi.la": "cdn.example"}
+	for host, want := range map[string]string{
+		"ltn.hitomi.la": "front.example",
+		"aa.hitomi.la":  "cdn.example",
+		"hitomi.la":     "",
+		"other.example": "",
+	} {
+		if got := ServerName(names, host); got != want {
+			t.Errorf("ServerName(%s) = %q, want %q", host, got, want)
+		}
+	}
+	names["*"] = "any.example"
+	if got := ServerName(names, "other.example"); got != "any.example" {
+		t.Errorf("ServerName falls back to %q, want the one of *", got)
+	}
+}
+
+func TestDialTlsServerName(t *testing.T) {
+	// the certificate of the test server is for example.com and 127.0.0.1
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(c TlsConf) { conf.Tls = c }(conf.Tls)
+	previous := tlsConfig
+	defer func() { tlsConfig = previous }()
+
+	var dialer net.Dialer
+	addr := server.Listener.Addr().String()
+	dial := func(c TlsConf) error {
+		conf.Tls = c
+		config, err := NewTlsConfig(c)
+		if err != nil {
+			return err
+		}
+		tlsConfig = config
+		conn, err := DialTls(context.Background(), dialer.DialContext, "tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	if err := dial(TlsConf{CaFile: caFile, ServerNames: map[string]string{"127.0.0.1": "example.com"}}); err != nil {
+		t.Errorf("the certificate of the sent name is refused: %v", err)
+	}
+	if err := dial(TlsConf{CaFile: caFile, ServerNames: map[string]string{"*": "wrong.example"}}); err == nil {
+		t.Error("the certificate is accepted for another name")
+	}
+	if err := dial(TlsConf{InsecureSkipVerify: true, ServerNames: map[string]string{"*": "wrong.example"}}); err != nil {
+		t.Errorf("InsecureSkipVerify still verifies: %v", err)
+	}
+	if _, err := NewTlsConfig(TlsConf{CaFile: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Error("a missing CaFile is accepted")
+	}
+}
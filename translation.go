@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// TagTranslation maps tag names like "female:glasses" to their translation.
+type TagTranslation map[string]string
+
+var tagTranslation TagTranslation
+
+// tagNamespaces are the EhTagTranslation namespaces a tag without a gender
+// is looked up in, in order.
+var tagNamespaces = []string{"other", "mixed"}
+
+// LoadTagTranslation reads the db.text.json (or db.raw.json) release of
+// EhTagTranslation.
+func LoadTagTranslation(fileName string) (TagTranslation, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var db struct {
+		Data []struct {
+			Namespace string `json:"namespace"`
+			Data      map[string]struct {
+				Name string `json:"name"`
+			} `json:"data"`
+		} `json:"data"`
+	}
+	if err = json.Unmarshal(data, &db); err != nil {
+		return nil, err
+	}
+	translation := make(TagTranslation)
+	for _, namespace := range db.Data {
+		for tag, entry := range namespace.Data {
+			if entry.Name != "" {
+				translation[namespace.Namespace+":"+tag] = entry.Name
+			}
+		}
+	}
+	return translation, nil
+}
+
+func (t TagTranslation) Translate(tag Tag) string {
+	if tag.Female || tag.Male {
+		return t[tag.Name()]
+	}
+	for _, namespace := range tagNamespaces {
+		if name, ok := t[namespace+":"+tag.Tag]; ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// TranslateTags fills in the translation of every tag of gallery.
+func TranslateTags(gallery *Gallery) {
+	if tagTranslation == nil {
+		return
+	}
+	for i, tag := range gallery.Tags {
+		gallery.Tags[i].Translation = tagTranslation.Translate(tag)
+	}
+}
+
+// TranslatedTagNames is like TagNames but uses the translation of a tag
+// where there is one.
+func TranslatedTagNames(tags []Tag) []string {
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag.Translation != "" {
+			names = append(names, tag.Translation)
+		} else {
+			names = append(names, tag.Name())
+		}
+	}
+	return names
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+var tuiFlag = flag.Bool("tui", false, "show the queue and progress on an interactive screen instead of the log")
+
+const tuiRefresh = 500 * time.Millisecond
+
+// logBuffer keeps the last lines of the log for the screen, and the failures
+// apart so they don't scroll away.
+type logBuffer struct {
+	lock   sync.Mutex
+	lines  []string
+	errors []string
+}
+
+var tuiLog = &logBuffer{}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		b.lines = appendLast(b.lines, line, 100)
+		if strings.Contains(line, "Fail") || strings.Contains(line, "Partial") {
+			b.errors = appendLast(b.errors, line, 100)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *logBuffer) Last(n int) ([]string, []string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return lastLines(b.lines, n), lastLines(b.errors, n)
+}
+
+func appendLast(lines []string, line string, max int) []string {
+	lines = append(lines, line)
+	if len(lines) > max {
+		lines = lines[len(lines)-max:]
+	}
+	return lines
+}
+
+func lastLines(lines []string, n int) []string {
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return append([]string{}, lines...)
+}
+
+type tuiTick time.Time
+
+type tuiModel struct {
+	cursor    int
+	status    DaemonStatus
+	active    *ActiveGallery
+	transfers []*Transfer
+	lastBytes int64
+	lastTick  time.Time
+	speed     float64
+	width     int
+}
+
+// RunTui shows the daemon until q is pressed. Everything printed goes to
+// the screen's log instead.
+func RunTui() {
+	tty := os.Stdout
+	if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+		os.Stdout = devNull
+	}
+	log.SetOutput(tuiLog)
+	err := tea.NewProgram(&tuiModel{lastTick: time.Now(), width: 100}, tea.WithOutput(tty)).Start()
+	os.Stdout = tty
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		CommonError("TUI Fail: " + err.Error())
+	}
+	if err = SaveFailures(); err != nil {
+		log.Println("Save Failed List Fail: " + err.Error())
+	}
+}
+
+func tuiTickCmd() tea.Cmd {
+	return tea.Tick(tuiRefresh, func(t time.Time) tea.Msg {
+		return tuiTick(t)
+	})
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	m.refresh()
+	return tea.Batch(tea.EnterAltScreen, tuiTickCmd())
+}
+
+func (m *tuiModel) refresh() {
+	m.status = daemon.Status()
+	m.active = Active()
+	m.transfers = Transfers()
+	now := time.Now()
+	bytes := atomic.LoadInt64(&summary.Bytes)
+	if elapsed := now.Sub(m.lastTick).Seconds(); elapsed > 0 {
+		m.speed = float64(bytes-m.lastBytes) / elapsed
+	}
+	m.lastBytes, m.lastTick = bytes, now
+	if m.cursor >= len(m.status.Pending) {
+		m.cursor = len(m.status.Pending) - 1
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tuiTick:
+		m.refresh()
+		return m, tuiTickCmd()
+	case tea.WindowSizeMsg:
+		if msg.Width > 0 {
+			m.width = msg.Width
+		}
+	case tea.KeyMsg:
+		return m, m.key(msg.String())
+	}
+	return m, nil
+}
+
+func (m *tuiModel) key(key string) tea.Cmd {
+	var selected *PendingJob
+	if m.cursor < len(m.status.Pending) {
+		selected = &m.status.Pending[m.cursor]
+	}
+	// queue.json is saved once the lock is given back, SaveQueue takes it
+	changed := false
+	defer func() {
+		if changed {
+			SaveQueueLogged()
+		}
+	}()
+	daemon.lock.Lock()
+	defer daemon.lock.Unlock()
+	switch key {
+	case "q", "ctrl+c":
+		return tea.Quit
+	case "up", "k":
+		m.cursor--
+	case "down", "j":
+		m.cursor++
+	case "p":
+		if pause.Paused() {
+			pause.Resume()
+			log.Println("Resumed")
+		} else {
+			pause.Pause()
+			log.Println("Paused")
+		}
+	case "s":
+		SkipActive()
+	case "+", "-", "=":
+		if selected != nil {
+			priorities := map[string]string{"+": "high", "=": "normal", "-": "low"}
+			changed = daemon.pending.SetPriority(selected.Id, priorities[key])
+		}
+	case "K", "J":
+		if selected != nil {
+			to := m.cursor - 1
+			if key == "J" {
+				to = m.cursor + 1
+			}
+			if daemon.pending.Move(selected.Id, to) {
+				m.cursor = to
+				changed = true
+			}
+		}
+	case "x", "delete":
+		if selected != nil {
+			if item, ok := daemon.pending.Remove(selected.Id); ok {
+				ReleaseGallery(item.Job.Url)
+				log.Println("Cancelled: " + item.Job.Url)
+				changed = true
+			}
+		}
+	}
+	return func() tea.Msg {
+		return tuiTick(time.Now())
+	}
+}
+
+func (m *tuiModel) View() string {
+	var b strings.Builder
+	state := "Running"
+	if m.status.Paused {
+		state = "Paused"
+	} else if throttle.Paused() {
+		state = "Rate Limited"
+	}
+	s := m.status.Summary
+	b.WriteString("hitomi-go  " + state + "  " + FormatBytes(m.speed) + "/s  " +
+		"Galleries " + strconv.FormatInt(s.GalleriesSucceeded, 10) + " ok " + strconv.FormatInt(s.GalleriesFailed, 10) + " failed  " +
+		"Images " + strconv.FormatInt(s.ImagesDownloaded, 10) + " ok " + strconv.FormatInt(s.ImagesFailed, 10) + " failed  " +
+		FormatBytes(float64(s.Bytes)) + "\n\n")
+
+	if m.active != nil {
+		done := atomic.LoadInt64(&m.active.Task.done)
+		failed := atomic.LoadInt64(&m.active.Task.failed)
+		b.WriteString("Now: " + m.truncate(m.active.Title, 6) + "\n")
+		b.WriteString(progressBar(done+failed, int64(m.active.Pages), 40) + " " +
+			strconv.FormatInt(done, 10) + "/" + strconv.Itoa(m.active.Pages))
+		if failed > 0 {
+			b.WriteString(" (" + strconv.FormatInt(failed, 10) + " failed)")
+		}
+		b.WriteString("\n")
+		for _, t := range m.transfers {
+			elapsed := time.Since(t.Start).Seconds()
+			speed := 0.0
+			if elapsed > 0 {
+				speed = float64(t.Done()) / elapsed
+			}
+			b.WriteString("  " + progressBar(t.Done(), t.Total, 20) + " " + t.Name + "  " + FormatBytes(speed) + "/s\n")
+		}
+	} else if len(m.status.Pending) == 0 {
+		b.WriteString("Queue Empty\n")
+	}
+
+	b.WriteString("\nQueue (" + strconv.Itoa(len(m.status.Pending)) + ")\n")
+	for i, item := range m.status.Pending {
+		if i >= 10 {
+			b.WriteString("  ...\n")
+			break
+		}
+		cursor := "  "
+		if i == m.cursor {
+			cursor = "> "
+		}
+		priority := item.Job.Priority
+		if priority == "" {
+			priority = "normal"
+		}
+		b.WriteString(cursor + "[" + priority + "] " + m.truncate(item.Job.Url, 12+len(priority)) + "\n")
+	}
+
+	lines, errors := tuiLog.Last(5)
+	if len(errors) > 0 {
+		b.WriteString("\nErrors\n")
+		for _, line := range errors {
+			b.WriteString("  " + m.truncate(line, 2) + "\n")
+		}
+	}
+	b.WriteString("\nLog\n")
+	for _, line := range lines {
+		b.WriteString("  " + m.truncate(line, 2) + "\n")
+	}
+	b.WriteString("\np pause/resume  s skip  j/k select  +/=/- priority  J/K move  x cancel  q quit\n")
+	return b.String()
+}
+
+func (m *tuiModel) truncate(s string, used int) string {
+	max := m.width - used
+	if max < 10 {
+		max = 10
+	}
+	runes := []rune(s)
+	if len(runes) > max {
+		return string(runes[:max-1]) + "…"
+	}
+	return s
+}
+
+func progressBar(done int64, total int64, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = int(done * int64(width) / total)
+	}
+	if filled > width {
+		filled = width
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", width-filled) + "]"
+}
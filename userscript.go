@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"html"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// addTokenFile keeps the generated token of /add when AddToken is not set,
+// so installed userscripts keep working after a restart.
+const addTokenFile = "add-token.txt"
+
+const userscriptTemplate = `// ==UserScript==
+// @name         hitomi-go
+// @description  Adds a Download button to hitomi pages which queues them on hitomi-go
+// @match        https://hitomi.la/*
+// @grant        GM_xmlhttpRequest
+// @connect      {{host}}
+// ==/UserScript==
+
+(function () {
+  var endpoint = "{{endpoint}}";
+  var button = document.createElement("button");
+  button.textContent = "Download";
+  button.style.cssText = "position:fixed;right:16px;bottom:16px;z-index:99999;padding:8px 16px;font-size:16px";
+  button.onclick = function () {
+    button.disabled = true;
+    GM_xmlhttpRequest({
+      method: "GET",
+      url: endpoint + encodeURIComponent(location.href),
+      onload: function (res) {
+        button.textContent = res.status === 200 ? "Queued" : "Failed: " + res.responseText;
+        button.disabled = false;
+      },
+      onerror: function () {
+        button.textContent = "hitomi-go Not Running";
+        button.disabled = false;
+      }
+    });
+  };
+  document.body.appendChild(button);
+})();
+`
+
+// AddToken is the token /add requires, AddToken of the config or the one in
+// add-token.txt, generated the first time.
+func AddToken() (string, error) {
+	if conf.AddToken != "" {
+		return conf.AddToken, nil
+	}
+	if data, err := ioutil.ReadFile(addTokenFile); err == nil {
+		if token := strings.TrimSpace(string(data)); token != "" {
+			return token, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	if err := ioutil.WriteFile(addTokenFile, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// AddEndpoint is the url of /add on host with token, the page url is
+// appended to it.
+func AddEndpoint(host string, token string) string {
+	scheme := "http://"
+	if conf.ListenCert != "" {
+		scheme = "https://"
+	}
+	return scheme + host + "/add?token=" + token + "&url="
+}
+
+// ListenHost is the address browsers reach Listen at.
+func ListenHost() string {
+	listen := conf.Listen
+	if listen == "" || listen == "off" {
+		listen = defaultListen
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return listen
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func Userscript(host string, token string) string {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	return strings.NewReplacer(
+		"{{host}}", hostname,
+		"{{endpoint}}", AddEndpoint(host, token),
+	).Replace(userscriptTemplate)
+}
+
+func Bookmarklet(host string, token string) string {
+	return "javascript:void(window.open(" + strconv.Quote(AddEndpoint(host, token)) + "+encodeURIComponent(location.href)))"
+}
+
+// AddPageHandler serves /add?token=...&url=..., queueing the gallery or
+// search url of the page the userscript or bookmarklet was used on.
+func (d *Daemon) AddPageHandler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "https://hitomi.la")
+		if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(token)) != 1 {
+			http.Error(w, "Invalid Token", http.StatusForbidden)
+			return
+		}
+		urls := HitomiUrls(r.URL.Query().Get("url"))
+		if len(urls) == 0 {
+			http.Error(w, "Not A Gallery Or Search Url", http.StatusBadRequest)
+			return
+		}
+		added := d.Add(UrlJobs(urls))
+		log.Println("Queued " + strconv.Itoa(len(added)) + " Galleries From " + r.RemoteAddr)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("Queued " + strconv.Itoa(len(added)) + " Galleries\n"))
+	}
+}
+
+// UserscriptHandler serves the userscript at /userscript.user.js and a page
+// with the bookmarklet at /bookmarklet, both for the host they were
+// requested from.
+func UserscriptHandler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bookmarklet" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(`<!DOCTYPE html><title>hitomi-go</title><p>Drag this to your bookmarks bar: <a href="` +
+				html.EscapeString(Bookmarklet(r.Host, token)) + `">hitomi-go</a></p>` +
+				`<p>Or install the <a href="/userscript.user.js">userscript</a>.</p>`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Write([]byte(Userscript(r.Host, token)))
+	}
+}
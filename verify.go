@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var requeue = flag.Bool("requeue", false, "verify: write broken galleries to failed.txt/failed.json for retry-failed")
+
+// Lister is implemented by storages which can list the galleries saved in
+// them.
+type Lister interface {
+	// Galleries returns the slash separated dir of every saved gallery.
+	Galleries() ([]string, error)
+}
+
+func (s *LocalStorage) Galleries() ([]string, error) {
+	var dirs []string
+	err := filepath.Walk(s.Root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := skipHidden(s.Root, p, info); err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() != manifestFile {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Root, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, filepath.ToSlash(rel))
+		return nil
+	})
+	return dirs, err
+}
+
+func (s *ZipStorage) Galleries() ([]string, error) {
+	var dirs []string
+	err := filepath.Walk(s.Root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := skipHidden(s.Root, p, info); err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(p) != s.Ext {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Root, strings.TrimSuffix(p, s.Ext))
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, filepath.ToSlash(rel))
+		return nil
+	})
+	return dirs, err
+}
+
+type VerifyResult struct {
+	Dir      string
+	Manifest Manifest
+	Missing  []string
+	Corrupt  []string
+	// Unlisted is the number of pages which never made it into the manifest.
+	Unlisted int
+}
+
+func (r VerifyResult) Ok() bool {
+	return len(r.Missing) == 0 && len(r.Corrupt) == 0 && r.Unlisted == 0
+}
+
+func (r VerifyResult) String() string {
+	var problems []string
+	if len(r.Missing) > 0 {
+		problems = append(problems, strconv.Itoa(len(r.Missing))+" Missing ("+strings.Join(r.Missing, ", ")+")")
+	}
+	if len(r.Corrupt) > 0 {
+		problems = append(problems, strconv.Itoa(len(r.Corrupt))+" Corrupt ("+strings.Join(r.Corrupt, ", ")+")")
+	}
+	if r.Unlisted > 0 {
+		problems = append(problems, strconv.Itoa(r.Unlisted)+" Never Downloaded")
+	}
+	return strings.Join(problems, ", ")
+}
+
+// VerifyGallery re-hashes every file listed in the manifest of dir.
+func VerifyGallery(dir string) (VerifyResult, error) {
+	result := VerifyResult{Dir: dir}
+	manifest, err := ReadManifest(dir)
+	if err != nil {
+		return result, err
+	}
+	result.Manifest = manifest
+	for _, file := range manifest.Files {
+		content, err := storage.Read(dir + "/" + file.Name)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return result, err
+			}
+			result.Missing = append(result.Missing, file.Name)
+			continue
+		}
+		if int64(len(content)) != file.Size || Sha256Sum(content) != file.Sha256 {
+			result.Corrupt = append(result.Corrupt, file.Name)
+		}
+	}
+	if manifest.Pages > len(manifest.Files) {
+		result.Unlisted = manifest.Pages - len(manifest.Files)
+	}
+	return result, nil
+}
+
+// VerifyAll verifies every gallery of the storage and returns the broken
+// ones.
+func VerifyAll() ([]VerifyResult, error) {
+	lister, ok := storage.(Lister)
+	if !ok {
+		return nil, errors.New("Verify Needs Storage local, zip Or tar")
+	}
+	dirs, err := lister.Galleries()
+	if err != nil {
+		return nil, err
+	}
+	var broken []VerifyResult
+	for i, dir := range dirs {
+		if Interrupted() {
+			break
+		}
+		result, err := VerifyGallery(dir)
+		if err != nil {
+			log.Println("Verify Fail: " + dir + " Because " + err.Error())
+			continue
+		}
+		if !result.Ok() {
+			fmt.Println()
+			log.Println("Broken (" + strconv.Itoa(i+1) + "/" + strconv.Itoa(len(dirs)) + "): " + dir + ": " + result.String())
+			broken = append(broken, result)
+		} else {
+			fmt.Print(".")
+		}
+	}
+	fmt.Println()
+	log.Println("Verify Finish: " + strconv.Itoa(len(dirs)) + " Galleries, " + strconv.Itoa(len(broken)) + " Broken")
+	return broken, nil
+}
+
+// Verify reports broken galleries and, with --requeue, records them for
+// retry-failed.
+func Verify() {
+	broken, err := VerifyAll()
+	if err != nil {
+		CommonError(err)
+	}
+	if !*requeue {
+		return
+	}
+	for _, result := range broken {
+		if result.Manifest.Url == "" {
+			continue
+		}
+		RecordFailure(Failure{
+			Url:    result.Manifest.Url,
+			Id:     result.Manifest.Id,
+			Title:  result.Manifest.Title,
+			Reason: "Verify: " + result.String(),
+		})
+	}
+	if err := SaveFailures(); err != nil {
+		log.Println("Save Failed List Fail: " + err.Error())
+	} else if len(broken) > 0 {
+		log.Println(strconv.Itoa(len(broken)) + " Broken Galleries Saved to " + failedJsonFile + ", Run With retry-failed to Download Missing Pages")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+)
+
+func IsVideo(gallery Gallery) bool {
+	return gallery.VideoFileName != "" && len(gallery.Files) == 0
+}
+
+func VideoUrl(gallery Gallery) string {
+	return "https://" + ImageHost("streaming") + "/videos/" + gallery.VideoFileName
+}
+
+// DownloadVideo downloads into a ".part" file with range requests, so every
+// retry and every later run continues where the last one stopped. It is
+// retried as often as the request of an image.
+func DownloadVideo(ctx context.Context, gallery Gallery, savePath string, conf Conf) error {
+	name := savePath + "/" + ValidFileName(gallery.VideoFileName)
+	if storage.Exists(name) {
+		atomic.AddInt64(&summary.ImagesSkipped, 1)
+		return nil
+	}
+	partDir := os.TempDir()
+	if local, ok := localStorage(); ok {
+		partDir = local.Root
+	}
+	part := filepath.Join(partDir, filepath.FromSlash(name)) + ".part"
+	if err := os.MkdirAll(filepath.Dir(part), 0755); err != nil {
+		return err
+	}
+
+	var err error
+	retries := RequestRetries(conf)
+	for tries := 1; tries <= retries+1; tries++ {
+		if err = downloadVideoPart(ctx, gallery, part); err == nil || ctx.Err() != nil {
+			break
+		}
+		log.Println("Download Video Fail: " + gallery.VideoFileName + " Because " + err.Error() + ", Resuming")
+		if tries <= retries {
+			atomic.AddInt64(&summary.Retries, 1)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(part)
+	if err != nil {
+		return err
+	}
+	if local, ok := localStorage(); ok {
+		err = os.Rename(part, filepath.Join(local.Root, filepath.FromSlash(name)))
+	} else if err = StorePart(name, part, info.Size()); err == nil {
+		os.Remove(part)
+	}
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&summary.ImagesDownloaded, 1)
+	atomic.AddInt64(&summary.Bytes, info.Size())
+	return nil
+}
+
+// StorePart writes the downloaded file part as name into a storage which is
+// not local, streaming it instead of reading the whole video into memory.
+func StorePart(name string, part string, size int64) error {
+	f, err := os.Open(part)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	switch w := storage.(type) {
+	case StreamWriter:
+		_, err = w.WriteStream(name, f)
+	case SizedWriter:
+		err = w.WriteSized(name, f, size)
+	default:
+		var content []byte
+		if content, err = ioutil.ReadAll(f); err == nil {
+			err = storage.Write(name, content)
+		}
+	}
+	return err
+}
+
+func downloadVideoPart(ctx context.Context, gallery Gallery, part string) error {
+	f, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", VideoUrl(gallery), nil)
+	if err != nil {
+		return err
+	}
+	for key, value := range RequestHeaders("https://hitomi.la/galleries/"+gallery.Id+".html", gallery.Id) {
+		req.Header.Set(key, value)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	res, err := ImageDo(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// the server ignored the range, start over
+		if err = f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		if offset > 0 {
+			return nil
+		}
+		fallthrough
+	default:
+		return errors.New("Status Code " + strconv.Itoa(res.StatusCode))
+	}
+	body, stop := WatchStall(res.Body, cancel)
+	defer stop()
+	_, err = io.Copy(f, body)
+	return err
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadVideoRequestRetry(t *testing.T) {
+	defer func(s Storage) { storage = s }(storage)
+	root := t.TempDir()
+	storage = &LocalStorage{Root: root, Mode: 0644}
+	video := []byte("not really a video")
+	gallery := Gallery{Id: "5678", VideoFileName: "video.mp4"}
+
+	mockSite(t, map[string]http.HandlerFunc{"/videos/video.mp4": failFirst(2, http.StatusServiceUnavailable, video)})
+	if err := DownloadVideo(context.Background(), gallery, "few", Conf{Retry: 5, RequestRetry: 1}); err == nil {
+		t.Error("downloaded with RequestRetry 1 after 2 failures")
+	}
+
+	mockSite(t, map[string]http.HandlerFunc{"/videos/video.mp4": failFirst(2, http.StatusServiceUnavailable, video)})
+	if err := DownloadVideo(context.Background(), gallery, "enough", Conf{Retry: 0, RequestRetry: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(root, "enough", "video.mp4")); err != nil || string(data) != string(video) {
+		t.Errorf("video = %q, %v", data, err)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import "strconv"
+
+// SplitVolumes splits a gallery of more than VolumePages pages into volumes
+// of that many pages, saved to Vol.1, Vol.2, ... below the path of the
+// gallery as galleries of their own. Pages are placed by their number, so a
+// volume keeps its pages when only some of the gallery are downloaded.
+func SplitVolumes(gallery Gallery, conf Conf) []QueuedGallery {
+	size := conf.VolumePages
+	if size < 1 || gallery.PageCount <= size {
+		return []QueuedGallery{{Gallery: gallery, Conf: conf}}
+	}
+	dir, err := GalleryPath(gallery, conf)
+	if err != nil {
+		// DownloadGallery reports it
+		return []QueuedGallery{{Gallery: gallery, Conf: conf}}
+	}
+	dir = ClaimGalleryPath(gallery, dir)
+	volumes := make([][]Image, (gallery.PageCount+size-1)/size)
+	for _, img := range gallery.Files {
+		v := (img.Page - 1) / size
+		if v < 0 || v >= len(volumes) {
+			v = len(volumes) - 1
+		}
+		volumes[v] = append(volumes[v], img)
+	}
+	var queued []QueuedGallery
+	for v, files := range volumes {
+		if len(files) == 0 {
+			continue
+		}
+		name := "Vol." + strconv.Itoa(v+1)
+		volume := gallery
+		volume.Files = files
+		volume.Title += " " + name
+		if volume.JpTitle != "" {
+			volume.JpTitle += " " + name
+		}
+		volumeConf := conf
+		volumeConf.PathTemplate = "{{" + strconv.Quote(dir+"/"+name) + "}}"
+		queued = append(queued, QueuedGallery{Gallery: volume, Conf: volumeConf, More: true})
+	}
+	queued[len(queued)-1].More = false
+	return queued
+}
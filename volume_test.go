@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitVolumes(t *testing.T) {
+	gallery := Gallery{Id: "1234", Title: "Long", Lang: "japanese", PageCount: 5}
+	for _, page := range []int{1, 2, 4, 5} {
+		gallery.Files = append(gallery.Files, Image{Name: string(rune('0'+page)) + ".jpg", Page: page})
+	}
+	volumeConf := Conf{VolumePages: 2}
+	dir, err := GalleryPath(gallery, volumeConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volumes := SplitVolumes(gallery, volumeConf)
+	want := []struct {
+		name  string
+		pages int
+	}{{"Vol.1", 2}, {"Vol.2", 1}, {"Vol.3", 1}}
+	if len(volumes) != len(want) {
+		t.Fatalf("%d volumes, want %d", len(volumes), len(want))
+	}
+	for i, volume := range volumes {
+		path, err := GalleryPath(volume.Gallery, volume.Conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path != dir+"/"+want[i].name || len(volume.Gallery.Files) != want[i].pages {
+			t.Errorf("volume %d = %s with %d pages, want %s/%s with %d", i+1, path, len(volume.Gallery.Files), dir, want[i].name, want[i].pages)
+		}
+		if !strings.HasSuffix(volume.Gallery.Title, want[i].name) || volume.More != (i < len(want)-1) {
+			t.Errorf("volume %d: Title %q, More %v", i+1, volume.Gallery.Title, volume.More)
+		}
+	}
+
+	volumeConf.VolumePages = 5
+	if volumes = SplitVolumes(gallery, volumeConf); len(volumes) != 1 || volumes[0].More {
+		t.Errorf("a gallery of VolumePages pages was split: %+v", volumes)
+	}
+}
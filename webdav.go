@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type WebDAVConf struct {
+	Url      string
+	User     string
+	Password string
+}
+
+type WebDAVStorage struct {
+	Conf WebDAVConf
+	dirs sync.Map
+}
+
+var webdavClient = &http.Client{Timeout: 5 * time.Minute}
+
+func (s *WebDAVStorage) Write(name string, content []byte) error {
+	return s.WriteSized(name, bytes.NewReader(content), int64(len(content)))
+}
+
+func (s *WebDAVStorage) WriteSized(name string, r io.Reader, size int64) error {
+	dirs := strings.Split(strings.Trim(name, "/"), "/")
+	dirs = dirs[:len(dirs)-1]
+	for i := range dirs {
+		if err := s.Mkcol(strings.Join(dirs[:i+1], "/") + "/"); err != nil {
+			return err
+		}
+	}
+	res, err := s.Send("PUT", name, r, size)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != 200 && res.StatusCode != 201 && res.StatusCode != 204 {
+		return errors.New("WebDAV PUT Status Code " + strconv.Itoa(res.StatusCode))
+	}
+	return nil
+}
+
+func (s *WebDAVStorage) Exists(name string) bool {
+	_, err := s.Stat(name)
+	return err == nil
+}
+
+func (s *WebDAVStorage) Stat(name string) (os.FileInfo, error) {
+	res, err := s.Do("HEAD", name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == 404 {
+		return nil, os.ErrNotExist
+	}
+	if res.StatusCode != 200 {
+		return nil, errors.New("WebDAV HEAD Status Code " + strconv.Itoa(res.StatusCode))
+	}
+	modTime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	return remoteFileInfo{name: path.Base(name), size: res.ContentLength, modTime: modTime}, nil
+}
+
+func (s *WebDAVStorage) Read(name string) ([]byte, error) {
+	res, err := s.Request("GET", name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return nil, os.ErrNotExist
+	}
+	if res.StatusCode != 200 {
+		return nil, errors.New("WebDAV GET Status Code " + strconv.Itoa(res.StatusCode))
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+func (s *WebDAVStorage) Finalize(dir string) error {
+	return nil
+}
+
+func (s *WebDAVStorage) Mkcol(dir string) error {
+	if _, done := s.dirs.Load(dir); done {
+		return nil
+	}
+	res, err := s.Do("MKCOL", dir, nil)
+	if err != nil {
+		return err
+	}
+	// 405 means the collection already exists
+	if res.StatusCode != 201 && res.StatusCode != 405 {
+		return errors.New("WebDAV MKCOL " + dir + " Status Code " + strconv.Itoa(res.StatusCode))
+	}
+	s.dirs.Store(dir, struct{}{})
+	return nil
+}
+
+// Do sends a request whose response body is not needed.
+func (s *WebDAVStorage) Do(method string, name string, body []byte) (*http.Response, error) {
+	res, err := s.Request(method, name, body)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	return res, nil
+}
+
+func (s *WebDAVStorage) Request(method string, name string, body []byte) (*http.Response, error) {
+	return s.Send(method, name, bytes.NewReader(body), int64(len(body)))
+}
+
+func (s *WebDAVStorage) Send(method string, name string, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.Url(name), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	if s.Conf.User != "" {
+		req.SetBasicAuth(s.Conf.User, s.Conf.Password)
+	}
+	return webdavClient.Do(req)
+}
+
+func (s *WebDAVStorage) Url(name string) string {
+	pieces := strings.Split(name, "/")
+	for i, piece := range pieces {
+		pieces[i] = url.PathEscape(piece)
+	}
+	return strings.TrimRight(s.Conf.Url, "/") + "/" + strings.TrimLeft(strings.Join(pieces, "/"), "/")
+}
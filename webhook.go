@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	EventGalleryStarted   = "gallery_started"
+	EventGalleryCompleted = "gallery_completed"
+	EventGalleryFailed    = "gallery_failed"
+	EventBatchFinished    = "batch_finished"
+)
+
+type WebhookConf struct {
+	Url string
+	// Events to post, empty for all of them.
+	Events  []string
+	Headers map[string]string
+}
+
+// WebhookEvent is the JSON body posted to webhooks.
+type WebhookEvent struct {
+	Event   string          `json:"event"`
+	Time    time.Time       `json:"time"`
+	Gallery *WebhookGallery `json:"gallery,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Summary *Summary        `json:"summary,omitempty"`
+}
+
+type WebhookGallery struct {
+	Id       string   `json:"id"`
+	Title    string   `json:"title"`
+	JpTitle  string   `json:"japanese_title,omitempty"`
+	Url      string   `json:"url"`
+	Language string   `json:"language,omitempty"`
+	Type     string   `json:"type,omitempty"`
+	Artists  []string `json:"artists,omitempty"`
+	Pages    int      `json:"pages"`
+	Bytes    int64    `json:"bytes,omitempty"`
+	Path     string   `json:"path,omitempty"`
+}
+
+func NewWebhookGallery(gallery Gallery, savePath string, size int64) *WebhookGallery {
+	return &WebhookGallery{
+		Id:       gallery.Id,
+		Title:    gallery.Title,
+		JpTitle:  gallery.JpTitle,
+		Url:      gallery.Url,
+		Language: gallery.Lang,
+		Type:     gallery.Type,
+		Artists:  gallery.Artists,
+		Pages:    len(gallery.Files),
+		Bytes:    size,
+		Path:     savePath,
+	}
+}
+
+func (w WebhookConf) Wants(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// EmitEvent posts event to every webhook which wants it, in the background.
+func EmitEvent(event WebhookEvent) {
+	event.Time = time.Now()
+	StreamWebhookEvent(event)
+	for _, webhook := range conf.Webhooks {
+		if !webhook.Wants(event.Event) {
+			continue
+		}
+		notifyWg.Add(1)
+		go func(webhook WebhookConf) {
+			defer notifyWg.Done()
+			if err := PostWebhook(webhook, event); err != nil {
+				log.Println("Webhook Fail: " + webhook.Url + " " + event.Event + " Because " + err.Error())
+			}
+		}(webhook)
+	}
+}
+
+func PostWebhook(webhook WebhookConf, event WebhookEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for tries := 0; tries <= conf.Retry; tries++ {
+		req, err := http.NewRequest("POST", webhook.Url, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for key, value := range webhook.Headers {
+			req.Header.Set(key, value)
+		}
+		res, err := notifyClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode/100 == 2 {
+			return nil
+		}
+		lastErr = errors.New("Status Code " + strconv.Itoa(res.StatusCode))
+		if res.StatusCode/100 == 4 && res.StatusCode != http.StatusTooManyRequests {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	return lastErr
+}